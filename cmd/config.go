@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-dig/pkg/errors"
+	"go-dig/pkg/output"
+)
+
+// ConfigFileName is the name of the per-user config file in the home directory
+const ConfigFileName = ".godigrc"
+
+// Sources of a setting, besides the config file and environment variables,
+// which are named by path and line or by variable
+const (
+	SourceDefault     = "default"
+	SourceCommandLine = "command line"
+	SourceShell       = "shell" // Changed with "set" in the interactive shell
+)
+
+// settingNames lists the settings that can be given defaults, in display order.
+// Each can be set in the config file as "name = value" and in the environment
+// as GODIG_<NAME>.
+var settingNames = []string{"server", "port", "type", "timeout", "tries", "output", "idnout", "follow", "tcp", "dnssec"}
+
+// plusSettings maps the +options that override a setting to its name
+var plusSettings = map[string]string{
+	"idnout":   "idnout",
+	"noidnout": "idnout",
+	"follow":   "follow",
+	"nofollow": "follow",
+	"time":     "timeout",
+	"tries":    "tries",
+	"retry":    "tries",
+	"tcp":      "tcp",
+	"notcp":    "tcp",
+	"dnssec":   "dnssec",
+	"nodnssec": "dnssec",
+}
+
+// setting is a default value together with where it was read from
+type setting struct {
+	value  string
+	source string
+}
+
+// defaults holds the settings read from the config file and the environment
+type defaults struct {
+	file     string // Path of the config file that was read; empty if there was none
+	settings map[string]setting
+}
+
+// configFile returns the path of the config file: $GODIG_CONFIG if set,
+// otherwise ~/.godigrc. It returns an empty string if neither is available.
+func (p *CLIParser) configFile() string {
+	if path, ok := p.getenv("GODIG_CONFIG"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ConfigFileName)
+}
+
+// getenv looks up an environment variable, through the parser's lookup
+// function when one is set
+func (p *CLIParser) getenv(name string) (string, bool) {
+	if p.lookupEnv != nil {
+		return p.lookupEnv(name)
+	}
+	return os.LookupEnv(name)
+}
+
+// loadDefaults reads the config file and then the GODIG_* environment
+// variables; a variable overrides the same setting from the file
+func (p *CLIParser) loadDefaults() (*defaults, error) {
+	loaded := &defaults{settings: map[string]setting{}}
+
+	if path := p.configFile(); path != "" {
+		found, err := readConfigFile(path, loaded.settings)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			loaded.file = path
+		}
+	}
+
+	for _, name := range settingNames {
+		variable := "GODIG_" + strings.ToUpper(name)
+		if value, ok := p.getenv(variable); ok && value != "" {
+			loaded.settings[name] = setting{value: value, source: variable}
+		}
+	}
+
+	return loaded, nil
+}
+
+// readConfigFile parses "name = value" lines into settings and reports whether
+// the file exists. Blank lines and lines starting with '#' are ignored.
+func readConfigFile(path string, settings map[string]setting) (bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.NewSystemError(fmt.Sprintf("cannot read config file %s", path), err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		source := fmt.Sprintf("%s:%d", path, number)
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return true, errors.NewInputError(fmt.Sprintf("expected 'name = value' at %s, got '%s'", source, line), nil)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isSettingName(name) {
+			return true, errors.NewInputError(fmt.Sprintf("unknown setting '%s' at %s (expected one of %s)", name, source, strings.Join(settingNames, ", ")), nil)
+		}
+		settings[name] = setting{value: strings.TrimSpace(value), source: source}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, errors.NewSystemError(fmt.Sprintf("cannot read config file %s", path), err)
+	}
+
+	return true, nil
+}
+
+// isSettingName reports whether name is one of settingNames
+func isSettingName(name string) bool {
+	for _, known := range settingNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// apply validates the named settings and stores them in config, recording
+// where each came from. Settings not named are left alone.
+func (d *defaults) apply(config *Config, names ...string) error {
+	config.ConfigFile = d.file
+	for _, name := range names {
+		s, ok := d.settings[name]
+		if !ok {
+			continue
+		}
+		if err := applySetting(config, name, s.value); err != nil {
+			return errors.NewInputError(fmt.Sprintf("invalid %s '%s' from %s", name, s.value, s.source), err)
+		}
+		config.setSource(name, s.source)
+	}
+	return nil
+}
+
+// applySetting validates a single setting value and stores it in config
+func applySetting(config *Config, name, value string) error {
+	switch name {
+	case "server":
+		if err := errors.ValidateDNSServer(value); err != nil {
+			return err
+		}
+		config.Server = value
+	case "port":
+		if err := errors.ValidateDNSPort(value); err != nil {
+			return err
+		}
+		config.Port, _ = strconv.Atoi(value)
+	case "type":
+		if err := errors.ValidateRecordType(value); err != nil {
+			return err
+		}
+		config.RecordType = strings.ToUpper(value)
+	case "timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+		config.Timeout = timeout
+	case "tries":
+		if err := errors.ValidateTries(value); err != nil {
+			return err
+		}
+		config.Tries, _ = strconv.Atoi(value)
+	case "output":
+		format := strings.ToLower(value)
+		switch format {
+		case output.FormatText, output.FormatJSON, output.FormatZone, output.FormatCSV, output.FormatTSV:
+		default:
+			return fmt.Errorf("expected text, json, zone, csv or tsv")
+		}
+		config.OutputFormat = format
+	case "idnout", "follow", "tcp", "dnssec":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		switch name {
+		case "idnout":
+			config.IDNOut = enabled
+		case "follow":
+			config.FollowCNAME = enabled
+		case "tcp":
+			config.TCP = enabled
+		case "dnssec":
+			config.DNSSEC = enabled
+		}
+	default:
+		return fmt.Errorf("unknown setting")
+	}
+	return nil
+}
+
+// setSource records where the value of a setting came from
+func (c *Config) setSource(name, source string) {
+	if c.Sources == nil {
+		c.Sources = map[string]string{}
+	}
+	c.Sources[name] = source
+}
+
+// Source returns where the value of a setting came from: SourceDefault,
+// SourceCommandLine, a config file location ("path:line") or an environment
+// variable name
+func (c *Config) Source(name string) string {
+	if source, ok := c.Sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// settingValue returns the effective value of a setting for display
+func (c *Config) settingValue(name string) string {
+	switch name {
+	case "server":
+		if c.Server == "" {
+			return "(system resolver)"
+		}
+		return c.Server
+	case "port":
+		if c.Port == 0 {
+			return "53"
+		}
+		return strconv.Itoa(c.Port)
+	case "type":
+		return c.RecordType
+	case "timeout":
+		return c.Timeout.String()
+	case "tries":
+		return strconv.Itoa(c.Tries)
+	case "output":
+		return c.OutputFormat
+	case "idnout":
+		return strconv.FormatBool(c.IDNOut)
+	case "follow":
+		return strconv.FormatBool(c.FollowCNAME)
+	case "tcp":
+		return strconv.FormatBool(c.TCP)
+	case "dnssec":
+		return strconv.FormatBool(c.DNSSEC)
+	}
+	return ""
+}
+
+// FormatConfig describes the effective configuration, one setting per line
+// with the source of its value
+func FormatConfig(config *Config) string {
+	var out strings.Builder
+
+	out.WriteString(";; Effective configuration")
+	if config.ConfigFile != "" {
+		out.WriteString(fmt.Sprintf(" (config file: %s)", config.ConfigFile))
+	}
+	out.WriteString("\n")
+
+	for _, name := range settingNames {
+		out.WriteString(fmt.Sprintf("%-8s %-20s ; %s\n", name, config.settingValue(name), config.Source(name)))
+	}
+
+	return out.String()
+}
@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/errors"
+	"go-dig/pkg/output"
+)
+
+// testParser returns a parser that sees only the given environment, with
+// GODIG_CONFIG pointing at a file holding rc (or at a missing file if rc is empty)
+func testParser(t *testing.T, rc string, env map[string]string) (*CLIParser, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	if rc != "" {
+		if err := os.WriteFile(path, []byte(rc), 0o600); err != nil {
+			t.Fatalf("writing config file: %v", err)
+		}
+	}
+
+	lookup := map[string]string{"GODIG_CONFIG": path}
+	for name, value := range env {
+		lookup[name] = value
+	}
+	return &CLIParser{lookupEnv: func(name string) (string, bool) {
+		value, ok := lookup[name]
+		return value, ok
+	}}, path
+}
+
+// TestMain keeps the parsers made with NewCLIParser from reading the
+// developer's ~/.godigrc and GODIG_* variables
+func TestMain(m *testing.M) {
+	for _, name := range settingNames {
+		os.Unsetenv("GODIG_" + strings.ToUpper(name))
+	}
+	dir, err := os.MkdirTemp("", "go-dig-cmd")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("GODIG_CONFIG", filepath.Join(dir, ConfigFileName))
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestCLIParser_Parse_Defaults(t *testing.T) {
+	rc := "# team defaults\n\nserver = 1.1.1.1\ntimeout = 3s\nOUTPUT = json\nfollow = true\n"
+
+	tests := []struct {
+		name            string
+		rc              string
+		env             map[string]string
+		args            []string
+		expectedServer  string
+		expectedTimeout time.Duration
+		expectedOutput  string
+		expectedType    string
+		expectedFollow  bool
+		expectedSources map[string]string
+	}{
+		{
+			name:            "no config",
+			args:            []string{"example.com"},
+			expectedTimeout: 5 * time.Second,
+			expectedOutput:  output.FormatText,
+			expectedType:    "A",
+			expectedSources: map[string]string{"server": SourceDefault, "timeout": SourceDefault},
+		},
+		{
+			name:            "config file",
+			rc:              rc,
+			args:            []string{"example.com"},
+			expectedServer:  "1.1.1.1",
+			expectedTimeout: 3 * time.Second,
+			expectedOutput:  output.FormatJSON,
+			expectedType:    "A",
+			expectedFollow:  true,
+			expectedSources: map[string]string{"server": ":3", "timeout": ":4", "output": ":5", "follow": ":6", "type": SourceDefault},
+		},
+		{
+			name:            "environment overrides file",
+			rc:              rc,
+			env:             map[string]string{"GODIG_SERVER": "9.9.9.9", "GODIG_TYPE": "mx", "GODIG_FOLLOW": "false"},
+			args:            []string{"example.com"},
+			expectedServer:  "9.9.9.9",
+			expectedTimeout: 3 * time.Second,
+			expectedOutput:  output.FormatJSON,
+			expectedType:    "MX",
+			expectedSources: map[string]string{"server": "GODIG_SERVER", "type": "GODIG_TYPE", "follow": "GODIG_FOLLOW", "timeout": ":4"},
+		},
+		{
+			name:            "flags override environment",
+			rc:              rc,
+			env:             map[string]string{"GODIG_SERVER": "9.9.9.9"},
+			args:            []string{"-s", "8.8.8.8", "-o", "text", "-t", "TXT", "+nofollow", "example.com"},
+			expectedServer:  "8.8.8.8",
+			expectedTimeout: 3 * time.Second,
+			expectedOutput:  output.FormatText,
+			expectedType:    "TXT",
+			expectedSources: map[string]string{"server": SourceCommandLine, "output": SourceCommandLine, "type": SourceCommandLine, "follow": SourceCommandLine},
+		},
+		{
+			name:            "empty variable is ignored",
+			rc:              rc,
+			env:             map[string]string{"GODIG_SERVER": ""},
+			args:            []string{"example.com"},
+			expectedServer:  "1.1.1.1",
+			expectedTimeout: 3 * time.Second,
+			expectedOutput:  output.FormatJSON,
+			expectedType:    "A",
+			expectedFollow:  true,
+			expectedSources: map[string]string{"server": ":3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, path := testParser(t, tt.rc, tt.env)
+			config, err := parser.Parse(tt.args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if config.Server != tt.expectedServer || config.Timeout != tt.expectedTimeout || config.OutputFormat != tt.expectedOutput || config.RecordType != tt.expectedType || config.FollowCNAME != tt.expectedFollow {
+				t.Errorf("Unexpected config: server %q timeout %v output %q type %q follow %v",
+					config.Server, config.Timeout, config.OutputFormat, config.RecordType, config.FollowCNAME)
+			}
+
+			for name, expected := range tt.expectedSources {
+				// File locations are given as ":line" relative to the temporary path
+				if strings.HasPrefix(expected, ":") {
+					expected = path + expected
+				}
+				if source := config.Source(name); source != expected {
+					t.Errorf("Source(%q) = %q, want %q", name, source, expected)
+				}
+			}
+
+			if tt.rc != "" && config.ConfigFile != path {
+				t.Errorf("ConfigFile = %q, want %q", config.ConfigFile, path)
+			}
+			if tt.rc == "" && config.ConfigFile != "" {
+				t.Errorf("ConfigFile = %q for a missing file, want empty", config.ConfigFile)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_InvalidDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		rc          string
+		env         map[string]string
+		expectError string
+	}{
+		{"unknown setting", "server = 1.1.1.1\ncolour = always\n", nil, "unknown setting 'colour' at %s:2"},
+		{"malformed line", "server 1.1.1.1\n", nil, "expected 'name = value' at %s:1"},
+		{"invalid server in file", "server = dns.example\n", nil, "invalid server 'dns.example' from %s:1"},
+		{"invalid timeout in file", "timeout = 0s\n", nil, "invalid timeout '0s' from %s:1"},
+		{"invalid boolean", "idnout = sometimes\n", nil, "invalid idnout 'sometimes' from %s:1"},
+		{"invalid output variable", "", map[string]string{"GODIG_OUTPUT": "xml"}, "invalid output 'xml' from GODIG_OUTPUT"},
+		{"invalid type variable", "", map[string]string{"GODIG_TYPE": "SRV"}, "invalid type 'SRV' from GODIG_TYPE"},
+		{"invalid port in file", "port = 70000\n", nil, "invalid port '70000' from %s:1"},
+		{"invalid tries variable", "", map[string]string{"GODIG_TRIES": "0"}, "invalid tries '0' from GODIG_TRIES"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, path := testParser(t, tt.rc, tt.env)
+			_, err := parser.Parse([]string{"example.com"})
+			if err == nil {
+				t.Fatal("Parse() error = nil, want error")
+			}
+
+			expected := tt.expectError
+			if strings.Contains(expected, "%s") {
+				expected = strings.Replace(expected, "%s", path, 1)
+			}
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, expected)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_DefaultsForCommands(t *testing.T) {
+	parser, path := testParser(t, "server = 1.1.1.1\ntimeout = 2s\ntype = MX\n", nil)
+
+	for _, args := range [][]string{
+		{CommandPropagation, "-expect", "192.0.2.1", "example.com"},
+		{CommandCheckDelegation, "example.com"},
+		{CommandMailCheck, "example.com"},
+	} {
+		t.Run(args[0], func(t *testing.T) {
+			config, err := parser.Parse(args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if config.Server != "1.1.1.1" || config.Source("server") != path+":1" || config.Timeout != 2*time.Second {
+				t.Errorf("Expected server and timeout defaults, got server %q (%s) timeout %v", config.Server, config.Source("server"), config.Timeout)
+			}
+			if config.RecordType == "MX" {
+				t.Error("Expected the command's own record type to be kept")
+			}
+		})
+	}
+
+	config, err := parser.Parse([]string{CommandMailCheck, "-s", "8.8.8.8", "example.com"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Server != "8.8.8.8" || config.Source("server") != SourceCommandLine {
+		t.Errorf("Expected -s to override the default, got %q (%s)", config.Server, config.Source("server"))
+	}
+}
+
+func TestCLIParser_Parse_CommandsWithoutDefaults(t *testing.T) {
+	// Commands that send no queries do not read the defaults, so a broken file does not stop them
+	parser, _ := testParser(t, "server 1.1.1.1\n", map[string]string{"GODIG_TYPE": "SRV"})
+
+	for _, args := range [][]string{
+		{CommandServe, "example.com.zone"},
+		{CommandReadPcap, "capture.pcap"},
+		{CommandDecode, "-in", "hex", "0a0b"},
+	} {
+		t.Run(args[0], func(t *testing.T) {
+			if _, err := parser.Parse(args); err != nil {
+				t.Errorf("Parse() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_PrintConfig(t *testing.T) {
+	parser, path := testParser(t, "server = 1.1.1.1\n", map[string]string{"GODIG_TIMEOUT": "7s"})
+
+	config, err := parser.Parse([]string{"--print-config", "+idnout"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v, want nil without a domain", err)
+	}
+	if !config.PrintConfig {
+		t.Fatal("Expected PrintConfig to be set")
+	}
+
+	printed := FormatConfig(config)
+	expectedLines := []string{
+		";; Effective configuration (config file: " + path + ")",
+		"server   1.1.1.1              ; " + path + ":1",
+		"type     A                    ; default",
+		"port     53                   ; default",
+		"timeout  7s                   ; GODIG_TIMEOUT",
+		"tries    1                    ; default",
+		"output   text                 ; default",
+		"idnout   true                 ; command line",
+		"follow   false                ; default",
+		"tcp      false                ; default",
+		"dnssec   false                ; default",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(printed, line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, printed)
+		}
+	}
+
+	// A domain is still accepted and validated alongside --print-config
+	if _, err := parser.Parse([]string{"--print-config", "bad..domain"}); err == nil {
+		t.Error("Expected an invalid domain to be rejected")
+	}
+}
@@ -3,19 +3,143 @@ package cmd
 import (
 "flag"
 "fmt"
+"net"
 "os"
+"strconv"
 "strings"
 "time"
 
+"go-dig/pkg/dns"
 "go-dig/pkg/errors"
+"go-dig/pkg/idn"
+"go-dig/pkg/output"
+"go-dig/pkg/watch"
+"go-dig/pkg/wire"
+
+mdns "github.com/miekg/dns"
+)
+
+// identityNames are the CHAOS TXT names that +identify asks for, which name
+// the server software and the instance that answered
+var identityNames = []string{"version.bind", "hostname.bind", "id.server"}
+
+// Commands selectable as the first command-line argument
+const (
+CommandPropagation     = "propagation"
+CommandCheckDelegation = "check-delegation"
+CommandMailCheck       = "mailcheck"
+CommandShell           = "shell" // Also selected with -i
+CommandServe           = "serve"
+CommandReadPcap        = "read-pcap"
+CommandDecode          = "decode"
+CommandEncode          = "encode"
+)
+
+// Colour settings of Config.Color; ColorAuto colours output to a terminal
+// unless NO_COLOR is set
+const (
+ColorAuto   = ""
+ColorAlways = "always"
+ColorNever  = "never"
 )
 
 // Config holds the parsed command-line configuration
 type Config struct {
+Command    string // Empty for a plain query; CommandShell for the interactive shell
 Domain     string
 RecordType string
 Server     string
 Timeout    time.Duration
+Tries      int  // Attempts per query when the server does not answer in time
+Port       int  // Server port; 0 keeps the port of the server address (53 by default)
+IPv4Only   bool // -4: query over IPv4 only
+IPv6Only   bool // -6: query over IPv6 only
+
+// Display settings
+IDNOut       bool   // Show internationalized names as Unicode (U-labels)
+OutputFormat string // output.FormatText, output.FormatJSON or output.FormatZone
+Color        string // ColorAlways or ColorNever from +color or +nocolor; empty detects a terminal
+
+// Resolution settings
+FollowCNAME bool // Chase CNAME chains the server left unresolved
+TCP         bool // Query over TCP, reusing the connection between queries
+DNSSEC      bool // Set the DNSSEC OK bit in queries
+
+// Query class and server identification
+Class    string // Query class name such as CH or CLASS3; empty means IN
+NSID     bool   // Ask for the server's name server identifier (RFC 5001)
+Identify bool   // +identify: ask the server who it is instead of looking up a domain
+
+// Header flags and opcode of queries
+NoRecurse bool // +norecurse: clear the RD bit to query authoritative servers
+CD        bool // +cd: set the checking disabled bit
+AD        bool // +adflag: set the authenticated data bit
+AAOnly    bool // +aaonly: set the authoritative answer bit
+Opcode    int  // +opcode=: query opcode; 0 is QUERY
+
+// DNS cookies (RFC 7873)
+Cookie      bool   // +cookie: send cookies and reuse the server cookies returned
+CookieValue string // +cookie=<hex>: the cookie to send in place of a random one
+
+// Watch mode settings
+Watch    bool
+WatchMin time.Duration
+WatchMax time.Duration
+
+// Propagation check settings
+Expected  []string
+Resolvers []string
+Interval  time.Duration
+Deadline  time.Duration
+
+// Mail check settings
+Selectors []string // DKIM selectors
+
+// Serve settings
+Listen string     // Address and port to answer queries on
+Zones  []ZoneFile // Zone files to serve
+
+// Fixture and capture settings
+RecordFile string // Append every query and response to this fixture file
+ReplayFile string // Answer queries from this fixture file instead of the network
+PcapFile   string // Write the packets of every query to this pcap file
+InputFile  string // Capture read by read-pcap
+
+// Wire message settings
+Encoding  string // Text encoding of messages for decode and encode; see wire.Encoding*
+Message   string // Encoded message given to decode; read from standard input when empty
+MessageID int    // ID of the query written by encode; -1 picks one at random
+
+// Queries holds one configuration per domain when a plain query names more
+// than one; Domain is then empty. Each starts from the options before the
+// first domain and adds the type, @server and +options that follow its own.
+Queries []*Config
+
+// Configuration reporting
+PrintConfig bool              // Show the effective configuration instead of querying
+ConfigFile  string            // Config file the defaults were read from; empty if none
+Sources     map[string]string // Where each setting came from; see Source
+}
+
+// ZoneFile is a zone file given to the serve command. An empty Origin is
+// taken from the file's SOA record.
+type ZoneFile struct {
+Origin string
+Path   string
+}
+
+// stringList is a flag value that collects repeated occurrences of a flag
+type stringList []string
+
+// String returns the collected values joined by commas
+func (l *stringList) String() string {
+return strings.Join(*l, ",")
+}
+
+// Set appends a value to the list
+func (l *stringList) Set(value string) error {
+*l = append(*l, value)
+return nil
 }
 
 // Parser interface defines the contract for CLI argument parsing
@@ -25,7 +149,9 @@ ShowUsage()
 }
 
 // CLIParser implements the Parser interface
-type CLIParser struct{}
+type CLIParser struct {
+lookupEnv func(string) (string, bool) // Environment lookup; os.LookupEnv when nil
+}
 
 // NewCLIParser creates a new CLI parser instance
 func NewCLIParser() Parser {
@@ -34,28 +160,494 @@ return &CLIParser{}
 
 // Parse parses command-line arguments and returns a Config struct
 func (p *CLIParser) Parse(args []string) (*Config, error) {
+// Serve, read-pcap and decode send no queries, so they use none of the defaults
+if len(args) > 0 {
+switch args[0] {
+case CommandServe:
+return p.parseServe(args[1:])
+case CommandReadPcap:
+return p.parseReadPcap(args[1:])
+case CommandDecode:
+return p.parseDecode(args[1:])
+}
+}
+
+// Defaults from the config file and environment sit beneath the command line
+defaults, err := p.loadDefaults()
+if err != nil {
+return nil, err
+}
+
+interactive := false
+if len(args) > 0 {
+switch args[0] {
+case CommandPropagation:
+return p.parsePropagation(args[1:], defaults)
+case CommandCheckDelegation:
+return p.parseCheckDelegation(args[1:], defaults)
+case CommandMailCheck:
+return p.parseMailCheck(args[1:], defaults)
+case CommandEncode:
+return p.parseEncode(args[1:], defaults)
+case CommandShell:
+// The shell takes the same options as a plain query
+interactive = true
+args = args[1:]
+}
+}
+
 config := &Config{
-RecordType: "A",             // Default record type
-Timeout:    5 * time.Second, // Default timeout
+RecordType:   "A",             // Default record type
+Timeout:      5 * time.Second, // Default timeout
+Tries:        1,
+OutputFormat: output.FormatText,
+WatchMin:     watch.DefaultMinInterval,
+WatchMax:     watch.DefaultMaxInterval,
+}
+if err := defaults.apply(config, settingNames...); err != nil {
+return nil, err
 }
 
 // Create a new flag set for each parse operation to avoid conflicts
 flagSet := flag.NewFlagSet("go-dig", flag.ContinueOnError)
 
 // Define flags
-recordType := flagSet.String("t", "A", "DNS record type (A, AAAA, MX, CNAME, TXT)")
-server := flagSet.String("s", "", "DNS server to use (IP address)")
+recordType := flagSet.String("t", config.RecordType, "DNS record type (A, AAAA, MX, CNAME, TXT, NS)")
+class := flagSet.String("c", "", "Query class (IN, CH, HS, ANY or a number)")
+server := flagSet.String("s", config.Server, "DNS server to use (IP address)")
+outputFormat := flagSet.String("o", config.OutputFormat, "Output format (text, json, zone, csv, tsv)")
+printConfig := flagSet.Bool("print-config", false, "Show the effective configuration and where each value came from")
+port := flagSet.String("p", "", "DNS server port")
+flagSet.BoolVar(&config.IPv4Only, "4", false, "Query over IPv4 only")
+flagSet.BoolVar(&config.IPv6Only, "6", false, "Query over IPv6 only")
+flagSet.BoolVar(&interactive, "i", interactive, "Start the interactive shell")
+trafficFlags(flagSet, config)
 
 // Suppress default error output from flag package
 flagSet.SetOutput(os.Stderr)
 
-// Parse flags
-err := flagSet.Parse(args)
+// Parse flags, which end at the first domain name. @server and +options
+// before it apply to every query; a type or class there is the domain, as
+// in "go-dig in", so -t and -c give those for every query.
+var globalArgs []string
+for {
+err = flagSet.Parse(args)
+if err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+args = flagSet.Args()
+if len(args) == 0 || !isOptionArg(args[0]) {
+break
+}
+globalArgs = append(globalArgs, args[0])
+args = args[1:]
+}
+
+config.RecordType = strings.ToUpper(*recordType)
+config.Server = *server
+config.OutputFormat = strings.ToLower(*outputFormat)
+config.PrintConfig = *printConfig
+
+switch config.OutputFormat {
+case output.FormatText, output.FormatJSON, output.FormatZone, output.FormatCSV, output.FormatTSV:
+default:
+return nil, errors.NewInputError(fmt.Sprintf("unsupported output format '%s' (expected text, json, zone, csv or tsv)", *outputFormat), nil)
+}
+
+// Check which flags were explicitly provided; they override the defaults
+serverFlagProvided := false
+flagSet.Visit(func(f *flag.Flag) {
+switch f.Name {
+case "s":
+serverFlagProvided = true
+config.setSource("server", SourceCommandLine)
+case "t":
+config.setSource("type", SourceCommandLine)
+case "o":
+config.setSource("output", SourceCommandLine)
+case "p":
+config.setSource("port", SourceCommandLine)
+}
+})
+
+if *port != "" {
+if err := errors.ValidateDNSPort(*port); err != nil {
+return nil, err
+}
+config.Port, _ = strconv.Atoi(*port)
+}
+if config.IPv4Only && config.IPv6Only {
+return nil, errors.NewInputError("options -4 and -6 cannot be used together", nil)
+}
+if *class != "" {
+if err := setClass(config, *class); err != nil {
+return nil, err
+}
+}
+if err := p.applyQueryArgs(config, globalArgs, SourceCommandLine); err != nil {
+return nil, err
+}
+
+// The remaining arguments are the domains, each with its own arguments
+remaining := args
+if interactive {
+config.Command = CommandShell
+if len(remaining) > 0 {
+return nil, errors.NewInputError(fmt.Sprintf("the shell takes no domain name, got '%s'; look names up at the prompt", remaining[0]), nil)
+}
+if config.Watch {
+return nil, errors.NewInputError("+watch cannot be used in the shell", nil)
+}
+if config.Identify {
+return nil, errors.NewInputError("+identify cannot be used in the shell", nil)
+}
+if err := p.validateConfig(config, serverFlagProvided); err != nil {
+return nil, err
+}
+return config, nil
+}
+if len(remaining) == 0 && config.PrintConfig {
+return config, nil
+}
+if config.Identify {
+return p.identifyQueries(config, remaining, serverFlagProvided)
+}
+if len(remaining) == 0 {
+return nil, errors.NewInputError("domain name is required", nil)
+}
+
+specs, err := splitQueries(remaining)
+if err != nil {
+return nil, err
+}
+queries := make([]*Config, 0, len(specs))
+for _, spec := range specs {
+query := config.clone()
+query.Domain = spec[0]
+if err := p.applyQueryArgs(query, spec[1:], SourceCommandLine); err != nil {
+return nil, err
+}
+if query.Identify {
+return nil, errors.NewInputError("+identify takes no domain name; give it before any other arguments", nil)
+}
+// Validate inputs using the new error handling
+if err := p.validateConfig(query, serverFlagProvided); err != nil {
+return nil, err
+}
+queries = append(queries, query)
+}
+
+// A single domain is the plain query it always was
+if len(queries) == 1 {
+return queries[0], nil
+}
+for _, query := range queries {
+if query.Watch {
+return nil, errors.NewInputError("+watch takes a single domain name", nil)
+}
+}
+config.Queries = queries
+return config, nil
+}
+
+// identifyQueries returns the configuration for +identify: a CHAOS TXT
+// query for each of identityNames, all asking for the NSID
+func (p *CLIParser) identifyQueries(config *Config, remaining []string, serverFlagProvided bool) (*Config, error) {
+if len(remaining) > 0 {
+return nil, errors.NewInputError(fmt.Sprintf("+identify takes no domain name, got '%s'", remaining[0]), nil)
+}
+if config.Watch {
+return nil, errors.NewInputError("+watch cannot be used with +identify", nil)
+}
+
+for _, name := range identityNames {
+query := config.clone()
+query.Domain = name
+query.RecordType = "TXT"
+query.Class = "CH"
+query.NSID = true
+if err := p.validateConfig(query, serverFlagProvided); err != nil {
+return nil, err
+}
+config.Queries = append(config.Queries, query)
+}
+return config, nil
+}
+
+// splitQueries splits the arguments after the flags into query
+// specifications, each a domain name followed by the arguments that belong
+// to it
+func splitQueries(args []string) ([][]string, error) {
+var specs [][]string
+for _, arg := range args {
+switch {
+case strings.HasPrefix(arg, "-"):
+return nil, errors.NewInputError(fmt.Sprintf("too many arguments: flag '%s' must come before the first domain name", arg), nil)
+case len(specs) > 0 && isQueryArg(arg):
+specs[len(specs)-1] = append(specs[len(specs)-1], arg)
+default:
+specs = append(specs, []string{arg})
+}
+}
+return specs, nil
+}
+
+// isQueryArg reports whether a command-line argument that follows a domain
+// modifies its query rather than naming another domain: a record type or
+// class, @server or +option. Type names are recognized even when not
+// supported, so that they are reported as such instead of being looked up
+// as domains.
+func isQueryArg(arg string) bool {
+if isOptionArg(arg) {
+return true
+}
+return isTypeName(arg) || isClass(arg)
+}
+
+// isTypeName reports whether arg names a record type, supported or not
+func isTypeName(arg string) bool {
+_, ok := mdns.StringToType[strings.ToUpper(arg)]
+return ok
+}
+
+// isOptionArg reports whether a command-line argument is @server or a
+// +option, which can never be a domain name
+func isOptionArg(arg string) bool {
+return strings.HasPrefix(arg, "@") || strings.HasPrefix(arg, "+")
+}
+
+// isClass reports whether arg names a query class, in the forms accepted
+// after a domain name: a class name or CLASSnnn, but not a bare number or a
+// name that is also a record type. ANY after a domain is the type, as in
+// dig; the ANY class is given with -c.
+func isClass(arg string) bool {
+if _, err := strconv.Atoi(arg); err == nil || isTypeName(arg) {
+return false
+}
+_, err := dns.ParseClass(arg)
+return err == nil
+}
+
+// setClass sets the query class given by name or number, keeping it in the
+// form the server will show
+func setClass(config *Config, class string) error {
+code, err := dns.ParseClass(class)
 if err != nil {
+return err
+}
+config.Class = mdns.Class(code).String()
+return nil
+}
+
+// applyQueryArgs applies the arguments that may follow a domain name: a
+// record type, class, @server and +options
+func (p *CLIParser) applyQueryArgs(config *Config, args []string, source string) error {
+var plusOptions []string
+for _, arg := range args {
+switch {
+case strings.HasPrefix(arg, "@"):
+server := strings.TrimPrefix(arg, "@")
+if err := errors.ValidateDNSServer(server); err != nil {
+return err
+}
+config.Server = server
+config.setSource("server", source)
+case strings.HasPrefix(arg, "+"):
+plusOptions = append(plusOptions, arg)
+case isClass(arg):
+if err := setClass(config, arg); err != nil {
+return err
+}
+default:
+if err := errors.ValidateRecordType(arg); err != nil {
+return errors.NewInputError(fmt.Sprintf("unexpected argument '%s' after the name", arg), err)
+}
+config.RecordType = strings.ToUpper(arg)
+config.setSource("type", source)
+}
+}
+return p.applyPlusOptions(config, plusOptions, source)
+}
+
+// splitPlusOptions separates dig-style +options from the remaining arguments
+func splitPlusOptions(args []string) ([]string, []string) {
+var plusOptions, rest []string
+for _, arg := range args {
+if strings.HasPrefix(arg, "+") {
+plusOptions = append(plusOptions, arg)
+} else {
+rest = append(rest, arg)
+}
+}
+return plusOptions, rest
+}
+
+// applyPlusOptions applies +options in order, recording source for the
+// settings they override
+func (p *CLIParser) applyPlusOptions(config *Config, options []string, source string) error {
+for _, option := range options {
+if err := p.applyPlusOption(config, option); err != nil {
+return err
+}
+name, _, _ := strings.Cut(strings.ToLower(strings.TrimPrefix(option, "+")), "=")
+if name, ok := plusSettings[name]; ok {
+config.setSource(name, source)
+}
+}
+return nil
+}
+
+// applyPlusOption applies a single +option such as +watch or +watch-min=10s
+func (p *CLIParser) applyPlusOption(config *Config, option string) error {
+name, value, hasValue := strings.Cut(strings.TrimPrefix(option, "+"), "=")
+
+switch strings.ToLower(name) {
+case "watch":
+config.Watch = true
+case "nowatch":
+config.Watch = false
+case "color":
+config.Color = ColorAlways
+case "nocolor":
+config.Color = ColorNever
+case "idnout":
+config.IDNOut = true
+case "noidnout":
+config.IDNOut = false
+case "follow":
+config.FollowCNAME = true
+case "nofollow":
+config.FollowCNAME = false
+case "tcp":
+config.TCP = true
+case "notcp":
+config.TCP = false
+case "dnssec":
+config.DNSSEC = true
+case "nodnssec":
+config.DNSSEC = false
+case "nsid":
+config.NSID = true
+case "nonsid":
+config.NSID = false
+case "identify":
+config.Identify = true
+case "recurse":
+config.NoRecurse = false
+case "norecurse":
+config.NoRecurse = true
+case "cd", "cdflag":
+config.CD = true
+case "nocd", "nocdflag":
+config.CD = false
+case "adflag":
+config.AD = true
+case "noadflag":
+config.AD = false
+case "aaonly", "aaflag":
+config.AAOnly = true
+case "noaaonly", "noaaflag":
+config.AAOnly = false
+case "cookie":
+if hasValue {
+if err := dns.ValidateCookie(value); err != nil {
+return err
+}
+}
+config.Cookie = true
+config.CookieValue = value
+case "nocookie":
+config.Cookie = false
+config.CookieValue = ""
+case "opcode":
+if !hasValue {
+return errors.NewInputError("option '+opcode' requires an opcode (e.g. +opcode=NOTIFY)", nil)
+}
+opcode, err := dns.ParseOpcode(value)
+if err != nil {
+return err
+}
+config.Opcode = opcode
+case "time":
+if !hasValue {
+return errors.NewInputError("option '+time' requires a number of seconds (e.g. +time=2)", nil)
+}
+if err := errors.ValidateQueryTime(value); err != nil {
+return err
+}
+seconds, _ := strconv.Atoi(value)
+config.Timeout = time.Duration(seconds) * time.Second
+case "tries", "retry":
+if !hasValue {
+return errors.NewInputError(fmt.Sprintf("option '+%s' requires a number (e.g. +%s=3)", name, name), nil)
+}
+validate := errors.ValidateTries
+if strings.ToLower(name) == "retry" {
+validate = errors.ValidateRetries
+}
+if err := validate(value); err != nil {
+return err
+}
+count, _ := strconv.Atoi(value)
+if strings.ToLower(name) == "retry" {
+count++ // Retries follow the first attempt
+}
+config.Tries = count
+case "watch-min", "watch-max":
+if !hasValue {
+return errors.NewInputError(fmt.Sprintf("option '+%s' requires a duration value (e.g. +%s=30s)", name, name), nil)
+}
+duration, err := time.ParseDuration(value)
+if err != nil || duration <= 0 {
+return errors.NewInputError(fmt.Sprintf("invalid duration '%s' for option '+%s'", value, name), err)
+}
+if strings.ToLower(name) == "watch-min" {
+config.WatchMin = duration
+} else {
+config.WatchMax = duration
+}
+default:
+return errors.NewInputError(fmt.Sprintf("unknown option '%s'", option), nil)
+}
+
+return nil
+}
+
+// trafficFlags defines the -record, -replay and -pcap flags of the commands
+// that send queries
+func trafficFlags(flagSet *flag.FlagSet, config *Config) {
+flagSet.StringVar(&config.RecordFile, "record", "", "Append every query and response to this fixture file")
+flagSet.StringVar(&config.ReplayFile, "replay", "", "Answer queries from this fixture file instead of the network")
+flagSet.StringVar(&config.PcapFile, "pcap", "", "Write the packets of every query to this pcap file")
+}
+
+// parsePropagation parses the arguments of the propagation command
+func (p *CLIParser) parsePropagation(args []string, defaults *defaults) (*Config, error) {
+config := &Config{
+Command: CommandPropagation,
+Timeout: 5 * time.Second, // Default timeout
+}
+if err := defaults.apply(config, "server", "timeout", "tries", "idnout"); err != nil {
+return nil, err
+}
+
+flagSet := flag.NewFlagSet("go-dig propagation", flag.ContinueOnError)
+
+var expected stringList
+recordType := flagSet.String("t", "A", "DNS record type (A, AAAA, MX, CNAME, TXT, NS)")
+server := flagSet.String("s", config.Server, "DNS server used to discover the zone's nameservers")
+resolvers := flagSet.String("resolvers", "", "Comma-separated list of recursive resolvers to poll")
+flagSet.Var(&expected, "expect", "Expected record value (repeat for multiple values)")
+flagSet.DurationVar(&config.Interval, "interval", 30*time.Second, "Delay between polling rounds")
+flagSet.DurationVar(&config.Deadline, "deadline", 10*time.Minute, "Give up after this long")
+trafficFlags(flagSet, config)
+
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
 return nil, errors.NewInputError("invalid command line arguments", err)
 }
 
-// Get remaining arguments (should be the domain)
 remaining := flagSet.Args()
 if len(remaining) == 0 {
 return nil, errors.NewInputError("domain name is required", nil)
@@ -67,16 +659,90 @@ return nil, errors.NewInputError(fmt.Sprintf("too many arguments: expected domai
 config.Domain = remaining[0]
 config.RecordType = strings.ToUpper(*recordType)
 config.Server = *server
+config.Expected = expected
+
+if len(config.Expected) == 0 {
+return nil, errors.NewInputError("at least one -expect value is required", nil)
+}
+if config.Interval <= 0 {
+return nil, errors.NewInputError("polling interval must be positive", nil)
+}
+if config.Deadline < config.Interval {
+return nil, errors.NewInputError("deadline must not be shorter than the polling interval", nil)
+}
+
+serverFlagProvided := false
+resolversFlagProvided := false
+flagSet.Visit(func(f *flag.Flag) {
+switch f.Name {
+case "s":
+serverFlagProvided = true
+config.setSource("server", SourceCommandLine)
+case "resolvers":
+resolversFlagProvided = true
+}
+})
+
+if resolversFlagProvided {
+config.Resolvers = []string{}
+for _, resolver := range strings.Split(*resolvers, ",") {
+resolver = strings.TrimSpace(resolver)
+if resolver == "" {
+continue
+}
+if err := errors.ValidateDNSServer(resolver); err != nil {
+return nil, err
+}
+config.Resolvers = append(config.Resolvers, resolver)
+}
+}
+
+if err := p.validateConfig(config, serverFlagProvided); err != nil {
+return nil, err
+}
+
+return config, nil
+}
+
+// parseCheckDelegation parses the arguments of the check-delegation command
+func (p *CLIParser) parseCheckDelegation(args []string, defaults *defaults) (*Config, error) {
+config := &Config{
+Command:    CommandCheckDelegation,
+RecordType: "NS",
+Timeout:    5 * time.Second, // Default timeout
+}
+if err := defaults.apply(config, "server", "timeout", "tries", "idnout"); err != nil {
+return nil, err
+}
+
+flagSet := flag.NewFlagSet("go-dig check-delegation", flag.ContinueOnError)
+server := flagSet.String("s", config.Server, "DNS server used for recursive lookups")
+trafficFlags(flagSet, config)
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+
+remaining := flagSet.Args()
+if len(remaining) == 0 {
+return nil, errors.NewInputError("zone name is required", nil)
+}
+if len(remaining) > 1 {
+return nil, errors.NewInputError(fmt.Sprintf("too many arguments: expected zone name only, got %d arguments", len(remaining)), nil)
+}
+
+config.Domain = strings.TrimSuffix(remaining[0], ".")
+config.Server = *server
 
-// Check if -s flag was explicitly provided
 serverFlagProvided := false
 flagSet.Visit(func(f *flag.Flag) {
 if f.Name == "s" {
 serverFlagProvided = true
+config.setSource("server", SourceCommandLine)
 }
 })
 
-// Validate inputs using the new error handling
 if err := p.validateConfig(config, serverFlagProvided); err != nil {
 return nil, err
 }
@@ -84,13 +750,264 @@ return nil, err
 return config, nil
 }
 
+// parseMailCheck parses the arguments of the mailcheck command
+func (p *CLIParser) parseMailCheck(args []string, defaults *defaults) (*Config, error) {
+config := &Config{
+Command:    CommandMailCheck,
+RecordType: "TXT",
+Timeout:    5 * time.Second, // Default timeout
+}
+if err := defaults.apply(config, "server", "timeout", "tries", "idnout"); err != nil {
+return nil, err
+}
+
+flagSet := flag.NewFlagSet("go-dig mailcheck", flag.ContinueOnError)
+server := flagSet.String("s", config.Server, "DNS server to use (IP address)")
+selectors := flagSet.String("selectors", "", "Comma-separated list of DKIM selectors to check")
+trafficFlags(flagSet, config)
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+
+remaining := flagSet.Args()
+if len(remaining) == 0 {
+return nil, errors.NewInputError("domain name is required", nil)
+}
+if len(remaining) > 1 {
+return nil, errors.NewInputError(fmt.Sprintf("too many arguments: expected domain name only, got %d arguments", len(remaining)), nil)
+}
+
+config.Domain = strings.TrimSuffix(remaining[0], ".")
+config.Server = *server
+
+for _, selector := range strings.Split(*selectors, ",") {
+selector = strings.TrimSpace(selector)
+if selector == "" {
+continue
+}
+if err := errors.ValidateDomain(selector); err != nil {
+return nil, errors.NewInputError(fmt.Sprintf("invalid DKIM selector '%s'", selector), err)
+}
+selector, _ = idn.ToASCII(selector)
+config.Selectors = append(config.Selectors, selector)
+}
+
+serverFlagProvided := false
+flagSet.Visit(func(f *flag.Flag) {
+if f.Name == "s" {
+serverFlagProvided = true
+config.setSource("server", SourceCommandLine)
+}
+})
+
+if err := p.validateConfig(config, serverFlagProvided); err != nil {
+return nil, err
+}
+
+return config, nil
+}
+
+// parseServe parses the arguments of the serve command: zone files, each
+// optionally prefixed with the origin to load it under (origin=path)
+func (p *CLIParser) parseServe(args []string) (*Config, error) {
+config := &Config{Command: CommandServe}
+
+flagSet := flag.NewFlagSet("go-dig serve", flag.ContinueOnError)
+address := flagSet.String("listen", "127.0.0.1", "IP address to listen on")
+port := flagSet.String("p", "53", "Port to listen on over UDP and TCP (0 picks a free port)")
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+
+if net.ParseIP(*address) == nil {
+return nil, errors.NewInputError(fmt.Sprintf("listen address '%s' is not an IP address", *address), nil)
+}
+// Port 0 lets the system pick a free port, which suits test harnesses
+if *port != "0" {
+if err := errors.ValidateDNSPort(*port); err != nil {
+return nil, err
+}
+}
+config.Listen = net.JoinHostPort(*address, *port)
+
+remaining := flagSet.Args()
+if len(remaining) == 0 {
+return nil, errors.NewInputError("at least one zone file is required", nil)
+}
+for _, arg := range remaining {
+zone := ZoneFile{Path: arg}
+if origin, path, found := strings.Cut(arg, "="); found {
+if err := errors.ValidateDomain(strings.TrimSuffix(origin, ".")); err != nil {
+return nil, errors.NewInputError(fmt.Sprintf("invalid zone origin '%s'", origin), err)
+}
+zone = ZoneFile{Origin: origin, Path: path}
+}
+if zone.Path == "" {
+return nil, errors.NewInputError(fmt.Sprintf("zone file path missing in '%s'", arg), nil)
+}
+config.Zones = append(config.Zones, zone)
+}
+
+return config, nil
+}
+
+// parseReadPcap parses the arguments of the read-pcap command
+func (p *CLIParser) parseReadPcap(args []string) (*Config, error) {
+config := &Config{Command: CommandReadPcap, Port: 53}
+
+flagSet := flag.NewFlagSet("go-dig read-pcap", flag.ContinueOnError)
+outputFormat := flagSet.String("o", output.FormatText, "Output format (text, json)")
+port := flagSet.String("p", "53", "Port of the DNS traffic to decode")
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+
+config.OutputFormat = strings.ToLower(*outputFormat)
+switch config.OutputFormat {
+case output.FormatText, output.FormatJSON:
+default:
+return nil, errors.NewInputError(fmt.Sprintf("unsupported output format '%s' (expected text or json)", *outputFormat), nil)
+}
+if err := errors.ValidateDNSPort(*port); err != nil {
+return nil, err
+}
+config.Port, _ = strconv.Atoi(*port)
+
+remaining := flagSet.Args()
+if len(remaining) == 0 {
+return nil, errors.NewInputError("capture file is required", nil)
+}
+if len(remaining) > 1 {
+return nil, errors.NewInputError(fmt.Sprintf("too many arguments: expected capture file only, got %d arguments", len(remaining)), nil)
+}
+config.InputFile = remaining[0]
+
+return config, nil
+}
+
+// parseDecode parses the arguments of the decode command: an encoded message,
+// or none to read it from standard input
+func (p *CLIParser) parseDecode(args []string) (*Config, error) {
+config := &Config{Command: CommandDecode}
+
+flagSet := flag.NewFlagSet("go-dig decode", flag.ContinueOnError)
+outputFormat := flagSet.String("o", output.FormatText, "Output format (text, json)")
+encoding := flagSet.String("in", wire.EncodingAuto, "Encoding of the message (auto, hex, base64, raw)")
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+
+config.OutputFormat = strings.ToLower(*outputFormat)
+switch config.OutputFormat {
+case output.FormatText, output.FormatJSON:
+default:
+return nil, errors.NewInputError(fmt.Sprintf("unsupported output format '%s' (expected text or json)", *outputFormat), nil)
+}
+config.Encoding = strings.ToLower(*encoding)
+switch config.Encoding {
+case wire.EncodingAuto, wire.EncodingHex, wire.EncodingBase64, wire.EncodingBase64URL, wire.EncodingRaw:
+default:
+return nil, errors.NewInputError(fmt.Sprintf("unsupported message encoding '%s' (expected auto, hex, base64 or raw)", *encoding), nil)
+}
+
+remaining := flagSet.Args()
+if len(remaining) > 1 {
+return nil, errors.NewInputError(fmt.Sprintf("too many arguments: expected one encoded message, got %d arguments", len(remaining)), nil)
+}
+if len(remaining) == 1 {
+config.Message = remaining[0]
+}
+
+return config, nil
+}
+
+// parseEncode parses the arguments of the encode command, which takes the
+// record type and +dnssec like a plain query
+func (p *CLIParser) parseEncode(args []string, defaults *defaults) (*Config, error) {
+config := &Config{Command: CommandEncode, RecordType: "A", MessageID: -1}
+if err := defaults.apply(config, "type", "dnssec"); err != nil {
+return nil, err
+}
+
+plusOptions, args := splitPlusOptions(args)
+for _, option := range plusOptions {
+name, _, _ := strings.Cut(strings.ToLower(option), "=")
+switch name {
+case "+dnssec", "+nodnssec", "+nsid", "+nonsid", "+recurse", "+norecurse", "+cd", "+nocd", "+cdflag", "+nocdflag",
+"+adflag", "+noadflag", "+aaonly", "+noaaonly", "+aaflag", "+noaaflag", "+opcode", "+cookie", "+nocookie":
+default:
+return nil, errors.NewInputError(fmt.Sprintf("option '%s' does not change the encoded query (expected +[no]dnssec, +[no]nsid, a header flag or +opcode=)", option), nil)
+}
+}
+if err := p.applyPlusOptions(config, plusOptions, SourceCommandLine); err != nil {
+return nil, err
+}
+
+flagSet := flag.NewFlagSet("go-dig encode", flag.ContinueOnError)
+recordType := flagSet.String("t", config.RecordType, "DNS record type (A, AAAA, MX, CNAME, TXT, NS)")
+class := flagSet.String("c", "", "Query class (IN, CH, HS, ANY or a number)")
+encoding := flagSet.String("o", wire.EncodingHex, "Encoding of the message (hex, base64, base64url, raw)")
+id := flagSet.Int("id", -1, "Message ID (0-65535) [default: random]")
+flagSet.SetOutput(os.Stderr)
+
+if err := flagSet.Parse(args); err != nil {
+return nil, errors.NewInputError("invalid command line arguments", err)
+}
+
+config.RecordType = strings.ToUpper(*recordType)
+if *class != "" {
+if err := setClass(config, *class); err != nil {
+return nil, err
+}
+}
+config.Encoding = strings.ToLower(*encoding)
+switch config.Encoding {
+case wire.EncodingHex, wire.EncodingBase64, wire.EncodingBase64URL, wire.EncodingRaw:
+default:
+return nil, errors.NewInputError(fmt.Sprintf("unsupported message encoding '%s' (expected hex, base64, base64url or raw)", *encoding), nil)
+}
+if *id < -1 || *id > 65535 {
+return nil, errors.NewInputError(fmt.Sprintf("message ID %d out of range (expected 0-65535)", *id), nil)
+}
+config.MessageID = *id
+
+remaining := flagSet.Args()
+if len(remaining) == 0 {
+return nil, errors.NewInputError("domain name is required", nil)
+}
+if len(remaining) > 1 {
+return nil, errors.NewInputError(fmt.Sprintf("too many arguments: expected domain name only, got %d arguments", len(remaining)), nil)
+}
+config.Domain = remaining[0]
+
+if err := p.validateConfig(config, false); err != nil {
+return nil, err
+}
+
+return config, nil
+}
+
 // validateConfig validates the parsed configuration
 func (p *CLIParser) validateConfig(config *Config, serverFlagProvided bool) error {
+// The shell reads its domain names at the prompt
+if config.Command != CommandShell {
 // Validate domain name using the new error handling
 if err := errors.ValidateDomain(config.Domain); err != nil {
 return err
 }
 
+// Internationalized names are sent as A-labels; +idnout converts them back for display
+config.Domain, _ = idn.ToASCII(config.Domain)
+}
+
 // Validate record type using the new error handling
 if err := errors.ValidateRecordType(config.RecordType); err != nil {
 return err
@@ -103,20 +1020,125 @@ return err
 }
 }
 
+// The server must match the address family selected with -4 or -6
+if config.Server != "" {
+if err := errors.ValidateAddressFamily(config.Server, config.IPv4Only, config.IPv6Only); err != nil {
+return err
+}
+}
+
+// Replayed answers would only be recorded again
+if config.RecordFile != "" && config.ReplayFile != "" {
+return errors.NewInputError("options -record and -replay cannot be used together", nil)
+}
+if config.PcapFile != "" && config.ReplayFile != "" {
+return errors.NewInputError("options -pcap and -replay cannot be used together: replayed queries send no packets", nil)
+}
+
+// Validate watch interval bounds
+if config.Watch && config.WatchMin > config.WatchMax {
+return errors.NewInputError(fmt.Sprintf("+watch-min (%v) cannot be greater than +watch-max (%v)", config.WatchMin, config.WatchMax), nil)
+}
+
 return nil
 }
 
+// usageExamples are the command lines shown at the end of the usage text
+var usageExamples = []string{
+"go-dig google.com",
+"go-dig -t AAAA google.com",
+"go-dig google.com AAAA @8.8.8.8",
+"go-dig -t MX -s 1.1.1.1 google.com",
+"go-dig google.com +watch",
+"go-dig example.com example.org MX @1.1.1.1 example.net +tcp",
+"go-dig münchen.de +idnout",
+"go-dig -o json www.example.com +follow",
+"go-dig propagation -expect 192.0.2.10 www.example.com",
+"go-dig check-delegation example.com",
+"go-dig mailcheck -selectors google example.com",
+"go-dig shell -s 1.1.1.1 +tcp",
+"go-dig @1.1.1.1 +identify",
+"go-dig mailcheck -record incident.jsonl example.com",
+"go-dig -pcap lookup.pcap example.com",
+"go-dig read-pcap -o json capture.pcapng",
+"go-dig encode -t AAAA -o base64url example.com",
+"echo 'https://doh.example/dns-query?dns=AAABAAAB...' | go-dig decode",
+"go-dig serve -p 5353 example.com.zone",
+}
+
 // ShowUsage displays usage information
 func (p *CLIParser) ShowUsage() {
-fmt.Fprintf(os.Stderr, "Usage: go-dig <domain> [options]\n\n")
+fmt.Fprintf(os.Stderr, "Usage: go-dig <domain> [options]\n")
+fmt.Fprintf(os.Stderr, "       go-dig [options] <domain> [type] [@server] [+option...] <domain> ...\n")
+fmt.Fprintf(os.Stderr, "       go-dig propagation -expect <value> [options] <domain>\n")
+fmt.Fprintf(os.Stderr, "       go-dig check-delegation [-s <server>] <zone>\n")
+fmt.Fprintf(os.Stderr, "       go-dig mailcheck [-s <server>] [-selectors <list>] <domain>\n")
+fmt.Fprintf(os.Stderr, "       go-dig shell [options]   (or go-dig -i [options])\n")
+fmt.Fprintf(os.Stderr, "       go-dig serve [-listen <address>] [-p <port>] [origin=]<zonefile>...\n")
+fmt.Fprintf(os.Stderr, "       go-dig read-pcap [-o <format>] [-p <port>] <capture>\n")
+fmt.Fprintf(os.Stderr, "       go-dig decode [-o <format>] [-in <encoding>] [<message>]\n")
+fmt.Fprintf(os.Stderr, "       go-dig encode [-t <type>] [-c <class>] [-o <encoding>] [-id <n>] [+option...] <domain>\n\n")
 fmt.Fprintf(os.Stderr, "Arguments:\n")
-fmt.Fprintf(os.Stderr, "  domain       Domain name to query\n\n")
+fmt.Fprintf(os.Stderr, "  domain       Domain name to query; a type, @server and +options after it apply to it alone\n\n")
 fmt.Fprintf(os.Stderr, "Options:\n")
-fmt.Fprintf(os.Stderr, "  -t <type>    DNS record type (A, AAAA, MX, CNAME, TXT) [default: A]\n")
-fmt.Fprintf(os.Stderr, "  -s <server>  DNS server to use (IP address) [default: system default]\n\n")
+fmt.Fprintf(os.Stderr, "  -t <type>    DNS record type (A, AAAA, MX, CNAME, TXT, NS) [default: A]\n")
+fmt.Fprintf(os.Stderr, "  -s <server>  DNS server to use (IP address) [default: system default]\n")
+fmt.Fprintf(os.Stderr, "  -o <format>  Output format (text, json, zone, csv, tsv) [default: text]\n")
+fmt.Fprintf(os.Stderr, "  -p <port>    DNS server port [default: 53]\n")
+fmt.Fprintf(os.Stderr, "  -4, -6       Query over IPv4 or IPv6 only\n")
+fmt.Fprintf(os.Stderr, "  +time=<seconds>        Query timeout [default: 5]\n")
+fmt.Fprintf(os.Stderr, "  +tries=<n>, +retry=<n> Attempts per query, or retries after the first [default: 1 try]\n")
+fmt.Fprintf(os.Stderr, "  +watch       Re-query when the answer TTL expires and report changes\n")
+fmt.Fprintf(os.Stderr, "  +watch-min=<duration>  Shortest delay between watch queries [default: 5s]\n")
+fmt.Fprintf(os.Stderr, "  +watch-max=<duration>  Longest delay between watch queries [default: 1h]\n")
+fmt.Fprintf(os.Stderr, "  +idnout      Show internationalized names in Unicode instead of xn-- form\n")
+fmt.Fprintf(os.Stderr, "  +[no]color   Colour and align text output [default: on for a terminal unless NO_COLOR is set]\n")
+fmt.Fprintf(os.Stderr, "  +follow      Chase CNAME chains the server did not resolve with further queries\n")
+fmt.Fprintf(os.Stderr, "  +tcp         Query over TCP instead of UDP\n")
+fmt.Fprintf(os.Stderr, "  +dnssec      Set the DNSSEC OK bit in queries\n")
+fmt.Fprintf(os.Stderr, "  -c <class>   Query class (IN, CH, HS, ANY or a number) [default: IN]\n")
+fmt.Fprintf(os.Stderr, "  +nsid        Ask the server for its name server identifier\n")
+fmt.Fprintf(os.Stderr, "  +identify    Ask version.bind, hostname.bind and id.server in CHAOS and the NSID\n")
+fmt.Fprintf(os.Stderr, "  +norecurse   Clear the RD bit to ask a server about its own data only\n")
+fmt.Fprintf(os.Stderr, "  +cd          Set the CD bit so a validating resolver skips DNSSEC checks\n")
+fmt.Fprintf(os.Stderr, "  +adflag      Set the AD bit to ask whether the answer was validated\n")
+fmt.Fprintf(os.Stderr, "  +aaonly      Set the AA bit in queries\n")
+fmt.Fprintf(os.Stderr, "  +opcode=<name>         Query opcode (QUERY, NOTIFY, UPDATE, ... or 0-15) [default: QUERY]\n")
+fmt.Fprintf(os.Stderr, "  +cookie[=<hex>]        Send a DNS cookie and check the server's; reuse server cookies between queries\n")
+fmt.Fprintf(os.Stderr, "  -i           Start the interactive shell (same as the shell command)\n")
+fmt.Fprintf(os.Stderr, "  -record <file>        Append every query and response to a fixture file\n")
+fmt.Fprintf(os.Stderr, "  -replay <file>        Answer queries from a fixture file without network access\n")
+fmt.Fprintf(os.Stderr, "  -pcap <file>          Write the packets of every query to a pcap file\n")
+fmt.Fprintf(os.Stderr, "  --print-config        Show the effective configuration and where each value came from\n\n")
+fmt.Fprintf(os.Stderr, "Defaults for server, port, type, timeout, tries, output, idnout, follow, tcp and dnssec are read from\n")
+fmt.Fprintf(os.Stderr, "~/.godigrc (\"name = value\" lines; $GODIG_CONFIG overrides the path) and from\n")
+fmt.Fprintf(os.Stderr, "GODIG_SERVER, GODIG_TYPE, ... environment variables. Flags take precedence.\n\n")
+fmt.Fprintf(os.Stderr, "Propagation options:\n")
+fmt.Fprintf(os.Stderr, "  -expect <value>       Expected record value (repeat for multiple values)\n")
+fmt.Fprintf(os.Stderr, "  -resolvers <list>     Comma-separated resolvers to poll [default: 8.8.8.8,1.1.1.1,9.9.9.9,208.67.222.222]\n")
+fmt.Fprintf(os.Stderr, "  -interval <duration>  Delay between polling rounds [default: 30s]\n")
+fmt.Fprintf(os.Stderr, "  -deadline <duration>  Give up after this long [default: 10m]\n\n")
+fmt.Fprintf(os.Stderr, "Mailcheck options:\n")
+fmt.Fprintf(os.Stderr, "  -selectors <list>     Comma-separated DKIM selectors to check (e.g. google,selector1)\n\n")
+fmt.Fprintf(os.Stderr, "Serve options:\n")
+fmt.Fprintf(os.Stderr, "  -listen <address>     IP address to listen on [default: 127.0.0.1]\n")
+fmt.Fprintf(os.Stderr, "  -p <port>             Port to listen on over UDP and TCP; 0 picks a free port [default: 53]\n\n")
+fmt.Fprintf(os.Stderr, "Read-pcap options:\n")
+fmt.Fprintf(os.Stderr, "  -o <format>           Output format (text, json) [default: text]\n")
+fmt.Fprintf(os.Stderr, "  -p <port>             Port of the DNS traffic to decode [default: 53]\n\n")
+fmt.Fprintf(os.Stderr, "Decode options (the message is read from standard input when not given):\n")
+fmt.Fprintf(os.Stderr, "  -o <format>           Output format (text, json) [default: text]\n")
+fmt.Fprintf(os.Stderr, "  -in <encoding>        Message encoding (auto, hex, base64, raw); auto also takes DoH URLs [default: auto]\n\n")
+fmt.Fprintf(os.Stderr, "Encode options:\n")
+fmt.Fprintf(os.Stderr, "  -t <type>             DNS record type [default: A]\n")
+fmt.Fprintf(os.Stderr, "  -o <encoding>         Message encoding (hex, base64, base64url, raw) [default: hex]\n")
+fmt.Fprintf(os.Stderr, "  -id <n>               Message ID [default: random]\n")
+fmt.Fprintf(os.Stderr, "  -c <class>            Query class [default: IN]\n")
+fmt.Fprintf(os.Stderr, "  +dnssec, +nsid        Set the DNSSEC OK bit, ask for the NSID\n")
+fmt.Fprintf(os.Stderr, "  +norecurse, +cd, +adflag, +aaonly, +opcode=<name>  Header flags and opcode, as for a query\n")
+fmt.Fprintf(os.Stderr, "  +cookie[=<hex>]       Add a new client cookie, or the given cookie\n\n")
 fmt.Fprintf(os.Stderr, "Examples:\n")
-fmt.Fprintf(os.Stderr, "  go-dig google.com\n")
-fmt.Fprintf(os.Stderr, "  go-dig google.com -t AAAA\n")
-fmt.Fprintf(os.Stderr, "  go-dig google.com -s 8.8.8.8\n")
-fmt.Fprintf(os.Stderr, "  go-dig google.com -t MX -s 1.1.1.1\n")
+for _, example := range usageExamples {
+fmt.Fprintf(os.Stderr, "  %s\n", example)
+}
 }
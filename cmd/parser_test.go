@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"go-dig/pkg/errors"
+	"go-dig/pkg/output"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -113,11 +115,6 @@ func TestCLIParser_Parse_InvalidInputs(t *testing.T) {
 			args:        []string{},
 			expectError: "domain name is required",
 		},
-		{
-			name:        "too many arguments",
-			args:        []string{"google.com", "extra.com"},
-			expectError: "too many arguments",
-		},
 		{
 			name:        "invalid record type",
 			args:        []string{"-t", "INVALID", "google.com"},
@@ -230,7 +227,7 @@ func TestValidateDomain(t *testing.T) {
 }
 
 func TestValidateRecordType(t *testing.T) {
-	validTypes := []string{"A", "AAAA", "MX", "CNAME", "TXT", "a", "aaaa", "mx", "cname", "txt"}
+	validTypes := []string{"A", "AAAA", "MX", "CNAME", "TXT", "NS", "a", "aaaa", "mx", "cname", "txt", "ns"}
 	for _, recordType := range validTypes {
 		t.Run("valid_"+recordType, func(t *testing.T) {
 			err := errors.ValidateRecordType(recordType)
@@ -240,7 +237,7 @@ func TestValidateRecordType(t *testing.T) {
 		})
 	}
 
-	invalidTypes := []string{"", "INVALID", "NS", "SOA", "PTR", "123"}
+	invalidTypes := []string{"", "INVALID", "SOA", "PTR", "123"}
 	for _, recordType := range invalidTypes {
 		t.Run("invalid_"+recordType, func(t *testing.T) {
 			err := errors.ValidateRecordType(recordType)
@@ -324,3 +321,838 @@ func TestCLIParser_EdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestCLIParser_Parse_Propagation(t *testing.T) {
+	parser := NewCLIParser()
+
+	t.Run("defaults", func(t *testing.T) {
+		config, err := parser.Parse([]string{"propagation", "-expect", "192.0.2.10", "www.example.com"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if config.Command != CommandPropagation {
+			t.Errorf("Command = %q, want %q", config.Command, CommandPropagation)
+		}
+		if config.Domain != "www.example.com" || config.RecordType != "A" {
+			t.Errorf("Unexpected query %s %s", config.Domain, config.RecordType)
+		}
+		if len(config.Expected) != 1 || config.Expected[0] != "192.0.2.10" {
+			t.Errorf("Expected = %v, want [192.0.2.10]", config.Expected)
+		}
+		if config.Resolvers != nil {
+			t.Errorf("Resolvers = %v, want nil to select the defaults", config.Resolvers)
+		}
+		if config.Interval != 30*time.Second || config.Deadline != 10*time.Minute {
+			t.Errorf("Unexpected interval %v / deadline %v", config.Interval, config.Deadline)
+		}
+	})
+
+	t.Run("all options", func(t *testing.T) {
+		config, err := parser.Parse([]string{"propagation", "-t", "mx", "-s", "1.1.1.1",
+			"-expect", "10 mx1.example.com", "-expect", "20 mx2.example.com",
+			"-resolvers", "8.8.8.8, 9.9.9.9", "-interval", "5s", "-deadline", "1m", "example.com"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if config.RecordType != "MX" || config.Server != "1.1.1.1" {
+			t.Errorf("Unexpected type %s / server %s", config.RecordType, config.Server)
+		}
+		if len(config.Expected) != 2 {
+			t.Errorf("Expected two expected values, got %v", config.Expected)
+		}
+		if len(config.Resolvers) != 2 || config.Resolvers[1] != "9.9.9.9" {
+			t.Errorf("Resolvers = %v, want [8.8.8.8 9.9.9.9]", config.Resolvers)
+		}
+		if config.Interval != 5*time.Second || config.Deadline != time.Minute {
+			t.Errorf("Unexpected interval %v / deadline %v", config.Interval, config.Deadline)
+		}
+	})
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"missing expected value", []string{"propagation", "example.com"}, "-expect"},
+		{"missing domain", []string{"propagation", "-expect", "192.0.2.10"}, "domain name is required"},
+		{"invalid resolver", []string{"propagation", "-expect", "x", "-resolvers", "resolver.example", "example.com"}, "not a valid IP address"},
+		{"deadline shorter than interval", []string{"propagation", "-expect", "x", "-interval", "1m", "-deadline", "10s", "example.com"}, "deadline"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil {
+				t.Fatalf("Parse() error = nil, want error containing %q", tt.expectError)
+			}
+			if !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.expectError)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %T", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_PlusOptions(t *testing.T) {
+	parser := NewCLIParser()
+
+	t.Run("watch with defaults", func(t *testing.T) {
+		config, err := parser.Parse([]string{"google.com", "+watch"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if !config.Watch {
+			t.Error("Expected watch mode to be enabled")
+		}
+		if config.Domain != "google.com" {
+			t.Errorf("Domain = %v, want google.com", config.Domain)
+		}
+		if config.WatchMin != 5*time.Second || config.WatchMax != time.Hour {
+			t.Errorf("Unexpected watch bounds %v / %v", config.WatchMin, config.WatchMax)
+		}
+	})
+
+	t.Run("watch bounds before flags", func(t *testing.T) {
+		config, err := parser.Parse([]string{"+watch", "+watch-min=1s", "+watch-max=2m", "-t", "MX", "google.com"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if config.RecordType != "MX" {
+			t.Errorf("RecordType = %v, want MX", config.RecordType)
+		}
+		if config.WatchMin != time.Second || config.WatchMax != 2*time.Minute {
+			t.Errorf("Unexpected watch bounds %v / %v", config.WatchMin, config.WatchMax)
+		}
+	})
+
+	t.Run("idnout", func(t *testing.T) {
+		config, err := parser.Parse([]string{"münchen.de", "+idnout"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if !config.IDNOut {
+			t.Error("Expected +idnout to enable Unicode output")
+		}
+		if config.Domain != "xn--mnchen-3ya.de" {
+			t.Errorf("Domain = %v, want the A-label form xn--mnchen-3ya.de", config.Domain)
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		config, err := parser.Parse([]string{"+follow", "www.example.com"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if !config.FollowCNAME {
+			t.Error("Expected +follow to enable CNAME chasing")
+		}
+
+		config, err = parser.Parse([]string{"+follow", "+nofollow", "www.example.com"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v, want nil", err)
+		}
+		if config.FollowCNAME {
+			t.Error("Expected +nofollow to override +follow")
+		}
+	})
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"unknown option", []string{"+bogus", "google.com"}, "unknown option '+bogus'"},
+		{"missing duration", []string{"+watch-min", "google.com"}, "requires a duration"},
+		{"invalid duration", []string{"+watch-max=soon", "google.com"}, "invalid duration"},
+		{"inverted bounds", []string{"+watch", "+watch-min=2m", "+watch-max=1m", "google.com"}, "cannot be greater"},
+		{"invalid IDN", []string{"+idnout", "☃.net"}, "label '☃' contains U+2603"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil {
+				t.Fatalf("Parse() error = nil, want error containing %q", tt.expectError)
+			}
+			if !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.expectError)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %T", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_QueryOptions(t *testing.T) {
+	parser := NewCLIParser()
+
+	tests := []struct {
+		name            string
+		args            []string
+		expectedTimeout time.Duration
+		expectedTries   int
+		expectedPort    int
+		expectedIPv4    bool
+		expectedIPv6    bool
+	}{
+		{"defaults", []string{"example.com"}, 5 * time.Second, 1, 0, false, false},
+		{"time", []string{"+time=2", "example.com"}, 2 * time.Second, 1, 0, false, false},
+		{"tries", []string{"example.com", "+tries=3"}, 5 * time.Second, 3, 0, false, false},
+		{"retry adds the first try", []string{"+retry=2", "example.com"}, 5 * time.Second, 3, 0, false, false},
+		{"last of tries and retry wins", []string{"+tries=4", "+retry=0", "example.com"}, 5 * time.Second, 1, 0, false, false},
+		{"port", []string{"-p", "5353", "example.com"}, 5 * time.Second, 1, 5353, false, false},
+		{"IPv4 only", []string{"-4", "-s", "8.8.8.8", "example.com"}, 5 * time.Second, 1, 0, true, false},
+		{"IPv6 only", []string{"-6", "-s", "2001:4860:4860::8888", "example.com"}, 5 * time.Second, 1, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.Parse(tt.args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v, want nil", err)
+			}
+			if config.Timeout != tt.expectedTimeout || config.Tries != tt.expectedTries || config.Port != tt.expectedPort ||
+				config.IPv4Only != tt.expectedIPv4 || config.IPv6Only != tt.expectedIPv6 {
+				t.Errorf("Unexpected config: timeout %v tries %d port %d -4 %v -6 %v",
+					config.Timeout, config.Tries, config.Port, config.IPv4Only, config.IPv6Only)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"time without value", []string{"+time", "example.com"}, "requires a number of seconds"},
+		{"time zero", []string{"+time=0", "example.com"}, "query time '0' is out of range"},
+		{"time with unit", []string{"+time=5s", "example.com"}, "query time '5s' must be numeric"},
+		{"tries zero", []string{"+tries=0", "example.com"}, "number of tries '0' is out of range"},
+		{"retry too many", []string{"+retry=10", "example.com"}, "number of retries '10' is out of range"},
+		{"tries without value", []string{"+tries", "example.com"}, "requires a number"},
+		{"port zero", []string{"-p", "0", "example.com"}, "DNS server port '0' is out of range"},
+		{"port not numeric", []string{"-p", "dns", "example.com"}, "must be numeric"},
+		{"both families", []string{"-4", "-6", "example.com"}, "-4 and -6 cannot be used together"},
+		{"server of other family", []string{"-6", "-s", "8.8.8.8", "example.com"}, "not an IPv6 address"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Fatalf("Parse() error = %v, want error containing %q", err, tt.expectError)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_MultipleQueries(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{"-s", "1.1.1.1", "+tcp", "a.example.com", "b.example.com", "mx", "IN", "@8.8.8.8", "c.example.com", "+notcp", "+dnssec"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Domain != "" || len(config.Queries) != 3 {
+		t.Fatalf("Expected 3 queries and no single domain, got %+v", config)
+	}
+
+	expected := []struct {
+		domain, recordType, server string
+		tcp, dnssec                bool
+	}{
+		{"a.example.com", "A", "1.1.1.1", true, false},
+		{"b.example.com", "MX", "8.8.8.8", true, false},
+		{"c.example.com", "A", "1.1.1.1", false, true},
+	}
+	for i, want := range expected {
+		query := config.Queries[i]
+		if query.Domain != want.domain || query.RecordType != want.recordType || query.Server != want.server || query.TCP != want.tcp || query.DNSSEC != want.dnssec {
+			t.Errorf("Query %d = %s %s @%s tcp=%v dnssec=%v, want %+v", i+1, query.Domain, query.RecordType, query.Server, query.TCP, query.DNSSEC, want)
+		}
+	}
+
+	// Flags and options before the first domain apply to all of them
+	config, err = parser.Parse([]string{"-t", "AAAA", "@9.9.9.9", "a.example.com", "b.example.com", "TXT"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Queries[0].RecordType != "AAAA" || config.Queries[1].RecordType != "TXT" || config.Queries[1].Server != "9.9.9.9" {
+		t.Errorf("Unexpected queries %+v and %+v", config.Queries[0], config.Queries[1])
+	}
+
+	// Arguments after a single domain make a plain query
+	config, err = parser.Parse([]string{"example.com", "NS", "@8.8.4.4", "+follow"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Queries != nil || config.Domain != "example.com" || config.RecordType != "NS" || config.Server != "8.8.4.4" || !config.FollowCNAME {
+		t.Errorf("Unexpected single query %+v", config)
+	}
+
+	// Before a domain, type and class mnemonics are domain names
+	for _, name := range []string{"in", "ch", "MX"} {
+		config, err = parser.Parse([]string{"+tcp", name})
+		if err != nil || config.Domain != name || config.RecordType != "A" || !config.TCP {
+			t.Errorf("Expected an A query for %s, got %+v (error %v)", name, config, err)
+		}
+	}
+	config, err = parser.Parse([]string{"in", "TXT"})
+	if err != nil || config.Domain != "in" || config.RecordType != "TXT" {
+		t.Errorf("Expected a TXT query for in, got %+v (error %v)", config, err)
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"unsupported type", []string{"a.example.com", "SRV"}, "unexpected argument 'SRV'"},
+		{"invalid second domain", []string{"a.example.com", "-bad-.example.com"}, "must come before the first domain"},
+		{"invalid server", []string{"a.example.com", "b.example.com", "@not-an-ip"}, "not-an-ip"},
+		{"watch", []string{"a.example.com", "b.example.com", "+watch"}, "+watch takes a single domain name"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want input error containing %q", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_Class(t *testing.T) {
+	parser := NewCLIParser()
+
+	tests := []struct {
+		name  string
+		args  []string
+		class string
+		nsid  bool
+	}{
+		{"default", []string{"example.com"}, "", false},
+		{"flag", []string{"-c", "chaos", "-t", "TXT", "version.bind"}, "CH", false},
+		{"numeric flag", []string{"-c", "4", "example.com"}, "HS", false},
+		{"after the domain", []string{"version.bind", "TXT", "ch", "+nsid"}, "CH", true},
+		{"generic form", []string{"example.com", "CLASS42"}, "CLASS42", false},
+		{"any class flag", []string{"-c", "any", "example.com"}, "CLASS255", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.Parse(tt.args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if config.Class != tt.class || config.NSID != tt.nsid {
+				t.Errorf("Class = %q, NSID = %v, want %q and %v", config.Class, config.NSID, tt.class, tt.nsid)
+			}
+		})
+	}
+
+	if _, err := parser.Parse([]string{"-c", "XX", "example.com"}); err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), "unknown query class 'XX'") {
+		t.Errorf("Expected an input error for an unknown class, got %v", err)
+	}
+
+	// ANY after the domain is the record type, not the class
+	if _, err := parser.Parse([]string{"example.com", "ANY"}); err == nil || !strings.Contains(err.Error(), "unsupported record type 'ANY'") {
+		t.Errorf("Expected ANY to be read as an unsupported record type, got %v", err)
+	}
+}
+
+func TestCLIParser_Parse_HeaderFlags(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{"+norecurse", "+cd", "example.com", "+adflag", "+aaonly", "+opcode=notify"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !config.NoRecurse || !config.CD || !config.AD || !config.AAOnly || config.Opcode != 4 {
+		t.Errorf("Unexpected header settings %+v", config)
+	}
+
+	config, err = parser.Parse([]string{"+norecurse", "+nocd", "example.com", "+recurse"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.NoRecurse || config.CD || config.Opcode != 0 {
+		t.Errorf("Expected the later options to win, got %+v", config)
+	}
+
+	for _, args := range [][]string{{"example.com", "+opcode"}, {"example.com", "+opcode=XFR"}} {
+		if _, err := parser.Parse(args); !errors.IsInputError(err) {
+			t.Errorf("Parse(%v) error = %v, want an input error", args, err)
+		}
+	}
+
+	config, err = parser.Parse([]string{"encode", "+norecurse", "+opcode=UPDATE", "example.com"})
+	if err != nil || !config.NoRecurse || config.Opcode != 5 {
+		t.Errorf("Expected encode to take header options, got %+v (error %v)", config, err)
+	}
+}
+
+func TestCLIParser_Parse_Cookie(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{"example.com", "+cookie"})
+	if err != nil || !config.Cookie || config.CookieValue != "" {
+		t.Errorf("Expected cookies without a value, got %+v (error %v)", config, err)
+	}
+
+	config, err = parser.Parse([]string{"+cookie=0102030405060708", "example.com", "+nocookie"})
+	if err != nil || config.Cookie || config.CookieValue != "" {
+		t.Errorf("Expected +nocookie to turn cookies off, got %+v (error %v)", config, err)
+	}
+
+	config, err = parser.Parse([]string{"encode", "+cookie=0102030405060708", "example.com"})
+	if err != nil || !config.Cookie || config.CookieValue != "0102030405060708" {
+		t.Errorf("Expected encode to take the cookie, got %+v (error %v)", config, err)
+	}
+
+	if _, err := parser.Parse([]string{"example.com", "+cookie=0102"}); err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), "invalid DNS cookie") {
+		t.Errorf("Expected an input error for a short cookie, got %v", err)
+	}
+}
+
+func TestConfig_OutputOptions(t *testing.T) {
+	parser := NewCLIParser()
+
+	// Test output is not a terminal, so colour is off unless asked for
+	config, err := parser.Parse([]string{"example.com", "+idnout"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if options := config.OutputOptions(); options.Color || !options.UnicodeNames {
+		t.Errorf("Unexpected options %+v", options)
+	}
+
+	config, err = parser.Parse([]string{"-o", "json", "+color", "example.com"})
+	if err != nil || config.Color != ColorAlways {
+		t.Fatalf("Expected +color, got %+v (error %v)", config, err)
+	}
+	if options := config.OutputOptions(); !options.Color || options.Format != "json" {
+		t.Errorf("Unexpected options %+v", options)
+	}
+
+	config, err = parser.Parse([]string{"+color", "example.com", "+nocolor"})
+	if err != nil || config.Color != ColorNever || config.OutputOptions().Color {
+		t.Errorf("Expected +nocolor to win, got %+v (error %v)", config, err)
+	}
+}
+
+func TestConfig_ErrorOptions(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	t.Setenv("NO_COLOR", "")
+
+	// Each stream decides colour for itself unless it is forced
+	config, err := NewCLIParser().Parse([]string{"-o", "json", "example.com"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if options := config.streamOptions(writer); options.Color || options.Format != "json" {
+		t.Errorf("Expected no colour for a pipe, got %+v", options)
+	}
+	config.Color = ColorAlways
+	if !config.streamOptions(writer).Color || !config.ErrorOptions().Color {
+		t.Error("Expected +color to colour every stream")
+	}
+}
+
+func TestCLIParser_Parse_Identify(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{"-s", "192.0.2.53", "+identify"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(config.Queries) != len(identityNames) {
+		t.Fatalf("Expected %d queries, got %+v", len(identityNames), config)
+	}
+	for i, query := range config.Queries {
+		if query.Domain != identityNames[i] || query.RecordType != "TXT" || query.Class != "CH" || !query.NSID || query.Server != "192.0.2.53" {
+			t.Errorf("Unexpected query %d: %+v", i+1, query)
+		}
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"with a domain", []string{"+identify", "example.com"}, "+identify takes no domain name"},
+		{"after a domain", []string{"example.com", "+identify"}, "+identify takes no domain name"},
+		{"in the shell", []string{"-i", "+identify"}, "+identify cannot be used in the shell"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want input error containing %q", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_OutputFormat(t *testing.T) {
+	parser := NewCLIParser()
+
+	tests := []struct {
+		name           string
+		args           []string
+		expectedFormat string
+		expectError    string
+	}{
+		{"default", []string{"google.com"}, output.FormatText, ""},
+		{"json", []string{"-o", "json", "google.com"}, output.FormatJSON, ""},
+		{"case insensitive", []string{"-o", "JSON", "google.com"}, output.FormatJSON, ""},
+		{"zone", []string{"-o", "zone", "google.com"}, output.FormatZone, ""},
+		{"csv", []string{"-o", "csv", "google.com"}, output.FormatCSV, ""},
+		{"tsv", []string{"-o", "TSV", "google.com"}, output.FormatTSV, ""},
+		{"explicit text", []string{"-o", "text", "google.com"}, output.FormatText, ""},
+		{"unsupported", []string{"-o", "xml", "google.com"}, "", "unsupported output format 'xml'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.Parse(tt.args)
+			if tt.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectError) || !errors.IsInputError(err) {
+					t.Fatalf("Parse() error = %v, want input error containing %q", err, tt.expectError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v, want nil", err)
+			}
+			if config.OutputFormat != tt.expectedFormat {
+				t.Errorf("OutputFormat = %q, want %q", config.OutputFormat, tt.expectedFormat)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_CheckDelegation(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{"check-delegation", "-s", "1.1.1.1", "example.com."})
+	if err != nil {
+		t.Fatalf("Parse() error = %v, want nil", err)
+	}
+	if config.Command != CommandCheckDelegation {
+		t.Errorf("Command = %q, want %q", config.Command, CommandCheckDelegation)
+	}
+	if config.Domain != "example.com" {
+		t.Errorf("Domain = %q, want example.com", config.Domain)
+	}
+	if config.Server != "1.1.1.1" {
+		t.Errorf("Server = %q, want 1.1.1.1", config.Server)
+	}
+
+	if _, err := parser.Parse([]string{"check-delegation"}); err == nil || !strings.Contains(err.Error(), "zone name is required") {
+		t.Errorf("Expected missing zone error, got %v", err)
+	}
+	if _, err := parser.Parse([]string{"check-delegation", "-s", "bogus", "example.com"}); !errors.IsInputError(err) {
+		t.Errorf("Expected input error for invalid server, got %v", err)
+	}
+}
+
+func TestCLIParser_Parse_MailCheck(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{"mailcheck", "-selectors", "google, selector1,", "example.com."})
+	if err != nil {
+		t.Fatalf("Parse() error = %v, want nil", err)
+	}
+	if config.Command != CommandMailCheck {
+		t.Errorf("Command = %q, want %q", config.Command, CommandMailCheck)
+	}
+	if config.Domain != "example.com" {
+		t.Errorf("Domain = %q, want example.com", config.Domain)
+	}
+	if len(config.Selectors) != 2 || config.Selectors[0] != "google" || config.Selectors[1] != "selector1" {
+		t.Errorf("Selectors = %v, want [google selector1]", config.Selectors)
+	}
+
+	if _, err := parser.Parse([]string{"mailcheck"}); err == nil || !strings.Contains(err.Error(), "domain name is required") {
+		t.Errorf("Expected missing domain error, got %v", err)
+	}
+	if _, err := parser.Parse([]string{"mailcheck", "-selectors", "bad..selector", "example.com"}); !errors.IsInputError(err) {
+		t.Errorf("Expected input error for invalid selector, got %v", err)
+	}
+	if _, err := parser.Parse([]string{"mailcheck", "-s", "bogus", "example.com"}); !errors.IsInputError(err) {
+		t.Errorf("Expected input error for invalid server, got %v", err)
+	}
+}
+
+func TestCLIParser_Parse_Shell(t *testing.T) {
+	parser := NewCLIParser()
+
+	for _, args := range [][]string{
+		{CommandShell, "-s", "1.1.1.1", "-t", "MX", "+tcp", "+dnssec"},
+		{"-i", "-s", "1.1.1.1", "-t", "MX", "+tcp", "+dnssec"},
+	} {
+		t.Run(args[0], func(t *testing.T) {
+			config, err := parser.Parse(args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if config.Command != CommandShell || config.Domain != "" {
+				t.Errorf("Expected the shell without a domain, got command %q domain %q", config.Command, config.Domain)
+			}
+			if config.Server != "1.1.1.1" || config.RecordType != "MX" || !config.TCP || !config.DNSSEC {
+				t.Errorf("Expected the options to carry into the shell, got %+v", config)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"domain", []string{CommandShell, "example.com"}, "the shell takes no domain name"},
+		{"watch", []string{"-i", "+watch"}, "+watch cannot be used in the shell"},
+		{"invalid server", []string{"-i", "-s", "dns.example"}, "dns.example"},
+		{"invalid type", []string{CommandShell, "-t", "SRV"}, "SRV"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Fatalf("Parse() error = %v, want error containing %q", err, tt.expectError)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_Serve(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{CommandServe, "-p", "0", "example.com.zone", "example.org=db.example"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Command != CommandServe || config.Listen != "127.0.0.1:0" {
+		t.Errorf("Expected serve on 127.0.0.1:0, got command %q listen %q", config.Command, config.Listen)
+	}
+	expected := []ZoneFile{{Path: "example.com.zone"}, {Origin: "example.org", Path: "db.example"}}
+	if len(config.Zones) != len(expected) || config.Zones[0] != expected[0] || config.Zones[1] != expected[1] {
+		t.Errorf("Zones = %+v, want %+v", config.Zones, expected)
+	}
+
+	config, err = parser.Parse([]string{CommandServe, "-listen", "::1", "example.com.zone"})
+	if err != nil || config.Listen != "[::1]:53" {
+		t.Errorf("Expected the default port on ::1, got %v (error %v)", config, err)
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"no zone files", []string{CommandServe}, "at least one zone file is required"},
+		{"listen host name", []string{CommandServe, "-listen", "localhost", "a.zone"}, "not an IP address"},
+		{"port out of range", []string{CommandServe, "-p", "70000", "a.zone"}, "out of range"},
+		{"invalid origin", []string{CommandServe, "bad..origin=a.zone"}, "invalid zone origin"},
+		{"missing path", []string{CommandServe, "example.com="}, "zone file path missing"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Fatalf("Parse() error = %v, want error containing %q", err, tt.expectError)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_Fixtures(t *testing.T) {
+	parser := NewCLIParser()
+
+	tests := []struct {
+		name   string
+		args   []string
+		record string
+		replay string
+	}{
+		{"record query", []string{"-record", "out.jsonl", "example.com"}, "out.jsonl", ""},
+		{"replay query", []string{"-replay", "in.jsonl", "example.com"}, "", "in.jsonl"},
+		{"replay shell", []string{CommandShell, "-replay", "in.jsonl"}, "", "in.jsonl"},
+		{"record mailcheck", []string{CommandMailCheck, "-record", "out.jsonl", "example.com"}, "out.jsonl", ""},
+		{"replay check-delegation", []string{CommandCheckDelegation, "-replay", "in.jsonl", "example.com"}, "", "in.jsonl"},
+		{"record propagation", []string{CommandPropagation, "-expect", "192.0.2.1", "-record", "out.jsonl", "example.com"}, "out.jsonl", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.Parse(tt.args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if config.RecordFile != tt.record || config.ReplayFile != tt.replay {
+				t.Errorf("RecordFile = %q, ReplayFile = %q, want %q and %q", config.RecordFile, config.ReplayFile, tt.record, tt.replay)
+			}
+		})
+	}
+
+	_, err := parser.Parse([]string{"-record", "out.jsonl", "-replay", "in.jsonl", "example.com"})
+	if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), "-record and -replay cannot be used together") {
+		t.Errorf("Expected an input error for -record with -replay, got %v", err)
+	}
+
+	config, err := parser.Parse([]string{CommandCheckDelegation, "-pcap", "out.pcap", "example.com"})
+	if err != nil || config.PcapFile != "out.pcap" {
+		t.Errorf("Expected -pcap to be accepted, got %+v (error %v)", config, err)
+	}
+	_, err = parser.Parse([]string{"-pcap", "out.pcap", "-replay", "in.jsonl", "example.com"})
+	if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), "-pcap and -replay cannot be used together") {
+		t.Errorf("Expected an input error for -pcap with -replay, got %v", err)
+	}
+}
+
+func TestCLIParser_Parse_ReadPcap(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{CommandReadPcap, "-o", "JSON", "-p", "5353", "capture.pcapng"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Command != CommandReadPcap || config.InputFile != "capture.pcapng" || config.OutputFormat != "json" || config.Port != 5353 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	config, err = parser.Parse([]string{CommandReadPcap, "capture.pcap"})
+	if err != nil || config.Port != 53 || config.OutputFormat != "text" {
+		t.Errorf("Expected port 53 and text output by default, got %+v (error %v)", config, err)
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"no file", []string{CommandReadPcap}, "capture file is required"},
+		{"two files", []string{CommandReadPcap, "a.pcap", "b.pcap"}, "too many arguments"},
+		{"bad format", []string{CommandReadPcap, "-o", "xml", "a.pcap"}, "unsupported output format 'xml'"},
+		{"bad port", []string{CommandReadPcap, "-p", "0", "a.pcap"}, "port"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want input error containing %q", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_Decode(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{CommandDecode, "-o", "json", "-in", "HEX", "0a0b"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Command != CommandDecode || config.Message != "0a0b" || config.Encoding != "hex" || config.OutputFormat != "json" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	config, err = parser.Parse([]string{CommandDecode})
+	if err != nil || config.Message != "" || config.Encoding != "auto" || config.OutputFormat != "text" {
+		t.Errorf("Expected standard input, auto detection and text output by default, got %+v (error %v)", config, err)
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"two messages", []string{CommandDecode, "0a0b", "0c0d"}, "too many arguments"},
+		{"bad format", []string{CommandDecode, "-o", "xml", "0a0b"}, "unsupported output format 'xml'"},
+		{"bad encoding", []string{CommandDecode, "-in", "octal", "0a0b"}, "unsupported message encoding 'octal'"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want input error containing %q", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestCLIParser_Parse_Encode(t *testing.T) {
+	parser := NewCLIParser()
+
+	config, err := parser.Parse([]string{CommandEncode, "-t", "mx", "-o", "base64url", "-id", "4660", "+dnssec", "example.com"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if config.Command != CommandEncode || config.Domain != "example.com" || config.RecordType != "MX" ||
+		config.Encoding != "base64url" || config.MessageID != 4660 || !config.DNSSEC {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	config, err = parser.Parse([]string{CommandEncode, "example.com"})
+	if err != nil || config.RecordType != "A" || config.Encoding != "hex" || config.MessageID != -1 {
+		t.Errorf("Expected an A query in hex with a random ID by default, got %+v (error %v)", config, err)
+	}
+
+	invalid := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{"no domain", []string{CommandEncode}, "domain name is required"},
+		{"bad type", []string{CommandEncode, "-t", "SRV", "example.com"}, "SRV"},
+		{"bad encoding", []string{CommandEncode, "-o", "json", "example.com"}, "unsupported message encoding 'json'"},
+		{"bad id", []string{CommandEncode, "-id", "70000", "example.com"}, "out of range"},
+		{"other option", []string{CommandEncode, "+tcp", "example.com"}, "does not change the encoded query"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.args)
+			if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Parse() error = %v, want input error containing %q", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestUsageExamples_Parse(t *testing.T) {
+	// Examples that name files refer to them relative to the working directory
+	t.Chdir(t.TempDir())
+
+	for _, example := range usageExamples {
+		t.Run(example, func(t *testing.T) {
+			// A pipeline runs go-dig last
+			command := example[strings.LastIndex(example, "|")+1:]
+			args := strings.Fields(command)
+			if len(args) == 0 || args[0] != "go-dig" {
+				t.Fatalf("Example %q does not run go-dig", example)
+			}
+			if _, err := NewCLIParser().Parse(args[1:]); err != nil {
+				t.Errorf("Parse(%q) error = %v", args[1:], err)
+			}
+		})
+	}
+}
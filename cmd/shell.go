@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+	"go-dig/pkg/output"
+
+	"golang.org/x/term"
+)
+
+// shellPrompt is shown before every line read from a terminal
+const shellPrompt = "> "
+
+// shellHelp describes the commands understood by the shell
+const shellHelp = `Commands:
+  <name> [type] [class] [@server] [+option...]  Look up a name; the arguments apply to this lookup only
+  server [address|default]                      Show or set the server for later lookups
+  type [type]                                   Show or set the record type for later lookups
+  set [+option|name=value ...]                  Change settings, or show them all with their sources
+  help                                          Show this help
+  exit                                          Leave the shell (also quit, Ctrl-D or Ctrl-C)
+
+Settings: server, port, type, timeout, tries, output, idnout, follow, tcp, dnssec
+Options:  +time=<s> +tries=<n> +retry=<n> +[no]idnout +[no]color +[no]follow +[no]tcp +[no]dnssec +[no]nsid
+          +[no]recurse +[no]cd +[no]adflag +[no]aaonly +opcode=<name> +[no]cookie[=<hex>]
+`
+
+// Shell is an interactive session in the style of nslookup. Settings such as
+// the server and record type persist between lookups, and one client and
+// formatter serve every lookup until a setting that shapes them changes, so
+// TCP connections stay open across queries.
+type Shell struct {
+	parser    *CLIParser
+	config    *Config
+	newClient func(*Config) (dns.Client, error)
+	client    dns.Client
+	formatter output.Formatter
+	out       io.Writer
+}
+
+// NewShell creates a shell starting from config. newClient builds the DNS
+// client for a configuration; the shell closes clients it no longer uses if
+// they implement io.Closer.
+func NewShell(config *Config, newClient func(*Config) (dns.Client, error)) (*Shell, error) {
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Shell{
+		parser:    &CLIParser{},
+		config:    config.clone(),
+		newClient: newClient,
+		client:    client,
+		formatter: newFormatter(config),
+		out:       os.Stdout,
+	}, nil
+}
+
+// Run reads commands from in until it ends or the user leaves, writing
+// results and errors to out. A terminal gets a prompt, line editing and
+// history; other input is read line by line without a prompt, so command
+// files can be piped in.
+func (s *Shell) Run(in io.Reader, out io.Writer) error {
+	if file, ok := in.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		return s.runTerminal(file, out)
+	}
+
+	s.out = out
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if !s.execute(scanner.Text()) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.NewSystemError("cannot read shell input", err)
+	}
+	return nil
+}
+
+// runTerminal reads commands from a terminal in raw mode, which lets the
+// line editor handle cursor keys and recall earlier lines
+func (s *Shell) runTerminal(in *os.File, out io.Writer) error {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return errors.NewSystemError("cannot switch the terminal to raw mode", err)
+	}
+	defer term.Restore(int(in.Fd()), state)
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, out}, shellPrompt)
+	s.out = terminal
+
+	fmt.Fprint(s.out, ";; go-dig shell: type help for commands, exit to leave\n")
+	for {
+		line, err := terminal.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != term.ErrPasteIndicator {
+			return errors.NewSystemError("cannot read from the terminal", err)
+		}
+		if !s.execute(line) {
+			return nil
+		}
+	}
+}
+
+// Close releases the client's open connections
+func (s *Shell) Close() error {
+	return closeClient(s.client)
+}
+
+// execute runs one command line and reports whether the shell should go on
+func (s *Shell) execute(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+
+	var err error
+	switch strings.ToLower(fields[0]) {
+	case "exit", "quit":
+		return false
+	case "help", "?":
+		fmt.Fprint(s.out, shellHelp)
+	case "server":
+		err = s.setServer(fields[1:])
+	case "type":
+		err = s.setType(fields[1:])
+	case "set":
+		err = s.set(fields[1:])
+	default:
+		err = s.lookup(fields)
+	}
+
+	if err != nil {
+		fmt.Fprint(s.out, s.formatter.FormatError(err))
+	}
+	return true
+}
+
+// setServer shows or changes the server; "default" returns to the system resolver
+func (s *Shell) setServer(args []string) error {
+	switch {
+	case len(args) == 0:
+		fmt.Fprintf(s.out, "server %s ; %s\n", s.config.settingValue("server"), s.config.Source("server"))
+		return nil
+	case len(args) > 1:
+		return errors.NewInputError("expected a single server address", nil)
+	case strings.ToLower(args[0]) == "default":
+		updated := s.config.clone()
+		updated.Server = ""
+		updated.setSource("server", SourceShell)
+		return s.update(updated)
+	}
+	return s.set([]string{"server=" + args[0]})
+}
+
+// setType shows or changes the record type
+func (s *Shell) setType(args []string) error {
+	switch len(args) {
+	case 0:
+		fmt.Fprintf(s.out, "type %s ; %s\n", s.config.settingValue("type"), s.config.Source("type"))
+		return nil
+	case 1:
+		return s.set([]string{"type=" + args[0]})
+	}
+	return errors.NewInputError("expected a single record type", nil)
+}
+
+// set applies +options and name=value settings for later lookups, or shows
+// the settings when given none. Nothing changes if any argument is invalid.
+func (s *Shell) set(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(s.out, FormatConfig(s.config))
+		return nil
+	}
+
+	updated := s.config.clone()
+	var plusOptions []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "+") {
+			plusOptions = append(plusOptions, arg)
+			continue
+		}
+
+		name, value, found := strings.Cut(arg, "=")
+		name = strings.ToLower(name)
+		if !found || !isSettingName(name) {
+			return errors.NewInputError(fmt.Sprintf("cannot set '%s': expected +option or name=value with a name from %s", arg, strings.Join(settingNames, ", ")), nil)
+		}
+		if err := applySetting(updated, name, value); err != nil {
+			return errors.NewInputError(fmt.Sprintf("invalid %s '%s'", name, value), err)
+		}
+		updated.setSource(name, SourceShell)
+	}
+	if err := s.parser.applyPlusOptions(updated, plusOptions, SourceShell); err != nil {
+		return err
+	}
+
+	return s.update(updated)
+}
+
+// update validates a changed configuration and makes it current, replacing
+// the client and formatter only if the change affects them
+func (s *Shell) update(updated *Config) error {
+	if err := validateShellConfig(updated); err != nil {
+		return err
+	}
+
+	if clientChanged(s.config, updated) {
+		client, err := s.newClient(updated)
+		if err != nil {
+			return err
+		}
+		closeClient(s.client)
+		s.client = client
+	}
+	if formatterChanged(s.config, updated) {
+		s.formatter = newFormatter(updated)
+	}
+	s.config = updated
+	return nil
+}
+
+// lookup queries a name with the current settings. A record type, @server and
+// +options after the name apply to this lookup only.
+func (s *Shell) lookup(fields []string) error {
+	domain := fields[0]
+	config := s.config.clone()
+
+	if err := s.parser.applyQueryArgs(config, fields[1:], SourceShell); err != nil {
+		return err
+	}
+	if err := validateShellConfig(config); err != nil {
+		return err
+	}
+
+	// Options given with the lookup get a client and formatter of their own
+	client, formatter := s.client, s.formatter
+	if clientChanged(s.config, config) {
+		var err error
+		if client, err = s.newClient(config); err != nil {
+			return err
+		}
+		defer closeClient(client)
+	}
+	if formatterChanged(s.config, config) {
+		formatter = newFormatter(config)
+	}
+
+	result, err := client.Query(domain, config.RecordType, config.Server)
+	// CSV and TSV rows of failures name the query they belong to
+	if err != nil && (result == nil || !output.Tabular(config.OutputFormat)) {
+		fmt.Fprint(s.out, formatter.FormatError(err))
+		return nil
+	}
+	fmt.Fprint(s.out, formatter.FormatResult(result))
+	return nil
+}
+
+// validateShellConfig checks the settings that only conflict in combination
+func validateShellConfig(config *Config) error {
+	if config.Watch {
+		return errors.NewInputError("+watch cannot be used in the shell", nil)
+	}
+	if config.Identify {
+		return errors.NewInputError("+identify cannot be used in the shell", nil)
+	}
+	if config.Server != "" {
+		return errors.ValidateAddressFamily(config.Server, config.IPv4Only, config.IPv6Only)
+	}
+	return nil
+}
+
+// clientChanged reports whether the settings that shape the DNS client differ
+func clientChanged(old, updated *Config) bool {
+	return old.Timeout != updated.Timeout || old.Tries != updated.Tries || old.Port != updated.Port ||
+		old.IPv4Only != updated.IPv4Only || old.IPv6Only != updated.IPv6Only ||
+		old.FollowCNAME != updated.FollowCNAME || old.TCP != updated.TCP || old.DNSSEC != updated.DNSSEC ||
+		old.Class != updated.Class || old.NSID != updated.NSID || old.NoRecurse != updated.NoRecurse ||
+		old.CD != updated.CD || old.AD != updated.AD || old.AAOnly != updated.AAOnly || old.Opcode != updated.Opcode ||
+		old.Cookie != updated.Cookie || old.CookieValue != updated.CookieValue
+}
+
+// formatterChanged reports whether the display settings differ
+func formatterChanged(old, updated *Config) bool {
+	return old.OutputFormat != updated.OutputFormat || old.IDNOut != updated.IDNOut || old.Color != updated.Color
+}
+
+// newFormatter creates the formatter for the display settings of config
+func newFormatter(config *Config) output.Formatter {
+	return output.NewFormatterWithOptions(config.OutputOptions())
+}
+
+// OutputOptions returns the display settings of output written to standard
+// output. Colour follows +color or +nocolor, or else whether standard output
+// is a terminal.
+func (c *Config) OutputOptions() output.Options {
+	return c.streamOptions(os.Stdout)
+}
+
+// ErrorOptions returns the display settings of errors written to standard
+// error, whose colour is decided for that stream: a log file given with
+// 2>file gets no escape codes even when standard output is a terminal.
+func (c *Config) ErrorOptions() output.Options {
+	return c.streamOptions(os.Stderr)
+}
+
+// streamOptions returns the display settings of output written to file
+func (c *Config) streamOptions(file *os.File) output.Options {
+	color := output.ColorEnabled(file)
+	switch c.Color {
+	case ColorAlways:
+		color = true
+	case ColorNever:
+		color = false
+	}
+	return output.Options{Format: c.OutputFormat, UnicodeNames: c.IDNOut, Color: color}
+}
+
+// closeClient closes client if it holds connections
+func closeClient(client dns.Client) error {
+	if closer, ok := client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// clone returns a copy of the configuration that can be changed without
+// affecting the original
+func (c *Config) clone() *Config {
+	clone := *c
+	clone.Sources = make(map[string]string, len(c.Sources))
+	for name, source := range c.Sources {
+		clone.Sources[name] = source
+	}
+	return &clone
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// shellClient records the queries it receives and answers each with one
+// address; the settings it was built from are kept for inspection
+type shellClient struct {
+	config  *Config
+	queries []string // "domain type server"
+	closed  bool
+}
+
+func (c *shellClient) Query(domain, recordType, server string) (*dns.Result, error) {
+	c.queries = append(c.queries, domain+" "+recordType+" "+server)
+	if strings.HasSuffix(domain, ".invalid") {
+		err := errors.NewDNSError("domain '"+domain+"' not found (NXDOMAIN)", nil, domain, server).WithKind(errors.ErrNXDomain)
+		return &dns.Result{Domain: domain, Error: err}, err
+	}
+	return &dns.Result{
+		Domain:     domain,
+		RecordType: recordType,
+		Server:     server,
+		Records:    []string{"192.0.2.1"},
+		Answers:    []dns.Record{{Name: domain + ".", Type: recordType, TTL: 300, Value: "192.0.2.1"}},
+	}, nil
+}
+
+func (c *shellClient) Exchange(msg *mdns.Msg, server, network string) (*mdns.Msg, time.Duration, error) {
+	return nil, 0, errors.NewNetworkError("exchange not supported in tests", nil, server)
+}
+
+func (c *shellClient) SetTimeout(duration time.Duration) {}
+
+func (c *shellClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+// runShell runs script through a shell started from config and returns its
+// output and the clients it built, in order
+func runShell(t *testing.T, config *Config, script string) (string, []*shellClient) {
+	t.Helper()
+
+	var clients []*shellClient
+	shell, err := NewShell(config, func(config *Config) (dns.Client, error) {
+		client := &shellClient{config: config}
+		clients = append(clients, client)
+		return client, nil
+	})
+	if err != nil {
+		t.Fatalf("NewShell() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := shell.Run(strings.NewReader(script), &out); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if err := shell.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return out.String(), clients
+}
+
+// shellConfig returns the configuration a plain "go-dig shell" starts from
+func shellConfig() *Config {
+	return &Config{Command: CommandShell, RecordType: "A", Timeout: 5 * time.Second, Tries: 1, OutputFormat: "text"}
+}
+
+func TestShell_PersistentSettings(t *testing.T) {
+	script := strings.Join([]string{
+		"example.com",
+		"server 1.1.1.1",
+		"type mx",
+		"example.com",
+		"www.example.com AAAA @8.8.8.8",
+		"example.org",
+		"server default",
+		"example.net",
+	}, "\n")
+
+	out, clients := runShell(t, shellConfig(), script)
+
+	if len(clients) != 1 {
+		t.Fatalf("Expected server and type changes to keep the client, got %d clients", len(clients))
+	}
+	expected := []string{
+		"example.com A ",
+		"example.com MX 1.1.1.1",
+		"www.example.com AAAA 8.8.8.8",
+		"example.org MX 1.1.1.1",
+		"example.net MX ",
+	}
+	if strings.Join(clients[0].queries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected queries:\n%s\nwant:\n%s", strings.Join(clients[0].queries, "\n"), strings.Join(expected, "\n"))
+	}
+	if !clients[0].closed {
+		t.Error("Expected Close to close the client")
+	}
+	if strings.Count(out, "192.0.2.1") != len(expected) {
+		t.Errorf("Expected every lookup to print its answer, got:\n%s", out)
+	}
+}
+
+func TestShell_SetReplacesClientOnlyWhenNeeded(t *testing.T) {
+	script := strings.Join([]string{
+		"set +tcp +dnssec timeout=2s",
+		"example.com",
+		"set type=TXT output=json",
+		"example.com",
+		"example.com +follow",
+		"example.com",
+	}, "\n")
+
+	out, clients := runShell(t, shellConfig(), script)
+
+	// The initial client, the one for +tcp +dnssec, and the one-off +follow client
+	if len(clients) != 3 {
+		t.Fatalf("Expected 3 clients, got %d", len(clients))
+	}
+	if !clients[0].closed {
+		t.Error("Expected the replaced client to be closed")
+	}
+	settings := clients[1].config
+	if !settings.TCP || !settings.DNSSEC || settings.Timeout != 2*time.Second || settings.FollowCNAME {
+		t.Errorf("Unexpected settings for the second client: %+v", settings)
+	}
+	if len(clients[1].queries) != 3 {
+		t.Errorf("Expected the second client to serve 3 lookups, got %v", clients[1].queries)
+	}
+	if !clients[2].config.FollowCNAME || !clients[2].closed || len(clients[2].queries) != 1 {
+		t.Errorf("Expected a one-off +follow client closed after its lookup, got %+v", clients[2])
+	}
+	if !strings.Contains(out, `"type": "TXT"`) {
+		t.Errorf("Expected JSON output after set output=json, got:\n%s", out)
+	}
+}
+
+func TestShell_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		expectError string
+	}{
+		{"unknown setting", "set colour=always", "cannot set 'colour=always'"},
+		{"bare word", "set tcp", "cannot set 'tcp'"},
+		{"invalid value", "set timeout=soon", "invalid timeout 'soon'"},
+		{"unknown option", "set +bogus", "unknown option '+bogus'"},
+		{"watch", "set +watch", "+watch cannot be used in the shell"},
+		{"invalid server", "server dns.example", "dns.example"},
+		{"invalid type", "type SRV", "invalid type 'SRV'"},
+		{"unexpected argument", "example.com SRV", "unexpected argument 'SRV'"},
+		{"invalid one-off server", "example.com @nowhere", "nowhere"},
+		{"failed lookup", "missing.invalid", "NXDOMAIN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, clients := runShell(t, shellConfig(), tt.line+"\nset\n")
+			if !strings.Contains(out, "Error: ") || !strings.Contains(out, tt.expectError) {
+				t.Errorf("Expected error containing %q, got:\n%s", tt.expectError, out)
+			}
+			// A rejected command leaves the settings alone
+			if !strings.Contains(out, "type     A                    ; default") || len(clients) != 1 {
+				t.Errorf("Expected settings to be unchanged, got %d clients and:\n%s", len(clients), out)
+			}
+		})
+	}
+}
+
+func TestShell_ShowAndExit(t *testing.T) {
+	config := shellConfig()
+	config.Server = "9.9.9.9"
+	config.setSource("server", SourceCommandLine)
+
+	out, clients := runShell(t, config, "server\ntype\nset +idnout\nset\nhelp\n\nexit\nexample.com\n")
+
+	for _, expected := range []string{
+		"server 9.9.9.9 ; command line\n",
+		"type A ; default\n",
+		"idnout   true                 ; shell\n",
+		"Commands:\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out)
+		}
+	}
+	if len(clients[0].queries) != 0 {
+		t.Errorf("Expected no lookups after exit, got %v", clients[0].queries)
+	}
+	if config.IDNOut {
+		t.Error("Expected the shell to leave the starting configuration unchanged")
+	}
+}
@@ -2,20 +2,31 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"go-dig/cmd"
+	"go-dig/pkg/delegation"
 	"go-dig/pkg/dns"
 	"go-dig/pkg/errors"
+	"go-dig/pkg/mailcheck"
 	"go-dig/pkg/output"
+	"go-dig/pkg/pcap"
+	"go-dig/pkg/propagation"
+	"go-dig/pkg/watch"
+	"go-dig/pkg/wire"
+	"go-dig/pkg/zoneserver"
 )
 
 func main() {
 	// Create components
 	parser := cmd.NewCLIParser()
-	formatter := output.NewFormatter()
+	// Errors before the command line is parsed are coloured for a terminal
+	formatter := output.NewFormatterWithOptions(output.Options{Color: output.ColorEnabled(os.Stderr)})
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -30,11 +41,19 @@ func main() {
 		}
 	}()
 
+	// Clients opened below, whose capture is closed on the way out
+	var opened atomic.Pointer[clientFactory]
+
 	// Handle signals in a separate goroutine
 	go func() {
 		sig := <-sigChan
 		systemErr := errors.NewSystemError(fmt.Sprintf("received signal %v, shutting down gracefully", sig), nil)
 		fmt.Fprint(os.Stderr, formatter.FormatError(systemErr))
+		if clients := opened.Load(); clients != nil {
+			if err := clients.close(); err != nil {
+				fmt.Fprint(os.Stderr, formatter.FormatError(err))
+			}
+		}
 		os.Exit(130) // Standard exit code for SIGINT
 	}()
 
@@ -54,31 +73,525 @@ func main() {
 		os.Exit(getExitCode(err))
 	}
 
+	// Show where the settings came from instead of querying
+	if config.PrintConfig {
+		fmt.Print(cmd.FormatConfig(config))
+		os.Exit(0)
+	}
+
+	// Apply display options from the command line. Errors go to standard
+	// error, so whether they are coloured depends on that stream.
+	formatter = output.NewFormatterWithOptions(config.ErrorOptions())
+
+	// Serve answers queries and read-pcap decodes them rather than sending them
+	switch config.Command {
+	case cmd.CommandServe:
+		os.Exit(runServe(config, formatter))
+	case cmd.CommandReadPcap:
+		os.Exit(runReadPcap(config, formatter))
+	case cmd.CommandDecode:
+		os.Exit(runDecode(config, formatter))
+	case cmd.CommandEncode:
+		os.Exit(runEncode(config, formatter))
+	}
+
+	// Open the fixture or capture given with -record, -replay or -pcap, which every client shares
+	clients, err := newClientFactory(config)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		os.Exit(getExitCode(err))
+	}
+	opened.Store(clients)
+
+	// A capture that could not be written fails a run that otherwise succeeded
+	code := runClients(config, clients, formatter)
+	if err := clients.close(); err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		if code == 0 {
+			code = getExitCode(err)
+		}
+	}
+	os.Exit(code)
+}
+
+// runClients runs the queries and checks of config with clients from the
+// factory and returns the exit code
+func runClients(config *cmd.Config, clients *clientFactory, formatter output.Formatter) int {
+	// The shell builds its own clients as its settings change
+	if config.Command == cmd.CommandShell {
+		return runShell(config, clients, formatter)
+	}
+
+	// Each query of a command line naming several domains has its own client
+	if len(config.Queries) > 0 {
+		return runQueries(config, clients)
+	}
+
 	// Create DNS client
-	client := dns.NewClient()
-	client.SetTimeout(config.Timeout)
+	client, err := clients.newClient(config)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	switch config.Command {
+	case cmd.CommandPropagation:
+		return runPropagation(config, client, formatter)
+	case cmd.CommandCheckDelegation:
+		return runCheckDelegation(config, client, formatter)
+	case cmd.CommandMailCheck:
+		return runMailCheck(config, client, formatter)
+	}
+
+	if config.Watch {
+		runWatch(config, client, formatter)
+		return 0
+	}
 
 	// Perform DNS query with proper error propagation
 	result, err := client.Query(config.Domain, config.RecordType, config.Server)
+	results := output.NewFormatterWithOptions(config.OutputOptions())
 	if err != nil {
 		// Ensure error is properly formatted and propagated
-		fmt.Fprint(os.Stderr, formatter.FormatError(err))
-		os.Exit(getExitCode(err))
+		printFailure(results, formatter, config.OutputFormat, result, err)
+		return getExitCode(err)
 	}
 
 	// Validate result before formatting
 	if result == nil {
 		systemErr := errors.NewSystemError("DNS query returned nil result", nil)
 		fmt.Fprint(os.Stderr, formatter.FormatError(systemErr))
-		os.Exit(getExitCode(systemErr))
+		return getExitCode(systemErr)
 	}
 
 	// Format and display results
-	output := formatter.FormatResult(result)
-	fmt.Print(output)
+	fmt.Print(results.FormatResult(result))
+
+	// The name exists but has no records of the requested type
+	if result.NoData {
+		return 4
+	}
 
 	// Successful execution
-	os.Exit(0)
+	return 0
+}
+
+// clientFactory creates DNS clients that record to or replay from the same
+// fixture, write to the same packet capture and share DNS cookies
+type clientFactory struct {
+	transport dns.Transport     // Replays a fixture; nil queries the network
+	record    io.Writer         // Fixture the exchanges are appended to; nil records nothing
+	capture   dns.PacketCapture // Capture the packets are written to; nil captures nothing
+	cookies   *dns.CookieJar    // Server cookies learned by every client that sends cookies
+
+	pcapWriter *pcap.Writer // Writer of capture, to check for failed writes when closing
+	pcapFile   *os.File     // File named by -pcap; nil without a capture
+	closeOnce  sync.Once    // Closes the capture once, on exit or on a signal
+}
+
+// newClientFactory opens the files named by -record, -replay and -pcap
+func newClientFactory(config *cmd.Config) (*clientFactory, error) {
+	factory := &clientFactory{cookies: dns.NewCookieJar()}
+	if config.ReplayFile != "" {
+		replayer, err := dns.LoadFixture(config.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		factory.transport = replayer
+	}
+	if config.RecordFile != "" {
+		// The file stays open until the process exits; each exchange is written as it completes
+		file, err := os.OpenFile(config.RecordFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, errors.NewInputError(fmt.Sprintf("cannot open fixture file %s", config.RecordFile), err)
+		}
+		factory.record = file
+	}
+	if config.PcapFile != "" {
+		file, err := os.Create(config.PcapFile)
+		if err != nil {
+			return nil, errors.NewInputError(fmt.Sprintf("cannot create capture file %s", config.PcapFile), err)
+		}
+		writer, err := pcap.NewWriter(file)
+		if err != nil {
+			return nil, errors.NewSystemError(fmt.Sprintf("cannot write capture file %s", config.PcapFile), err)
+		}
+		factory.capture = writer
+		factory.pcapWriter, factory.pcapFile = writer, file
+	}
+	return factory, nil
+}
+
+// close closes the packet capture. It reports the first packet that could not
+// be written, since the clients go on querying when a write fails. Only the
+// first call closes the file; later calls return nil.
+func (f *clientFactory) close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		if f.pcapFile == nil {
+			return
+		}
+		err = f.pcapWriter.Err()
+		if closeErr := f.pcapFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			err = errors.NewSystemError(fmt.Sprintf("cannot write capture file %s", f.pcapFile.Name()), err)
+		}
+	})
+	return err
+}
+
+// newClient creates a DNS client with the query settings of config
+func (f *clientFactory) newClient(config *cmd.Config) (dns.Client, error) {
+	class, err := queryClass(config)
+	if err != nil {
+		return nil, err
+	}
+	var cookies *dns.CookieJar
+	if config.Cookie {
+		cookies = f.cookies
+	}
+	return dns.NewClientWithOptions(dns.Options{
+		Timeout:     config.Timeout,
+		Tries:       config.Tries,
+		Port:        config.Port,
+		IPv4Only:    config.IPv4Only,
+		IPv6Only:    config.IPv6Only,
+		FollowCNAME: config.FollowCNAME,
+		TCP:         config.TCP,
+		DNSSEC:      config.DNSSEC,
+		Class:       class,
+		NSID:        config.NSID || config.Watch, // A watch tells anycast instances apart by their NSID
+		NoRecurse:   config.NoRecurse,
+		CD:          config.CD,
+		AD:          config.AD,
+		AAOnly:      config.AAOnly,
+		Opcode:      config.Opcode,
+		Cookies:     cookies,
+		Cookie:      config.CookieValue,
+		Transport:   f.transport,
+		Record:      f.record,
+		Capture:     f.capture,
+	}), nil
+}
+
+// queryClass returns the code of the query class of config; no class is IN
+func queryClass(config *cmd.Config) (uint16, error) {
+	class := config.Class
+	if class == "" {
+		class = "IN"
+	}
+	return dns.ParseClass(class)
+}
+
+// runShell reads commands from standard input until the user leaves
+func runShell(config *cmd.Config, clients *clientFactory, formatter output.Formatter) int {
+	shell, err := cmd.NewShell(config, clients.newClient)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	defer shell.Close()
+
+	if err := shell.Run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	return 0
+}
+
+// runServe loads the zone files and answers queries for them until the
+// process is interrupted
+func runServe(config *cmd.Config, formatter output.Formatter) int {
+	var zones []*zoneserver.Zone
+	for _, file := range config.Zones {
+		zone, err := zoneserver.LoadZone(file.Path, file.Origin)
+		if err != nil {
+			fmt.Fprint(os.Stderr, formatter.FormatError(err))
+			return getExitCode(err)
+		}
+		fmt.Printf(";; Loaded zone %s from %s (%d records)\n", zone.Origin, file.Path, zone.Len())
+		zones = append(zones, zone)
+	}
+
+	address, err := zoneserver.NewServer(zones...).Start(config.Listen)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	fmt.Printf(";; Serving on %s over UDP and TCP\n", address)
+
+	// The signal handler ends the process
+	select {}
+}
+
+// runReadPcap decodes the DNS messages in a capture file and prints them
+func runReadPcap(config *cmd.Config, formatter output.Formatter) int {
+	file, err := os.Open(config.InputFile)
+	if err != nil {
+		err := errors.NewInputError(fmt.Sprintf("cannot open capture file %s", config.InputFile), err)
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	defer file.Close()
+
+	packets, err := pcap.ReadDNS(file, config.Port)
+	if err != nil {
+		// Show the messages read before a truncated or damaged part
+		if len(packets) > 0 {
+			fmt.Print(output.FormatCapture(packets, config.OutputOptions()))
+		}
+		err := errors.NewInputError(fmt.Sprintf("cannot read capture file %s", config.InputFile), err)
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	fmt.Print(output.FormatCapture(packets, config.OutputOptions()))
+	return 0
+}
+
+// runDecode prints a DNS message given as hex, base64 or a DoH URL, on the
+// command line or standard input
+func runDecode(config *cmd.Config, formatter output.Formatter) int {
+	text := config.Message
+	if text == "" {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			err := errors.NewSystemError("cannot read message from standard input", err)
+			fmt.Fprint(os.Stderr, formatter.FormatError(err))
+			return getExitCode(err)
+		}
+		text = string(input)
+	}
+
+	data, err := wire.ParseText(text, config.Encoding)
+	if err != nil {
+		err := errors.NewInputError("cannot parse encoded message", err)
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	msg, err := wire.Decode(data)
+	if err != nil {
+		err := errors.NewInputError(fmt.Sprintf("malformed DNS message (%d bytes)", len(data)), err)
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	fmt.Print(output.FormatMessage(msg, config.OutputOptions()))
+	return 0
+}
+
+// runEncode writes the wire form of the query a plain lookup would send
+func runEncode(config *cmd.Config, formatter output.Formatter) int {
+	class, err := queryClass(config)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	options := dns.Options{
+		DNSSEC:    config.DNSSEC,
+		Class:     class,
+		NSID:      config.NSID,
+		NoRecurse: config.NoRecurse,
+		CD:        config.CD,
+		AD:        config.AD,
+		AAOnly:    config.AAOnly,
+		Opcode:    config.Opcode,
+		Cookie:    config.CookieValue,
+	}
+	if config.Cookie {
+		options.Cookies = dns.NewCookieJar()
+	}
+	msg, err := dns.NewQuery(config.Domain, config.RecordType, options)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	if config.MessageID >= 0 {
+		msg.Id = uint16(config.MessageID)
+	}
+
+	data, err := msg.Pack()
+	if err == nil {
+		data, err = wire.Encode(data, config.Encoding)
+	}
+	if err != nil {
+		err := errors.NewSystemError("cannot encode query", err)
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	os.Stdout.Write(data)
+	return 0
+}
+
+// runQueries sends the queries of a command line naming several domains
+// concurrently and prints their results in command-line order. The exit
+// status is that of the first query that failed; 4 if none failed but one
+// found no records of its type.
+func runQueries(config *cmd.Config, clients *clientFactory) int {
+	results := make([]*dns.Result, len(config.Queries))
+	errs := make([]error, len(config.Queries))
+
+	// With cookies the queries go one at a time, so that later queries send
+	// the server cookies returned to earlier ones
+	sequential := false
+	for _, query := range config.Queries {
+		sequential = sequential || query.Cookie
+	}
+
+	var wg sync.WaitGroup
+	for i, query := range config.Queries {
+		wg.Add(1)
+		run := func() {
+			defer wg.Done()
+			client, err := clients.newClient(query)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i], errs[i] = client.Query(query.Domain, query.RecordType, query.Server)
+		}
+		if sequential {
+			run()
+		} else {
+			go run()
+		}
+	}
+	wg.Wait()
+
+	// Queries with the same display options share a formatter for each
+	// stream, so CSV and TSV output is a single table with one header and
+	// JSON output has one document per line (JSON Lines)
+	formatters := make(map[output.Options]output.Formatter)
+	errFormatters := make(map[output.Options]output.Formatter)
+	formatterFor := func(formatters map[output.Options]output.Formatter, options output.Options) output.Formatter {
+		options.JSONLines = true
+		formatter, ok := formatters[options]
+		if !ok {
+			formatter = output.NewFormatterWithOptions(options)
+			formatters[options] = formatter
+		}
+		return formatter
+	}
+
+	exitCode := 0
+	for i, query := range config.Queries {
+		formatter := formatterFor(formatters, query.OutputOptions())
+		errFormatter := formatterFor(errFormatters, query.ErrorOptions())
+		if i > 0 && query.OutputFormat != output.FormatJSON && !output.Tabular(query.OutputFormat) {
+			fmt.Println()
+		}
+
+		switch {
+		case errs[i] != nil:
+			printFailure(formatter, errFormatter, query.OutputFormat, results[i], errs[i])
+			if exitCode == 0 || exitCode == 4 {
+				exitCode = getExitCode(errs[i])
+			}
+		case results[i] == nil:
+			systemErr := errors.NewSystemError("DNS query returned nil result", nil)
+			fmt.Fprint(os.Stderr, errFormatter.FormatError(systemErr))
+			if exitCode == 0 || exitCode == 4 {
+				exitCode = getExitCode(systemErr)
+			}
+		default:
+			fmt.Print(formatter.FormatResult(results[i]))
+			if results[i].NoData && exitCode == 0 {
+				exitCode = 4
+			}
+		}
+	}
+	return exitCode
+}
+
+// printFailure writes the error of a failed query to standard error with
+// errFormatter; CSV and TSV output writes it with the results formatter as a
+// row of the table on standard output instead, with the query it belongs to
+// when there is a result
+func printFailure(results, errFormatter output.Formatter, format string, result *dns.Result, err error) {
+	switch {
+	case !output.Tabular(format):
+		fmt.Fprint(os.Stderr, errFormatter.FormatError(err))
+	case result != nil:
+		fmt.Print(results.FormatResult(result))
+	default:
+		fmt.Print(results.FormatError(err))
+	}
+}
+
+// runPropagation polls the zone's nameservers and resolvers until they all return
+// the expected value, printing progress after every round
+func runPropagation(config *cmd.Config, client dns.Client, formatter output.Formatter) int {
+	checker := propagation.NewChecker(client)
+	opts := propagation.Options{
+		Domain:     config.Domain,
+		RecordType: config.RecordType,
+		Expected:   config.Expected,
+		Resolvers:  config.Resolvers,
+		Bootstrap:  config.Server,
+		Interval:   config.Interval,
+		Deadline:   config.Deadline,
+	}
+
+	report, err := checker.Run(opts, func(report *propagation.Report, round *propagation.Round) {
+		fmt.Print(output.FormatPropagation(report, round))
+	})
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	fmt.Printf(";; %s %s propagated to all servers for zone %s\n", report.Domain, report.RecordType, report.Zone)
+	return 0
+}
+
+// runCheckDelegation compares the zone's delegation at the parent with the
+// zone's own NS set and probes every nameserver
+func runCheckDelegation(config *cmd.Config, client dns.Client, formatter output.Formatter) int {
+	report, err := delegation.NewChecker(client).Check(config.Domain, config.Server)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	fmt.Print(output.FormatDelegation(report))
+
+	if !report.Healthy() {
+		err := errors.NewDNSError(fmt.Sprintf("delegation check found problems with zone '%s'", report.Zone), nil, report.Zone, "")
+		fmt.Fprint(os.Stderr, "\n"+formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	return 0
+}
+
+// runMailCheck fetches and analyses the domain's mail authentication records
+func runMailCheck(config *cmd.Config, client dns.Client, formatter output.Formatter) int {
+	report, err := mailcheck.NewChecker(client, config.Server).Check(config.Domain, config.Selectors)
+	if err != nil {
+		fmt.Fprint(os.Stderr, formatter.FormatError(err))
+		return getExitCode(err)
+	}
+
+	fmt.Print(output.FormatMailCheck(report))
+
+	if !report.Healthy() {
+		err := errors.NewDNSError(fmt.Sprintf("mail check found problems with domain '%s'", report.Domain), nil, report.Domain, config.Server)
+		fmt.Fprint(os.Stderr, "\n"+formatter.FormatError(err))
+		return getExitCode(err)
+	}
+	return 0
+}
+
+// runWatch re-runs the query whenever the answer TTL expires and prints what
+// changed. It runs until the process is interrupted.
+func runWatch(config *cmd.Config, client dns.Client, formatter output.Formatter) {
+	watcher := watch.NewWatcher(client, config.WatchMin, config.WatchMax)
+	options := config.OutputOptions()
+	watcher.Run(config.Domain, config.RecordType, config.Server, nil, func(update *watch.Update) {
+		fmt.Print(output.FormatWatchUpdate(update, options))
+	})
 }
 
 // getExitCode returns appropriate exit code based on error type
@@ -87,13 +600,14 @@ func main() {
 // 1 = General error / Invalid arguments
 // 2 = Network/DNS error
 // 3 = System error
+// 4 = No data (set by main for NODATA answers, not derived from an error)
 // 130 = Interrupted by signal (SIGINT)
 func getExitCode(err error) int {
 	if err == nil {
 		return 0
 	}
 
-	if digErr, ok := err.(*errors.DigError); ok {
+	if digErr, ok := errors.AsDigError(err); ok {
 		switch digErr.Type {
 		case errors.ErrorTypeInput:
 			return 1 // Invalid arguments
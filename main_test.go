@@ -1,10 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"go-dig/cmd"
+	"go-dig/pkg/errors"
 )
 
 func TestMainApplicationFlow(t *testing.T) {
@@ -126,6 +133,21 @@ func TestGetExitCode(t *testing.T) {
 			err:      &testError{},
 			expected: 1,
 		},
+		{
+			name:     "Network error",
+			err:      errors.NewNetworkError("timeout", nil, "8.8.8.8:53").WithKind(errors.ErrTimeout),
+			expected: 2,
+		},
+		{
+			name:     "Wrapped DNS error",
+			err:      fmt.Errorf("query failed: %w", errors.NewDNSError("not found", nil, "example.com", "").WithKind(errors.ErrNXDomain)),
+			expected: 2,
+		},
+		{
+			name:     "Wrapped system error",
+			err:      fmt.Errorf("startup: %w", errors.NewSystemError("panic", nil)),
+			expected: 3,
+		},
 	}
 
 	for _, tt := range tests {
@@ -138,6 +160,51 @@ func TestGetExitCode(t *testing.T) {
 	}
 }
 
+func TestClientFactory_Close_ReportsFailedWrites(t *testing.T) {
+	config := &cmd.Config{PcapFile: filepath.Join(t.TempDir(), "capture.pcap")}
+	clients, err := newClientFactory(config)
+	if err != nil {
+		t.Fatalf("newClientFactory() error = %v", err)
+	}
+
+	// A UDP source with a TCP destination cannot be written as a packet
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	dst := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53}
+	if err := clients.capture.WriteDNS(time.Now(), src, dst, []byte{0}); err == nil {
+		t.Fatal("Expected WriteDNS to fail")
+	}
+
+	err = clients.close()
+	if getExitCode(err) != 3 || !strings.Contains(err.Error(), "cannot write capture file") {
+		t.Errorf("Expected a system error for the capture, got %v", err)
+	}
+	if err := clients.close(); err != nil {
+		t.Errorf("Expected a second close to do nothing, got %v", err)
+	}
+}
+
+func TestQueryClass(t *testing.T) {
+	tests := []struct {
+		class    string
+		expected uint16
+	}{
+		{"", 1},
+		{"IN", 1},
+		{"CH", 3},
+		{"CLASS255", 255},
+	}
+	for _, tt := range tests {
+		class, err := queryClass(&cmd.Config{Class: tt.class})
+		if err != nil || class != tt.expected {
+			t.Errorf("queryClass(%q) = %d, %v, want %d", tt.class, class, err, tt.expected)
+		}
+	}
+
+	if _, err := queryClass(&cmd.Config{Class: "XX"}); !errors.IsInputError(err) {
+		t.Errorf("Expected an input error for an unknown class, got %v", err)
+	}
+}
+
 // testError is a simple error type for testing
 type testError struct{}
 
@@ -0,0 +1,368 @@
+package delegation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Severity ranks how serious a finding is
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+// String returns a string representation of the severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "WARNING"
+	case SeverityError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Finding is a single problem discovered by the check
+type Finding struct {
+	Severity   Severity
+	Nameserver string // Empty for zone-wide findings
+	Message    string
+}
+
+// Probe holds the outcome of one SOA query to a nameserver
+type Probe struct {
+	Network       string
+	Rcode         string
+	Authoritative bool
+	Serial        uint32
+	HasSOA        bool
+	Error         error
+}
+
+// Nameserver holds everything learned about one nameserver of the zone
+type Nameserver struct {
+	Name     string
+	Address  string
+	InParent bool
+	InChild  bool
+	Glue     []string
+	UDP      Probe
+	TCP      Probe
+}
+
+// Report is the result of a delegation check
+type Report struct {
+	Zone         string
+	Parent       string
+	ParentServer string // Parent nameserver that returned the referral
+	ParentNS     []string
+	ChildNS      []string
+	Nameservers  []*Nameserver
+	Findings     []Finding
+}
+
+// Healthy reports whether the check found no errors
+func (r *Report) Healthy() bool {
+	for _, finding := range r.Findings {
+		if finding.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker compares a zone's delegation at the parent with the zone itself
+type Checker struct {
+	client dns.Client
+}
+
+// NewChecker creates a delegation checker that issues queries through client
+func NewChecker(client dns.Client) *Checker {
+	return &Checker{client: client}
+}
+
+// Check inspects the delegation of zone. resolver is used for recursive
+// lookups (parent NS set and nameserver addresses); empty means system default.
+func (c *Checker) Check(zone, resolver string) (*Report, error) {
+	zone = strings.TrimSuffix(zone, ".")
+	if err := errors.ValidateDomain(zone); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(zone, ".") {
+		return nil, errors.NewInputError(fmt.Sprintf("cannot check delegation of top-level domain '%s'", zone), nil)
+	}
+
+	report := &Report{Zone: zone}
+
+	parentNS, err := c.findParent(report, resolver)
+	if err != nil {
+		return report, err
+	}
+
+	glue, err := c.fetchReferral(report, parentNS, resolver)
+	if err != nil {
+		return report, err
+	}
+
+	// Collect every nameserver named by either side of the delegation
+	byName := map[string]*Nameserver{}
+	for _, name := range report.ParentNS {
+		byName[name] = &Nameserver{Name: name, InParent: true, Glue: glue[name]}
+	}
+
+	report.ChildNS = c.fetchChildNS(zone, report.ParentNS, byName, resolver)
+	for _, name := range report.ChildNS {
+		if ns, found := byName[name]; found {
+			ns.InChild = true
+		} else {
+			byName[name] = &Nameserver{Name: name, InChild: true}
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ns := byName[name]
+		if ns.Address == "" {
+			ns.Address = c.address(ns, resolver)
+		}
+		if ns.Address != "" {
+			ns.UDP = c.probe(zone, ns.Address, "udp")
+			ns.TCP = c.probe(zone, ns.Address, "tcp")
+		}
+		report.Nameservers = append(report.Nameservers, ns)
+	}
+
+	report.Findings = analyze(report)
+	return report, nil
+}
+
+// findParent walks up from the zone to the closest enclosing zone, the first
+// name above it with NS records, and returns that zone's nameservers. The
+// parent is not always the next label up: a.b.example.com may be delegated
+// straight from example.com.
+func (c *Checker) findParent(report *Report, resolver string) ([]string, error) {
+	name := report.Zone
+	for strings.Contains(name, ".") {
+		name = name[strings.Index(name, ".")+1:]
+		result, err := c.client.Query(name, "NS", resolver)
+		if err != nil && !errors.IsDNSError(err) {
+			// The resolver cannot be reached; walking further up would fail the same way
+			return nil, err
+		}
+		if err == nil && len(result.Records) > 0 {
+			report.Parent = name
+			return result.Records, nil
+		}
+	}
+	return nil, errors.NewDNSError(fmt.Sprintf("could not find the parent zone of '%s'", report.Zone), nil, report.Zone, resolver)
+}
+
+// fetchReferral asks a parent nameserver for the zone's NS records without
+// recursion and returns the glue addresses from the referral
+func (c *Checker) fetchReferral(report *Report, parentNS []string, resolver string) (map[string][]string, error) {
+	var lastErr error
+	for _, server := range parentNS {
+		address := c.lookup(strings.TrimSuffix(server, "."), resolver)
+		if address == "" {
+			continue
+		}
+
+		response, err := c.exchange(report.Zone, mdns.TypeNS, address, "udp")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		nsSet := ownedNS(report.Zone, response.Ns)
+		if len(nsSet) == 0 {
+			// The parent also serves the child zone and answered directly
+			nsSet = ownedNS(report.Zone, response.Answer)
+		}
+		if len(nsSet) == 0 {
+			lastErr = errors.NewDNSError(fmt.Sprintf("parent server %s returned no delegation for '%s'", server, report.Zone), nil, report.Zone, address)
+			continue
+		}
+
+		report.ParentServer = strings.TrimSuffix(server, ".")
+		report.ParentNS = nsSet
+
+		glue := map[string][]string{}
+		for _, rr := range response.Extra {
+			name := strings.TrimSuffix(strings.ToLower(rr.Header().Name), ".")
+			switch record := rr.(type) {
+			case *mdns.A:
+				glue[name] = append(glue[name], record.A.String())
+			case *mdns.AAAA:
+				glue[name] = append(glue[name], record.AAAA.String())
+			}
+		}
+		return glue, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.NewDNSError(fmt.Sprintf("could not reach any nameserver of parent zone '%s'", report.Parent), nil, report.Zone, resolver)
+	}
+	return nil, lastErr
+}
+
+// fetchChildNS asks the delegated nameservers for the zone's own NS set.
+// The first authoritative answer wins.
+func (c *Checker) fetchChildNS(zone string, parentNS []string, byName map[string]*Nameserver, resolver string) []string {
+	for _, name := range parentNS {
+		ns := byName[name]
+		ns.Address = c.address(ns, resolver)
+		if ns.Address == "" {
+			continue
+		}
+
+		response, err := c.exchange(zone, mdns.TypeNS, ns.Address, "udp")
+		if err != nil || !response.Authoritative {
+			continue
+		}
+		if nsSet := ownedNS(zone, response.Answer); len(nsSet) > 0 {
+			return nsSet
+		}
+	}
+	return nil
+}
+
+// address returns the address used to probe a nameserver, preferring glue
+func (c *Checker) address(ns *Nameserver, resolver string) string {
+	if len(ns.Glue) > 0 {
+		return ns.Glue[0]
+	}
+	return c.lookup(ns.Name, resolver)
+}
+
+// lookup resolves a host name to its first IPv4 address, falling back to IPv6
+func (c *Checker) lookup(host, resolver string) string {
+	for _, recordType := range []string{"A", "AAAA"} {
+		result, err := c.client.Query(host, recordType, resolver)
+		if err == nil && len(result.Records) > 0 {
+			return result.Records[0]
+		}
+	}
+	return ""
+}
+
+// probe sends a non-recursive SOA query for zone to server
+func (c *Checker) probe(zone, server, network string) Probe {
+	probe := Probe{Network: network}
+
+	response, err := c.exchange(zone, mdns.TypeSOA, server, network)
+	if err != nil {
+		probe.Error = err
+		return probe
+	}
+
+	probe.Rcode = mdns.RcodeToString[response.Rcode]
+	probe.Authoritative = response.Authoritative
+	for _, rr := range response.Answer {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			probe.Serial = soa.Serial
+			probe.HasSOA = true
+		}
+	}
+	return probe
+}
+
+// exchange sends a non-recursive query, retrying over TCP if the UDP answer is truncated
+func (c *Checker) exchange(name string, qtype uint16, server, network string) (*mdns.Msg, error) {
+	msg := new(mdns.Msg)
+	msg.SetQuestion(mdns.Fqdn(name), qtype)
+	msg.RecursionDesired = false
+
+	response, _, err := c.client.Exchange(msg, server, network)
+	if err == nil && response.Truncated && network == "udp" {
+		response, _, err = c.client.Exchange(msg, server, "tcp")
+	}
+	return response, err
+}
+
+// ownedNS returns the sorted, lower-cased NS targets owned by zone
+func ownedNS(zone string, records []mdns.RR) []string {
+	var names []string
+	for _, rr := range records {
+		ns, ok := rr.(*mdns.NS)
+		if !ok || !strings.EqualFold(strings.TrimSuffix(ns.Hdr.Name, "."), zone) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(strings.ToLower(ns.Ns), "."))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// analyze turns the collected data into findings
+func analyze(report *Report) []Finding {
+	var findings []Finding
+	add := func(severity Severity, nameserver, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Nameserver: nameserver, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(report.ChildNS) == 0 {
+		add(SeverityError, "", "no nameserver returned an authoritative NS set for %s", report.Zone)
+	}
+
+	serials := map[uint32][]string{}
+	for _, ns := range report.Nameservers {
+		inZone := ns.Name == report.Zone || strings.HasSuffix(ns.Name, "."+report.Zone)
+		if ns.InParent && inZone && len(ns.Glue) == 0 {
+			add(SeverityError, ns.Name, "missing glue: in-zone nameserver has no address records at the parent")
+		}
+		if ns.InParent && !ns.InChild && len(report.ChildNS) > 0 {
+			add(SeverityWarning, ns.Name, "listed at the parent but not in the zone's apex NS set")
+		}
+		if ns.InChild && !ns.InParent {
+			add(SeverityWarning, ns.Name, "listed in the zone's apex NS set but not at the parent")
+		}
+
+		if ns.Address == "" {
+			add(SeverityError, ns.Name, "could not resolve an address for the nameserver")
+			continue
+		}
+
+		for _, probe := range []Probe{ns.UDP, ns.TCP} {
+			transport := strings.ToUpper(probe.Network)
+			switch {
+			case probe.Error != nil:
+				add(SeverityError, ns.Name, "lame: no response over %s (%s)", transport, errors.Message(probe.Error))
+			case probe.Rcode != "NOERROR":
+				add(SeverityError, ns.Name, "lame: answered %s over %s", probe.Rcode, transport)
+			case !probe.Authoritative:
+				add(SeverityError, ns.Name, "lame: non-authoritative answer over %s", transport)
+			case !probe.HasSOA:
+				add(SeverityError, ns.Name, "authoritative answer over %s has no SOA record", transport)
+			}
+		}
+
+		if ns.UDP.HasSOA && ns.UDP.Authoritative {
+			serials[ns.UDP.Serial] = append(serials[ns.UDP.Serial], ns.Name)
+		}
+	}
+
+	if len(serials) > 1 {
+		var parts []string
+		for serial, names := range serials {
+			parts = append(parts, fmt.Sprintf("%d (%s)", serial, strings.Join(names, ", ")))
+		}
+		sort.Strings(parts)
+		add(SeverityWarning, "", "SOA serial mismatch: %s", strings.Join(parts, "; "))
+	}
+
+	return findings
+}
@@ -0,0 +1,244 @@
+package delegation
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"go-dig/pkg/dnsfake"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+func nsRecord(owner, target string) mdns.RR {
+	return &mdns.NS{Hdr: mdns.RR_Header{Name: owner, Rrtype: mdns.TypeNS, Class: mdns.ClassINET, Ttl: 3600}, Ns: target}
+}
+
+func aRecord(owner, address string) mdns.RR {
+	return &mdns.A{Hdr: mdns.RR_Header{Name: owner, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 3600}, A: net.ParseIP(address)}
+}
+
+// parentHandler returns a referral for example.com with the given NS set and glue
+func parentHandler(nameservers []string, glue map[string]string) dnsfake.Handler {
+	return func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetReply(msg)
+		for _, ns := range nameservers {
+			response.Ns = append(response.Ns, nsRecord("example.com.", ns))
+		}
+		for name, address := range glue {
+			response.Extra = append(response.Extra, aRecord(name, address))
+		}
+		return response
+	}
+}
+
+// childHandler answers authoritatively for example.com
+func childHandler(nameservers []string, serial uint32) dnsfake.Handler {
+	return func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetReply(msg)
+		response.Authoritative = true
+		switch msg.Question[0].Qtype {
+		case mdns.TypeNS:
+			for _, ns := range nameservers {
+				response.Answer = append(response.Answer, nsRecord("example.com.", ns))
+			}
+		case mdns.TypeSOA:
+			response.Answer = append(response.Answer, &mdns.SOA{
+				Hdr:    mdns.RR_Header{Name: "example.com.", Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: 3600},
+				Ns:     "ns1.example.com.",
+				Mbox:   "hostmaster.example.com.",
+				Serial: serial,
+			})
+		}
+		return response
+	}
+}
+
+func healthyFixture() *dnsfake.Client {
+	nameservers := []string{"ns1.example.com.", "ns2.example.com."}
+	client := dnsfake.NewClient()
+	client.Answer("", "com", "NS", "a.gtld-servers.net.")
+	client.Answer("", "a.gtld-servers.net", "A", "192.0.2.1")
+	client.Answer("", "ns1.example.com", "A", "192.0.2.53")
+	client.Answer("", "ns2.example.com", "A", "192.0.2.54")
+	client.Handle("192.0.2.1", parentHandler(nameservers, map[string]string{"ns1.example.com.": "192.0.2.53", "ns2.example.com.": "192.0.2.54"}))
+	client.Handle("192.0.2.53", childHandler(nameservers, 2024010101))
+	client.Handle("192.0.2.54", childHandler(nameservers, 2024010101))
+	return client
+}
+
+func TestChecker_Check_Healthy(t *testing.T) {
+	report, err := NewChecker(healthyFixture()).Check("example.com", "")
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+
+	if !report.Healthy() || len(report.Findings) != 0 {
+		t.Errorf("Expected a healthy delegation, got findings %+v", report.Findings)
+	}
+	if report.Parent != "com" || report.ParentServer != "a.gtld-servers.net" {
+		t.Errorf("Unexpected parent %s / %s", report.Parent, report.ParentServer)
+	}
+	if len(report.Nameservers) != 2 {
+		t.Fatalf("Expected 2 nameservers, got %d", len(report.Nameservers))
+	}
+
+	ns := report.Nameservers[0]
+	if ns.Name != "ns1.example.com" || !ns.InParent || !ns.InChild || len(ns.Glue) != 1 {
+		t.Errorf("Unexpected nameserver %+v", ns)
+	}
+	if ns.UDP.Serial != 2024010101 || !ns.TCP.Authoritative {
+		t.Errorf("Unexpected probes %+v / %+v", ns.UDP, ns.TCP)
+	}
+}
+
+func TestChecker_Check_Problems(t *testing.T) {
+	client := healthyFixture()
+	parentSet := []string{"ns1.example.com.", "ns2.example.com.", "ns.other.net."}
+	childSet := []string{"ns1.example.com.", "ns2.example.com.", "ns3.example.com."}
+
+	// ns2 has no glue, ns.other.net is lame, ns3 is unknown to the parent and unresolvable
+	client.Answer("", "ns.other.net", "A", "198.51.100.1")
+	client.Handle("192.0.2.1", parentHandler(parentSet, map[string]string{"ns1.example.com.": "192.0.2.53"}))
+	client.Handle("192.0.2.53", childHandler(childSet, 2024010101))
+	client.Handle("192.0.2.54", childHandler(childSet, 2024010102))
+	client.Handle("198.51.100.1", func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetRcode(msg, mdns.RcodeRefused)
+		return response
+	})
+
+	report, err := NewChecker(client).Check("example.com.", "")
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+	if report.Healthy() {
+		t.Error("Expected an unhealthy delegation")
+	}
+
+	expected := []string{
+		"ns2.example.com: missing glue",
+		"ns.other.net: listed at the parent but not in the zone's apex NS set",
+		"ns.other.net: lame: answered REFUSED over UDP",
+		"ns.other.net: lame: answered REFUSED over TCP",
+		"ns3.example.com: listed in the zone's apex NS set but not at the parent",
+		"ns3.example.com: could not resolve an address",
+		": SOA serial mismatch: 2024010101 (ns1.example.com); 2024010102 (ns2.example.com)",
+	}
+
+	var actual []string
+	for _, finding := range report.Findings {
+		actual = append(actual, finding.Nameserver+": "+finding.Message)
+	}
+	joined := strings.Join(actual, "\n")
+
+	for _, want := range expected {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected finding %q, got:\n%s", want, joined)
+		}
+	}
+}
+
+func TestChecker_Check_NonAuthoritativeAndTCPFailure(t *testing.T) {
+	client := healthyFixture()
+	authoritative := childHandler([]string{"ns1.example.com.", "ns2.example.com."}, 2024010101)
+	client.Handle("192.0.2.54", func(msg *mdns.Msg, network string) *mdns.Msg {
+		if network == "tcp" {
+			return nil
+		}
+		response := authoritative(msg, network)
+		if msg.Question[0].Qtype == mdns.TypeSOA {
+			response.Authoritative = false
+		}
+		return response
+	})
+
+	report, err := NewChecker(client).Check("example.com", "")
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+
+	var messages []string
+	for _, finding := range report.Findings {
+		messages = append(messages, finding.Message)
+	}
+	joined := strings.Join(messages, "\n")
+
+	for _, want := range []string{"lame: non-authoritative answer over UDP", "lame: no response over TCP (DNS server refused connection)"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected finding %q, got:\n%s", want, joined)
+		}
+	}
+}
+
+func TestChecker_Check_InvalidZone(t *testing.T) {
+	client := healthyFixture()
+
+	if _, err := NewChecker(client).Check("com", ""); !errors.IsInputError(err) {
+		t.Errorf("Expected input error for a top-level domain, got %v", err)
+	}
+	if _, err := NewChecker(client).Check("bad..zone", ""); !errors.IsInputError(err) {
+		t.Errorf("Expected input error for an invalid zone, got %v", err)
+	}
+}
+
+func TestChecker_Check_NoDelegation(t *testing.T) {
+	client := healthyFixture()
+	client.Handle("192.0.2.1", func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetReply(msg)
+		return response
+	})
+
+	_, err := NewChecker(client).Check("example.com", "")
+	if !errors.IsDNSError(err) || !strings.Contains(err.Error(), "returned no delegation") {
+		t.Errorf("Expected missing delegation error, got %v", err)
+	}
+}
+
+func TestChecker_Check_ParentAboveNextLabel(t *testing.T) {
+	// a.b.example.com is delegated straight from example.com; b.example.com is not a zone
+	nameservers := []string{"ns1.a.b.example.com."}
+	client := dnsfake.NewClient()
+	client.Answer("", "example.com", "NS", "ns.example.com.")
+	client.Answer("", "ns.example.com", "A", "192.0.2.1")
+	client.Answer("", "ns1.a.b.example.com", "A", "192.0.2.53")
+	client.Handle("192.0.2.1", func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetReply(msg)
+		response.Ns = append(response.Ns, nsRecord("a.b.example.com.", nameservers[0]))
+		return response
+	})
+	client.Handle("192.0.2.53", func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetReply(msg)
+		response.Authoritative = true
+		response.Answer = append(response.Answer, nsRecord("a.b.example.com.", nameservers[0]))
+		return response
+	})
+
+	report, err := NewChecker(client).Check("a.b.example.com", "")
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+	if report.Parent != "example.com" || report.ParentServer != "ns.example.com" {
+		t.Errorf("Expected the referral from example.com, got %s / %s", report.Parent, report.ParentServer)
+	}
+	if len(report.ParentNS) != 1 || report.ParentNS[0] != "ns1.a.b.example.com" {
+		t.Errorf("Unexpected parent NS set %v", report.ParentNS)
+	}
+}
+
+func TestChecker_Check_NoParent(t *testing.T) {
+	client := healthyFixture()
+	// com has no NS records of its own, so no parent zone is found
+	client.Answer("", "com", "NS")
+
+	_, err := NewChecker(client).Check("example.com", "")
+	if !errors.IsDNSError(err) || !strings.Contains(err.Error(), "could not find the parent zone of 'example.com'") {
+		t.Errorf("Expected missing parent error, got %v", err)
+	}
+}
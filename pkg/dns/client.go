@@ -1,42 +1,201 @@
 package dns
 
 import (
+	"encoding/hex"
 	"fmt"
 	"go-dig/pkg/errors"
+	"go-dig/pkg/idn"
+	"io"
 	"net"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
+// Record holds a single answer record together with its owner name and TTL
+type Record struct {
+	Name  string
+	Type  string
+	TTL   uint32
+	Value string
+	Data  string // Record data in master-file syntax (RFC 1035), with TXT strings quoted
+}
+
 // Result holds the results of a DNS query including timing information
 type Result struct {
-	Domain     string
-	RecordType string
-	Records    []string
-	Server     string
-	QueryTime  time.Duration
-	Error      error
+	Domain         string
+	RecordType     string
+	Records        []string
+	Answers        []Record               // Structured form of Records, in the same order
+	Rcode          string                 // Response code name (e.g. NOERROR, NXDOMAIN); empty if no response
+	NoData         bool                   // NOERROR without answers and with an SOA: the name exists but has no records of this type (RFC 2308)
+	SOA            *Record                // Authority-section SOA of a NODATA answer
+	NegativeTTL    uint32                 // How long the NODATA answer may be cached (RFC 2308)
+	ExtendedErrors []errors.ExtendedError // Extended DNS Errors (RFC 8914) sent with the response
+	Class          string                 // Query class name (e.g. IN, CH)
+	NSID           string                 // Name server identifier (RFC 5001) sent with the response, if asked for
+	Cookie         *CookieResult          // DNS cookie exchange (RFC 7873); nil unless cookies were sent
+	Flags          []string               // Header flags set in the last response, in dig's order (qr aa tc rd ra ad cd)
+	Opcode         string                 // Opcode of the last response (e.g. QUERY, NOTIFY)
+	Chain          []Record               // CNAME records leading from Domain to the owner of the answers, in order
+	Unresolved     bool                   // The chain ends at a target the server did not resolve (FollowCNAME chases it)
+	Referral       []Record               // Authority-section NS records of a referral: an empty, non-authoritative answer naming the servers to ask
+	Server         string
+	QueryTime      time.Duration
+	Error          error
 }
 
 // Client interface defines the DNS query functionality
 type Client interface {
 	Query(domain, recordType, server string) (*Result, error)
+	Exchange(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error)
 	SetTimeout(duration time.Duration)
 }
 
+// ednsBufferSize is the UDP payload size advertised in queries, the value
+// recommended by DNS Flag Day 2020 to avoid fragmentation
+const ednsBufferSize = 1232
+
+// MaxChainLength is the number of CNAME aliases a query follows before
+// giving up on the chain
+const MaxChainLength = 16
+
+// Options configures a client created with NewClientWithOptions
+type Options struct {
+	Timeout     time.Duration
+	Tries       int  // Attempts per query when the server does not answer in time; 0 means 1
+	Port        int  // Port used for every server, overriding any port in the address; 0 keeps it
+	IPv4Only    bool // Query over IPv4 only
+	IPv6Only    bool // Query over IPv6 only
+	FollowCNAME bool // Chase CNAME chains the server did not resolve with further queries
+	TCP         bool // Query over TCP, keeping one connection per server open between queries
+	DNSSEC      bool // Set the DNSSEC OK bit so servers include DNSSEC records
+
+	Class uint16 // Query class (see ParseClass); 0 means IN
+	NSID  bool   // Ask servers for their name server identifier (RFC 5001)
+
+	NoRecurse bool // Clear the RD bit so servers answer from their own data only
+	CD        bool // Set the CD bit so validating resolvers skip DNSSEC checks
+	AD        bool // Set the AD bit to ask whether the answer was validated (RFC 6840)
+	AAOnly    bool // Set the AA bit, which some servers take as a request for authoritative data only
+	Opcode    int  // Query opcode (see ParseOpcode); 0 is QUERY
+
+	Cookies *CookieJar // Send DNS cookies (RFC 7873), keeping server cookies in this jar; nil sends none
+	Cookie  string     // Send this cookie, in hex, until a server returns a valid one (see ValidateCookie); invalid cookies are ignored
+
+	Transport Transport     // Sends queries in place of the network, such as a Replayer
+	Record    io.Writer     // Write every exchange to this fixture (see Recorder)
+	Capture   PacketCapture // Receives every message sent and received over the network
+}
+
+// PacketCapture receives the DNS messages a client exchanges with servers, in
+// wire format exactly as they were sent and received. The addresses are those
+// of the connection, *net.UDPAddr or *net.TCPAddr. pcap.Writer implements it.
+type PacketCapture interface {
+	WriteDNS(at time.Time, src, dst net.Addr, msg []byte) error
+}
+
 // client implements the Client interface
 type client struct {
-	timeout time.Duration
+	timeout     time.Duration
+	tries       int
+	port        int
+	ipv4Only    bool
+	ipv6Only    bool
+	followCNAME bool
+	tcp         bool
+	query       querySettings // Class, flags and EDNS options of every query
+	transport   Transport     // nil sends queries over the network
+	capture     PacketCapture // nil captures nothing
+	cookies     *CookieJar    // nil sends no cookies
+
+	cookieMu sync.Mutex
+	cookie   *cookiePair // Sent in place of the jar's cookie until the jar holds a server cookie from the server
+
+	mu    sync.Mutex
+	conns map[string]*tcpConn // TCP connections kept open between queries, by server address
+}
+
+// tcpConn is a TCP connection to one server kept open between queries. Its
+// mutex keeps one exchange at a time on the connection, so queries to other
+// servers are not held up by a dial or a slow answer.
+type tcpConn struct {
+	mu   sync.Mutex
+	conn *dns.Conn // nil until dialled, and after a failed exchange
 }
 
 // NewClient creates a new DNS client with default timeout
 func NewClient() Client {
 	return &client{
 		timeout: 5 * time.Second, // Default 5 second timeout
+		tries:   1,
+		query:   newQuerySettings(Options{}),
+	}
+}
+
+// NewClientWithOptions creates a DNS client with the given options. Zero
+// values select the defaults.
+func NewClientWithOptions(options Options) Client {
+	c := &client{
+		timeout:     5 * time.Second, // Default 5 second timeout
+		tries:       1,
+		port:        options.Port,
+		ipv4Only:    options.IPv4Only,
+		ipv6Only:    options.IPv6Only,
+		followCNAME: options.FollowCNAME,
+		tcp:         options.TCP,
+		query:       newQuerySettings(options),
+		transport:   options.Transport,
+		capture:     options.Capture,
+		cookies:     options.Cookies,
+	}
+	if options.Timeout > 0 {
+		c.timeout = options.Timeout
 	}
+	if options.Tries > 0 {
+		c.tries = options.Tries
+	}
+	if cookie, err := parseCookie(options.Cookie); options.Cookie != "" && err == nil {
+		c.cookie = &cookie
+		if c.cookies == nil {
+			c.cookies = NewCookieJar()
+		}
+	}
+	if options.Record != nil {
+		next := c.transport
+		if next == nil {
+			next = TransportFunc(c.exchangeNetwork)
+		}
+		c.transport = NewRecorder(next, options.Record)
+	}
+	return c
+}
+
+// Close closes the TCP connections the client kept open between queries.
+// The client remains usable and dials again when needed.
+func (c *client) Close() error {
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+
+	// Exchanges in progress finish before their connection is closed
+	var firstErr error
+	for _, tc := range conns {
+		tc.mu.Lock()
+		if tc.conn != nil {
+			if err := tc.conn.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			tc.conn = nil
+		}
+		tc.mu.Unlock()
+	}
+	return firstErr
 }
 
 // SetTimeout sets the query timeout duration
@@ -49,6 +208,7 @@ func (c *client) Query(domain, recordType, server string) (*Result, error) {
 	result := &Result{
 		Domain:     domain,
 		RecordType: recordType,
+		Class:      dns.Class(c.query.class).String(),
 		Server:     server,
 		Records:    []string{},
 	}
@@ -59,6 +219,10 @@ func (c *client) Query(domain, recordType, server string) (*Result, error) {
 		return result, err
 	}
 
+	// Internationalized names go on the wire as A-labels
+	domain, _ = idn.ToASCII(domain)
+	result.Domain = domain
+
 	// Validate record type
 	if err := errors.ValidateRecordType(recordType); err != nil {
 		result.Error = err
@@ -66,6 +230,646 @@ func (c *client) Query(domain, recordType, server string) (*Result, error) {
 	}
 
 	// Prepare DNS server
+	finalServer, err := c.serverAddress(server)
+	if err != nil {
+		result.Error = err
+		return result, err
+	}
+	result.Server = finalServer
+
+	// Select the transport protocol and address family
+	network := "udp"
+	if c.tcp {
+		network = "tcp"
+	}
+	network = c.family(network)
+
+	// Determine DNS query type
+	recordTypeUpper := strings.ToUpper(recordType)
+	queryType, err := queryTypeCode(recordType)
+	if err != nil {
+		result.Error = err
+		return result, err
+	}
+
+	// Follow the CNAME chain from the query name; a CNAME query wants the alias itself
+	name := dns.Fqdn(domain)
+	seen := map[string]bool{strings.ToLower(name): true}
+	for {
+		response, err := c.send(network, name, queryType, finalServer, result)
+		if err != nil {
+			result.Error = err
+			return result, err
+		}
+
+		target := name
+		if queryType != dns.TypeCNAME {
+			target, err = walkChain(result, response.Answer, name, seen)
+			if err != nil {
+				result.Error = err
+				return result, err
+			}
+		}
+
+		// Check response code and create appropriate DNS errors
+		if response.Rcode != dns.RcodeSuccess {
+			dnsErr := rcodeError(response.Rcode, result, finalServer)
+			result.Error = dnsErr
+			return result, dnsErr
+		}
+
+		extractRecords(result, response.Answer, recordTypeUpper)
+
+		// A server that is not authoritative for the target, or does not recurse,
+		// may stop at a CNAME; chase the rest of the chain if asked to
+		if len(result.Records) == 0 && target != name && c.followCNAME {
+			name = target
+			continue
+		}
+
+		if len(result.Records) == 0 {
+			// A truncated answer may have dropped the records; otherwise the name exists without this type
+			if response.Truncated {
+				err := errors.NewDNSError(fmt.Sprintf("response for %s records of '%s' was truncated and contained no answers", recordTypeUpper, domain), nil, domain, finalServer).WithKind(errors.ErrTruncated)
+				result.Error = err
+				return result, err
+			}
+			// Only an SOA makes an empty answer NODATA (RFC 2308, section 2.2); a
+			// chain that stops at its target without one was simply not resolved,
+			// and a server without the zone that does not recurse refers to the
+			// servers that have it
+			soa, ttl := negativeSOA(response.Ns)
+			referral := referralNS(response.Ns)
+			switch {
+			case soa != nil:
+				result.NoData = true
+				result.SOA, result.NegativeTTL = soa, ttl
+			case target != name:
+				result.Unresolved = true
+			case !response.Authoritative && len(referral) > 0:
+				result.Referral = referral
+			default:
+				err := errors.NewDNSError(fmt.Sprintf("DNS server returned no %s records for '%s' and neither an SOA nor a referral", recordTypeUpper, domain), nil, domain, finalServer).WithKind(errors.ErrEmptyAnswer)
+				result.Error = err
+				return result, err
+			}
+		}
+
+		return result, nil
+	}
+}
+
+// send queries server for name and records the response code, Extended DNS
+// Errors and elapsed time on result
+func (c *client) send(network, name string, queryType uint16, server string, result *Result) (*dns.Msg, error) {
+	msg := newQuery(name, queryType, c.query)
+	var cookie cookiePair
+	if c.cookies != nil {
+		cookie = c.cookieFor(server)
+		addCookie(msg, cookie)
+	}
+
+	response, err := c.exchangeWithRetry(msg, server, network, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cookies != nil {
+		result.Cookie = c.keepCookie(response, server, cookie)
+		// A server that rejects a cookie answers BADCOOKIE with a fresh server
+		// cookie; send the query once more with it (RFC 7873, section 5.3)
+		if response.Rcode == dns.RcodeBadCookie && result.Cookie.Status == CookieValid {
+			msg = newQuery(name, queryType, c.query)
+			cookie = c.cookies.get(server)
+			addCookie(msg, cookie)
+			if response, err = c.exchangeWithRetry(msg, server, network, result); err != nil {
+				return nil, err
+			}
+			result.Cookie = c.keepCookie(response, server, cookie)
+			result.Cookie.BadCookie = true
+		}
+	}
+
+	result.Rcode = dns.RcodeToString[response.Rcode]
+	result.ExtendedErrors = extendedErrors(response)
+	result.Flags = responseFlags(response)
+	result.Opcode = OpcodeName(response.Opcode)
+	if c.query.nsid {
+		result.NSID = nsid(response)
+	}
+	return response, nil
+}
+
+// exchangeWithRetry sends msg to server, trying again while the server does
+// not answer in time, and adds the time taken to result
+func (c *client) exchangeWithRetry(msg *dns.Msg, server, network string, result *Result) (*dns.Msg, error) {
+	var response *dns.Msg
+	var err error
+	for attempt := 1; ; attempt++ {
+		var rtt time.Duration
+		response, rtt, err = c.roundTrip(msg, server, network)
+		result.QueryTime += rtt
+
+		if err == nil {
+			break
+		}
+		// Classify and wrap the network error
+		netErr := errors.ClassifyNetworkError(err, server)
+		if attempt >= c.tries || !errors.Is(netErr, errors.ErrTimeout) {
+			return nil, netErr
+		}
+	}
+
+	if response == nil {
+		return nil, errors.NewNetworkError("no response received from DNS server", nil, server).WithKind(errors.ErrNoResponse)
+	}
+	return response, nil
+}
+
+// NewQuery returns the first message a client with the given options sends
+// when querying domain for recordType, with a random ID. Internationalized
+// names are converted to A-labels as they are by Query. With cookies, the
+// message carries the given cookie or a new client cookie.
+func NewQuery(domain, recordType string, options Options) (*dns.Msg, error) {
+	if err := errors.ValidateDomain(domain); err != nil {
+		return nil, err
+	}
+	domain, _ = idn.ToASCII(domain)
+
+	if err := errors.ValidateRecordType(recordType); err != nil {
+		return nil, err
+	}
+	queryType, err := queryTypeCode(recordType)
+	if err != nil {
+		return nil, err
+	}
+	msg := newQuery(dns.Fqdn(domain), queryType, newQuerySettings(options))
+	switch {
+	case options.Cookie != "":
+		cookie, err := parseCookie(options.Cookie)
+		if err != nil {
+			return nil, err
+		}
+		addCookie(msg, cookie)
+	case options.Cookies != nil:
+		addCookie(msg, cookiePair{client: newClientCookie()})
+	}
+	return msg, nil
+}
+
+// querySettings are the options that shape the query messages a client sends
+type querySettings struct {
+	class   uint16
+	dnssec  bool
+	nsid    bool
+	recurse bool
+	cd      bool
+	ad      bool
+	aa      bool
+	opcode  int
+}
+
+// newQuerySettings takes the query settings from options
+func newQuerySettings(options Options) querySettings {
+	settings := querySettings{class: options.Class, dnssec: options.DNSSEC, nsid: options.NSID,
+		recurse: !options.NoRecurse, cd: options.CD, ad: options.AD, aa: options.AAOnly, opcode: options.Opcode}
+	if settings.class == 0 {
+		settings.class = dns.ClassINET
+	}
+	return settings
+}
+
+// newQuery builds a query for name, which must be fully qualified, with the
+// header flags and opcode of settings
+func newQuery(name string, queryType uint16, settings querySettings) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, queryType)
+	msg.Question[0].Qclass = settings.class
+	msg.Opcode = settings.opcode
+	msg.RecursionDesired = settings.recurse
+	msg.CheckingDisabled = settings.cd
+	msg.AuthenticatedData = settings.ad
+	msg.Authoritative = settings.aa
+	// EDNS lets resolvers explain failures with Extended DNS Errors
+	msg.SetEdns0(ednsBufferSize, settings.dnssec)
+	if settings.nsid {
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+	return msg
+}
+
+// ParseClass returns the code of a query class given by name (IN, CH or
+// CHAOS, HS or HESIOD, NONE, ANY), in the CLASSnnn form of RFC 3597, or as
+// a number
+func ParseClass(class string) (uint16, error) {
+	upper := strings.ToUpper(class)
+	switch upper {
+	case "CHAOS":
+		return dns.ClassCHAOS, nil
+	case "HESIOD":
+		return dns.ClassHESIOD, nil
+	}
+	if code, ok := dns.StringToClass[upper]; ok {
+		return code, nil
+	}
+
+	number := strings.TrimPrefix(upper, "CLASS")
+	code, err := strconv.ParseUint(number, 10, 16)
+	if err != nil || number == "" {
+		return 0, errors.NewInputError(fmt.Sprintf("unknown query class '%s' (expected IN, CH, HS, ANY, CLASSnnn or a number)", class), nil)
+	}
+	return uint16(code), nil
+}
+
+// ParseOpcode returns the code of a query opcode given by name (QUERY,
+// IQUERY, STATUS, NOTIFY, UPDATE) or as a number from 0 to 15
+func ParseOpcode(opcode string) (int, error) {
+	if code, ok := dns.StringToOpcode[strings.ToUpper(opcode)]; ok {
+		return code, nil
+	}
+	code, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(opcode), "OPCODE"), 10, 4)
+	if err != nil {
+		return 0, errors.NewInputError(fmt.Sprintf("unknown opcode '%s' (expected QUERY, IQUERY, STATUS, NOTIFY, UPDATE or a number from 0 to 15)", opcode), nil)
+	}
+	return int(code), nil
+}
+
+// OpcodeName returns the name of an opcode, or OPCODEnn for one without a name
+func OpcodeName(opcode int) string {
+	if name, ok := dns.OpcodeToString[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("OPCODE%d", opcode)
+}
+
+// responseFlags returns the names of the header flags set in a response, in
+// the order dig shows them
+func responseFlags(response *dns.Msg) []string {
+	flags := []string{}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", response.Response},
+		{"aa", response.Authoritative},
+		{"tc", response.Truncated},
+		{"rd", response.RecursionDesired},
+		{"ra", response.RecursionAvailable},
+		{"ad", response.AuthenticatedData},
+		{"cd", response.CheckingDisabled},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+// queryTypeCode returns the type code of a supported record type
+func queryTypeCode(recordType string) (uint16, error) {
+	switch strings.ToUpper(recordType) {
+	case "A":
+		return dns.TypeA, nil
+	case "AAAA":
+		return dns.TypeAAAA, nil
+	case "MX":
+		return dns.TypeMX, nil
+	case "CNAME":
+		return dns.TypeCNAME, nil
+	case "TXT":
+		return dns.TypeTXT, nil
+	case "NS":
+		return dns.TypeNS, nil
+	}
+	// This should not happen due to validation, but handle it gracefully
+	return 0, errors.NewInputError(fmt.Sprintf("record type '%s' not supported", recordType), nil)
+}
+
+// roundTrip sends msg through the client's transport, or over the network
+// if it has none
+func (c *client) roundTrip(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+	if c.transport != nil {
+		return c.transport.Exchange(msg, server, network)
+	}
+	return c.exchangeNetwork(msg, server, network)
+}
+
+// exchangeNetwork sends msg to server over the network and returns the time
+// taken, also when the exchange fails. With the TCP option the connection to
+// the server is kept for later queries; a kept connection the server has
+// since closed is replaced once before giving up.
+func (c *client) exchangeNetwork(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+	dnsClient := &dns.Client{Net: network, Timeout: c.timeout}
+	start := time.Now()
+
+	if !c.tcp || !strings.HasPrefix(network, "tcp") {
+		if c.capture == nil {
+			response, _, err := dnsClient.Exchange(msg, server)
+			return response, time.Since(start), err
+		}
+		conn, err := dnsClient.Dial(server)
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+		defer conn.Close()
+		response, err := c.exchangeConn(dnsClient, conn, msg)
+		return response, time.Since(start), err
+	}
+
+	tc := c.tcpConn(server)
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	reused := tc.conn != nil
+	for {
+		if tc.conn == nil {
+			conn, err := dnsClient.Dial(server)
+			if err != nil {
+				return nil, time.Since(start), err
+			}
+			tc.conn = conn
+		}
+
+		response, err := c.exchangeConn(dnsClient, tc.conn, msg)
+		if err == nil {
+			return response, time.Since(start), nil
+		}
+
+		// The connection is in an unknown state after a failure
+		tc.conn.Close()
+		tc.conn = nil
+		if !reused {
+			return nil, time.Since(start), err
+		}
+		reused = false
+	}
+}
+
+// tcpConn returns the kept-open TCP connection to server, adding an
+// undialled one the first time
+func (c *client) tcpConn(server string) *tcpConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tc, ok := c.conns[server]
+	if !ok {
+		if c.conns == nil {
+			c.conns = map[string]*tcpConn{}
+		}
+		tc = &tcpConn{}
+		c.conns[server] = tc
+	}
+	return tc
+}
+
+// exchangeConn sends msg over conn and reads the response. With a packet
+// capture the messages are written and read here rather than by the dns
+// package, so that the capture gets the bytes that crossed the wire.
+func (c *client) exchangeConn(dnsClient *dns.Client, conn *dns.Conn, msg *dns.Msg) (*dns.Msg, error) {
+	if c.capture == nil {
+		response, _, err := dnsClient.ExchangeWithConn(msg, conn)
+		return response, err
+	}
+
+	if opt := msg.IsEdns0(); opt != nil && opt.UDPSize() > dns.MinMsgSize {
+		conn.UDPSize = opt.UDPSize()
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	c.capture.WriteDNS(time.Now(), conn.LocalAddr(), conn.RemoteAddr(), packed)
+
+	// Like the dns package, skip replies to other queries
+	for {
+		raw, err := conn.ReadMsgHeader(nil)
+		if err != nil {
+			return nil, err
+		}
+		c.capture.WriteDNS(time.Now(), conn.RemoteAddr(), conn.LocalAddr(), raw)
+
+		response := new(dns.Msg)
+		if err := response.Unpack(raw); err != nil {
+			return nil, err
+		}
+		if response.Id == msg.Id {
+			return response, nil
+		}
+	}
+}
+
+// walkChain appends the CNAME records in answers that lead from name to
+// result.Chain and returns the name at the end of the chain. seen holds the
+// names already visited, so loops are detected across chased queries.
+func walkChain(result *Result, answers []dns.RR, name string, seen map[string]bool) (string, error) {
+	aliases := map[string]*dns.CNAME{}
+	for _, answer := range answers {
+		if cname, ok := answer.(*dns.CNAME); ok {
+			aliases[strings.ToLower(cname.Hdr.Name)] = cname
+		}
+	}
+
+	for {
+		cname, ok := aliases[strings.ToLower(name)]
+		if !ok {
+			return name, nil
+		}
+		if seen[strings.ToLower(cname.Target)] {
+			return name, errors.NewDNSError(fmt.Sprintf("CNAME chain of '%s' loops back to '%s'", result.Domain, cname.Target), nil, result.Domain, result.Server).WithKind(errors.ErrCNAMELoop)
+		}
+		if len(result.Chain) >= MaxChainLength {
+			return name, errors.NewDNSError(fmt.Sprintf("CNAME chain of '%s' is longer than %d aliases", result.Domain, MaxChainLength), nil, result.Domain, result.Server).WithKind(errors.ErrChainTooLong)
+		}
+
+		result.Chain = append(result.Chain, Record{
+			Name:  cname.Hdr.Name,
+			Type:  "CNAME",
+			TTL:   cname.Hdr.Ttl,
+			Value: cname.Target,
+			Data:  rdata(cname),
+		})
+		seen[strings.ToLower(cname.Target)] = true
+		name = cname.Target
+	}
+}
+
+// rcodeError maps a failing response code to a DNS error. When the query
+// followed a CNAME chain the error names the alias target it applies to.
+func rcodeError(rcode int, result *Result, server string) *errors.DigError {
+	domain := result.Domain
+	subject := fmt.Sprintf("domain '%s'", domain)
+	if len(result.Chain) > 0 {
+		subject = fmt.Sprintf("alias target '%s' of '%s'", strings.TrimSuffix(result.Chain[len(result.Chain)-1].Value, "."), domain)
+	}
+
+	var dnsErr *errors.DigError
+	switch rcode {
+	case dns.RcodeNameError:
+		dnsErr = errors.NewDNSError(fmt.Sprintf("%s not found (NXDOMAIN)", subject), nil, domain, server).WithKind(errors.ErrNXDomain)
+	case dns.RcodeServerFailure:
+		dnsErr = errors.NewDNSError("DNS server experienced an internal failure", nil, domain, server).WithKind(errors.ErrServFail)
+	case dns.RcodeRefused:
+		dnsErr = errors.NewDNSError("DNS server refused the query", nil, domain, server).WithKind(errors.ErrRefused)
+	case dns.RcodeNotImplemented:
+		dnsErr = errors.NewDNSError("DNS server does not support this query type", nil, domain, server).WithKind(errors.ErrNotImplemented)
+	case dns.RcodeFormatError:
+		dnsErr = errors.NewDNSError("DNS query format error", nil, domain, server).WithKind(errors.ErrFormatError)
+	case dns.RcodeBadCookie:
+		dnsErr = errors.NewDNSError("DNS server rejected the DNS cookie (BADCOOKIE)", nil, domain, server).WithKind(errors.ErrBadCookie)
+	default:
+		dnsErr = errors.NewDNSError(fmt.Sprintf("DNS query failed with response code %d", rcode), nil, domain, server)
+	}
+	return dnsErr.WithExtendedErrors(result.ExtendedErrors)
+}
+
+// extractRecords appends the answers of the requested type to result
+func extractRecords(result *Result, answers []dns.RR, recordTypeUpper string) {
+	for _, answer := range answers {
+		var value string
+		switch recordTypeUpper {
+		case "A":
+			if aRecord, ok := answer.(*dns.A); ok {
+				value = aRecord.A.String()
+			}
+		case "AAAA":
+			if aaaaRecord, ok := answer.(*dns.AAAA); ok {
+				value = aaaaRecord.AAAA.String()
+			}
+		case "MX":
+			if mxRecord, ok := answer.(*dns.MX); ok {
+				value = fmt.Sprintf("%d %s", mxRecord.Preference, mxRecord.Mx)
+			}
+		case "CNAME":
+			if cnameRecord, ok := answer.(*dns.CNAME); ok {
+				value = cnameRecord.Target
+			}
+		case "TXT":
+			if txtRecord, ok := answer.(*dns.TXT); ok {
+				// TXT records can have multiple strings, join them
+				value = strings.Join(txtRecord.Txt, " ")
+			}
+		case "NS":
+			if nsRecord, ok := answer.(*dns.NS); ok {
+				value = nsRecord.Ns
+			}
+		}
+		if value == "" {
+			continue
+		}
+
+		result.Records = append(result.Records, value)
+		result.Answers = append(result.Answers, Record{
+			Name:  answer.Header().Name,
+			Type:  recordTypeUpper,
+			TTL:   answer.Header().Ttl,
+			Value: value,
+			Data:  rdata(answer),
+		})
+	}
+}
+
+// extendedErrors returns the Extended DNS Error options of the response's OPT record
+func extendedErrors(response *dns.Msg) []errors.ExtendedError {
+	opt := response.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	var extended []errors.ExtendedError
+	for _, option := range opt.Option {
+		if ede, ok := option.(*dns.EDNS0_EDE); ok {
+			extended = append(extended, errors.ExtendedError{Code: ede.InfoCode, Text: ede.ExtraText})
+		}
+	}
+	return extended
+}
+
+// nsid returns the name server identifier in the response's OPT record
+func nsid(response *dns.Msg) string {
+	opt := response.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, option := range opt.Option {
+		if id, ok := option.(*dns.EDNS0_NSID); ok {
+			// The option holds the identifier in hex
+			decoded, err := hex.DecodeString(id.Nsid)
+			if err != nil {
+				return ""
+			}
+			return string(decoded)
+		}
+	}
+	return ""
+}
+
+// rdata returns the data of a record in master-file syntax
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// referralNS returns the NS records in the authority section of a referral
+func referralNS(authority []dns.RR) []Record {
+	var records []Record
+	for _, rr := range authority {
+		if ns, ok := rr.(*dns.NS); ok {
+			records = append(records, Record{Name: ns.Hdr.Name, Type: "NS", TTL: ns.Hdr.Ttl, Value: ns.Ns, Data: rdata(ns)})
+		}
+	}
+	return records
+}
+
+// negativeSOA returns the SOA record from the authority section of a negative
+// answer and its negative-caching TTL: the lesser of the SOA's own TTL and its
+// MINIMUM field (RFC 2308, section 5)
+func negativeSOA(authority []dns.RR) (*Record, uint32) {
+	for _, rr := range authority {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+		record := &Record{
+			Name:  soa.Hdr.Name,
+			Type:  "SOA",
+			TTL:   soa.Hdr.Ttl,
+			Value: fmt.Sprintf("%s %s %d %d %d %d %d", soa.Ns, soa.Mbox, soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minttl),
+			Data:  rdata(soa),
+		}
+		ttl := soa.Hdr.Ttl
+		if soa.Minttl < ttl {
+			ttl = soa.Minttl
+		}
+		return record, ttl
+	}
+	return nil, 0
+}
+
+// Exchange sends a prepared DNS message to server over network ("udp" or "tcp")
+// and returns the raw response. server accepts the same formats as Query, and
+// the port and address family options apply as they do to Query.
+func (c *client) Exchange(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+	finalServer, err := c.serverAddress(server)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, rtt, err := c.roundTrip(msg, finalServer, c.family(network))
+	if err != nil {
+		return nil, rtt, errors.ClassifyNetworkError(err, finalServer)
+	}
+
+	return response, rtt, nil
+}
+
+// resolveServer turns a user-supplied DNS server into a host:port address.
+// An empty server selects the system default resolver.
+func resolveServer(server string) (string, error) {
 	var finalServer string
 	if server == "" {
 		// Use system default DNS server
@@ -83,24 +887,19 @@ func (c *client) Query(domain, recordType, server string) (*Result, error) {
 			// IPv6 with port in brackets format [::1]:53
 			host, port, err := net.SplitHostPort(server)
 			if err != nil {
-				err := errors.NewInputError(fmt.Sprintf("invalid DNS server format: %s", server), err)
-				result.Error = err
-				return result, err
+				return "", errors.NewInputError(fmt.Sprintf("invalid DNS server format: %s", server), err)
 			}
 			if err := errors.ValidateDNSServer(host); err != nil {
-				result.Error = err
-				return result, err
+				return "", err
 			}
 			if err := errors.ValidateDNSPort(port); err != nil {
-				result.Error = err
-				return result, err
+				return "", err
 			}
 			finalServer = server
 		} else if !strings.Contains(server, ":") || net.ParseIP(server) != nil {
 			// IPv4 address or IPv6 address without port
 			if err := errors.ValidateDNSServer(server); err != nil {
-				result.Error = err
-				return result, err
+				return "", err
 			}
 			// Handle IPv6 addresses properly
 			if strings.Contains(server, ":") && net.ParseIP(server) != nil {
@@ -113,126 +912,51 @@ func (c *client) Query(domain, recordType, server string) (*Result, error) {
 			// IPv4 with port
 			host, port, err := net.SplitHostPort(server)
 			if err != nil {
-				err := errors.NewInputError(fmt.Sprintf("invalid DNS server format: %s", server), err)
-				result.Error = err
-				return result, err
+				return "", errors.NewInputError(fmt.Sprintf("invalid DNS server format: %s", server), err)
 			}
 			if err := errors.ValidateDNSServer(host); err != nil {
-				result.Error = err
-				return result, err
+				return "", err
 			}
 			if err := errors.ValidateDNSPort(port); err != nil {
-				result.Error = err
-				return result, err
+				return "", err
 			}
 			finalServer = server
 		}
 	}
-	result.Server = finalServer
-
-	// Create DNS client
-	dnsClient := new(dns.Client)
-	dnsClient.Timeout = c.timeout
+	return finalServer, nil
+}
 
-	// Determine DNS query type
-	var queryType uint16
-	recordTypeUpper := strings.ToUpper(recordType)
-	switch recordTypeUpper {
-	case "A":
-		queryType = dns.TypeA
-	case "AAAA":
-		queryType = dns.TypeAAAA
-	case "MX":
-		queryType = dns.TypeMX
-	case "CNAME":
-		queryType = dns.TypeCNAME
-	case "TXT":
-		queryType = dns.TypeTXT
-	default:
-		// This should not happen due to validation, but handle it gracefully
-		err := errors.NewInputError(fmt.Sprintf("record type '%s' not supported", recordType), nil)
-		result.Error = err
-		return result, err
+// serverAddress resolves server like resolveServer, then applies the client's
+// port and checks the address family selected with -4 or -6
+func (c *client) serverAddress(server string) (string, error) {
+	finalServer, err := resolveServer(server)
+	if err != nil {
+		return "", err
 	}
 
-	// Create DNS message
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), queryType)
-	msg.RecursionDesired = true
-
-	// Perform the query and measure time
-	startTime := time.Now()
-	response, _, err := dnsClient.Exchange(msg, finalServer)
-	result.QueryTime = time.Since(startTime)
-
+	host, port, err := net.SplitHostPort(finalServer)
 	if err != nil {
-		// Classify and wrap the network error
-		netErr := errors.ClassifyNetworkError(err, finalServer)
-		result.Error = netErr
-		return result, netErr
+		return "", errors.NewInputError(fmt.Sprintf("invalid DNS server format: %s", finalServer), err)
 	}
-
-	if response == nil {
-		err := errors.NewNetworkError("no response received from DNS server", nil, finalServer)
-		result.Error = err
-		return result, err
+	if err := errors.ValidateAddressFamily(host, c.ipv4Only, c.ipv6Only); err != nil {
+		return "", err
 	}
-
-	// Check response code and create appropriate DNS errors
-	if response.Rcode != dns.RcodeSuccess {
-		var dnsErr *errors.DigError
-		switch response.Rcode {
-		case dns.RcodeNameError:
-			dnsErr = errors.NewDNSError(fmt.Sprintf("domain '%s' not found (NXDOMAIN)", domain), nil, domain, finalServer)
-		case dns.RcodeServerFailure:
-			dnsErr = errors.NewDNSError("DNS server experienced an internal failure", nil, domain, finalServer)
-		case dns.RcodeRefused:
-			dnsErr = errors.NewDNSError("DNS server refused the query", nil, domain, finalServer)
-		case dns.RcodeNotImplemented:
-			dnsErr = errors.NewDNSError("DNS server does not support this query type", nil, domain, finalServer)
-		case dns.RcodeFormatError:
-			dnsErr = errors.NewDNSError("DNS query format error", nil, domain, finalServer)
-		default:
-			dnsErr = errors.NewDNSError(fmt.Sprintf("DNS query failed with response code %d", response.Rcode), nil, domain, finalServer)
-		}
-		result.Error = dnsErr
-		return result, dnsErr
-	}
-
-	// Extract records from response based on record type
-	for _, answer := range response.Answer {
-		switch recordTypeUpper {
-		case "A":
-			if aRecord, ok := answer.(*dns.A); ok {
-				result.Records = append(result.Records, aRecord.A.String())
-			}
-		case "AAAA":
-			if aaaaRecord, ok := answer.(*dns.AAAA); ok {
-				result.Records = append(result.Records, aaaaRecord.AAAA.String())
-			}
-		case "MX":
-			if mxRecord, ok := answer.(*dns.MX); ok {
-				result.Records = append(result.Records, fmt.Sprintf("%d %s", mxRecord.Preference, mxRecord.Mx))
-			}
-		case "CNAME":
-			if cnameRecord, ok := answer.(*dns.CNAME); ok {
-				result.Records = append(result.Records, cnameRecord.Target)
-			}
-		case "TXT":
-			if txtRecord, ok := answer.(*dns.TXT); ok {
-				// TXT records can have multiple strings, join them
-				result.Records = append(result.Records, strings.Join(txtRecord.Txt, " "))
-			}
-		}
+	if c.port != 0 {
+		port = strconv.Itoa(c.port)
 	}
+	return net.JoinHostPort(host, port), nil
+}
 
-	if len(result.Records) == 0 {
-		err := errors.NewDNSError(fmt.Sprintf("no %s records found for domain '%s'", recordTypeUpper, domain), nil, domain, finalServer)
-		result.Error = err
-		return result, err
+// family restricts network ("udp" or "tcp") to the address family selected
+// with -4 or -6
+func (c *client) family(network string) string {
+	switch {
+	case c.ipv4Only:
+		return network + "4"
+	case c.ipv6Only:
+		return network + "6"
 	}
-
-	return result, nil
+	return network
 }
 
 // getSystemDNS attempts to determine the system's default DNS server
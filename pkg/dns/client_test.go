@@ -1,45 +1,24 @@
 package dns
 
 import (
+	"bytes"
+	"fmt"
+	"go-dig/pkg/dnstest"
 	"go-dig/pkg/errors"
+	"go-dig/pkg/pcap"
+	"go-dig/pkg/zoneserver"
+	"io"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
-// mockDNSServer creates a mock DNS server for testing
-func mockDNSServer(t *testing.T, handler func(w dns.ResponseWriter, r *dns.Msg)) (string, func()) {
-	// Create a UDP listener on a random port
-	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Failed to resolve UDP address: %v", err)
-	}
-
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		t.Fatalf("Failed to listen on UDP: %v", err)
-	}
-
-	server := &dns.Server{
-		PacketConn: conn,
-		Handler:    dns.HandlerFunc(handler),
-	}
-
-	go func() {
-		if err := server.ActivateAndServe(); err != nil {
-			t.Logf("Mock DNS server error: %v", err)
-		}
-	}()
-
-	// Return server address and cleanup function
-	return conn.LocalAddr().String(), func() {
-		server.Shutdown()
-	}
-}
-
 func TestNewClient(t *testing.T) {
 	client := NewClient()
 	if client == nil {
@@ -50,6 +29,20 @@ func TestNewClient(t *testing.T) {
 	var _ Client = client
 }
 
+func TestNewClientWithOptions(t *testing.T) {
+	c, ok := NewClientWithOptions(Options{Timeout: 2 * time.Second, FollowCNAME: true}).(*client)
+	if !ok {
+		t.Fatal("NewClientWithOptions() did not return a *client")
+	}
+	if c.timeout != 2*time.Second || !c.followCNAME {
+		t.Errorf("Unexpected client settings: timeout %v, followCNAME %v", c.timeout, c.followCNAME)
+	}
+
+	if c := NewClientWithOptions(Options{}).(*client); c.timeout != 5*time.Second {
+		t.Errorf("Expected the default timeout for a zero Timeout, got %v", c.timeout)
+	}
+}
+
 func TestClient_SetTimeout(t *testing.T) {
 	client := NewClient()
 	timeout := 10 * time.Second
@@ -128,7 +121,8 @@ func TestClient_Query_InvalidDNSServer(t *testing.T) {
 
 func TestClient_Query_SuccessfulARecord(t *testing.T) {
 	// Create mock DNS server that returns A record
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -147,7 +141,7 @@ func TestClient_Query_SuccessfulARecord(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
@@ -176,6 +170,10 @@ func TestClient_Query_SuccessfulARecord(t *testing.T) {
 		t.Errorf("Expected IP '192.0.2.1', got %s", result.Records[0])
 	}
 
+	if result.Rcode != "NOERROR" {
+		t.Errorf("Expected rcode NOERROR, got %q", result.Rcode)
+	}
+
 	if result.QueryTime <= 0 {
 		t.Error("Expected positive query time")
 	}
@@ -187,7 +185,8 @@ func TestClient_Query_SuccessfulARecord(t *testing.T) {
 
 func TestClient_Query_MultipleARecords(t *testing.T) {
 	// Create mock DNS server that returns multiple A records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -209,7 +208,7 @@ func TestClient_Query_MultipleARecords(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
@@ -236,14 +235,15 @@ func TestClient_Query_MultipleARecords(t *testing.T) {
 
 func TestClient_Query_NXDOMAIN(t *testing.T) {
 	// Create mock DNS server that returns NXDOMAIN
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Rcode = dns.RcodeNameError // NXDOMAIN
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("nonexistent.example.com", "A", serverAddr)
@@ -263,37 +263,141 @@ func TestClient_Query_NXDOMAIN(t *testing.T) {
 	if !strings.Contains(err.Error(), "not found (NXDOMAIN)") {
 		t.Errorf("Expected NXDOMAIN error, got: %s", err.Error())
 	}
+
+	if !errors.Is(err, errors.ErrNXDomain) {
+		t.Errorf("Expected errors.Is(err, ErrNXDomain), got kind %v", errors.KindOf(err))
+	}
+
+	if result.Rcode != "NXDOMAIN" {
+		t.Errorf("Expected rcode NXDOMAIN, got %q", result.Rcode)
+	}
 }
 
 func TestClient_Query_NoARecords(t *testing.T) {
-	// Create mock DNS server that returns successful response but no A records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	// Create mock DNS server that returns successful response but no A records and no SOA
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
-		// No answer records added
+		// No answer or authority records added
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
 
-	if err == nil {
-		t.Fatal("Expected error for no A records")
+	// Without an SOA or a referral the empty answer explains nothing (RFC 2308, section 2.2)
+	if !errors.Is(err, errors.ErrEmptyAnswer) {
+		t.Fatalf("Expected an empty answer error, got %v", err)
 	}
+	if result.NoData || result.Unresolved || result.Referral != nil {
+		t.Errorf("Expected no NODATA, unresolved chain or referral, got %+v", result)
+	}
+	if result.Rcode != "NOERROR" {
+		t.Errorf("Expected rcode NOERROR, got %q", result.Rcode)
+	}
+}
 
-	if !errors.IsDNSError(err) {
-		t.Errorf("Expected DNS error, got %T", err)
+func TestClient_Query_Referral(t *testing.T) {
+	// A server without the zone that does not recurse names the servers to ask
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		for _, text := range []string{"example.com. 172800 IN NS a.iana-servers.net.", "example.com. 172800 IN NS b.iana-servers.net."} {
+			rr, _ := dns.NewRR(text)
+			msg.Ns = append(msg.Ns, rr)
+		}
+		glue, _ := dns.NewRR("a.iana-servers.net. 172800 IN A 192.0.2.53")
+		msg.Extra = append(msg.Extra, glue)
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	result, err := NewClientWithOptions(Options{NoRecurse: true}).Query("www.example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Expected a referral to succeed, got error: %v", err)
 	}
+	if result.NoData || len(result.Referral) != 2 {
+		t.Fatalf("Expected a referral to two servers, got %+v", result)
+	}
+	if ns := result.Referral[0]; ns.Name != "example.com." || ns.Type != "NS" || ns.TTL != 172800 || ns.Value != "a.iana-servers.net." {
+		t.Errorf("Unexpected referral record %+v", ns)
+	}
+}
 
-	if result.Error == nil {
-		t.Fatal("Expected result.Error to be set")
+// noDataSOA returns the authority-section SOA of a NODATA answer from example.com
+func noDataSOA() *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:      "ns1.example.com.",
+		Mbox:    "hostmaster.example.com.",
+		Serial:  2024010101,
+		Refresh: 7200,
+		Retry:   3600,
+		Expire:  1209600,
+		Minttl:  300,
+	}
+}
+
+func TestClient_Query_NoDataWithSOA(t *testing.T) {
+	tests := []struct {
+		name        string
+		soaTTL      uint32
+		minTTL      uint32
+		expectedTTL uint32
+	}{
+		{"minimum below TTL", 3600, 300, 300},
+		{"TTL below minimum", 60, 900, 60},
 	}
 
-	if !strings.Contains(err.Error(), "no A records found") {
-		t.Errorf("Expected error about no A records, got: %s", err.Error())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := dnstest.NewServer(t)
+			server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.Authoritative = true
+				msg.Ns = append(msg.Ns, &dns.SOA{
+					Hdr:     dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: tt.soaTTL},
+					Ns:      "ns1.example.com.",
+					Mbox:    "hostmaster.example.com.",
+					Serial:  2024010101,
+					Refresh: 7200,
+					Retry:   3600,
+					Expire:  1209600,
+					Minttl:  tt.minTTL,
+				})
+				w.WriteMsg(msg)
+			})
+			serverAddr := server.Addr()
+
+			client := NewClient()
+			result, err := client.Query("www.example.com", "AAAA", serverAddr)
+			if err != nil {
+				t.Fatalf("Expected NODATA to succeed, got error: %v", err)
+			}
+
+			if !result.NoData {
+				t.Fatal("Expected result.NoData to be set")
+			}
+			if result.SOA == nil {
+				t.Fatal("Expected the authority SOA to be recorded")
+			}
+			if result.SOA.Name != "example.com." || result.SOA.Type != "SOA" || result.SOA.TTL != tt.soaTTL {
+				t.Errorf("Unexpected SOA record: %+v", result.SOA)
+			}
+			expectedValue := fmt.Sprintf("ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 %d", tt.minTTL)
+			if result.SOA.Value != expectedValue {
+				t.Errorf("Expected SOA value %q, got %q", expectedValue, result.SOA.Value)
+			}
+			if result.NegativeTTL != tt.expectedTTL {
+				t.Errorf("Expected negative TTL %d, got %d", tt.expectedTTL, result.NegativeTTL)
+			}
+		})
 	}
 }
 
@@ -329,11 +433,12 @@ func TestClient_Query_ServerWithoutPort(t *testing.T) {
 
 func TestClient_Query_Timeout(t *testing.T) {
 	// Create mock DNS server that doesn't respond
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		// Don't respond to simulate timeout
 		time.Sleep(2 * time.Second)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	client.SetTimeout(100 * time.Millisecond) // Very short timeout
@@ -426,14 +531,15 @@ func TestClient_Query_InvalidRecordType(t *testing.T) {
 
 func TestClient_Query_DNSServerFailure(t *testing.T) {
 	// Create mock DNS server that returns server failure
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Rcode = dns.RcodeServerFailure
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
@@ -453,18 +559,93 @@ func TestClient_Query_DNSServerFailure(t *testing.T) {
 	if !strings.Contains(err.Error(), "internal failure") {
 		t.Errorf("Expected server failure error, got: %s", err.Error())
 	}
+
+	if !errors.Is(err, errors.ErrServFail) {
+		t.Errorf("Expected errors.Is(err, ErrServFail), got kind %v", errors.KindOf(err))
+	}
+}
+
+func TestClient_Query_ExtendedDNSErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		rcode     int
+		options   []*dns.EDNS0_EDE
+		expectErr bool
+	}{
+		{"SERVFAIL with DNSSEC bogus", dns.RcodeServerFailure, []*dns.EDNS0_EDE{{InfoCode: dns.ExtendedErrorCodeDNSBogus, ExtraText: "signature expired"}}, true},
+		{"SERVFAIL with two codes", dns.RcodeServerFailure, []*dns.EDNS0_EDE{{InfoCode: dns.ExtendedErrorCodeNoReachableAuthority}, {InfoCode: dns.ExtendedErrorCodeNetworkError, ExtraText: "timeout"}}, true},
+		{"stale answer", dns.RcodeSuccess, []*dns.EDNS0_EDE{{InfoCode: dns.ExtendedErrorCodeStaleAnswer}}, false},
+		{"SERVFAIL without EDE", dns.RcodeServerFailure, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sentEDNS bool
+			server := dnstest.NewServer(t)
+			server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+				sentEDNS = r.IsEdns0() != nil
+
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.Rcode = tt.rcode
+				if tt.rcode == dns.RcodeSuccess {
+					rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+					msg.Answer = append(msg.Answer, rr)
+				}
+				opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+				opt.SetUDPSize(1232)
+				for _, ede := range tt.options {
+					opt.Option = append(opt.Option, ede)
+				}
+				msg.Extra = append(msg.Extra, opt)
+
+				w.WriteMsg(msg)
+			})
+			serverAddr := server.Addr()
+
+			client := NewClient()
+			result, err := client.Query("example.com", "A", serverAddr)
+
+			if !sentEDNS {
+				t.Error("Expected the query to carry an OPT record")
+			}
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error: %v, got %v", tt.expectErr, err)
+			}
+
+			if len(result.ExtendedErrors) != len(tt.options) {
+				t.Fatalf("Expected %d extended errors, got %+v", len(tt.options), result.ExtendedErrors)
+			}
+			for i, ede := range tt.options {
+				if result.ExtendedErrors[i].Code != ede.InfoCode || result.ExtendedErrors[i].Text != ede.ExtraText {
+					t.Errorf("Extended error %d = %+v, want code %d text %q", i, result.ExtendedErrors[i], ede.InfoCode, ede.ExtraText)
+				}
+			}
+
+			if tt.expectErr {
+				digErr, ok := errors.AsDigError(err)
+				if !ok {
+					t.Fatalf("Expected a DigError, got %T", err)
+				}
+				if len(digErr.ExtendedErrors) != len(tt.options) {
+					t.Errorf("Expected the error to carry %d extended errors, got %+v", len(tt.options), digErr.ExtendedErrors)
+				}
+			}
+		})
+	}
 }
 
 func TestClient_Query_DNSRefused(t *testing.T) {
 	// Create mock DNS server that refuses the query
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Rcode = dns.RcodeRefused
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
@@ -484,15 +665,20 @@ func TestClient_Query_DNSRefused(t *testing.T) {
 	if !strings.Contains(err.Error(), "refused") {
 		t.Errorf("Expected refused error, got: %s", err.Error())
 	}
+
+	if !errors.Is(err, errors.ErrRefused) {
+		t.Errorf("Expected errors.Is(err, ErrRefused), got kind %v", errors.KindOf(err))
+	}
 }
 
 func TestClient_Query_NetworkTimeout(t *testing.T) {
 	// Create mock DNS server that doesn't respond to simulate network timeout
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		// Don't respond to simulate timeout
 		time.Sleep(2 * time.Second)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	client.SetTimeout(100 * time.Millisecond) // Very short timeout
@@ -515,6 +701,10 @@ func TestClient_Query_NetworkTimeout(t *testing.T) {
 	if !strings.Contains(err.Error(), "timeout") {
 		t.Errorf("Expected timeout error, got: %s", err.Error())
 	}
+
+	if !errors.Is(err, errors.ErrTimeout) {
+		t.Errorf("Expected errors.Is(err, ErrTimeout), got kind %v", errors.KindOf(err))
+	}
 }
 
 func TestClient_Query_ValidRecordTypes(t *testing.T) {
@@ -540,7 +730,8 @@ func TestClient_Query_ValidRecordTypes(t *testing.T) {
 
 func TestClient_Query_SuccessfulAAAARecord(t *testing.T) {
 	// Create mock DNS server that returns AAAA record
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -559,7 +750,7 @@ func TestClient_Query_SuccessfulAAAARecord(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "AAAA", serverAddr)
@@ -599,7 +790,8 @@ func TestClient_Query_SuccessfulAAAARecord(t *testing.T) {
 
 func TestClient_Query_MultipleAAAARecords(t *testing.T) {
 	// Create mock DNS server that returns multiple AAAA records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -621,7 +813,7 @@ func TestClient_Query_MultipleAAAARecords(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "AAAA", serverAddr)
@@ -648,33 +840,31 @@ func TestClient_Query_MultipleAAAARecords(t *testing.T) {
 
 func TestClient_Query_NoAAAARecords(t *testing.T) {
 	// Create mock DNS server that returns successful response but no AAAA records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
-		// No answer records added
+		// No answer records added, only the SOA of a NODATA answer
+		msg.Ns = append(msg.Ns, noDataSOA())
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "AAAA", serverAddr)
 
-	if err == nil {
-		t.Fatal("Expected error for no AAAA records")
-	}
-
-	if !errors.IsDNSError(err) {
-		t.Errorf("Expected DNS error, got %T", err)
+	if err != nil {
+		t.Fatalf("Expected NODATA to succeed, got error: %v", err)
 	}
 
-	if result.Error == nil {
-		t.Fatal("Expected result.Error to be set")
+	if !result.NoData {
+		t.Error("Expected result.NoData to be set for an empty AAAA answer")
 	}
 
-	if !strings.Contains(err.Error(), "no AAAA records found") {
-		t.Errorf("Expected error about no AAAA records, got: %s", err.Error())
+	if len(result.Records) != 0 {
+		t.Errorf("Expected no records, got %v", result.Records)
 	}
 }
 
@@ -682,7 +872,8 @@ func TestClient_Query_NoAAAARecords(t *testing.T) {
 
 func TestClient_Query_SuccessfulMXRecord(t *testing.T) {
 	// Create mock DNS server that returns MX record
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		// Add small delay to ensure query time is measurable
 		time.Sleep(1 * time.Millisecond)
 
@@ -705,7 +896,7 @@ func TestClient_Query_SuccessfulMXRecord(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "MX", serverAddr)
@@ -745,7 +936,8 @@ func TestClient_Query_SuccessfulMXRecord(t *testing.T) {
 
 func TestClient_Query_MultipleMXRecords(t *testing.T) {
 	// Create mock DNS server that returns multiple MX records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -776,7 +968,7 @@ func TestClient_Query_MultipleMXRecords(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "MX", serverAddr)
@@ -803,33 +995,31 @@ func TestClient_Query_MultipleMXRecords(t *testing.T) {
 
 func TestClient_Query_NoMXRecords(t *testing.T) {
 	// Create mock DNS server that returns successful response but no MX records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
-		// No answer records added
+		// No answer records added, only the SOA of a NODATA answer
+		msg.Ns = append(msg.Ns, noDataSOA())
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "MX", serverAddr)
 
-	if err == nil {
-		t.Fatal("Expected error for no MX records")
-	}
-
-	if !errors.IsDNSError(err) {
-		t.Errorf("Expected DNS error, got %T", err)
+	if err != nil {
+		t.Fatalf("Expected NODATA to succeed, got error: %v", err)
 	}
 
-	if result.Error == nil {
-		t.Fatal("Expected result.Error to be set")
+	if !result.NoData {
+		t.Error("Expected result.NoData to be set for an empty MX answer")
 	}
 
-	if !strings.Contains(err.Error(), "no MX records found") {
-		t.Errorf("Expected error about no MX records, got: %s", err.Error())
+	if len(result.Records) != 0 {
+		t.Errorf("Expected no records, got %v", result.Records)
 	}
 }
 
@@ -837,7 +1027,8 @@ func TestClient_Query_NoMXRecords(t *testing.T) {
 
 func TestClient_Query_SuccessfulCNAMERecord(t *testing.T) {
 	// Create mock DNS server that returns CNAME record
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		// Add small delay to ensure query time is measurable
 		time.Sleep(1 * time.Millisecond)
 
@@ -859,7 +1050,7 @@ func TestClient_Query_SuccessfulCNAMERecord(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("alias.example.com", "CNAME", serverAddr)
@@ -899,7 +1090,8 @@ func TestClient_Query_SuccessfulCNAMERecord(t *testing.T) {
 
 func TestClient_Query_MultipleCNAMERecords(t *testing.T) {
 	// Create mock DNS server that returns multiple CNAME records (unusual but possible)
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -921,7 +1113,7 @@ func TestClient_Query_MultipleCNAMERecords(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("alias.example.com", "CNAME", serverAddr)
@@ -948,33 +1140,31 @@ func TestClient_Query_MultipleCNAMERecords(t *testing.T) {
 
 func TestClient_Query_NoCNAMERecords(t *testing.T) {
 	// Create mock DNS server that returns successful response but no CNAME records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
-		// No answer records added
+		// No answer records added, only the SOA of a NODATA answer
+		msg.Ns = append(msg.Ns, noDataSOA())
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("alias.example.com", "CNAME", serverAddr)
 
-	if err == nil {
-		t.Fatal("Expected error for no CNAME records")
-	}
-
-	if !errors.IsDNSError(err) {
-		t.Errorf("Expected DNS error, got %T", err)
+	if err != nil {
+		t.Fatalf("Expected NODATA to succeed, got error: %v", err)
 	}
 
-	if result.Error == nil {
-		t.Fatal("Expected result.Error to be set")
+	if !result.NoData {
+		t.Error("Expected result.NoData to be set for an empty CNAME answer")
 	}
 
-	if !strings.Contains(err.Error(), "no CNAME records found") {
-		t.Errorf("Expected error about no CNAME records, got: %s", err.Error())
+	if len(result.Records) != 0 {
+		t.Errorf("Expected no records, got %v", result.Records)
 	}
 }
 
@@ -982,7 +1172,8 @@ func TestClient_Query_NoCNAMERecords(t *testing.T) {
 
 func TestClient_Query_SuccessfulTXTRecord(t *testing.T) {
 	// Create mock DNS server that returns TXT record
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		// Add small delay to ensure query time is measurable
 		time.Sleep(1 * time.Millisecond)
 
@@ -1004,7 +1195,7 @@ func TestClient_Query_SuccessfulTXTRecord(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "TXT", serverAddr)
@@ -1044,7 +1235,8 @@ func TestClient_Query_SuccessfulTXTRecord(t *testing.T) {
 
 func TestClient_Query_TXTRecordWithMultipleStrings(t *testing.T) {
 	// Create mock DNS server that returns TXT record with multiple strings
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -1063,7 +1255,7 @@ func TestClient_Query_TXTRecordWithMultipleStrings(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "TXT", serverAddr)
@@ -1084,11 +1276,17 @@ func TestClient_Query_TXTRecordWithMultipleStrings(t *testing.T) {
 	if result.Records[0] != "part1 part2 part3" {
 		t.Errorf("Expected TXT 'part1 part2 part3', got %s", result.Records[0])
 	}
+
+	// The record data keeps the strings apart, quoted as in a zone file
+	if data := result.Answers[0].Data; data != `"part1" "part2" "part3"` {
+		t.Errorf("Expected TXT data '\"part1\" \"part2\" \"part3\"', got %s", data)
+	}
 }
 
 func TestClient_Query_MultipleTXTRecords(t *testing.T) {
 	// Create mock DNS server that returns multiple TXT records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -1115,7 +1313,7 @@ func TestClient_Query_MultipleTXTRecords(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "TXT", serverAddr)
@@ -1146,33 +1344,31 @@ func TestClient_Query_MultipleTXTRecords(t *testing.T) {
 
 func TestClient_Query_NoTXTRecords(t *testing.T) {
 	// Create mock DNS server that returns successful response but no TXT records
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
-		// No answer records added
+		// No answer records added, only the SOA of a NODATA answer
+		msg.Ns = append(msg.Ns, noDataSOA())
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "TXT", serverAddr)
 
-	if err == nil {
-		t.Fatal("Expected error for no TXT records")
-	}
-
-	if !errors.IsDNSError(err) {
-		t.Errorf("Expected DNS error, got %T", err)
+	if err != nil {
+		t.Fatalf("Expected NODATA to succeed, got error: %v", err)
 	}
 
-	if result.Error == nil {
-		t.Fatal("Expected result.Error to be set")
+	if !result.NoData {
+		t.Error("Expected result.NoData to be set for an empty TXT answer")
 	}
 
-	if !strings.Contains(err.Error(), "no TXT records found") {
-		t.Errorf("Expected error about no TXT records, got: %s", err.Error())
+	if len(result.Records) != 0 {
+		t.Errorf("Expected no records, got %v", result.Records)
 	}
 }
 
@@ -1180,7 +1376,8 @@ func TestClient_Query_NoTXTRecords(t *testing.T) {
 
 func TestClient_Query_CustomDNSServer(t *testing.T) {
 	// Create mock DNS server
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -1199,7 +1396,7 @@ func TestClient_Query_CustomDNSServer(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
@@ -1228,7 +1425,8 @@ func TestClient_Query_CustomDNSServer(t *testing.T) {
 
 func TestClient_Query_CustomDNSServerWithPort(t *testing.T) {
 	// Create mock DNS server
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -1247,7 +1445,7 @@ func TestClient_Query_CustomDNSServerWithPort(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	result, err := client.Query("example.com", "A", serverAddr)
@@ -1417,11 +1615,12 @@ func TestClient_Query_DNSServerConnectionRefused(t *testing.T) {
 
 func TestClient_Query_DNSServerTimeout(t *testing.T) {
 	// Create mock DNS server that doesn't respond (simulates timeout)
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		// Don't respond to simulate timeout
 		time.Sleep(2 * time.Second)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 	client.SetTimeout(100 * time.Millisecond) // Very short timeout
@@ -1524,7 +1723,8 @@ func TestClient_Query_DNSServerValidation(t *testing.T) {
 
 func TestClient_Query_CaseInsensitiveRecordTypes(t *testing.T) {
 	// Create mock DNS server that returns A record
-	serverAddr, cleanup := mockDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
 		msg := new(dns.Msg)
 		msg.SetReply(r)
 		msg.Authoritative = true
@@ -1543,7 +1743,7 @@ func TestClient_Query_CaseInsensitiveRecordTypes(t *testing.T) {
 
 		w.WriteMsg(msg)
 	})
-	defer cleanup()
+	serverAddr := server.Addr()
 
 	client := NewClient()
 
@@ -1609,3 +1809,880 @@ func TestClient_Query_AllRecordTypesCaseInsensitive(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_Query_SuccessfulNSRecord(t *testing.T) {
+	// Create mock DNS server that returns NS records
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Authoritative = true
+
+		for _, ns := range []string{"ns1.example.com.", "ns2.example.com."} {
+			nsRecord := &dns.NS{
+				Hdr: dns.RR_Header{
+					Name:   r.Question[0].Name,
+					Rrtype: dns.TypeNS,
+					Class:  dns.ClassINET,
+					Ttl:    86400,
+				},
+				Ns: ns,
+			}
+			msg.Answer = append(msg.Answer, nsRecord)
+		}
+
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	client := NewClient()
+	result, err := client.Query("example.com", "NS", serverAddr)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(result.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(result.Records))
+	}
+
+	if result.Records[0] != "ns1.example.com." || result.Records[1] != "ns2.example.com." {
+		t.Errorf("Unexpected NS records: %v", result.Records)
+	}
+}
+
+func TestClient_Query_StructuredAnswers(t *testing.T) {
+	// Create mock DNS server that returns A records with distinct TTLs
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+
+		for i, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+			aRecord := &dns.A{
+				Hdr: dns.RR_Header{
+					Name:   r.Question[0].Name,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    uint32(60 * (i + 1)),
+				},
+				A: net.ParseIP(ip),
+			}
+			msg.Answer = append(msg.Answer, aRecord)
+		}
+
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	client := NewClient()
+	result, err := client.Query("example.com", "A", serverAddr)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(result.Answers) != len(result.Records) {
+		t.Fatalf("Expected %d structured answers, got %d", len(result.Records), len(result.Answers))
+	}
+
+	for i, answer := range result.Answers {
+		if answer.Value != result.Records[i] {
+			t.Errorf("Answer %d value = %s, want %s", i, answer.Value, result.Records[i])
+		}
+		if answer.Name != "example.com." {
+			t.Errorf("Answer %d name = %s, want example.com.", i, answer.Name)
+		}
+		if answer.Type != "A" {
+			t.Errorf("Answer %d type = %s, want A", i, answer.Type)
+		}
+		if answer.TTL != uint32(60*(i+1)) {
+			t.Errorf("Answer %d TTL = %d, want %d", i, answer.TTL, 60*(i+1))
+		}
+	}
+}
+
+func TestClient_Query_InternationalizedDomain(t *testing.T) {
+	// Create mock DNS server that records the question it was asked
+	var question string
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		question = r.Question[0].Name
+
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	client := NewClient()
+	result, err := client.Query("MÜNCHEN.de", "A", serverAddr)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if question != "xn--mnchen-3ya.de." {
+		t.Errorf("Expected the question to use A-labels, got %s", question)
+	}
+
+	if result.Domain != "xn--mnchen-3ya.de" {
+		t.Errorf("Expected result domain xn--mnchen-3ya.de, got %s", result.Domain)
+	}
+}
+
+func TestClient_Query_TruncatedWithoutAnswers(t *testing.T) {
+	// Create mock DNS server that sets TC and drops the answers
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Truncated = true
+
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	client := NewClient()
+	_, err := client.Query("example.com", "TXT", serverAddr)
+
+	if !errors.Is(err, errors.ErrTruncated) {
+		t.Errorf("Expected errors.Is(err, ErrTruncated), got %v", err)
+	}
+
+	if !errors.IsDNSError(err) {
+		t.Errorf("Expected DNS error, got %T", err)
+	}
+}
+
+func TestClient_Exchange(t *testing.T) {
+	// Create mock DNS server that echoes the RD bit and returns an SOA record
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Authoritative = true
+
+		soa := &dns.SOA{
+			Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+			Ns:     "ns1.example.com.",
+			Mbox:   "hostmaster.example.com.",
+			Serial: 2024010101,
+		}
+		msg.Answer = append(msg.Answer, soa)
+
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeSOA)
+	query.RecursionDesired = false
+
+	client := NewClient()
+	response, _, err := client.Exchange(query, serverAddr, "udp")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !response.Authoritative {
+		t.Error("Expected authoritative response")
+	}
+
+	if response.RecursionDesired {
+		t.Error("Expected RD bit to be left as set by the caller")
+	}
+
+	if len(response.Answer) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", len(response.Answer))
+	}
+
+	if soa, ok := response.Answer[0].(*dns.SOA); !ok || soa.Serial != 2024010101 {
+		t.Errorf("Unexpected answer %v", response.Answer[0])
+	}
+}
+
+func TestClient_Exchange_PortAndFamily(t *testing.T) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	_, portText, _ := net.SplitHostPort(serverAddr)
+	port, _ := strconv.Atoi(portText)
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeSOA)
+
+	// Raw exchanges honour the port option like queries do
+	if _, _, err := NewClientWithOptions(Options{Port: port, IPv4Only: true}).Exchange(query, "127.0.0.1", "udp"); err != nil {
+		t.Errorf("Expected the port option to reach the server, got %v", err)
+	}
+
+	_, _, err := NewClientWithOptions(Options{IPv6Only: true}).Exchange(query, serverAddr, "udp")
+	if !errors.IsInputError(err) || !strings.Contains(err.Error(), "not an IPv6 address") {
+		t.Errorf("Expected an address family error, got %v", err)
+	}
+}
+
+func TestClient_Exchange_InvalidServer(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeSOA)
+
+	client := NewClient()
+	_, _, err := client.Exchange(query, "invalid-server", "udp")
+
+	if !errors.IsInputError(err) {
+		t.Errorf("Expected input error, got %v", err)
+	}
+}
+
+// chainServer answers from a fixed set of records keyed by lower-case owner
+// name, like a server that only knows the zones involved in a CNAME chain
+func chainServer(t *testing.T, records map[string][]string, nxdomain map[string]bool) string {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		name := strings.ToLower(r.Question[0].Name)
+		if nxdomain[name] {
+			msg.Rcode = dns.RcodeNameError
+		}
+		for _, text := range records[name] {
+			rr, err := dns.NewRR(text)
+			if err != nil {
+				t.Errorf("invalid test record %q: %v", text, err)
+				continue
+			}
+			msg.Answer = append(msg.Answer, rr)
+		}
+		w.WriteMsg(msg)
+	})
+	return server.Addr()
+}
+
+func TestClient_Query_CNAMEChain(t *testing.T) {
+	serverAddr := chainServer(t, map[string][]string{
+		"www.example.com.": {
+			"www.example.com. 300 IN CNAME cdn.example.net.",
+			"cdn.example.net. 60 IN CNAME edge.example.org.",
+			"edge.example.org. 20 IN A 192.0.2.7",
+		},
+	}, nil)
+
+	result, err := NewClient().Query("www.example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedChain := []Record{
+		{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "cdn.example.net.", Data: "cdn.example.net."},
+		{Name: "cdn.example.net.", Type: "CNAME", TTL: 60, Value: "edge.example.org.", Data: "edge.example.org."},
+	}
+	if len(result.Chain) != len(expectedChain) {
+		t.Fatalf("Expected %d hops, got %+v", len(expectedChain), result.Chain)
+	}
+	for i, hop := range expectedChain {
+		if result.Chain[i] != hop {
+			t.Errorf("Hop %d = %+v, want %+v", i, result.Chain[i], hop)
+		}
+	}
+
+	if len(result.Answers) != 1 || result.Answers[0].Name != "edge.example.org." || result.Answers[0].Value != "192.0.2.7" || result.Answers[0].TTL != 20 {
+		t.Errorf("Unexpected answers: %+v", result.Answers)
+	}
+	if result.NoData {
+		t.Error("Expected NoData to be false when the chain ends in records")
+	}
+
+	// A CNAME query returns the alias itself without walking the chain
+	result, err = NewClient().Query("www.example.com", "CNAME", serverAddr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Chain) != 0 || len(result.Records) != 2 {
+		t.Errorf("Expected CNAME records without a chain, got chain %+v records %v", result.Chain, result.Records)
+	}
+}
+
+func TestClient_Query_CNAMEChainErrors(t *testing.T) {
+	long := []string{}
+	for i := 0; i <= MaxChainLength; i++ {
+		long = append(long, fmt.Sprintf("hop%d.example.com. 60 IN CNAME hop%d.example.com.", i, i+1))
+	}
+
+	tests := []struct {
+		name         string
+		records      []string
+		expectedKind *errors.Kind
+		expectedMsg  string
+	}{
+		{
+			name: "loop",
+			records: []string{
+				"hop0.example.com. 60 IN CNAME a.example.com.",
+				"a.example.com. 60 IN CNAME b.example.com.",
+				"b.example.com. 60 IN CNAME a.example.com.",
+			},
+			expectedKind: errors.ErrCNAMELoop,
+			expectedMsg:  "loops back to 'a.example.com.'",
+		},
+		{
+			name:         "self reference",
+			records:      []string{"hop0.example.com. 60 IN CNAME hop0.example.com."},
+			expectedKind: errors.ErrCNAMELoop,
+			expectedMsg:  "loops back to 'hop0.example.com.'",
+		},
+		{
+			name:         "too long",
+			records:      long,
+			expectedKind: errors.ErrChainTooLong,
+			expectedMsg:  fmt.Sprintf("longer than %d aliases", MaxChainLength),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverAddr := chainServer(t, map[string][]string{"hop0.example.com.": tt.records}, nil)
+
+			result, err := NewClient().Query("hop0.example.com", "A", serverAddr)
+			if !errors.Is(err, tt.expectedKind) {
+				t.Fatalf("Expected %v, got %v", tt.expectedKind, err)
+			}
+			if !strings.Contains(err.Error(), tt.expectedMsg) {
+				t.Errorf("Expected error to contain %q, got %v", tt.expectedMsg, err)
+			}
+			if result.Error == nil {
+				t.Error("Expected result.Error to be set")
+			}
+			if tt.expectedKind == errors.ErrChainTooLong && len(result.Chain) != MaxChainLength {
+				t.Errorf("Expected the partial chain of %d hops, got %d", MaxChainLength, len(result.Chain))
+			}
+		})
+	}
+}
+
+func TestClient_Query_FollowCNAME(t *testing.T) {
+	records := map[string][]string{
+		"www.example.com.":  {"www.example.com. 300 IN CNAME cdn.example.net."},
+		"cdn.example.net.":  {"cdn.example.net. 60 IN A 192.0.2.8"},
+		"gone.example.com.": {"gone.example.com. 300 IN CNAME missing.example.net."},
+		"ping.example.com.": {"ping.example.com. 300 IN CNAME pong.example.net."},
+		"pong.example.net.": {"pong.example.net. 300 IN CNAME ping.example.com."},
+	}
+	serverAddr := chainServer(t, records, map[string]bool{"missing.example.net.": true})
+
+	// Without following, the unresolved chain is reported as is
+	result, err := NewClient().Query("www.example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Chain) != 1 || len(result.Records) != 0 {
+		t.Errorf("Expected one hop and no records without following, got chain %+v records %v", result.Chain, result.Records)
+	}
+	// A chain that stops at its target without an SOA is not NODATA
+	if !result.Unresolved || result.NoData {
+		t.Errorf("Expected an unresolved chain rather than NODATA, got Unresolved %v NoData %v", result.Unresolved, result.NoData)
+	}
+
+	follower := NewClientWithOptions(Options{FollowCNAME: true})
+
+	result, err = follower.Query("www.example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Chain) != 1 || result.Chain[0].Value != "cdn.example.net." {
+		t.Errorf("Unexpected chain: %+v", result.Chain)
+	}
+	if len(result.Records) != 1 || result.Records[0] != "192.0.2.8" {
+		t.Errorf("Expected the chased A record, got %v", result.Records)
+	}
+
+	// A chased target that does not exist is a dangling alias
+	_, err = follower.Query("gone.example.com", "A", serverAddr)
+	if !errors.Is(err, errors.ErrNXDomain) || !strings.Contains(err.Error(), "alias target 'missing.example.net' of 'gone.example.com' not found") {
+		t.Errorf("Expected NXDOMAIN for the alias target, got %v", err)
+	}
+
+	// Loops spanning several queries are detected too
+	_, err = follower.Query("ping.example.com", "A", serverAddr)
+	if !errors.Is(err, errors.ErrCNAMELoop) {
+		t.Errorf("Expected a CNAME loop across queries, got %v", err)
+	}
+}
+
+func TestClient_Query_ChainEndsInNoData(t *testing.T) {
+	// A resolver that chased the alias sends the SOA of the target's NODATA answer
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		alias, _ := dns.NewRR("alias.example.com. 300 IN CNAME www.example.com.")
+		msg.Answer = append(msg.Answer, alias)
+		msg.Ns = append(msg.Ns, noDataSOA())
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	result, err := NewClient().Query("alias.example.com", "MX", serverAddr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.NoData || result.Unresolved || result.NegativeTTL != 300 {
+		t.Errorf("Expected NODATA for the chain's target, got NoData %v Unresolved %v negative TTL %d", result.NoData, result.Unresolved, result.NegativeTTL)
+	}
+}
+
+func TestClient_Query_Tries(t *testing.T) {
+	server := dnstest.NewServer(t)
+	// Drop the first request so only a retry gets an answer
+	server.Handle("example.com.", dns.TypeA,
+		dnstest.Response{Drop: true},
+		dnstest.Response{Answer: []string{"example.com. 300 IN A 192.0.2.1"}},
+	)
+
+	single := NewClientWithOptions(Options{Timeout: 200 * time.Millisecond})
+	_, err := single.Query("example.com", "A", server.Addr())
+	if !errors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("Expected a timeout with a single try, got %v", err)
+	}
+
+	server.Reset()
+	retrying := NewClientWithOptions(Options{Timeout: 200 * time.Millisecond, Tries: 2})
+	result, err := retrying.Query("example.com", "A", server.Addr())
+	if err != nil {
+		t.Fatalf("Expected the second try to succeed, got %v", err)
+	}
+	if len(result.Records) != 1 {
+		t.Errorf("Expected one record, got %v", result.Records)
+	}
+	server.AssertQueryCount(t, "example.com.", dns.TypeA, 2)
+	if result.QueryTime < 200*time.Millisecond {
+		t.Errorf("Expected the query time to include the timed-out try, got %v", result.QueryTime)
+	}
+}
+
+func TestClient_Query_MalformedReply(t *testing.T) {
+	server := dnstest.NewServer(t)
+	server.Handle("example.com.", dns.TypeA, dnstest.Response{Malformed: true, Answer: []string{"example.com. 300 IN A 192.0.2.1"}})
+
+	result, err := NewClientWithOptions(Options{Timeout: 500 * time.Millisecond}).Query("example.com", "A", server.Addr())
+	if !errors.IsNetworkError(err) {
+		t.Errorf("Expected a network error for an unparsable reply, got %T: %v", err, err)
+	}
+	if result.Error == nil {
+		t.Error("Expected result.Error to be set")
+	}
+}
+
+func TestClient_Query_TriesStopOnOtherErrors(t *testing.T) {
+	// Nothing listens on this port, so the query is refused rather than timing out
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	addr := listener.LocalAddr().String()
+	listener.Close()
+
+	client := NewClientWithOptions(Options{Timeout: time.Second, Tries: 5})
+	start := time.Now()
+	_, err = client.Query("example.com", "A", addr)
+	if !errors.Is(err, errors.ErrConnRefused) {
+		t.Fatalf("Expected connection refused, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected no retries after a refused connection, took %v", elapsed)
+	}
+}
+
+func TestClient_Query_PortAndFamily(t *testing.T) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	_, portText, _ := net.SplitHostPort(serverAddr)
+	port, _ := strconv.Atoi(portText)
+
+	// The port option replaces the default port 53
+	result, err := NewClientWithOptions(Options{Port: port, IPv4Only: true}).Query("example.com", "A", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Server != serverAddr {
+		t.Errorf("Expected server %s, got %s", serverAddr, result.Server)
+	}
+
+	// The port option also replaces an explicit port
+	if result, err := NewClientWithOptions(Options{Port: port}).Query("example.com", "A", "127.0.0.1:53"); err != nil || result.Server != serverAddr {
+		t.Errorf("Expected the port option to win, got server %v error %v", result.Server, err)
+	}
+
+	_, err = NewClientWithOptions(Options{IPv6Only: true}).Query("example.com", "A", serverAddr)
+	if !errors.IsInputError(err) || !strings.Contains(err.Error(), "not an IPv6 address") {
+		t.Errorf("Expected an address family error, got %v", err)
+	}
+}
+
+func TestClient_Query_TCPServersInParallel(t *testing.T) {
+	answer := []string{"example.com. 300 IN A 192.0.2.1"}
+	slow := dnstest.NewServer(t)
+	slow.Handle("example.com.", dns.TypeA, dnstest.Response{Delay: time.Second, Answer: answer})
+	fast := dnstest.NewServer(t)
+	fast.Handle("example.com.", dns.TypeA, dnstest.Response{Answer: answer})
+	slowAddr, fastAddr := slow.Addr(), fast.Addr()
+
+	c := NewClientWithOptions(Options{TCP: true, Timeout: 3 * time.Second})
+	done := make(chan error)
+	go func() {
+		_, err := c.Query("example.com", "A", slowAddr)
+		done <- err
+	}()
+
+	// A slow answer on one server's connection does not hold up another server
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := c.Query("example.com", "A", fastAddr); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Query to the fast server took %v, waiting for the slow one", elapsed)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Query to the slow server error = %v", err)
+	}
+}
+
+func TestClient_Query_TCPReusesConnection(t *testing.T) {
+	var mu sync.Mutex
+	peers := map[string]int{}
+	var dnssecOK atomic.Bool
+	server := dnstest.NewServerWithOptions(t, dnstest.Options{TCPIdleTimeout: 200 * time.Millisecond})
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		peers[w.RemoteAddr().String()]++
+		mu.Unlock()
+		if opt := r.IsEdns0(); opt != nil && opt.Do() {
+			dnssecOK.Store(true)
+		}
+
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	c := NewClientWithOptions(Options{TCP: true, DNSSEC: true, Timeout: 2 * time.Second})
+	for _, domain := range []string{"example.com", "www.example.com", "mail.example.com"} {
+		result, err := c.Query(domain, "A", serverAddr)
+		if err != nil {
+			t.Fatalf("Query(%s) error = %v", domain, err)
+		}
+		if len(result.Records) != 1 {
+			t.Errorf("Query(%s) records = %v, want one", domain, result.Records)
+		}
+	}
+
+	mu.Lock()
+	if len(peers) != 1 {
+		t.Errorf("Expected all queries on one connection, got %v", peers)
+	}
+	mu.Unlock()
+	if !dnssecOK.Load() {
+		t.Error("Expected the DNSSEC OK bit to be set")
+	}
+
+	// After Close the client dials a new connection
+	if err := c.(interface{ Close() error }).Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := c.Query("example.com", "A", serverAddr); err != nil {
+		t.Fatalf("Query after Close error = %v", err)
+	}
+	mu.Lock()
+	if len(peers) != 2 {
+		t.Errorf("Expected a second connection after Close, got %v", peers)
+	}
+	mu.Unlock()
+
+	// A connection the server closed while idle is replaced transparently
+	time.Sleep(500 * time.Millisecond)
+	if _, err := c.Query("example.com", "A", serverAddr); err != nil {
+		t.Fatalf("Query after the server closed the connection error = %v", err)
+	}
+	mu.Lock()
+	if len(peers) != 3 {
+		t.Errorf("Expected a third connection after the idle timeout, got %v", peers)
+	}
+	mu.Unlock()
+}
+
+func TestClient_Query_ZoneServer(t *testing.T) {
+	zone, err := zoneserver.ParseZone(strings.NewReader(`$ORIGIN example.com.
+$TTL 3600
+@     IN SOA   ns1 hostmaster 1 7200 3600 1209600 300
+www   IN A     192.0.2.1
+alias IN CNAME www
+`), "", "example.com.zone")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+	server := zoneserver.NewServer(zone)
+	serverAddr, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer server.Shutdown()
+
+	for _, options := range []Options{{}, {TCP: true}} {
+		client := NewClientWithOptions(options)
+
+		result, err := client.Query("alias.example.com", "A", serverAddr)
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(result.Chain) != 1 || len(result.Records) != 1 || result.Records[0] != "192.0.2.1" {
+			t.Errorf("Expected the alias to resolve through one CNAME, got chain %+v records %v", result.Chain, result.Records)
+		}
+
+		result, err = client.Query("www.example.com", "MX", serverAddr)
+		if err != nil || !result.NoData || result.NegativeTTL != 300 {
+			t.Errorf("Expected NODATA with a 300s negative TTL, got %+v (error %v)", result, err)
+		}
+
+		_, err = client.Query("missing.example.com", "A", serverAddr)
+		if !errors.Is(err, errors.ErrNXDomain) {
+			t.Errorf("Expected NXDOMAIN, got %v", err)
+		}
+
+		closeClient, _ := client.(interface{ Close() error })
+		closeClient.Close()
+	}
+}
+
+func TestClient_Query_Capture(t *testing.T) {
+	server := dnstest.NewServer(t)
+	server.Handle("www.example.com.", dns.TypeA, dnstest.Response{Answer: []string{"www.example.com. 300 IN A 192.0.2.1"}})
+
+	var capture bytes.Buffer
+	writer, err := pcap.NewWriter(&capture)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+
+	for _, tcp := range []bool{false, true} {
+		client := NewClientWithOptions(Options{Timeout: time.Second, TCP: tcp, Capture: writer})
+		result, err := client.Query("www.example.com", "A", server.Addr())
+		if err != nil || len(result.Records) != 1 {
+			t.Fatalf("Query (TCP %v) = %v, error %v", tcp, result, err)
+		}
+		client.(io.Closer).Close()
+	}
+	if err := writer.Err(); err != nil {
+		t.Fatalf("Writer error = %v", err)
+	}
+
+	_, port, _ := net.SplitHostPort(server.Addr())
+	portNumber, _ := strconv.Atoi(port)
+	packets, err := pcap.ReadDNS(&capture, portNumber)
+	if err != nil {
+		t.Fatalf("ReadDNS() error = %v", err)
+	}
+
+	expected := []struct {
+		network  string
+		response bool
+	}{{"udp", false}, {"udp", true}, {"tcp", false}, {"tcp", true}}
+	if len(packets) != len(expected) {
+		t.Fatalf("Expected %d captured messages, got %d", len(expected), len(packets))
+	}
+	for i, packet := range packets {
+		if packet.Err != nil || packet.Network != expected[i].network || packet.Msg.Response != expected[i].response {
+			t.Errorf("Packet %d = %s response=%v (error %v), want %s response=%v", i, packet.Network, packet.Msg != nil && packet.Msg.Response, packet.Err, expected[i].network, expected[i].response)
+		}
+		serverSide := packet.Dst
+		if expected[i].response {
+			serverSide = packet.Src
+		}
+		if serverSide != server.Addr() {
+			t.Errorf("Packet %d went %s -> %s, want the server at %s", i, packet.Src, packet.Dst, server.Addr())
+		}
+	}
+	if packets[1].Msg.Id != packets[0].Msg.Id || len(packets[1].Msg.Answer) != 1 {
+		t.Errorf("Expected the captured response to answer the query, got %v", packets[1].Msg)
+	}
+}
+
+func TestNewQuery(t *testing.T) {
+	msg, err := NewQuery("münchen.de", "mx", Options{DNSSEC: true})
+	if err != nil {
+		t.Fatalf("NewQuery() error = %v", err)
+	}
+	if len(msg.Question) != 1 || msg.Question[0].Name != "xn--mnchen-3ya.de." || msg.Question[0].Qtype != dns.TypeMX {
+		t.Errorf("Unexpected question %v", msg.Question)
+	}
+	opt := msg.IsEdns0()
+	if !msg.RecursionDesired || opt == nil || !opt.Do() || opt.UDPSize() != ednsBufferSize {
+		t.Errorf("Expected a recursive query with EDNS and the DO bit, got %v", msg)
+	}
+
+	msg, err = NewQuery("example.com", "A", Options{Cookie: "0102030405060708"})
+	if err != nil || msg.IsEdns0().Option[0].(*dns.EDNS0_COOKIE).Cookie != "0102030405060708" {
+		t.Errorf("Expected the given cookie, got %v (error %v)", msg, err)
+	}
+
+	msg, err = NewQuery("example.com", "A", Options{NoRecurse: true, Opcode: dns.OpcodeNotify})
+	if err != nil || msg.RecursionDesired || msg.Opcode != dns.OpcodeNotify {
+		t.Errorf("Expected a non-recursive NOTIFY, got %v (error %v)", msg, err)
+	}
+
+	if _, err := NewQuery("example.com", "SRV", Options{}); !errors.IsInputError(err) {
+		t.Errorf("Expected an input error for an unsupported type, got %v", err)
+	}
+}
+
+func TestClient_Query_ChaosWithNSID(t *testing.T) {
+	var class atomic.Uint32
+	var askedNSID atomic.Bool
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		class.Store(uint32(r.Question[0].Qclass))
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		rr, _ := dns.NewRR(`hostname.bind. 0 CH TXT "fra-3"`)
+		msg.Answer = append(msg.Answer, rr)
+		if opt := r.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if _, ok := option.(*dns.EDNS0_NSID); ok {
+					askedNSID.Store(true)
+				}
+			}
+			msg.SetEdns0(ednsBufferSize, false)
+			msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "667261"})
+		}
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	c := NewClientWithOptions(Options{Class: dns.ClassCHAOS, NSID: true, Timeout: 2 * time.Second})
+	result, err := c.Query("hostname.bind", "TXT", serverAddr)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if class.Load() != dns.ClassCHAOS || result.Class != "CH" {
+		t.Errorf("Expected a CHAOS query, got class %d and result class %q", class.Load(), result.Class)
+	}
+	if !askedNSID.Load() || result.NSID != "fra" {
+		t.Errorf("Expected the NSID to be asked for and decoded, got %q", result.NSID)
+	}
+	if len(result.Records) != 1 || result.Records[0] != "fra-3" {
+		t.Errorf("Unexpected records %v", result.Records)
+	}
+
+	// The identifier is only reported when asked for
+	result, err = NewClientWithOptions(Options{Timeout: 2 * time.Second}).Query("hostname.bind", "TXT", serverAddr)
+	if err != nil || result.Class != "IN" || result.NSID != "" || class.Load() != dns.ClassINET {
+		t.Errorf("Expected a plain IN query, got class %q NSID %q (error %v)", result.Class, result.NSID, err)
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	tests := map[string]uint16{
+		"IN":       dns.ClassINET,
+		"ch":       dns.ClassCHAOS,
+		"CHAOS":    dns.ClassCHAOS,
+		"hs":       dns.ClassHESIOD,
+		"ANY":      dns.ClassANY,
+		"CLASS42":  42,
+		"3":        3,
+		"class255": 255,
+	}
+	for class, want := range tests {
+		if got, err := ParseClass(class); err != nil || got != want {
+			t.Errorf("ParseClass(%q) = %d, %v, want %d", class, got, err, want)
+		}
+	}
+	for _, class := range []string{"", "XX", "CLASS", "70000", "CLASS-1"} {
+		if _, err := ParseClass(class); !errors.IsInputError(err) {
+			t.Errorf("ParseClass(%q) error = %v, want an input error", class, err)
+		}
+	}
+}
+
+func TestClient_Query_HeaderFlags(t *testing.T) {
+	var header atomic.Pointer[dns.MsgHdr]
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		header.Store(&r.MsgHdr)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Authoritative = true
+		rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	c := NewClientWithOptions(Options{NoRecurse: true, CD: true, AD: true, AAOnly: true, Timeout: 2 * time.Second})
+	result, err := c.Query("example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	sent := header.Load()
+	if sent.RecursionDesired || !sent.CheckingDisabled || !sent.AuthenticatedData || !sent.Authoritative {
+		t.Errorf("Expected rd clear and cd, ad and aa set in the query, got %+v", *sent)
+	}
+	// SetReply copies rd and cd from the query
+	if flags := strings.Join(result.Flags, " "); flags != "qr aa cd" {
+		t.Errorf("Flags = %q, want \"qr aa cd\"", flags)
+	}
+	if result.Opcode != "QUERY" {
+		t.Errorf("Opcode = %q, want QUERY", result.Opcode)
+	}
+
+	// Queries are recursive by default
+	result, err = NewClientWithOptions(Options{Timeout: 2 * time.Second}).Query("example.com", "A", serverAddr)
+	if err != nil || !header.Load().RecursionDesired || header.Load().CheckingDisabled {
+		t.Errorf("Expected a plain recursive query, got %+v (error %v)", *header.Load(), err)
+	}
+	if flags := strings.Join(result.Flags, " "); flags != "qr aa rd" {
+		t.Errorf("Flags = %q, want \"qr aa rd\"", flags)
+	}
+}
+
+func TestParseOpcode(t *testing.T) {
+	tests := map[string]int{
+		"QUERY":    dns.OpcodeQuery,
+		"notify":   dns.OpcodeNotify,
+		"UPDATE":   dns.OpcodeUpdate,
+		"3":        3,
+		"OPCODE15": 15,
+	}
+	for opcode, want := range tests {
+		if got, err := ParseOpcode(opcode); err != nil || got != want {
+			t.Errorf("ParseOpcode(%q) = %d, %v, want %d", opcode, got, err, want)
+		}
+	}
+	for _, opcode := range []string{"", "XFR", "OPCODE", "16", "-1"} {
+		if _, err := ParseOpcode(opcode); !errors.IsInputError(err) {
+			t.Errorf("ParseOpcode(%q) error = %v, want an input error", opcode, err)
+		}
+	}
+	if name := OpcodeName(3); name != "OPCODE3" {
+		t.Errorf("OpcodeName(3) = %q, want OPCODE3", name)
+	}
+}
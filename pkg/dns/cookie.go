@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"go-dig/pkg/errors"
+
+	"github.com/miekg/dns"
+)
+
+// Cookie status of a response (RFC 7873)
+const (
+	CookieValid   = "valid"   // The server echoed the client cookie and sent a server cookie of valid length
+	CookieInvalid = "invalid" // The response cookie does not echo the client cookie or is malformed
+	CookieMissing = "missing" // The response carries no cookie
+)
+
+// Cookie lengths in bytes (RFC 7873, section 4)
+const (
+	clientCookieLength    = 8
+	minServerCookieLength = 8
+	maxServerCookieLength = 32
+)
+
+// CookieResult describes the DNS cookie exchange of the last query sent for
+// a result
+type CookieResult struct {
+	Client    string // Client cookie sent, in hex
+	Server    string // Server cookie returned, in hex; for an invalid cookie whatever followed the client cookie
+	Status    string // CookieValid, CookieInvalid or CookieMissing
+	BadCookie bool   // The server answered BADCOOKIE and the query was sent again with the server cookie it returned
+}
+
+// CookieJar holds the DNS cookies a client exchanges with servers: a random
+// client cookie for each server address and the server cookie that server
+// last returned with it. Clients sharing a jar send the cookies learned by
+// one another, so a session or batch of queries reuses them. A CookieJar is
+// safe for concurrent use.
+type CookieJar struct {
+	mu      sync.Mutex
+	cookies map[string]cookiePair // By server address
+}
+
+// cookiePair is a client cookie and the server cookie that goes with it
+type cookiePair struct {
+	client []byte
+	server []byte
+}
+
+// NewCookieJar returns an empty cookie jar
+func NewCookieJar() *CookieJar {
+	return &CookieJar{cookies: make(map[string]cookiePair)}
+}
+
+// get returns the cookies to send to server, making up a client cookie the
+// first time the server is queried
+func (j *CookieJar) get(server string) cookiePair {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	pair, ok := j.cookies[server]
+	if !ok {
+		pair.client = newClientCookie()
+		j.cookies[server] = pair
+	}
+	return pair
+}
+
+// newClientCookie returns a random client cookie
+func newClientCookie() []byte {
+	client := make([]byte, clientCookieLength)
+	rand.Read(client)
+	return client
+}
+
+// set keeps the server cookie a server returned with the given client cookie
+func (j *CookieJar) set(server string, pair cookiePair) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cookies[server] = pair
+}
+
+// ValidateCookie checks a DNS cookie written in hex: 16 digits of client
+// cookie, optionally followed by 16 to 64 digits of server cookie
+func ValidateCookie(cookie string) error {
+	_, err := parseCookie(cookie)
+	return err
+}
+
+// parseCookie splits a cookie in hex into its client and server cookies
+func parseCookie(cookie string) (cookiePair, error) {
+	data, err := hex.DecodeString(cookie)
+	server := len(data) - clientCookieLength
+	if err != nil || server < 0 || (server > 0 && (server < minServerCookieLength || server > maxServerCookieLength)) {
+		return cookiePair{}, errors.NewInputError(fmt.Sprintf("invalid DNS cookie '%s' (expected 16 hex digits of client cookie, optionally followed by 16 to 64 of server cookie)", cookie), nil)
+	}
+	return cookiePair{client: data[:clientCookieLength], server: data[clientCookieLength:]}, nil
+}
+
+// addCookie adds the COOKIE option to the OPT record of a query
+func addCookie(msg *dns.Msg, pair cookiePair) {
+	opt := msg.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+		Code:   dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(pair.client) + hex.EncodeToString(pair.server),
+	})
+}
+
+// keepCookie checks the cookie in a response to a query sent with the given
+// cookies and keeps a valid server cookie for later queries to server
+func (c *client) keepCookie(response *dns.Msg, server string, sent cookiePair) *CookieResult {
+	status, serverCookie := checkCookie(response, sent.client)
+	if status == CookieValid {
+		c.cookies.set(server, cookiePair{client: sent.client, server: serverCookie})
+		// The jar now has a server cookie to send, so a cookie given in
+		// Options has served its purpose
+		c.cookieMu.Lock()
+		c.cookie = nil
+		c.cookieMu.Unlock()
+	}
+	return &CookieResult{Client: hex.EncodeToString(sent.client), Server: hex.EncodeToString(serverCookie), Status: status}
+}
+
+// cookieFor returns the cookie to send to server: the cookie given in Options
+// until a server has returned a valid cookie, then the jar's
+func (c *client) cookieFor(server string) cookiePair {
+	c.cookieMu.Lock()
+	defer c.cookieMu.Unlock()
+	if c.cookie != nil {
+		return *c.cookie
+	}
+	return c.cookies.get(server)
+}
+
+// checkCookie compares the cookie in a response with the client cookie sent
+// and returns the status and the server cookie returned
+func checkCookie(response *dns.Msg, client []byte) (string, []byte) {
+	opt := response.IsEdns0()
+	if opt == nil {
+		return CookieMissing, nil
+	}
+	for _, option := range opt.Option {
+		cookie, ok := option.(*dns.EDNS0_COOKIE)
+		if !ok {
+			continue
+		}
+		data, err := hex.DecodeString(cookie.Cookie)
+		if err != nil || len(data) < clientCookieLength {
+			return CookieInvalid, nil
+		}
+		server := data[clientCookieLength:]
+		if !bytes.Equal(data[:clientCookieLength], client) || len(server) < minServerCookieLength || len(server) > maxServerCookieLength {
+			return CookieInvalid, server
+		}
+		return CookieValid, server
+	}
+	return CookieMissing, nil
+}
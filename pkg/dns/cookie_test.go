@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-dig/pkg/dnstest"
+	"go-dig/pkg/errors"
+
+	"github.com/miekg/dns"
+)
+
+// cookieServer answers like a server enforcing DNS cookies: its server cookie
+// is the client cookie reversed and repeated, and a query with a different server cookie
+// gets BADCOOKIE together with the right one
+type cookieServer struct {
+	mu   sync.Mutex
+	sent []string // Cookie of each query received, in hex
+}
+
+func (s *cookieServer) handle(w dns.ResponseWriter, r *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.SetEdns0(ednsBufferSize, false)
+
+	var cookie string
+	for _, option := range r.IsEdns0().Option {
+		if c, ok := option.(*dns.EDNS0_COOKIE); ok {
+			cookie = c.Cookie
+		}
+	}
+	s.mu.Lock()
+	s.sent = append(s.sent, cookie)
+	s.mu.Unlock()
+
+	rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+	msg.Answer = append(msg.Answer, rr)
+	if cookie != "" {
+		client := cookie[:2*clientCookieLength]
+		server := reverseHex(client)
+		msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: client + server})
+		if len(cookie) > len(client) && cookie[len(client):] != server {
+			msg.Rcode = dns.RcodeBadCookie
+			msg.Answer = nil
+		}
+	}
+	w.WriteMsg(msg)
+}
+
+// received returns the cookies of the queries received so far
+func (s *cookieServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sent...)
+}
+
+// reverseHex returns the bytes of a hex string in reverse order, doubled to
+// make a server cookie of valid length
+func reverseHex(text string) string {
+	data, _ := hex.DecodeString(text)
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return hex.EncodeToString(append(reversed, reversed...))
+}
+
+func TestClient_Query_CookiesSharedBetweenClients(t *testing.T) {
+	cookies := &cookieServer{}
+	server := dnstest.NewServer(t)
+	server.HandleFunc(cookies.handle)
+	serverAddr := server.Addr()
+
+	jar := NewCookieJar()
+	result, err := NewClientWithOptions(Options{Cookies: jar, Timeout: 2 * time.Second}).Query("example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if result.Cookie == nil || result.Cookie.Status != CookieValid || result.Cookie.Server != reverseHex(result.Cookie.Client) {
+		t.Fatalf("Expected a valid cookie, got %+v", result.Cookie)
+	}
+
+	// A second client with the same jar sends the server cookie it learned
+	result, err = NewClientWithOptions(Options{Cookies: jar, Timeout: 2 * time.Second}).Query("example.com", "A", serverAddr)
+	if err != nil || result.Cookie.Status != CookieValid || result.Cookie.BadCookie {
+		t.Fatalf("Expected a valid cookie on the second query, got %+v (error %v)", result.Cookie, err)
+	}
+	sent := cookies.received()
+	if len(sent) != 2 || len(sent[0]) != 16 || sent[1] != sent[0]+reverseHex(sent[0]) {
+		t.Errorf("Expected a client cookie, then it with the server cookie; sent %q", sent)
+	}
+
+	// Without a jar no cookie is sent or reported
+	result, err = NewClientWithOptions(Options{Timeout: 2 * time.Second}).Query("example.com", "A", serverAddr)
+	if err != nil || result.Cookie != nil || cookies.received()[2] != "" {
+		t.Errorf("Expected no cookie, got %+v (error %v)", result.Cookie, err)
+	}
+}
+
+func TestClient_Query_BadCookie(t *testing.T) {
+	cookies := &cookieServer{}
+	server := dnstest.NewServer(t)
+	server.HandleFunc(cookies.handle)
+	serverAddr := server.Addr()
+
+	// A forged server cookie is rejected, and the query is sent again with the one returned
+	forged := "0102030405060708" + strings.Repeat("ff", 16)
+	client := NewClientWithOptions(Options{Cookie: forged, Timeout: 2 * time.Second})
+	result, err := client.Query("example.com", "A", serverAddr)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if !result.Cookie.BadCookie || result.Cookie.Status != CookieValid || result.Rcode != "NOERROR" || len(result.Records) != 1 {
+		t.Errorf("Expected an answer after BADCOOKIE, got %+v with cookie %+v", result, result.Cookie)
+	}
+	if sent := cookies.received(); len(sent) != 2 || sent[0] != forged || sent[1] != "0102030405060708"+reverseHex("0102030405060708") {
+		t.Errorf("Unexpected cookies sent: %q", sent)
+	}
+
+	// Later queries send the cookie the server returned, not the forged one
+	result, err = client.Query("example.com", "A", serverAddr)
+	if err != nil || result.Cookie.BadCookie || result.Cookie.Status != CookieValid {
+		t.Fatalf("Expected the learned cookie to be accepted, got %+v (error %v)", result.Cookie, err)
+	}
+	if sent := cookies.received(); len(sent) != 3 || sent[2] != sent[1] {
+		t.Errorf("Expected the learned cookie to be sent again, sent %q", sent)
+	}
+}
+
+func TestClient_Query_BadCookieTwice(t *testing.T) {
+	server := dnstest.NewServer(t)
+	server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.SetEdns0(ednsBufferSize, false)
+		msg.Rcode = dns.RcodeBadCookie
+		msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE,
+			Cookie: r.IsEdns0().Option[0].(*dns.EDNS0_COOKIE).Cookie[:16] + strings.Repeat("ab", 8)})
+		w.WriteMsg(msg)
+	})
+	serverAddr := server.Addr()
+
+	result, err := NewClientWithOptions(Options{Cookies: NewCookieJar(), Timeout: 2 * time.Second}).Query("example.com", "A", serverAddr)
+	if !errors.Is(err, errors.ErrBadCookie) {
+		t.Fatalf("Expected a BADCOOKIE error, got %v", err)
+	}
+	if result.Rcode != "BADCOOKIE" || !result.Cookie.BadCookie {
+		t.Errorf("Expected BADCOOKIE after one retry, got %q with cookie %+v", result.Rcode, result.Cookie)
+	}
+}
+
+func TestClient_Query_CookieStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie func(sent string) string // The cookie the server returns; empty for none
+		status string
+	}{
+		{"missing", func(string) string { return "" }, CookieMissing},
+		{"other client cookie", func(string) string { return strings.Repeat("00", 8) + strings.Repeat("11", 8) }, CookieInvalid},
+		{"short server cookie", func(sent string) string { return sent[:16] + "1122" }, CookieInvalid},
+		{"client cookie only", func(sent string) string { return sent[:16] }, CookieInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := dnstest.NewServer(t)
+			server.HandleFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.SetEdns0(ednsBufferSize, false)
+				rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+				msg.Answer = append(msg.Answer, rr)
+				if cookie := tt.cookie(r.IsEdns0().Option[0].(*dns.EDNS0_COOKIE).Cookie); cookie != "" {
+					msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+				}
+				w.WriteMsg(msg)
+			})
+			serverAddr := server.Addr()
+
+			jar := NewCookieJar()
+			result, err := NewClientWithOptions(Options{Cookies: jar, Timeout: 2 * time.Second}).Query("example.com", "A", serverAddr)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if result.Cookie.Status != tt.status {
+				t.Errorf("Status = %q, want %q", result.Cookie.Status, tt.status)
+			}
+			// Only valid server cookies are kept
+			if pair := jar.get(serverAddr); len(pair.server) != 0 {
+				t.Errorf("Expected no server cookie in the jar, got %x", pair.server)
+			}
+		})
+	}
+}
+
+func TestValidateCookie(t *testing.T) {
+	for _, cookie := range []string{"0102030405060708", "0102030405060708" + strings.Repeat("ab", 8), "0102030405060708" + strings.Repeat("ab", 32)} {
+		if err := ValidateCookie(cookie); err != nil {
+			t.Errorf("ValidateCookie(%q) error = %v", cookie, err)
+		}
+	}
+	for _, cookie := range []string{"", "0102", "01020304050607zz", "0102030405060708ab", "0102030405060708" + strings.Repeat("ab", 33)} {
+		if err := ValidateCookie(cookie); !errors.IsInputError(err) {
+			t.Errorf("ValidateCookie(%q) error = %v, want an input error", cookie, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"go-dig/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Transport sends a query message to a server and returns the response and
+// the time the exchange took. network is "udp" or "tcp", optionally followed
+// by "4" or "6".
+type Transport interface {
+	Exchange(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error)
+}
+
+// TransportFunc adapts a function to the Transport interface
+type TransportFunc func(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error)
+
+// Exchange calls f
+func (f TransportFunc) Exchange(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+	return f(msg, server, network)
+}
+
+// FixtureEntry is one exchange in a fixture file. Fixture files hold one
+// JSON object per line; messages are in DNS wire format, base64-encoded.
+type FixtureEntry struct {
+	Time     time.Time     `json:"time"`
+	Server   string        `json:"server"`
+	Network  string        `json:"network"`
+	Query    []byte        `json:"query"`
+	Response []byte        `json:"response,omitempty"`
+	RTT      time.Duration `json:"rtt_ns"`
+	Error    string        `json:"error,omitempty"`
+	Timeout  bool          `json:"timeout,omitempty"` // The error was a timeout
+	Errno    int           `json:"errno,omitempty"`   // System error number behind the error, if any
+}
+
+// Recorder is a Transport that passes queries on to another transport and
+// writes every exchange to a fixture
+type Recorder struct {
+	next Transport
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// NewRecorder creates a Recorder that sends queries through next and appends
+// the exchanges to w
+func NewRecorder(next Transport, w io.Writer) *Recorder {
+	return &Recorder{next: next, encoder: json.NewEncoder(w)}
+}
+
+// Exchange sends msg through the next transport and records the exchange
+func (r *Recorder) Exchange(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+	started := time.Now()
+	response, rtt, err := r.next.Exchange(msg, server, network)
+
+	entry := FixtureEntry{Time: started.UTC(), Server: server, Network: network, RTT: rtt}
+	entry.Query, _ = msg.Pack()
+	if response != nil {
+		entry.Response, _ = response.Pack()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Timeout = errors.ClassifyNetworkError(err, server).Kind == errors.ErrTimeout
+		var errno syscall.Errno
+		if stderrors.As(err, &errno) {
+			entry.Errno = int(errno)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if writeErr := r.encoder.Encode(entry); writeErr != nil && r.err == nil {
+		r.err = writeErr
+	}
+
+	return response, rtt, err
+}
+
+// Err returns the first error writing the fixture, if any
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Replayer is a Transport that answers from a fixture without network access.
+// A query is matched to the first unused entry with the same question,
+// preferring one recorded for the same server and network, so repeated
+// queries are answered in recorded order. Responses get the ID of the query.
+type Replayer struct {
+	mu      sync.Mutex
+	entries []FixtureEntry
+	queries []*dns.Msg // Unpacked Query of each entry
+	used    []bool
+}
+
+// LoadFixture reads a fixture file for replay
+func LoadFixture(path string) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.NewInputError(fmt.Sprintf("cannot open fixture file %s", path), err)
+	}
+	defer file.Close()
+
+	replayer, err := NewReplayer(file)
+	if err != nil {
+		return nil, errors.NewInputError(fmt.Sprintf("cannot read fixture file %s", path), err)
+	}
+	return replayer, nil
+}
+
+// NewReplayer reads fixture entries from r
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	replayer := &Replayer{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry FixtureEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(entry.Query); err != nil || len(query.Question) == 0 {
+			return nil, fmt.Errorf("line %d: query is not a DNS message with a question", number)
+		}
+		if entry.Error == "" {
+			if err := new(dns.Msg).Unpack(entry.Response); err != nil {
+				return nil, fmt.Errorf("line %d: response is not a DNS message: %v", number, err)
+			}
+		}
+
+		replayer.entries = append(replayer.entries, entry)
+		replayer.queries = append(replayer.queries, query)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	replayer.used = make([]bool, len(replayer.entries))
+	return replayer, nil
+}
+
+// Exchange answers msg from the fixture
+func (r *Replayer) Exchange(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+	if len(msg.Question) == 0 {
+		return nil, 0, fmt.Errorf("replay: query has no question")
+	}
+	question := msg.Question[0]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, query := range r.queries {
+		if r.used[i] || !sameQuestion(query.Question[0], question) {
+			continue
+		}
+		if r.entries[i].Server == server && r.entries[i].Network == network {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil, 0, fmt.Errorf("replay: no recorded response for %s %s", question.Name, dns.TypeToString[question.Qtype])
+	}
+	r.used[match] = true
+
+	entry := r.entries[match]
+	if entry.Error != "" {
+		return nil, entry.RTT, &replayedError{message: entry.Error, timeout: entry.Timeout, errno: syscall.Errno(entry.Errno)}
+	}
+
+	response := new(dns.Msg)
+	if err := response.Unpack(entry.Response); err != nil {
+		return nil, entry.RTT, err
+	}
+	response.Id = msg.Id
+	return response, entry.RTT, nil
+}
+
+// Remaining returns the number of entries not used yet
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := 0
+	for _, used := range r.used {
+		if !used {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// sameQuestion compares questions, ignoring the case of the names
+func sameQuestion(a, b dns.Question) bool {
+	return strings.EqualFold(a.Name, b.Name) && a.Qtype == b.Qtype && a.Qclass == b.Qclass
+}
+
+// replayedError is a recorded transport error. It reports a timeout and
+// unwraps to the system error number as the original did, so it is
+// classified the same way.
+type replayedError struct {
+	message string
+	timeout bool
+	errno   syscall.Errno
+}
+
+func (e *replayedError) Error() string   { return e.message }
+func (e *replayedError) Timeout() bool   { return e.timeout }
+func (e *replayedError) Temporary() bool { return e.timeout }
+
+// Unwrap returns the system error number, if one was recorded
+func (e *replayedError) Unwrap() error {
+	if e.errno == 0 {
+		return nil
+	}
+	return e.errno
+}
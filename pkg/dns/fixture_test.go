@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"bytes"
+	"go-dig/pkg/dnstest"
+	"go-dig/pkg/errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := dnstest.NewServer(t)
+	server.Handle("www.example.com.", dns.TypeA, dnstest.Response{
+		Authoritative: true,
+		Answer:        []string{"www.example.com. 300 IN CNAME web.example.com.", "web.example.com. 300 IN A 192.0.2.1"},
+	})
+	server.Handle("missing.example.com.", dns.TypeMX, dnstest.Response{
+		Rcode: dns.RcodeNameError,
+		Ns:    []string{"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"},
+	})
+	server.Handle("slow.example.com.", dns.TypeA, dnstest.Response{Drop: true})
+
+	var fixture bytes.Buffer
+	recording := NewClientWithOptions(Options{Timeout: 200 * time.Millisecond, Record: &fixture})
+	recorded, err := recording.Query("www.example.com", "A", server.Addr())
+	if err != nil {
+		t.Fatalf("Recorded query error = %v", err)
+	}
+	recordedNX, _ := recording.Query("missing.example.com", "MX", server.Addr())
+	if _, err := recording.Query("slow.example.com", "A", server.Addr()); !errors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("Expected the recorded query to time out, got %v", err)
+	}
+	if lines := strings.Count(fixture.String(), "\n"); lines != 3 {
+		t.Fatalf("Expected three fixture entries, got %d:\n%s", lines, fixture.String())
+	}
+
+	// The server is gone, so every answer must come from the fixture
+	server.Close()
+	replayer, err := NewReplayer(&fixture)
+	if err != nil {
+		t.Fatalf("NewReplayer() error = %v", err)
+	}
+	replaying := NewClientWithOptions(Options{Timeout: 200 * time.Millisecond, Transport: replayer})
+
+	replayed, err := replaying.Query("WWW.example.com", "A", server.Addr())
+	if err != nil {
+		t.Fatalf("Replayed query error = %v", err)
+	}
+	if !reflect.DeepEqual(replayed.Records, recorded.Records) || replayed.QueryTime != recorded.QueryTime {
+		t.Errorf("Replayed %v in %v, recorded %v in %v", replayed.Records, replayed.QueryTime, recorded.Records, recorded.QueryTime)
+	}
+	if len(replayed.Chain) != 1 {
+		t.Errorf("Expected the replayed CNAME chain, got %v", replayed.Chain)
+	}
+
+	replayedNX, _ := replaying.Query("missing.example.com", "MX", server.Addr())
+	if replayedNX.Rcode != recordedNX.Rcode || replayedNX.Rcode != "NXDOMAIN" {
+		t.Errorf("Replayed Rcode %q, recorded %q", replayedNX.Rcode, recordedNX.Rcode)
+	}
+
+	if _, err := replaying.Query("slow.example.com", "A", server.Addr()); !errors.Is(err, errors.ErrTimeout) {
+		t.Errorf("Expected the replayed query to time out, got %v", err)
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("Expected every entry to be used, %d remain", replayer.Remaining())
+	}
+
+	// Each entry answers once
+	if _, err := replaying.Query("www.example.com", "A", server.Addr()); err == nil || !strings.Contains(err.Error(), "no recorded response for www.example.com. A") {
+		t.Errorf("Expected no recorded response to be left, got %v", err)
+	}
+}
+
+func TestReplayer_Matching(t *testing.T) {
+	var fixture bytes.Buffer
+	answers := map[string]string{"192.0.2.53:53": "192.0.2.1", "198.51.100.53:53": "192.0.2.2"}
+	recorder := NewRecorder(TransportFunc(func(msg *dns.Msg, server, network string) (*dns.Msg, time.Duration, error) {
+		reply := new(dns.Msg)
+		reply.SetReply(msg)
+		rr, _ := dns.NewRR("example.com. 300 IN A " + answers[server])
+		reply.Answer = append(reply.Answer, rr)
+		return reply, time.Millisecond, nil
+	}), &fixture)
+
+	for _, server := range []string{"192.0.2.53:53", "198.51.100.53:53"} {
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		if _, _, err := recorder.Exchange(query, server, "udp"); err != nil {
+			t.Fatalf("Exchange() error = %v", err)
+		}
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("Recorder.Err() = %v", err)
+	}
+
+	replayer, err := NewReplayer(&fixture)
+	if err != nil {
+		t.Fatalf("NewReplayer() error = %v", err)
+	}
+
+	// The entry recorded for the same server is preferred
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	response, rtt, err := replayer.Exchange(query, "198.51.100.53:53", "udp")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if response.Id != query.Id || rtt != time.Millisecond || response.Answer[0].(*dns.A).A.String() != "192.0.2.2" {
+		t.Errorf("Unexpected replay (rtt %v):\n%v", rtt, response)
+	}
+
+	// Otherwise any entry for the question answers
+	response, _, err = replayer.Exchange(query, "203.0.113.53:53", "tcp")
+	if err != nil || response.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Errorf("Expected the remaining entry, got %v (error %v)", response, err)
+	}
+}
+
+func TestLoadFixture(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := LoadFixture(filepath.Join(dir, "missing.jsonl")); err == nil || !errors.IsInputError(err) {
+		t.Errorf("Expected an input error for a missing file, got %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		expectError string
+	}{
+		{"not JSON", "{\n", "line 1"},
+		{"no query", `{"server":"192.0.2.53:53","network":"udp"}` + "\n", "line 1: query is not a DNS message"},
+		{"bad response", `{"query":"AAABAAABAAAAAAAAB2V4YW1wbGUDY29tAAABAAE=","response":"AAE="}` + "\n", "line 1: response is not a DNS message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "fixture.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("writing fixture: %v", err)
+			}
+			_, err := LoadFixture(path)
+			if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("LoadFixture() error = %v, want input error containing %q", err, tt.expectError)
+			}
+		})
+	}
+}
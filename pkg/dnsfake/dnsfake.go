@@ -0,0 +1,171 @@
+// Package dnsfake provides a fake dns.Client for testing code that looks names
+// up through one. Query answers come from a table instead of the network, and
+// names without records get a NODATA result, as the real client reports them.
+// Exchange passes raw messages to per-server handlers and records each one.
+//
+// It lives apart from dnstest because the dns package's own tests use dnstest.
+//
+//	client := dnsfake.NewClient()
+//	client.Answer("", "example.com", "NS", "ns1.example.com.", "ns2.example.com.")
+//	client.Answer("192.0.2.53", "www.example.com", "A", "203.0.113.10")
+//	client.Handle("192.0.2.53", func(msg *mdns.Msg, network string) *mdns.Msg { ... })
+package dnsfake
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// TTL is the TTL of every answer and of the SOA in NODATA results
+const TTL = 300
+
+// Handler answers a raw exchange received over network ("udp" or "tcp").
+// Returning nil makes the exchange fail as a refused connection.
+type Handler func(msg *mdns.Msg, network string) *mdns.Msg
+
+// answer is the records for one question, replaced by later once the
+// question has been asked more than after times
+type answer struct {
+	values  []string
+	later   []string
+	after   int
+	changes bool
+}
+
+// Client is a fake dns.Client. The zero value is not usable; call NewClient.
+type Client struct {
+	mu        sync.Mutex
+	answers   map[string]*answer
+	handlers  map[string]Handler
+	calls     map[string]int
+	exchanges []string
+}
+
+// NewClient returns a client that answers every query with NODATA and fails
+// every exchange with a timeout until told otherwise
+func NewClient() *Client {
+	return &Client{
+		answers:  map[string]*answer{},
+		handlers: map[string]Handler{},
+		calls:    map[string]int{},
+	}
+}
+
+// Answer sets the records returned for name and recordType when queried at
+// server. An empty server matches queries to any server without an answer of
+// its own. Without values the answer is NODATA.
+func (c *Client) Answer(server, name, recordType string, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.answers[key(server, name, recordType)] = &answer{values: values}
+}
+
+// AnswerAfter changes the answer set by Answer: once the question has been
+// asked at server more than after times, it is answered with values instead
+func (c *Client) AnswerAfter(server, name, recordType string, after int, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(server, name, recordType)
+	a, ok := c.answers[k]
+	if !ok {
+		a = &answer{}
+		c.answers[k] = a
+	}
+	a.later, a.after, a.changes = values, after, true
+}
+
+// Handle sets the handler for exchanges sent to server. An empty server
+// handles exchanges to any server without a handler of its own.
+func (c *Client) Handle(server string, handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[server] = handler
+}
+
+// Calls returns how many times name and recordType were queried at server
+func (c *Client) Calls(server, name, recordType string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[key(server, name, recordType)]
+}
+
+// Exchanges returns the exchanges sent so far as "name network", in order
+func (c *Client) Exchanges() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.exchanges...)
+}
+
+// Query answers from the table set up with Answer
+func (c *Client) Query(domain, recordType, server string) (*dns.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := key(server, domain, recordType)
+	c.calls[k]++
+
+	a, ok := c.answers[k]
+	if !ok {
+		a = c.answers[key("", domain, recordType)]
+	}
+
+	result := &dns.Result{Domain: domain, RecordType: recordType, Server: server, Rcode: "NOERROR", Class: "IN"}
+	var values []string
+	if a != nil {
+		values = a.values
+		if a.changes && c.calls[k] > a.after {
+			values = a.later
+		}
+	}
+
+	if len(values) == 0 {
+		zone := mdns.Fqdn(domain)
+		result.NoData = true
+		result.SOA = &dns.Record{Name: zone, Type: "SOA", TTL: TTL, Value: "ns.invalid. hostmaster.invalid. 1 7200 3600 1209600 300"}
+		result.NegativeTTL = TTL
+		return result, nil
+	}
+
+	for _, value := range values {
+		result.Records = append(result.Records, value)
+		result.Answers = append(result.Answers, dns.Record{Name: mdns.Fqdn(domain), Type: recordType, TTL: TTL, Value: value})
+	}
+	return result, nil
+}
+
+// Exchange passes msg to the handler for server
+func (c *Client) Exchange(msg *mdns.Msg, server, network string) (*mdns.Msg, time.Duration, error) {
+	c.mu.Lock()
+	if len(msg.Question) > 0 {
+		c.exchanges = append(c.exchanges, strings.TrimSuffix(msg.Question[0].Name, ".")+" "+network)
+	}
+	handler, ok := c.handlers[server]
+	if !ok {
+		handler, ok = c.handlers[""]
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, 0, errors.NewNetworkError("DNS server timeout", nil, server)
+	}
+	response := handler(msg, network)
+	if response == nil {
+		return nil, 0, errors.NewNetworkError("DNS server refused connection", nil, server)
+	}
+	return response, time.Millisecond, nil
+}
+
+// SetTimeout does nothing; the fake never waits
+func (c *Client) SetTimeout(duration time.Duration) {}
+
+// key identifies a question asked at a server, ignoring case and the
+// trailing dot of the name
+func key(server, name, recordType string) string {
+	return server + " " + strings.ToLower(strings.TrimSuffix(name, ".")) + " " + strings.ToUpper(recordType)
+}
@@ -0,0 +1,92 @@
+package dnsfake
+
+import (
+	"testing"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+var _ dns.Client = NewClient()
+
+func TestClient_Query(t *testing.T) {
+	client := NewClient()
+	client.Answer("", "www.example.com", "A", "192.0.2.1")
+	client.Answer("192.0.2.53", "www.example.com", "A", "192.0.2.2")
+
+	result, err := client.Query("WWW.example.com.", "a", "8.8.8.8")
+	if err != nil || result.NoData || len(result.Records) != 1 || result.Records[0] != "192.0.2.1" {
+		t.Errorf("Expected the answer for any server, got %+v, %v", result, err)
+	}
+	if result.Answers[0].Name != "WWW.example.com." || result.Answers[0].TTL != TTL {
+		t.Errorf("Unexpected structured answer %+v", result.Answers[0])
+	}
+
+	result, _ = client.Query("www.example.com", "A", "192.0.2.53")
+	if len(result.Records) != 1 || result.Records[0] != "192.0.2.2" {
+		t.Errorf("Expected the server's own answer, got %v", result.Records)
+	}
+	if client.Calls("192.0.2.53", "www.example.com", "A") != 1 {
+		t.Errorf("Calls() = %d, want 1", client.Calls("192.0.2.53", "www.example.com", "A"))
+	}
+}
+
+func TestClient_Query_NoData(t *testing.T) {
+	client := NewClient()
+	client.Answer("", "example.com", "MX")
+
+	for _, name := range []string{"example.com", "unknown.example.com"} {
+		result, err := client.Query(name, "MX", "")
+		if err != nil || result.Error != nil {
+			t.Fatalf("Query(%s) error = %v, want nil", name, err)
+		}
+		if !result.NoData || result.Rcode != "NOERROR" || result.SOA == nil || len(result.Records) != 0 {
+			t.Errorf("Expected a NODATA result for %s, got %+v", name, result)
+		}
+	}
+}
+
+func TestClient_AnswerAfter(t *testing.T) {
+	client := NewClient()
+	client.Answer("", "www.example.com", "A", "192.0.2.1")
+	client.AnswerAfter("", "www.example.com", "A", 1, "192.0.2.2")
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		result, _ := client.Query("www.example.com", "A", "")
+		got = append(got, result.Records[0])
+	}
+	if got[0] != "192.0.2.1" || got[1] != "192.0.2.2" || got[2] != "192.0.2.2" {
+		t.Errorf("Unexpected answers %v", got)
+	}
+}
+
+func TestClient_Exchange(t *testing.T) {
+	client := NewClient()
+	client.Handle("192.0.2.53", func(msg *mdns.Msg, network string) *mdns.Msg {
+		response := new(mdns.Msg)
+		response.SetReply(msg)
+		return response
+	})
+	client.Handle("192.0.2.54", func(msg *mdns.Msg, network string) *mdns.Msg { return nil })
+
+	msg := new(mdns.Msg)
+	msg.SetQuestion("example.com.", mdns.TypeSOA)
+
+	if response, _, err := client.Exchange(msg, "192.0.2.53", "udp"); err != nil || response.Id != msg.Id {
+		t.Errorf("Expected the handler's response, got %v, %v", response, err)
+	}
+	if _, _, err := client.Exchange(msg, "192.0.2.54", "tcp"); !errors.IsNetworkError(err) {
+		t.Errorf("Expected a network error for a nil response, got %v", err)
+	}
+	if _, _, err := client.Exchange(msg, "192.0.2.55", "udp"); !errors.IsNetworkError(err) {
+		t.Errorf("Expected a network error without a handler, got %v", err)
+	}
+
+	exchanges := client.Exchanges()
+	if len(exchanges) != 3 || exchanges[0] != "example.com udp" || exchanges[1] != "example.com tcp" {
+		t.Errorf("Unexpected exchanges %v", exchanges)
+	}
+}
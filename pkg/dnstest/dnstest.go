@@ -0,0 +1,391 @@
+// Package dnstest provides an in-process DNS server for tests. Responses are
+// scripted per question, including failure modes such as delays, dropped
+// packets, truncation and malformed replies, and every query received is
+// recorded for assertions.
+//
+//	server := dnstest.NewServer(t)
+//	server.Handle("www.example.com.", dns.TypeA,
+//		dnstest.Response{Drop: true},
+//		dnstest.Response{Answer: []string{"www.example.com. 300 IN A 192.0.2.1"}})
+//
+//	result, err := client.Query("www.example.com", "A", server.Addr())
+//	server.AssertQueryCount(t, "www.example.com.", dns.TypeA, 2)
+package dnstest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Response describes how the server replies to one query
+type Response struct {
+	Rcode         int      // Response code; NOERROR by default
+	Authoritative bool     // Set the AA flag
+	Answer        []string // Answer records in zone file format with absolute names
+	Ns            []string // Authority records
+	Extra         []string // Additional records
+
+	Delay     time.Duration // Wait this long before replying
+	Drop      bool          // Send no reply at all, so the client times out
+	Truncated bool          // Set the TC flag and leave out all records
+	TCPOnly   bool          // Over UDP reply truncated without records; over TCP reply in full
+	Malformed bool          // Send the reply cut short so that it cannot be parsed
+
+	Modify func(reply *mdns.Msg) // Final changes to the reply, such as EDNS options
+}
+
+// Options configures a server. Zero values select the defaults.
+type Options struct {
+	TCPIdleTimeout time.Duration // Close TCP connections left idle this long; 8 seconds by default
+}
+
+// Query is a query received by the server
+type Query struct {
+	Name    string // Question name as sent
+	Type    uint16 // Question type
+	Network string // "udp" or "tcp"
+	Msg     *mdns.Msg
+}
+
+// script is the sequence of responses for one question; the last response
+// repeats once the others have been used
+type script struct {
+	responses []scriptedResponse
+	next      int
+}
+
+// scriptedResponse is a Response with its records parsed
+type scriptedResponse struct {
+	Response
+	answer, ns, extra []mdns.RR
+}
+
+// Server is a DNS server listening on a local port over UDP and TCP
+type Server struct {
+	t       testing.TB
+	address string
+	servers []*mdns.Server
+
+	mu       sync.Mutex
+	scripts  map[string]*script
+	fallback mdns.HandlerFunc
+	queries  []Query
+}
+
+// NewServer starts a server on a free local port. It is shut down when the
+// test ends. Questions without a script are answered with REFUSED.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	return NewServerWithOptions(t, Options{})
+}
+
+// NewServerWithOptions starts a server like NewServer with the given options
+func NewServerWithOptions(t testing.TB, options Options) *Server {
+	t.Helper()
+
+	s := &Server{t: t, scripts: map[string]*script{}}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("dnstest: listening on UDP: %v", err)
+	}
+	s.address = packetConn.LocalAddr().String()
+
+	// TCP shares the UDP port so that one address serves both
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		packetConn.Close()
+		t.Fatalf("dnstest: listening on TCP: %v", err)
+	}
+
+	started := make(chan struct{}, 2)
+	failed := make(chan error, 2)
+	s.servers = []*mdns.Server{
+		{PacketConn: packetConn, Handler: s.handler("udp")},
+		{Listener: listener, Handler: s.handler("tcp")},
+	}
+	if options.TCPIdleTimeout > 0 {
+		s.servers[1].IdleTimeout = func() time.Duration { return options.TCPIdleTimeout }
+	}
+	for _, server := range s.servers {
+		server.NotifyStartedFunc = func() { started <- struct{}{} }
+		go func() {
+			if err := server.ActivateAndServe(); err != nil {
+				failed <- err
+			}
+		}()
+	}
+	t.Cleanup(s.Close)
+
+	for range s.servers {
+		select {
+		case <-started:
+		case err := <-failed:
+			t.Fatalf("dnstest: starting server: %v", err)
+		}
+	}
+	return s
+}
+
+// Addr returns the address of the server as host:port
+func (s *Server) Addr() string {
+	return s.address
+}
+
+// Close stops the server. It is called automatically when the test ends.
+func (s *Server) Close() {
+	for _, server := range s.servers {
+		server.Shutdown()
+	}
+}
+
+// Handle scripts the responses to queries for name and qtype. Successive
+// queries get successive responses, and the last one repeats. Handle replaces
+// any earlier script for the question and fails the test if a record does not
+// parse.
+func (s *Server) Handle(name string, qtype uint16, responses ...Response) {
+	s.t.Helper()
+
+	if len(responses) == 0 {
+		s.t.Fatalf("dnstest: no responses given for %s %s", name, mdns.TypeToString[qtype])
+	}
+
+	parsed := make([]scriptedResponse, 0, len(responses))
+	for _, response := range responses {
+		scripted := scriptedResponse{Response: response}
+		var err error
+		if scripted.answer, err = parseRecords(response.Answer); err == nil {
+			if scripted.ns, err = parseRecords(response.Ns); err == nil {
+				scripted.extra, err = parseRecords(response.Extra)
+			}
+		}
+		if err != nil {
+			s.t.Fatalf("dnstest: response for %s %s: %v", name, mdns.TypeToString[qtype], err)
+		}
+		parsed = append(parsed, scripted)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[questionKey(name, qtype)] = &script{responses: parsed}
+}
+
+// HandleFunc sets a handler for queries that have no script, replacing the
+// default REFUSED answer
+func (s *Server) HandleFunc(handler func(w mdns.ResponseWriter, r *mdns.Msg)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = handler
+}
+
+// Queries returns the queries received so far, in order
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// QueryCount returns how many queries for name and qtype were received
+func (s *Server) QueryCount(name string, qtype uint16) int {
+	key := questionKey(name, qtype)
+	count := 0
+	for _, query := range s.Queries() {
+		if questionKey(query.Name, query.Type) == key {
+			count++
+		}
+	}
+	return count
+}
+
+// Reset forgets the recorded queries and restarts every script from its
+// first response
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = nil
+	for _, script := range s.scripts {
+		script.next = 0
+	}
+}
+
+// AssertQueried fails the test unless a query for name and qtype was received
+func (s *Server) AssertQueried(t testing.TB, name string, qtype uint16) {
+	t.Helper()
+	if s.QueryCount(name, qtype) == 0 {
+		t.Errorf("dnstest: expected a query for %s %s, got %s", name, mdns.TypeToString[qtype], s.describeQueries())
+	}
+}
+
+// AssertNotQueried fails the test if a query for name and qtype was received
+func (s *Server) AssertNotQueried(t testing.TB, name string, qtype uint16) {
+	t.Helper()
+	if count := s.QueryCount(name, qtype); count > 0 {
+		t.Errorf("dnstest: expected no query for %s %s, got %d", name, mdns.TypeToString[qtype], count)
+	}
+}
+
+// AssertQueryCount fails the test unless exactly count queries for name and
+// qtype were received
+func (s *Server) AssertQueryCount(t testing.TB, name string, qtype uint16, count int) {
+	t.Helper()
+	if got := s.QueryCount(name, qtype); got != count {
+		t.Errorf("dnstest: expected %d queries for %s %s, got %d: %s", count, name, mdns.TypeToString[qtype], got, s.describeQueries())
+	}
+}
+
+// AssertNetworks fails the test unless the queries arrived over these
+// networks ("udp" or "tcp"), in order
+func (s *Server) AssertNetworks(t testing.TB, networks ...string) {
+	t.Helper()
+	var got []string
+	for _, query := range s.Queries() {
+		got = append(got, query.Network)
+	}
+	if strings.Join(got, ",") != strings.Join(networks, ",") {
+		t.Errorf("dnstest: expected queries over %v, got %v", networks, got)
+	}
+}
+
+// describeQueries lists the recorded queries for failure messages
+func (s *Server) describeQueries() string {
+	var described []string
+	for _, query := range s.Queries() {
+		described = append(described, fmt.Sprintf("%s %s/%s", query.Name, mdns.TypeToString[query.Type], query.Network))
+	}
+	if len(described) == 0 {
+		return "no queries"
+	}
+	return strings.Join(described, ", ")
+}
+
+// handler returns the handler for queries arriving over network
+func (s *Server) handler(network string) mdns.HandlerFunc {
+	return func(w mdns.ResponseWriter, r *mdns.Msg) {
+		if len(r.Question) != 1 {
+			reply := new(mdns.Msg)
+			reply.SetRcode(r, mdns.RcodeFormatError)
+			w.WriteMsg(reply)
+			return
+		}
+		question := r.Question[0]
+
+		s.mu.Lock()
+		s.queries = append(s.queries, Query{Name: question.Name, Type: question.Qtype, Network: network, Msg: r.Copy()})
+		response, scripted := s.nextResponse(question.Name, question.Qtype)
+		fallback := s.fallback
+		s.mu.Unlock()
+
+		if !scripted {
+			if fallback != nil {
+				fallback(w, r)
+				return
+			}
+			reply := new(mdns.Msg)
+			reply.SetRcode(r, mdns.RcodeRefused)
+			w.WriteMsg(reply)
+			return
+		}
+
+		if response.Delay > 0 {
+			time.Sleep(response.Delay)
+		}
+		if response.Drop {
+			return
+		}
+
+		reply := response.reply(r, network)
+		if response.Malformed {
+			packed, err := reply.Pack()
+			if err != nil {
+				return
+			}
+			// The header announces a question, but only the start of its name follows
+			w.Write(packed[:14])
+			return
+		}
+		// Like a real server, fit UDP replies into the size the client accepts
+		if network == "udp" {
+			size := mdns.MinMsgSize
+			if opt := r.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+				size = int(opt.UDPSize())
+			}
+			reply.Truncate(size)
+		}
+		w.WriteMsg(reply)
+	}
+}
+
+// nextResponse returns the scripted response for a question and advances its
+// script. The caller holds s.mu.
+func (s *Server) nextResponse(name string, qtype uint16) (scriptedResponse, bool) {
+	script, ok := s.scripts[questionKey(name, qtype)]
+	if !ok {
+		return scriptedResponse{}, false
+	}
+	response := script.responses[script.next]
+	if script.next < len(script.responses)-1 {
+		script.next++
+	}
+	return response, true
+}
+
+// reply builds the reply to r described by the response
+func (response scriptedResponse) reply(r *mdns.Msg, network string) *mdns.Msg {
+	reply := new(mdns.Msg)
+	reply.SetReply(r)
+	reply.Rcode = response.Rcode
+	reply.Authoritative = response.Authoritative
+	if opt := r.IsEdns0(); opt != nil {
+		reply.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+
+	if response.Truncated || (response.TCPOnly && network == "udp") {
+		reply.Truncated = true
+	} else {
+		// Copies keep Modify from changing the script
+		reply.Answer = copyRecords(response.answer)
+		reply.Ns = copyRecords(response.ns)
+		reply.Extra = append(reply.Extra, copyRecords(response.extra)...)
+	}
+
+	if response.Modify != nil {
+		response.Modify(reply)
+	}
+	return reply
+}
+
+// copyRecords returns deep copies of rrs
+func copyRecords(rrs []mdns.RR) []mdns.RR {
+	var copied []mdns.RR
+	for _, rr := range rrs {
+		copied = append(copied, mdns.Copy(rr))
+	}
+	return copied
+}
+
+// parseRecords parses records in zone file format
+func parseRecords(texts []string) ([]mdns.RR, error) {
+	var rrs []mdns.RR
+	for _, text := range texts {
+		rr, err := mdns.NewRR(text)
+		if err != nil {
+			return nil, err
+		}
+		if rr == nil {
+			return nil, fmt.Errorf("no record in %q", text)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// questionKey identifies a question independently of the case of the name
+func questionKey(name string, qtype uint16) string {
+	return strings.ToLower(mdns.Fqdn(name)) + " " + mdns.TypeToString[qtype]
+}
@@ -0,0 +1,221 @@
+package dnstest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// exchange sends a query for name and qtype to server over network
+func exchange(t *testing.T, server *Server, network, name string, qtype uint16) (*mdns.Msg, time.Duration, error) {
+	t.Helper()
+	query := new(mdns.Msg)
+	query.SetQuestion(name, qtype)
+	client := &mdns.Client{Net: network, Timeout: 300 * time.Millisecond}
+	return client.Exchange(query, server.Addr())
+}
+
+// recordingT captures the failures reported by assertions
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestServer_Script(t *testing.T) {
+	server := NewServer(t)
+	server.Handle("WWW.Example.com", mdns.TypeA,
+		Response{Drop: true},
+		Response{Authoritative: true, Answer: []string{"www.example.com. 300 IN A 192.0.2.1"}},
+	)
+
+	if _, _, err := exchange(t, server, "udp", "www.example.com.", mdns.TypeA); err == nil {
+		t.Fatal("Expected the first query to time out")
+	}
+
+	// The last response repeats
+	for i := 0; i < 2; i++ {
+		response, _, err := exchange(t, server, "udp", "www.example.com.", mdns.TypeA)
+		if err != nil {
+			t.Fatalf("Query %d error = %v", i+2, err)
+		}
+		if !response.Authoritative || len(response.Answer) != 1 || response.Answer[0].(*mdns.A).A.String() != "192.0.2.1" {
+			t.Errorf("Unexpected response:\n%v", response)
+		}
+	}
+
+	server.AssertQueryCount(t, "www.example.com.", mdns.TypeA, 3)
+	server.AssertQueried(t, "www.example.com", mdns.TypeA)
+	server.AssertNotQueried(t, "www.example.com.", mdns.TypeAAAA)
+	server.AssertNetworks(t, "udp", "udp", "udp")
+
+	// Reset starts the script over
+	server.Reset()
+	if len(server.Queries()) != 0 {
+		t.Errorf("Expected Reset to clear the queries, got %v", server.Queries())
+	}
+	if _, _, err := exchange(t, server, "udp", "www.example.com.", mdns.TypeA); err == nil {
+		t.Error("Expected the script to start over with the dropped reply")
+	}
+}
+
+func TestServer_FailureModes(t *testing.T) {
+	server := NewServer(t)
+	server.Handle("missing.example.com.", mdns.TypeA, Response{
+		Rcode:         mdns.RcodeNameError,
+		Authoritative: true,
+		Ns:            []string{"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"},
+	})
+	server.Handle("truncated.example.com.", mdns.TypeTXT, Response{Truncated: true, Answer: []string{`truncated.example.com. 300 IN TXT "lost"`}})
+	server.Handle("big.example.com.", mdns.TypeTXT, Response{TCPOnly: true, Answer: []string{`big.example.com. 300 IN TXT "only over TCP"`}})
+	server.Handle("broken.example.com.", mdns.TypeA, Response{Malformed: true, Answer: []string{"broken.example.com. 300 IN A 192.0.2.1"}})
+	server.Handle("slow.example.com.", mdns.TypeA, Response{Delay: 100 * time.Millisecond, Answer: []string{"slow.example.com. 300 IN A 192.0.2.1"}})
+
+	response, _, err := exchange(t, server, "udp", "missing.example.com.", mdns.TypeA)
+	if err != nil || response.Rcode != mdns.RcodeNameError || len(response.Ns) != 1 {
+		t.Errorf("Expected NXDOMAIN with an SOA, got %v (error %v)", response, err)
+	}
+
+	response, _, err = exchange(t, server, "tcp", "truncated.example.com.", mdns.TypeTXT)
+	if err != nil || !response.Truncated || len(response.Answer) != 0 {
+		t.Errorf("Expected a truncated reply without records, got %v (error %v)", response, err)
+	}
+
+	server.Reset()
+	response, _, err = exchange(t, server, "udp", "big.example.com.", mdns.TypeTXT)
+	if err != nil || !response.Truncated || len(response.Answer) != 0 {
+		t.Errorf("Expected a truncated reply over UDP, got %v (error %v)", response, err)
+	}
+	response, _, err = exchange(t, server, "tcp", "big.example.com.", mdns.TypeTXT)
+	if err != nil || response.Truncated || len(response.Answer) != 1 {
+		t.Errorf("Expected the full reply over TCP, got %v (error %v)", response, err)
+	}
+	server.AssertNetworks(t, "udp", "tcp")
+
+	if _, _, err := exchange(t, server, "udp", "broken.example.com.", mdns.TypeA); err == nil {
+		t.Error("Expected a malformed reply to fail to parse")
+	}
+
+	if _, rtt, err := exchange(t, server, "udp", "slow.example.com.", mdns.TypeA); err != nil || rtt < 100*time.Millisecond {
+		t.Errorf("Expected a delayed reply, got rtt %v (error %v)", rtt, err)
+	}
+}
+
+func TestServer_UDPSize(t *testing.T) {
+	var answers []string
+	for i := 0; i < 20; i++ {
+		answers = append(answers, fmt.Sprintf(`big.example.com. 300 IN TXT "%02d %s"`, i, strings.Repeat("x", 60)))
+	}
+
+	server := NewServer(t)
+	server.Handle("big.example.com.", mdns.TypeTXT, Response{Answer: answers})
+
+	response, _, err := exchange(t, server, "udp", "big.example.com.", mdns.TypeTXT)
+	if err != nil || !response.Truncated || len(response.Answer) >= len(answers) {
+		t.Errorf("Expected a reply truncated to 512 bytes, got %v (error %v)", response, err)
+	}
+
+	query := new(mdns.Msg)
+	query.SetQuestion("big.example.com.", mdns.TypeTXT)
+	query.SetEdns0(4096, false)
+	response, _, err = (&mdns.Client{Net: "udp"}).Exchange(query, server.Addr())
+	if err != nil || response.Truncated || len(response.Answer) != len(answers) {
+		t.Errorf("Expected the full reply with a 4096-byte EDNS buffer, got TC=%v (error %v)", response != nil && response.Truncated, err)
+	}
+}
+
+func TestServer_UnscriptedAndModify(t *testing.T) {
+	server := NewServer(t)
+
+	response, _, err := exchange(t, server, "udp", "example.com.", mdns.TypeA)
+	if err != nil || response.Rcode != mdns.RcodeRefused {
+		t.Errorf("Expected REFUSED without a script, got %v (error %v)", response, err)
+	}
+
+	server.HandleFunc(func(w mdns.ResponseWriter, r *mdns.Msg) {
+		reply := new(mdns.Msg)
+		reply.SetRcode(r, mdns.RcodeServerFailure)
+		w.WriteMsg(reply)
+	})
+	response, _, err = exchange(t, server, "udp", "example.com.", mdns.TypeA)
+	if err != nil || response.Rcode != mdns.RcodeServerFailure {
+		t.Errorf("Expected the fallback handler's SERVFAIL, got %v (error %v)", response, err)
+	}
+
+	server.Handle("example.com.", mdns.TypeA, Response{
+		Rcode: mdns.RcodeServerFailure,
+		Modify: func(reply *mdns.Msg) {
+			reply.SetEdns0(1232, false)
+			opt := reply.IsEdns0()
+			opt.Option = append(opt.Option, &mdns.EDNS0_EDE{InfoCode: mdns.ExtendedErrorCodeDNSBogus, ExtraText: "signature expired"})
+		},
+	})
+	response, _, err = exchange(t, server, "udp", "example.com.", mdns.TypeA)
+	if err != nil || response.IsEdns0() == nil || len(response.IsEdns0().Option) != 1 {
+		t.Errorf("Expected Modify to add an Extended DNS Error, got %v (error %v)", response, err)
+	}
+	if queries := server.Queries(); len(queries) != 3 || queries[0].Msg == nil || queries[0].Network != "udp" {
+		t.Errorf("Expected three recorded queries with their messages, got %+v", queries)
+	}
+}
+
+func TestServer_Assertions(t *testing.T) {
+	server := NewServer(t)
+	server.Handle("www.example.com.", mdns.TypeA, Response{Answer: []string{"www.example.com. 300 IN A 192.0.2.1"}})
+	if _, _, err := exchange(t, server, "tcp", "www.example.com.", mdns.TypeA); err != nil {
+		t.Fatalf("Query error = %v", err)
+	}
+
+	recorder := &recordingT{TB: t}
+	server.AssertQueried(recorder, "mail.example.com.", mdns.TypeMX)
+	server.AssertNotQueried(recorder, "www.example.com.", mdns.TypeA)
+	server.AssertQueryCount(recorder, "www.example.com.", mdns.TypeA, 2)
+	server.AssertNetworks(recorder, "udp")
+
+	expected := []string{
+		"expected a query for mail.example.com. MX, got www.example.com. A/tcp",
+		"expected no query for www.example.com. A, got 1",
+		"expected 2 queries for www.example.com. A, got 1",
+		"expected queries over [udp], got [tcp]",
+	}
+	if len(recorder.failures) != len(expected) {
+		t.Fatalf("Expected %d failures, got %v", len(expected), recorder.failures)
+	}
+	for i, failure := range recorder.failures {
+		if !strings.Contains(failure, expected[i]) {
+			t.Errorf("Failure %d = %q, want it to contain %q", i, failure, expected[i])
+		}
+	}
+}
+
+func TestServer_TCPIdleTimeout(t *testing.T) {
+	server := NewServerWithOptions(t, Options{TCPIdleTimeout: 100 * time.Millisecond})
+	server.Handle("www.example.com.", mdns.TypeA, Response{Answer: []string{"www.example.com. 300 IN A 192.0.2.1"}})
+
+	client := &mdns.Client{Net: "tcp", Timeout: 300 * time.Millisecond}
+	conn, err := client.Dial(server.Addr())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	query := new(mdns.Msg)
+	query.SetQuestion("www.example.com.", mdns.TypeA)
+	if _, _, err := client.ExchangeWithConn(query, conn); err != nil {
+		t.Fatalf("Exchange on a new connection error = %v", err)
+	}
+
+	// The server closes the connection once it has been idle too long
+	time.Sleep(300 * time.Millisecond)
+	if _, _, err := client.ExchangeWithConn(query, conn); err == nil {
+		t.Error("Expected the idle connection to be closed by the server")
+	}
+}
@@ -1,9 +1,17 @@
 package errors
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	stderrors "errors"
 	"fmt"
 	"net"
+	"os"
 	"strings"
+	"syscall"
+
+	"go-dig/pkg/idn"
 )
 
 // ErrorType represents different categories of errors
@@ -32,13 +40,130 @@ func (et ErrorType) String() string {
 	}
 }
 
+// Kind identifies the precise cause of an error. Every Kind is also a sentinel
+// error, so callers can branch on it with errors.Is, even through wrapping:
+//
+//	if errors.Is(err, errors.ErrNXDomain) { ... }
+//
+// The category sentinels ErrInput, ErrNetwork, ErrDNS and ErrSystem match any
+// DigError of that type, whatever its Kind.
+type Kind struct {
+	name     string
+	errType  ErrorType
+	category bool
+}
+
+// Error returns the name of the kind
+func (k *Kind) Error() string {
+	return k.name
+}
+
+// Type returns the error category the kind belongs to
+func (k *Kind) Type() ErrorType {
+	return k.errType
+}
+
+// Category sentinels, matching every DigError of the given type
+var (
+	ErrInput   = &Kind{name: "input error", errType: ErrorTypeInput, category: true}
+	ErrNetwork = &Kind{name: "network error", errType: ErrorTypeNetwork, category: true}
+	ErrDNS     = &Kind{name: "DNS error", errType: ErrorTypeDNS, category: true}
+	ErrSystem  = &Kind{name: "system error", errType: ErrorTypeSystem, category: true}
+)
+
+// DNS response kinds
+var (
+	ErrNXDomain       = &Kind{name: "NXDOMAIN", errType: ErrorTypeDNS}
+	ErrServFail       = &Kind{name: "SERVFAIL", errType: ErrorTypeDNS}
+	ErrRefused        = &Kind{name: "REFUSED", errType: ErrorTypeDNS}
+	ErrNotImplemented = &Kind{name: "NOTIMP", errType: ErrorTypeDNS}
+	ErrFormatError    = &Kind{name: "FORMERR", errType: ErrorTypeDNS}
+	ErrBadCookie      = &Kind{name: "BADCOOKIE", errType: ErrorTypeDNS}
+	ErrTruncated      = &Kind{name: "truncated response", errType: ErrorTypeDNS}
+	ErrCNAMELoop      = &Kind{name: "CNAME loop", errType: ErrorTypeDNS}
+	ErrChainTooLong   = &Kind{name: "CNAME chain too long", errType: ErrorTypeDNS}
+	ErrEmptyAnswer    = &Kind{name: "empty answer", errType: ErrorTypeDNS}
+)
+
+// Network failure kinds
+var (
+	ErrTimeout        = &Kind{name: "timeout", errType: ErrorTypeNetwork}
+	ErrConnRefused    = &Kind{name: "connection refused", errType: ErrorTypeNetwork}
+	ErrNetUnreachable = &Kind{name: "network unreachable", errType: ErrorTypeNetwork}
+	ErrPermission     = &Kind{name: "permission denied", errType: ErrorTypeNetwork}
+	ErrNoResponse     = &Kind{name: "no response", errType: ErrorTypeNetwork}
+	ErrTLSFailure     = &Kind{name: "TLS failure", errType: ErrorTypeNetwork}
+	ErrNoSuchHost     = &Kind{name: "no such host", errType: ErrorTypeNetwork}
+)
+
+// ExtendedError is an Extended DNS Error (RFC 8914) attached to a response,
+// explaining for example why a resolver answered SERVFAIL
+type ExtendedError struct {
+	Code uint16
+	Text string // Optional extra text supplied by the server
+}
+
+// extendedErrorNames holds the names registered with IANA for EDE info codes
+var extendedErrorNames = map[uint16]string{
+	0:  "Other Error",
+	1:  "Unsupported DNSKEY Algorithm",
+	2:  "Unsupported DS Digest Type",
+	3:  "Stale Answer",
+	4:  "Forged Answer",
+	5:  "DNSSEC Indeterminate",
+	6:  "DNSSEC Bogus",
+	7:  "Signature Expired",
+	8:  "Signature Not Yet Valid",
+	9:  "DNSKEY Missing",
+	10: "RRSIGs Missing",
+	11: "No Zone Key Bit Set",
+	12: "NSEC Missing",
+	13: "Cached Error",
+	14: "Not Ready",
+	15: "Blocked",
+	16: "Censored",
+	17: "Filtered",
+	18: "Prohibited",
+	19: "Stale NXDOMAIN Answer",
+	20: "Not Authoritative",
+	21: "Not Supported",
+	22: "No Reachable Authority",
+	23: "Network Error",
+	24: "Invalid Data",
+	25: "Signature Expired before Valid",
+	26: "Too Early",
+	27: "Unsupported NSEC3 Iterations Value",
+	28: "Unable to conform to policy",
+	29: "Synthesized",
+}
+
+// Name returns the registered name of the info code, or "Unknown" for
+// unassigned codes
+func (e ExtendedError) Name() string {
+	if name, ok := extendedErrorNames[e.Code]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// String returns the code, its name and any extra text, e.g.
+// "EDE 6 (DNSSEC Bogus): signature invalid"
+func (e ExtendedError) String() string {
+	if e.Text == "" {
+		return fmt.Sprintf("EDE %d (%s)", e.Code, e.Name())
+	}
+	return fmt.Sprintf("EDE %d (%s): %s", e.Code, e.Name(), e.Text)
+}
+
 // DigError represents a structured error with type and context
 type DigError struct {
-	Type    ErrorType
-	Message string
-	Cause   error
-	Domain  string
-	Server  string
+	Type           ErrorType
+	Kind           *Kind // Precise cause; nil when only the type is known
+	Message        string
+	Cause          error
+	Domain         string
+	Server         string
+	ExtendedErrors []ExtendedError // Extended DNS Errors sent with the failing response
 }
 
 // Error implements the error interface
@@ -54,6 +179,33 @@ func (de *DigError) Unwrap() error {
 	return de.Cause
 }
 
+// Is reports whether the error matches target, which is either the error's
+// own Kind or the category sentinel for its Type
+func (de *DigError) Is(target error) bool {
+	kind, ok := target.(*Kind)
+	if !ok {
+		return false
+	}
+	if kind.category {
+		return de.Type == kind.errType
+	}
+	return de.Kind == kind
+}
+
+// WithKind sets the precise cause of the error and returns it, so it can be
+// chained onto a constructor
+func (de *DigError) WithKind(kind *Kind) *DigError {
+	de.Kind = kind
+	return de
+}
+
+// WithExtendedErrors attaches the Extended DNS Errors of the failing response
+// and returns the error, so it can be chained onto a constructor
+func (de *DigError) WithExtendedErrors(extended []ExtendedError) *DigError {
+	de.ExtendedErrors = extended
+	return de
+}
+
 // NewInputError creates a new input validation error
 func NewInputError(message string, cause error) *DigError {
 	return &DigError{
@@ -93,61 +245,142 @@ func NewSystemError(message string, cause error) *DigError {
 	}
 }
 
-// IsInputError checks if the error is an input validation error
-func IsInputError(err error) bool {
-	if digErr, ok := err.(*DigError); ok {
-		return digErr.Type == ErrorTypeInput
+// Is reports whether any error in err's chain matches target. It is the
+// standard library errors.Is, re-exported for callers importing this package.
+func Is(err, target error) bool {
+	return stderrors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target. It is the
+// standard library errors.As, re-exported for callers importing this package.
+func As(err error, target interface{}) bool {
+	return stderrors.As(err, target)
+}
+
+// AsDigError returns the first DigError in err's chain, if any
+func AsDigError(err error) (*DigError, bool) {
+	var digErr *DigError
+	if stderrors.As(err, &digErr) {
+		return digErr, true
 	}
-	return false
+	return nil, false
 }
 
-// IsNetworkError checks if the error is a network-related error
-func IsNetworkError(err error) bool {
-	if digErr, ok := err.(*DigError); ok {
-		return digErr.Type == ErrorTypeNetwork
+// Message returns the short message of the first DigError in err's chain,
+// without its type and cause, or the full error text of any other error.
+// Reports that list several errors use it to keep each entry brief.
+func Message(err error) string {
+	if digErr, ok := AsDigError(err); ok {
+		return digErr.Message
 	}
-	return false
+	return err.Error()
 }
 
-// IsDNSError checks if the error is a DNS-related error
-func IsDNSError(err error) bool {
-	if digErr, ok := err.(*DigError); ok {
-		return digErr.Type == ErrorTypeDNS
+// KindOf returns the Kind of the first DigError in err's chain, or nil
+func KindOf(err error) *Kind {
+	if digErr, ok := AsDigError(err); ok {
+		return digErr.Kind
 	}
-	return false
+	return nil
+}
+
+// IsInputError checks if the error, or any error it wraps, is an input validation error
+func IsInputError(err error) bool {
+	return stderrors.Is(err, ErrInput)
+}
+
+// IsNetworkError checks if the error, or any error it wraps, is a network-related error
+func IsNetworkError(err error) bool {
+	return stderrors.Is(err, ErrNetwork)
 }
 
-// IsSystemError checks if the error is a system-level error
+// IsDNSError checks if the error, or any error it wraps, is a DNS-related error
+func IsDNSError(err error) bool {
+	return stderrors.Is(err, ErrDNS)
+}
+
+// IsSystemError checks if the error, or any error it wraps, is a system-level error
 func IsSystemError(err error) bool {
-	if digErr, ok := err.(*DigError); ok {
-		return digErr.Type == ErrorTypeSystem
-	}
-	return false
+	return stderrors.Is(err, ErrSystem)
 }
 
-// ClassifyNetworkError analyzes a network error and returns appropriate DigError
+// Socket error numbers recognised by ClassifyNetworkError. The Windows values
+// (WSAE*) are listed alongside the POSIX ones because the syscall package does
+// not define them all; they never occur on other platforms.
+var (
+	connRefusedErrnos = []syscall.Errno{syscall.ECONNREFUSED, 10061}
+	unreachableErrnos = []syscall.Errno{syscall.ENETUNREACH, syscall.EHOSTUNREACH, 10051, 10065}
+	permissionErrnos  = []syscall.Errno{syscall.EACCES, syscall.EPERM, 10013}
+)
+
+// ClassifyNetworkError inspects a network error and returns the matching
+// DigError. Classification relies on error types and values found anywhere in
+// the chain rather than on message text, so it works across platforms and
+// Go versions.
 func ClassifyNetworkError(err error, server string) *DigError {
 	if err == nil {
 		return nil
 	}
 
-	errStr := strings.ToLower(err.Error())
+	var dnsErr *net.DNSError
+	var errno syscall.Errno
 
-	// Check for common network error patterns
 	switch {
-	case strings.Contains(errStr, "timeout") || strings.Contains(errStr, "deadline exceeded"):
-		return NewNetworkError("DNS server timeout - server may be unreachable or overloaded", err, server)
-	case strings.Contains(errStr, "connection refused"):
-		return NewNetworkError("DNS server refused connection - server may be down or not accepting queries", err, server)
-	case strings.Contains(errStr, "no such host"):
-		return NewNetworkError("DNS server hostname could not be resolved", err, server)
-	case strings.Contains(errStr, "network unreachable"):
-		return NewNetworkError("Network unreachable - check your internet connection", err, server)
-	case strings.Contains(errStr, "permission denied"):
-		return NewNetworkError("Permission denied - may need elevated privileges", err, server)
-	default:
-		return NewNetworkError("Network error occurred while contacting DNS server", err, server)
+	case isTimeout(err):
+		return NewNetworkError("DNS server timeout - server may be unreachable or overloaded", err, server).WithKind(ErrTimeout)
+	case isTLSError(err):
+		return NewNetworkError("TLS handshake with DNS server failed", err, server).WithKind(ErrTLSFailure)
+	case stderrors.As(err, &dnsErr):
+		return NewNetworkError("DNS server hostname could not be resolved", err, server).WithKind(ErrNoSuchHost)
+	case stderrors.As(err, &errno):
+		switch {
+		case errnoIn(errno, connRefusedErrnos):
+			return NewNetworkError("DNS server refused connection - server may be down or not accepting queries", err, server).WithKind(ErrConnRefused)
+		case errnoIn(errno, unreachableErrnos):
+			return NewNetworkError("Network unreachable - check your internet connection", err, server).WithKind(ErrNetUnreachable)
+		case errnoIn(errno, permissionErrnos):
+			return NewNetworkError("Permission denied - may need elevated privileges", err, server).WithKind(ErrPermission)
+		}
 	}
+
+	return NewNetworkError("Network error occurred while contacting DNS server", err, server)
+}
+
+// isTimeout reports whether err is a deadline or timeout anywhere in its chain
+func isTimeout(err error) bool {
+	if stderrors.Is(err, context.DeadlineExceeded) || stderrors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return stderrors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isTLSError reports whether err comes from a TLS handshake or certificate check
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return stderrors.As(err, &recordErr) ||
+		stderrors.As(err, &alertErr) ||
+		stderrors.As(err, &verifyErr) ||
+		stderrors.As(err, &authorityErr) ||
+		stderrors.As(err, &hostnameErr) ||
+		stderrors.As(err, &invalidErr)
+}
+
+// errnoIn reports whether errno is one of the given values
+func errnoIn(errno syscall.Errno, values []syscall.Errno) bool {
+	for _, value := range values {
+		if errno == value {
+			return true
+		}
+	}
+	return false
 }
 
 // ValidateDomain performs comprehensive domain name validation
@@ -156,6 +389,16 @@ func ValidateDomain(domain string) error {
 		return NewInputError("domain name cannot be empty", nil)
 	}
 
+	// Internationalized names are checked in their A-label (wire) form
+	if idn.NeedsConversion(domain) {
+		ascii, err := idn.ToASCII(domain)
+		if err != nil {
+			// The message carries the reason, so it is not repeated as the cause
+			return NewInputError(fmt.Sprintf("invalid internationalized domain name '%s': %s", domain, err.Error()), nil)
+		}
+		domain = ascii
+	}
+
 	// Check domain length (max 253 characters per RFC)
 	if len(domain) > 253 {
 		return NewInputError(fmt.Sprintf("domain name too long (%d characters, max 253)", len(domain)), nil)
@@ -251,16 +494,80 @@ func ValidateRecordType(recordType string) error {
 		"MX":    true,
 		"CNAME": true,
 		"TXT":   true,
+		"NS":    true,
 	}
 
 	recordType = strings.ToUpper(recordType)
 	if !validTypes[recordType] {
-		return NewInputError(fmt.Sprintf("unsupported record type '%s' (supported: A, AAAA, MX, CNAME, TXT)", recordType), nil)
+		return NewInputError(fmt.Sprintf("unsupported record type '%s' (supported: A, AAAA, MX, CNAME, TXT, NS)", recordType), nil)
 	}
 
 	return nil
 }
 
+// ValidateQueryTime validates a query timeout given in whole seconds (+time=)
+func ValidateQueryTime(seconds string) error {
+	_, err := parseBoundedNumber("query time", seconds, 1, 300)
+	return err
+}
+
+// ValidateTries validates the number of attempts per query (+tries=)
+func ValidateTries(tries string) error {
+	_, err := parseBoundedNumber("number of tries", tries, 1, 10)
+	return err
+}
+
+// ValidateRetries validates the number of retries after the first attempt (+retry=)
+func ValidateRetries(retries string) error {
+	_, err := parseBoundedNumber("number of retries", retries, 0, 9)
+	return err
+}
+
+// ValidateAddressFamily checks that server is an address of the family
+// selected with -4 (ipv4Only) or -6 (ipv6Only)
+func ValidateAddressFamily(server string, ipv4Only, ipv6Only bool) error {
+	if ipv4Only && ipv6Only {
+		return NewInputError("options -4 and -6 cannot be used together", nil)
+	}
+
+	ip := net.ParseIP(server)
+	if ip == nil {
+		return nil
+	}
+	if ipv4Only && ip.To4() == nil {
+		return NewInputError(fmt.Sprintf("DNS server '%s' is not an IPv4 address, but -4 was given", server), nil)
+	}
+	if ipv6Only && ip.To4() != nil {
+		return NewInputError(fmt.Sprintf("DNS server '%s' is not an IPv6 address, but -6 was given", server), nil)
+	}
+	return nil
+}
+
+// parseBoundedNumber parses a decimal number between min and max, describing
+// the value as what in errors
+func parseBoundedNumber(what, value string, min, max int) (int, error) {
+	if value == "" {
+		return 0, NewInputError(fmt.Sprintf("%s cannot be empty", what), nil)
+	}
+
+	number := 0
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return 0, NewInputError(fmt.Sprintf("%s '%s' must be numeric", what, value), nil)
+		}
+		number = number*10 + int(r-'0')
+		if number > max {
+			return 0, NewInputError(fmt.Sprintf("%s '%s' is out of range (%d-%d)", what, value, min, max), nil)
+		}
+	}
+
+	if number < min {
+		return 0, NewInputError(fmt.Sprintf("%s '%s' is out of range (%d-%d)", what, value, min, max), nil)
+	}
+
+	return number, nil
+}
+
 // ValidateDNSPort validates DNS server port
 func ValidateDNSPort(port string) error {
 	if port == "" {
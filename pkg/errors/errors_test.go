@@ -1,9 +1,22 @@
 package errors
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"runtime"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestDigError_Error(t *testing.T) {
@@ -54,6 +67,110 @@ func TestDigError_Unwrap(t *testing.T) {
 	}
 }
 
+func TestDigError_Is(t *testing.T) {
+	nxdomain := NewDNSError("domain 'example.com' not found (NXDOMAIN)", nil, "example.com", "8.8.8.8:53").WithKind(ErrNXDomain)
+	wrapped := fmt.Errorf("lookup failed: %w", nxdomain)
+
+	tests := []struct {
+		name     string
+		target   error
+		expected bool
+	}{
+		{"own kind", ErrNXDomain, true},
+		{"other kind", ErrServFail, false},
+		{"own category", ErrDNS, true},
+		{"other category", ErrNetwork, false},
+		{"unrelated error", fmt.Errorf("NXDOMAIN"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Is(nxdomain, tt.target); got != tt.expected {
+				t.Errorf("Is(err, %v) = %v, want %v", tt.target, got, tt.expected)
+			}
+			if got := Is(wrapped, tt.target); got != tt.expected {
+				t.Errorf("Is(wrapped, %v) = %v, want %v", tt.target, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDigError_WrappedHelpers(t *testing.T) {
+	timeout := NewNetworkError("DNS server timeout", nil, "8.8.8.8:53").WithKind(ErrTimeout)
+	wrapped := fmt.Errorf("query 2 of 3: %w", timeout)
+
+	if !IsNetworkError(wrapped) || IsDNSError(wrapped) {
+		t.Error("Expected Is* checkers to see through wrapping")
+	}
+	if KindOf(wrapped) != ErrTimeout {
+		t.Errorf("KindOf() = %v, want %v", KindOf(wrapped), ErrTimeout)
+	}
+	if KindOf(fmt.Errorf("plain")) != nil || KindOf(NewInputError("bad", nil)) != nil {
+		t.Error("Expected KindOf() to be nil without a kind")
+	}
+
+	digErr, ok := AsDigError(wrapped)
+	if !ok || digErr != timeout {
+		t.Errorf("AsDigError() = %v, %v; want the wrapped DigError", digErr, ok)
+	}
+
+	if Message(wrapped) != "DNS server timeout" || Message(fmt.Errorf("plain")) != "plain" {
+		t.Errorf("Message() = %q, want the DigError message or the error text", Message(wrapped))
+	}
+
+	var target *DigError
+	if !As(wrapped, &target) || target.Server != "8.8.8.8:53" {
+		t.Errorf("As() did not find the DigError, got %v", target)
+	}
+
+	// Causes are part of the chain as well
+	cause := fmt.Errorf("read udp: %w", ErrTruncated)
+	if !Is(NewNetworkError("failed", cause, ""), ErrTruncated) {
+		t.Error("Expected Is() to match a kind found in the cause chain")
+	}
+}
+
+func TestKind(t *testing.T) {
+	if ErrNXDomain.Error() != "NXDOMAIN" || ErrNXDomain.Type() != ErrorTypeDNS {
+		t.Errorf("Unexpected kind %q of type %v", ErrNXDomain.Error(), ErrNXDomain.Type())
+	}
+	if ErrTimeout.Type() != ErrorTypeNetwork || ErrInput.Type() != ErrorTypeInput {
+		t.Error("Unexpected kind types")
+	}
+}
+
+func TestExtendedError(t *testing.T) {
+	tests := []struct {
+		extended     ExtendedError
+		expectedName string
+		expectedText string
+	}{
+		{ExtendedError{Code: 6, Text: "signature invalid"}, "DNSSEC Bogus", "EDE 6 (DNSSEC Bogus): signature invalid"},
+		{ExtendedError{Code: 3}, "Stale Answer", "EDE 3 (Stale Answer)"},
+		{ExtendedError{Code: 0}, "Other Error", "EDE 0 (Other Error)"},
+		{ExtendedError{Code: 4000, Text: "private use"}, "Unknown", "EDE 4000 (Unknown): private use"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedText, func(t *testing.T) {
+			if name := tt.extended.Name(); name != tt.expectedName {
+				t.Errorf("Name() = %q, want %q", name, tt.expectedName)
+			}
+			if text := tt.extended.String(); text != tt.expectedText {
+				t.Errorf("String() = %q, want %q", text, tt.expectedText)
+			}
+		})
+	}
+
+	err := NewDNSError("DNS server experienced an internal failure", nil, "example.com", "8.8.8.8:53").
+		WithKind(ErrServFail).
+		WithExtendedErrors([]ExtendedError{{Code: 22}})
+	digErr, ok := AsDigError(fmt.Errorf("query: %w", err))
+	if !ok || len(digErr.ExtendedErrors) != 1 || digErr.ExtendedErrors[0].Code != 22 {
+		t.Errorf("Expected extended errors to survive wrapping, got %+v", digErr)
+	}
+}
+
 func TestErrorTypeCheckers(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -124,47 +241,96 @@ func TestErrorTypeCheckers(t *testing.T) {
 }
 
 func TestClassifyNetworkError(t *testing.T) {
+	opError := func(op string, errno syscall.Errno) error {
+		return &net.OpError{Op: op, Net: "udp", Err: &os.SyscallError{Syscall: op, Err: errno}}
+	}
+
 	tests := []struct {
 		name           string
 		err            error
 		server         string
 		expectedType   ErrorType
 		expectedSubstr string
+		expectedKind   *Kind
 	}{
 		{
-			name:           "timeout error",
-			err:            fmt.Errorf("context deadline exceeded"),
+			name:           "context deadline",
+			err:            context.DeadlineExceeded,
 			server:         "8.8.8.8:53",
 			expectedType:   ErrorTypeNetwork,
 			expectedSubstr: "timeout",
+			expectedKind:   ErrTimeout,
+		},
+		{
+			name:           "socket read deadline",
+			err:            &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded},
+			server:         "8.8.8.8:53",
+			expectedType:   ErrorTypeNetwork,
+			expectedSubstr: "timeout",
+			expectedKind:   ErrTimeout,
 		},
 		{
 			name:           "connection refused",
-			err:            fmt.Errorf("connection refused"),
+			err:            opError("connect", syscall.ECONNREFUSED),
+			server:         "192.168.1.1:53",
+			expectedType:   ErrorTypeNetwork,
+			expectedSubstr: "refused connection",
+			expectedKind:   ErrConnRefused,
+		},
+		{
+			name:           "connection refused on Windows",
+			err:            opError("connect", syscall.Errno(10061)),
 			server:         "192.168.1.1:53",
 			expectedType:   ErrorTypeNetwork,
 			expectedSubstr: "refused connection",
+			expectedKind:   ErrConnRefused,
 		},
 		{
 			name:           "no such host",
-			err:            fmt.Errorf("no such host"),
+			err:            &net.DNSError{Err: "no such host", Name: "invalid.dns.server", IsNotFound: true},
 			server:         "invalid.dns.server",
 			expectedType:   ErrorTypeNetwork,
 			expectedSubstr: "could not be resolved",
+			expectedKind:   ErrNoSuchHost,
 		},
 		{
 			name:           "network unreachable",
-			err:            fmt.Errorf("network unreachable"),
+			err:            opError("connect", syscall.ENETUNREACH),
+			server:         "10.0.0.1:53",
+			expectedType:   ErrorTypeNetwork,
+			expectedSubstr: "Network unreachable",
+			expectedKind:   ErrNetUnreachable,
+		},
+		{
+			name:           "host unreachable",
+			err:            opError("connect", syscall.EHOSTUNREACH),
 			server:         "10.0.0.1:53",
 			expectedType:   ErrorTypeNetwork,
 			expectedSubstr: "Network unreachable",
+			expectedKind:   ErrNetUnreachable,
 		},
 		{
 			name:           "permission denied",
-			err:            fmt.Errorf("permission denied"),
+			err:            opError("bind", syscall.EACCES),
 			server:         "8.8.8.8:53",
 			expectedType:   ErrorTypeNetwork,
 			expectedSubstr: "Permission denied",
+			expectedKind:   ErrPermission,
+		},
+		{
+			name:           "TLS certificate from unknown authority",
+			err:            fmt.Errorf("handshake: %w", x509.UnknownAuthorityError{}),
+			server:         "1.1.1.1:853",
+			expectedType:   ErrorTypeNetwork,
+			expectedSubstr: "TLS handshake",
+			expectedKind:   ErrTLSFailure,
+		},
+		{
+			name:           "message text alone is not classified",
+			err:            fmt.Errorf("connection refused"),
+			server:         "8.8.8.8:53",
+			expectedType:   ErrorTypeNetwork,
+			expectedSubstr: "Network error occurred",
 		},
 		{
 			name:           "generic network error",
@@ -209,6 +375,10 @@ func TestClassifyNetworkError(t *testing.T) {
 				t.Errorf("ClassifyNetworkError().Message = %q, want to contain %q", result.Message, tt.expectedSubstr)
 			}
 
+			if result.Kind != tt.expectedKind {
+				t.Errorf("ClassifyNetworkError().Kind = %v, want %v", result.Kind, tt.expectedKind)
+			}
+
 			if result.Cause != tt.err {
 				t.Errorf("ClassifyNetworkError().Cause = %v, want %v", result.Cause, tt.err)
 			}
@@ -216,6 +386,142 @@ func TestClassifyNetworkError(t *testing.T) {
 	}
 }
 
+// selfSignedCertificate returns a TLS certificate for "localhost" that no client trusts
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveOnce accepts a single connection on listener and hands it to handle
+func serveOnce(t *testing.T, listener net.Listener, handle func(net.Conn)) {
+	t.Helper()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+}
+
+func TestClassifyNetworkError_RealSockets(t *testing.T) {
+	t.Run("read timeout", func(t *testing.T) {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer listener.Close()
+
+		conn, err := net.Dial("udp", listener.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 512))
+
+		if kind := ClassifyNetworkError(err, "127.0.0.1:53").Kind; kind != ErrTimeout {
+			t.Errorf("Kind = %v, want %v (error: %v)", kind, ErrTimeout, err)
+		}
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		address := listener.Addr().String()
+		listener.Close()
+
+		_, err = net.DialTimeout("tcp", address, time.Second)
+
+		if kind := ClassifyNetworkError(err, address).Kind; kind != ErrConnRefused {
+			t.Errorf("Kind = %v, want %v (error: %v)", kind, ErrConnRefused, err)
+		}
+	})
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}})
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer listener.Close()
+		serveOnce(t, listener, func(conn net.Conn) {
+			conn.(*tls.Conn).Handshake()
+		})
+
+		_, err = tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "localhost"})
+
+		if kind := ClassifyNetworkError(err, listener.Addr().String()).Kind; kind != ErrTLSFailure {
+			t.Errorf("Kind = %v, want %v (error: %v)", kind, ErrTLSFailure, err)
+		}
+	})
+
+	t.Run("server does not speak TLS", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer listener.Close()
+		serveOnce(t, listener, func(conn net.Conn) {
+			conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+		})
+
+		_, err = tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "localhost"})
+
+		if kind := ClassifyNetworkError(err, listener.Addr().String()).Kind; kind != ErrTLSFailure {
+			t.Errorf("Kind = %v, want %v (error: %v)", kind, ErrTLSFailure, err)
+		}
+	})
+
+	t.Run("network unreachable", func(t *testing.T) {
+		// Connecting a UDP socket only needs a route; most test hosts have none
+		// for the IPv6 documentation prefix
+		conn, err := net.Dial("udp", "[2001:db8::1]:53")
+		if err == nil {
+			conn.Close()
+			t.Skip("host has a route to 2001:db8::/32")
+		}
+
+		if kind := ClassifyNetworkError(err, "[2001:db8::1]:53").Kind; kind != ErrNetUnreachable {
+			t.Errorf("Kind = %v, want %v (error: %v)", kind, ErrNetUnreachable, err)
+		}
+	})
+
+	t.Run("permission denied", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("binding a privileged port is only refused for unprivileged users on Unix")
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:53")
+		if err == nil {
+			listener.Close()
+			t.Skip("privileged ports are not restricted on this host")
+		}
+
+		if kind := ClassifyNetworkError(err, "127.0.0.1:53").Kind; kind != ErrPermission {
+			t.Errorf("Kind = %v, want %v (error: %v)", kind, ErrPermission, err)
+		}
+	})
+}
+
 func TestValidateDomain(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -238,6 +544,16 @@ func TestValidateDomain(t *testing.T) {
 			domain:    "test-site.example-domain.co.uk",
 			wantError: false,
 		},
+		{
+			name:      "valid internationalized domain",
+			domain:    "münchen.de",
+			wantError: false,
+		},
+		{
+			name:      "valid A-label",
+			domain:    "xn--mnchen-3ya.de",
+			wantError: false,
+		},
 		{
 			name:      "empty domain",
 			domain:    "",
@@ -310,6 +626,24 @@ func TestValidateDomain(t *testing.T) {
 			wantError: true,
 			errorMsg:  "cannot be all numeric",
 		},
+		{
+			name:      "disallowed symbol in IDN",
+			domain:    "☃.net",
+			wantError: true,
+			errorMsg:  "invalid internationalized domain name '☃.net': label '☃' contains U+2603",
+		},
+		{
+			name:      "malformed A-label",
+			domain:    "xn--zz.de",
+			wantError: true,
+			errorMsg:  "label 'xn--zz' is not a valid A-label",
+		},
+		{
+			name:      "IDN label too long once encoded",
+			domain:    strings.Repeat("a", 60) + "ü.de",
+			wantError: true,
+			errorMsg:  "too long",
+		},
 	}
 
 	for _, tt := range tests {
@@ -338,6 +672,16 @@ func TestValidateDomain(t *testing.T) {
 	}
 }
 
+func TestValidateDomain_IDNReasonOnce(t *testing.T) {
+	err := ValidateDomain("☃.net")
+	if err == nil {
+		t.Fatal("ValidateDomain() = nil, want error")
+	}
+	if count := strings.Count(err.Error(), "contains U+2603"); count != 1 {
+		t.Errorf("Expected the reason once, found %d times in %q", count, err.Error())
+	}
+}
+
 func TestValidateDNSServer(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -449,6 +793,80 @@ func TestValidateDNSPort(t *testing.T) {
 	}
 }
 
+func TestValidateQueryOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		validate    func(string) error
+		value       string
+		expectError string
+	}{
+		{"time 1", ValidateQueryTime, "1", ""},
+		{"time 300", ValidateQueryTime, "300", ""},
+		{"time 0", ValidateQueryTime, "0", "query time '0' is out of range (1-300)"},
+		{"time too long", ValidateQueryTime, "301", "query time '301' is out of range (1-300)"},
+		{"time with unit", ValidateQueryTime, "2s", "query time '2s' must be numeric"},
+		{"time empty", ValidateQueryTime, "", "query time cannot be empty"},
+		{"tries 1", ValidateTries, "1", ""},
+		{"tries 10", ValidateTries, "10", ""},
+		{"tries 0", ValidateTries, "0", "number of tries '0' is out of range (1-10)"},
+		{"tries 11", ValidateTries, "11", "out of range"},
+		{"tries negative", ValidateTries, "-1", "must be numeric"},
+		{"retry 0", ValidateRetries, "0", ""},
+		{"retry 9", ValidateRetries, "9", ""},
+		{"retry 10", ValidateRetries, "10", "number of retries '10' is out of range (0-9)"},
+		{"retry huge", ValidateRetries, "99999999999999999999", "out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validate(tt.value)
+			if tt.expectError == "" {
+				if err != nil {
+					t.Errorf("Expected %q to be valid, got %v", tt.value, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectError, err)
+			}
+			if err != nil && !IsInputError(err) {
+				t.Errorf("Expected input error, got %T", err)
+			}
+		})
+	}
+}
+
+func TestValidateAddressFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		ipv4Only bool
+		ipv6Only bool
+		wantErr  bool
+	}{
+		{"no family", "2001:4860:4860::8888", false, false, false},
+		{"IPv4 with -4", "8.8.8.8", true, false, false},
+		{"IPv6 with -6", "2001:4860:4860::8888", false, true, false},
+		{"IPv6 with -4", "2001:4860:4860::8888", true, false, true},
+		{"IPv4 with -6", "8.8.8.8", false, true, true},
+		{"IPv4-mapped IPv6 with -6", "::ffff:8.8.8.8", false, true, true},
+		{"both families", "8.8.8.8", true, true, true},
+		{"host name is left to other checks", "dns.example", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAddressFamily(tt.server, tt.ipv4Only, tt.ipv6Only)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAddressFamily() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !IsInputError(err) {
+				t.Errorf("Expected input error, got %T", err)
+			}
+		})
+	}
+}
+
 func TestValidateRecordType(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -481,6 +899,11 @@ func TestValidateRecordType(t *testing.T) {
 			recordType: "TXT",
 			wantError:  false,
 		},
+		{
+			name:       "valid NS record",
+			recordType: "NS",
+			wantError:  false,
+		},
 		{
 			name:       "lowercase valid record",
 			recordType: "a",
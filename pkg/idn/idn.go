@@ -0,0 +1,182 @@
+// Package idn converts internationalized domain names between the Unicode
+// form users type (U-labels) and the ASCII form sent on the wire (A-labels),
+// following IDNA 2008 with UTS #46 mapping.
+package idn
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	// mapping applies UTS #46 lookup mapping (case folding, width and dot
+	// normalisation) and decodes A-labels, without IDNA 2008 validation
+	mapping = idna.New(
+		idna.MapForLookup(),
+		idna.Transitional(false),
+		idna.StrictDomainName(false),
+	)
+
+	// registration validates already-mapped labels and encodes them. Underscores
+	// stay allowed for names such as _dmarc; lengths are checked by the caller.
+	registration = idna.New(
+		idna.ValidateForRegistration(),
+		idna.StrictDomainName(false),
+		idna.VerifyDNSLength(false),
+	)
+)
+
+// LabelError reports which label of a name could not be converted and why
+type LabelError struct {
+	Label  string
+	Reason string
+}
+
+// Error returns a human-readable description of the invalid label
+func (e *LabelError) Error() string {
+	return fmt.Sprintf("label '%s' %s", e.Label, e.Reason)
+}
+
+// NeedsConversion reports whether name contains non-ASCII characters or
+// A-labels, i.e. whether IDNA processing applies to it
+func NeedsConversion(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	for _, label := range strings.Split(name, ".") {
+		if isALabel(label) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToASCII converts name to A-labels for the wire. Names that need no
+// conversion are returned unchanged. Invalid names yield a *LabelError.
+func ToASCII(name string) (string, error) {
+	if !NeedsConversion(name) {
+		return name, nil
+	}
+
+	mapped, err := mapping.ToUnicode(name)
+	if err != nil {
+		return "", diagnose(name)
+	}
+	for _, label := range strings.Split(mapped, ".") {
+		if reason := checkCodePoints(label); reason != "" {
+			return "", &LabelError{Label: label, Reason: reason}
+		}
+	}
+
+	ascii, err := registration.ToASCII(mapped)
+	if err != nil {
+		return "", diagnose(mapped)
+	}
+	return ascii, nil
+}
+
+// ToUnicode converts the A-labels of name to U-labels for display. Names that
+// cannot be converted are returned unchanged.
+func ToUnicode(name string) string {
+	if !NeedsConversion(name) {
+		return name
+	}
+	unicodeName, err := mapping.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return unicodeName
+}
+
+// diagnose finds the first invalid label of name and explains the problem
+func diagnose(name string) error {
+	// UTS #46 treats the ideographic and fullwidth full stops as dots
+	name = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(name)
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || !NeedsConversion(label) {
+			continue
+		}
+		mapped, err := mapping.ToUnicode(label)
+		if err == nil {
+			_, err = registration.ToASCII(mapped)
+		}
+		if err != nil {
+			return &LabelError{Label: label, Reason: labelReason(label)}
+		}
+	}
+	return &LabelError{Label: name, Reason: "is not valid under IDNA 2008"}
+}
+
+// labelReason explains why a single label failed IDNA processing
+func labelReason(label string) string {
+	if isALabel(label) {
+		decoded, err := idna.Punycode.ToUnicode(label)
+		if err != nil || decoded == label {
+			return "is not a valid A-label (malformed Punycode)"
+		}
+		return fmt.Sprintf("decodes to '%s', which %s", decoded, labelReason(decoded))
+	}
+
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return "cannot start or end with a hyphen"
+	}
+	if len(label) >= 4 && label[2:4] == "--" {
+		return "cannot have hyphens in both the third and fourth positions"
+	}
+	if first, _ := utf8.DecodeRuneInString(label); unicode.Is(unicode.M, first) {
+		return "cannot start with a combining mark"
+	}
+	if reason := checkCodePoints(label); reason != "" {
+		return reason
+	}
+
+	for _, r := range label {
+		if r == '‌' || r == '‍' {
+			return "contains a zero-width joiner or non-joiner in a context where it is not allowed"
+		}
+	}
+	for _, r := range label {
+		if unicode.In(r, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko) {
+			return "mixes right-to-left and left-to-right characters in a way RFC 5893 does not allow"
+		}
+	}
+	return "is not valid under IDNA 2008"
+}
+
+// checkCodePoints rejects characters that UTS #46 still accepts but IDNA 2008
+// disallows, such as symbols and punctuation. It returns an empty string if
+// every character is permitted.
+func checkCodePoints(label string) string {
+	for _, r := range label {
+		if !permitted(r) {
+			return fmt.Sprintf("contains U+%04X '%c', which IDNA 2008 does not permit", r, r)
+		}
+	}
+	return ""
+}
+
+// permitted approximates the RFC 5892 PVALID, CONTEXTJ and CONTEXTO classes:
+// letters, marks and decimal digits plus a few contextual characters. ASCII is
+// left to the caller's own hostname rules.
+func permitted(r rune) bool {
+	if r < utf8.RuneSelf {
+		return true
+	}
+	switch r {
+	case '·', '͵', '׳', '״', '・', '‌', '‍':
+		return true
+	}
+	return unicode.In(r, unicode.L, unicode.M, unicode.Nd)
+}
+
+// isALabel reports whether label carries the ACE prefix
+func isALabel(label string) bool {
+	return len(label) >= 4 && strings.EqualFold(label[:4], "xn--")
+}
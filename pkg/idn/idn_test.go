@@ -0,0 +1,109 @@
+package idn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"ASCII unchanged", "example.com", "example.com"},
+		{"ASCII keeps case", "Example.COM", "Example.COM"},
+		{"U-label", "münchen.de", "xn--mnchen-3ya.de"},
+		{"upper case mapped", "MÜNCHEN.DE", "xn--mnchen-3ya.de"},
+		{"sharp s kept (non-transitional)", "faß.de", "xn--fa-hia.de"},
+		{"fullwidth mapped", "ｅｘａｍｐｌｅ.ｃｏｍ", "example.com"},
+		{"ideographic full stop", "münchen。de", "xn--mnchen-3ya.de"},
+		{"underscore label", "_dmarc.münchen.de", "_dmarc.xn--mnchen-3ya.de"},
+		{"valid A-label", "xn--mnchen-3ya.de", "xn--mnchen-3ya.de"},
+		{"trailing dot", "bücher.example.", "xn--bcher-kva.example."},
+		{"CJK", "例え.jp", "xn--r8jz45g.jp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToASCII(tt.input)
+			if err != nil {
+				t.Fatalf("ToASCII(%q) error = %v, want nil", tt.input, err)
+			}
+			if got != tt.expected {
+				t.Errorf("ToASCII(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestToASCII_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		label  string
+		reason string
+	}{
+		{"symbol", "☃.net", "☃", "contains U+2603 '☃', which IDNA 2008 does not permit"},
+		{"symbol as A-label", "xn--n3h.net", "☃", "contains U+2603"},
+		{"bad Punycode", "xn--zz.de", "xn--zz", "is not a valid A-label (malformed Punycode)"},
+		{"leading hyphen", "-ü.de", "-ü", "cannot start or end with a hyphen"},
+		{"hyphens in third and fourth positions", "ab--ü.de", "ab--ü", "cannot have hyphens in both the third and fourth positions"},
+		{"leading combining mark", "́a.com", "́a", "cannot start with a combining mark"},
+		{"joiner out of context", "a‍b.de", "a‍b", "zero-width joiner"},
+		{"mixed direction", "١٢a.com", "١٢a", "RFC 5893"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToASCII(tt.input)
+			labelErr, ok := err.(*LabelError)
+			if !ok {
+				t.Fatalf("ToASCII(%q) error = %v, want *LabelError", tt.input, err)
+			}
+			if labelErr.Label != tt.label {
+				t.Errorf("Label = %q, want %q", labelErr.Label, tt.label)
+			}
+			if !strings.Contains(labelErr.Reason, tt.reason) {
+				t.Errorf("Reason = %q, want it to contain %q", labelErr.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xn--mnchen-3ya.de.", "münchen.de."},
+		{"mail.xn--bcher-kva.example", "mail.bücher.example"},
+		{"example.com", "example.com"},
+		{"xn--zz.de", "xn--zz.de"}, // Invalid A-labels are shown as they are
+	}
+
+	for _, tt := range tests {
+		if got := ToUnicode(tt.input); got != tt.expected {
+			t.Errorf("ToUnicode(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNeedsConversion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"example.com", false},
+		{"_dmarc.example.com", false},
+		{"münchen.de", true},
+		{"XN--mnchen-3ya.de", true},
+		{"axn--b.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := NeedsConversion(tt.input); got != tt.expected {
+			t.Errorf("NeedsConversion(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
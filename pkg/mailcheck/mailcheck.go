@@ -0,0 +1,268 @@
+package mailcheck
+
+import (
+	"fmt"
+	"strings"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Severity ranks how serious a finding is
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// String returns a string representation of the severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarning:
+		return "WARNING"
+	case SeverityError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Finding is a single syntax error or policy weakness
+type Finding struct {
+	Severity Severity
+	Check    string // Which record the finding is about, e.g. "SPF" or "DKIM selector1"
+	Message  string
+}
+
+// TagRecord is a parsed "tag=value; tag=value" record such as DMARC or DKIM
+type TagRecord struct {
+	Name     string // Owner name the record was fetched from
+	Raw      string
+	Tags     map[string]string
+	Problems []string // Syntax problems found while parsing
+}
+
+// Report is the result of a mail authentication check
+type Report struct {
+	Domain   string
+	MX       []string
+	SPF      *SPFResult
+	DMARC    *TagRecord
+	DKIM     map[string]*TagRecord // Keyed by selector; nil values mean not found
+	MTASTS   *TagRecord
+	TLSRPT   *TagRecord
+	BIMI     *TagRecord
+	Findings []Finding
+}
+
+// Healthy reports whether the check found no errors
+func (r *Report) Healthy() bool {
+	for _, finding := range r.Findings {
+		if finding.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker fetches and analyses a domain's mail authentication records
+type Checker struct {
+	client dns.Client
+	server string
+}
+
+// NewChecker creates a checker that issues queries through client to server.
+// An empty server selects the system default resolver.
+func NewChecker(client dns.Client, server string) *Checker {
+	return &Checker{client: client, server: server}
+}
+
+// Check fetches MX, SPF, DMARC, DKIM (for the given selectors), MTA-STS,
+// TLS-RPT and BIMI records for domain and reports syntax errors and weak policies
+func (c *Checker) Check(domain string, selectors []string) (*Report, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if err := errors.ValidateDomain(domain); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Domain: domain, DKIM: map[string]*TagRecord{}}
+	add := func(severity Severity, check, format string, args ...interface{}) {
+		report.Findings = append(report.Findings, Finding{Severity: severity, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// MX
+	mx, err := c.client.Query(domain, "MX", c.server)
+	if err != nil && !errors.IsDNSError(err) {
+		return report, err
+	}
+	if mx != nil {
+		report.MX = mx.Records
+	}
+	switch {
+	case len(report.MX) == 0:
+		add(SeverityWarning, "MX", "no MX records; mail is delivered to the domain's A/AAAA address")
+	case len(report.MX) == 1 && report.MX[0] == "0 .":
+		add(SeverityInfo, "MX", "null MX record: the domain does not accept mail")
+	}
+
+	// SPF
+	spf, err := c.evaluateSPF(domain)
+	if err != nil {
+		return report, err
+	}
+	report.SPF = spf
+	report.Findings = append(report.Findings, spf.Findings...)
+
+	// DMARC
+	dmarcName := "_dmarc." + domain
+	report.DMARC, err = c.fetchTagRecord(dmarcName, "v=DMARC1", "DMARC", report)
+	if err != nil {
+		return report, err
+	}
+	if report.DMARC == nil {
+		add(SeverityError, "DMARC", "no DMARC record at %s; receivers cannot enforce a policy", dmarcName)
+	} else {
+		report.Findings = append(report.Findings, checkDMARC(report.DMARC)...)
+	}
+
+	// DKIM
+	for _, selector := range selectors {
+		check := "DKIM " + selector
+		name := selector + "._domainkey." + domain
+		record, err := c.fetchTagRecord(name, "", check, report)
+		if err != nil {
+			return report, err
+		}
+		report.DKIM[selector] = record
+		if record == nil {
+			add(SeverityError, check, "no DKIM key at %s", name)
+			continue
+		}
+		report.Findings = append(report.Findings, checkDKIM(check, record)...)
+	}
+
+	// MTA-STS
+	mtaSTSName := "_mta-sts." + domain
+	report.MTASTS, err = c.fetchTagRecord(mtaSTSName, "v=STSv1", "MTA-STS", report)
+	if err != nil {
+		return report, err
+	}
+	if report.MTASTS == nil {
+		add(SeverityInfo, "MTA-STS", "no MTA-STS record at %s; inbound TLS is opportunistic", mtaSTSName)
+	} else {
+		report.Findings = append(report.Findings, checkMTASTS(report.MTASTS)...)
+	}
+
+	// TLS-RPT
+	tlsRPTName := "_smtp._tls." + domain
+	report.TLSRPT, err = c.fetchTagRecord(tlsRPTName, "v=TLSRPTv1", "TLS-RPT", report)
+	if err != nil {
+		return report, err
+	}
+	if report.TLSRPT == nil {
+		add(SeverityInfo, "TLS-RPT", "no TLS-RPT record at %s; TLS delivery failures are not reported", tlsRPTName)
+	} else {
+		report.Findings = append(report.Findings, checkTLSRPT(report.TLSRPT)...)
+	}
+
+	// BIMI
+	bimiName := "default._bimi." + domain
+	report.BIMI, err = c.fetchTagRecord(bimiName, "v=BIMI1", "BIMI", report)
+	if err != nil {
+		return report, err
+	}
+	if report.BIMI != nil {
+		report.Findings = append(report.Findings, checkBIMI(report.BIMI)...)
+		if report.DMARC != nil && !dmarcEnforced(report.DMARC) {
+			add(SeverityWarning, "BIMI", "logos are only shown when DMARC is enforced (p=quarantine or p=reject)")
+		}
+	}
+
+	return report, nil
+}
+
+// fetchTagRecord fetches the TXT records at name and parses the one starting
+// with prefix (any record if prefix is empty). More than one matching record
+// is reported as an error and the first one is used.
+func (c *Checker) fetchTagRecord(name, prefix, check string, report *Report) (*TagRecord, error) {
+	texts, err := c.lookupTXT(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, text := range texts {
+		if prefix == "" || hasVersionPrefix(text, prefix) {
+			matches = append(matches, text)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	if len(matches) > 1 {
+		report.Findings = append(report.Findings, Finding{
+			Severity: SeverityError,
+			Check:    check,
+			Message:  fmt.Sprintf("%d records found at %s; exactly one is allowed", len(matches), name),
+		})
+	}
+
+	return parseTagRecord(name, matches[0]), nil
+}
+
+// lookupTXT returns the TXT records at name, each with its character strings
+// concatenated without separators as SPF, DKIM and DMARC require. A missing
+// name or record set yields no records and no error.
+func (c *Checker) lookupTXT(name string) ([]string, error) {
+	if err := errors.ValidateDomain(name); err != nil {
+		return nil, err
+	}
+
+	msg := new(mdns.Msg)
+	msg.SetQuestion(mdns.Fqdn(name), mdns.TypeTXT)
+	msg.RecursionDesired = true
+
+	response, _, err := c.client.Exchange(msg, c.server, "udp")
+	if err == nil && response.Truncated {
+		response, _, err = c.client.Exchange(msg, c.server, "tcp")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.Rcode {
+	case mdns.RcodeSuccess, mdns.RcodeNameError:
+	default:
+		return nil, errors.NewDNSError(fmt.Sprintf("TXT lookup for '%s' failed with %s", name, mdns.RcodeToString[response.Rcode]), nil, name, c.server)
+	}
+
+	var texts []string
+	for _, rr := range response.Answer {
+		if txt, ok := rr.(*mdns.TXT); ok {
+			texts = append(texts, strings.Join(txt.Txt, ""))
+		}
+	}
+	return texts, nil
+}
+
+// dmarcEnforced reports whether a DMARC record quarantines or rejects failing mail
+func dmarcEnforced(record *TagRecord) bool {
+	policy := strings.ToLower(record.Tags["p"])
+	return (policy == "quarantine" || policy == "reject") && record.Tags["pct"] != "0"
+}
+
+// hasVersionPrefix reports whether a record starts with the given version tag,
+// e.g. "v=DMARC1", followed by a separator or the end of the record
+func hasVersionPrefix(text, prefix string) bool {
+	if len(text) < len(prefix) || !strings.EqualFold(text[:len(prefix)], prefix) {
+		return false
+	}
+	rest := text[len(prefix):]
+	return rest == "" || rest[0] == ';' || rest[0] == ' '
+}
@@ -0,0 +1,243 @@
+package mailcheck
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+
+	"go-dig/pkg/dnsfake"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// fixture is a fake client that serves MX answers from its table and TXT
+// records to raw exchanges from its own tables
+type fixture struct {
+	*dnsfake.Client
+	txt       map[string][]string // owner name without trailing dot -> records
+	rcodes    map[string]int      // owner name -> rcode to answer with instead
+	truncated map[string]bool     // owner names whose UDP answers are truncated
+}
+
+func newFixture() *fixture {
+	f := &fixture{Client: dnsfake.NewClient(), txt: map[string][]string{}}
+	f.Handle("", f.exchange)
+	return f
+}
+
+func (f *fixture) exchange(msg *mdns.Msg, network string) *mdns.Msg {
+	name := strings.TrimSuffix(msg.Question[0].Name, ".")
+
+	response := new(mdns.Msg)
+	if rcode, found := f.rcodes[name]; found {
+		response.SetRcode(msg, rcode)
+		return response
+	}
+
+	response.SetReply(msg)
+	if f.truncated[name] && network == "udp" {
+		response.Truncated = true
+		return response
+	}
+	for _, text := range f.txt[name] {
+		response.Answer = append(response.Answer, &mdns.TXT{
+			Hdr: mdns.RR_Header{Name: msg.Question[0].Name, Rrtype: mdns.TypeTXT, Class: mdns.ClassINET, Ttl: 300},
+			Txt: splitCharacterStrings(text),
+		})
+	}
+	return response
+}
+
+// splitCharacterStrings splits text into 255-byte character strings as a zone would
+func splitCharacterStrings(text string) []string {
+	var parts []string
+	for len(text) > 255 {
+		parts = append(parts, text[:255])
+		text = text[255:]
+	}
+	return append(parts, text)
+}
+
+// rsaKey returns a base64 SubjectPublicKeyInfo for an RSA key with the given modulus size
+func rsaKey(t *testing.T, bits int) string {
+	t.Helper()
+	modulus := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	modulus.Add(modulus, big.NewInt(1))
+
+	der, err := x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: modulus, E: 65537})
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error = %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func healthyFixture(t *testing.T) *fixture {
+	client := newFixture()
+	client.Answer("", "example.com", "MX", "10 mx.example.com.")
+	client.txt = map[string][]string{
+		"example.com":                      {"v=spf1 mx include:_spf.provider.net -all", "google-site-verification=abc"},
+		"_spf.provider.net":                {"v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 ~all"},
+		"_dmarc.example.com":               {"v=DMARC1; p=reject; rua=mailto:dmarc@example.com; adkim=s"},
+		"selector1._domainkey.example.com": {"v=DKIM1; k=rsa; p=" + rsaKey(t, 2048)},
+		"_mta-sts.example.com":             {"v=STSv1; id=20240101T000000"},
+		"_smtp._tls.example.com":           {"v=TLSRPTv1; rua=mailto:tls@example.com"},
+		"default._bimi.example.com":        {"v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem"},
+	}
+	return client
+}
+
+func TestChecker_Check_Healthy(t *testing.T) {
+	client := healthyFixture(t)
+
+	report, err := NewChecker(client, "").Check("example.com.", []string{"selector1"})
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+
+	if !report.Healthy() {
+		t.Errorf("Expected a healthy report, got findings %+v", report.Findings)
+	}
+	for _, finding := range report.Findings {
+		if finding.Severity != SeverityInfo {
+			t.Errorf("Unexpected finding %+v", finding)
+		}
+	}
+
+	if report.Domain != "example.com" || len(report.MX) != 1 {
+		t.Errorf("Unexpected domain or MX: %s %v", report.Domain, report.MX)
+	}
+	if report.SPF.Record != "v=spf1 mx include:_spf.provider.net -all" || report.SPF.Lookups != 2 {
+		t.Errorf("Unexpected SPF result %+v", report.SPF)
+	}
+	if report.DMARC.Tags["p"] != "reject" || report.DKIM["selector1"] == nil {
+		t.Errorf("Unexpected DMARC or DKIM: %+v %+v", report.DMARC, report.DKIM)
+	}
+	if report.MTASTS == nil || report.TLSRPT == nil || report.BIMI == nil {
+		t.Error("Expected MTA-STS, TLS-RPT and BIMI records")
+	}
+}
+
+func TestChecker_Check_Missing(t *testing.T) {
+	client := newFixture()
+
+	report, err := NewChecker(client, "").Check("example.com", []string{"s1"})
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+	if report.Healthy() {
+		t.Error("Expected an unhealthy report")
+	}
+
+	expected := []string{
+		"WARNING MX: no MX records",
+		"WARNING SPF: no SPF record",
+		"ERROR DMARC: no DMARC record at _dmarc.example.com",
+		"ERROR DKIM s1: no DKIM key at s1._domainkey.example.com",
+		"INFO MTA-STS: no MTA-STS record",
+		"INFO TLS-RPT: no TLS-RPT record",
+	}
+	if len(report.Findings) != len(expected) {
+		t.Fatalf("Expected %d findings, got %+v", len(expected), report.Findings)
+	}
+	for i, want := range expected {
+		finding := report.Findings[i]
+		got := finding.Severity.String() + " " + finding.Check + ": " + finding.Message
+		if !strings.HasPrefix(got, want) {
+			t.Errorf("Finding %d = %q, want prefix %q", i, got, want)
+		}
+	}
+}
+
+func TestChecker_Check_NullMXAndBIMIWithoutEnforcement(t *testing.T) {
+	client := healthyFixture(t)
+	client.Answer("", "example.com", "MX", "0 .")
+	client.txt["_dmarc.example.com"] = []string{"v=DMARC1; p=none; rua=mailto:dmarc@example.com"}
+
+	report, err := NewChecker(client, "").Check("example.com", nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+
+	var messages []string
+	for _, finding := range report.Findings {
+		messages = append(messages, finding.Check+": "+finding.Message)
+	}
+	joined := strings.Join(messages, "\n")
+
+	for _, want := range []string{"MX: null MX record", "DMARC: p=none only monitors", "BIMI: logos are only shown when DMARC is enforced"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected finding %q, got:\n%s", want, joined)
+		}
+	}
+}
+
+func TestChecker_Check_DuplicateRecords(t *testing.T) {
+	client := healthyFixture(t)
+	client.txt["_dmarc.example.com"] = append(client.txt["_dmarc.example.com"], "v=DMARC1; p=none")
+
+	report, err := NewChecker(client, "").Check("example.com", nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+	if report.Healthy() {
+		t.Error("Expected duplicate DMARC records to make the report unhealthy")
+	}
+	if report.DMARC.Tags["p"] != "reject" {
+		t.Errorf("Expected the first DMARC record to be used, got %+v", report.DMARC)
+	}
+}
+
+func TestChecker_Check_TruncatedRetriesOverTCP(t *testing.T) {
+	client := healthyFixture(t)
+	client.truncated = map[string]bool{"selector1._domainkey.example.com": true}
+
+	report, err := NewChecker(client, "").Check("example.com", []string{"selector1"})
+	if err != nil {
+		t.Fatalf("Check() error = %v, want nil", err)
+	}
+	if report.DKIM["selector1"] == nil {
+		t.Fatal("Expected the DKIM key to be fetched over TCP")
+	}
+	if !strings.Contains(strings.Join(client.Exchanges(), "\n"), "selector1._domainkey.example.com tcp") {
+		t.Errorf("Expected a TCP retry, got exchanges %v", client.Exchanges())
+	}
+}
+
+func TestChecker_Check_Errors(t *testing.T) {
+	client := healthyFixture(t)
+
+	if _, err := NewChecker(client, "").Check("bad..domain", nil); !errors.IsInputError(err) {
+		t.Errorf("Expected input error for an invalid domain, got %v", err)
+	}
+
+	client.rcodes = map[string]int{"_dmarc.example.com": mdns.RcodeServerFailure}
+	_, err := NewChecker(client, "").Check("example.com", nil)
+	if !errors.IsDNSError(err) || !strings.Contains(err.Error(), "SERVFAIL") {
+		t.Errorf("Expected SERVFAIL DNS error, got %v", err)
+	}
+}
+
+func TestHasVersionPrefix(t *testing.T) {
+	tests := []struct {
+		text     string
+		prefix   string
+		expected bool
+	}{
+		{"v=DMARC1; p=none", "v=DMARC1", true},
+		{"v=dmarc1;p=none", "v=DMARC1", true},
+		{"v=spf1 -all", "v=spf1", true},
+		{"v=spf1", "v=spf1", true},
+		{"v=spf10 -all", "v=spf1", false},
+		{"spf1", "v=spf1", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasVersionPrefix(tt.text, tt.prefix); got != tt.expected {
+			t.Errorf("hasVersionPrefix(%q, %q) = %v, want %v", tt.text, tt.prefix, got, tt.expected)
+		}
+	}
+}
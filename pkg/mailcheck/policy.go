@@ -0,0 +1,287 @@
+package mailcheck
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTagRecord splits a "tag=value; tag=value" record. Tag names are
+// lower-cased; fragments that are not tag=value pairs and repeated tags are
+// recorded in Problems, and the first value of a repeated tag wins.
+func parseTagRecord(name, raw string) *TagRecord {
+	record := &TagRecord{Name: name, Raw: raw, Tags: map[string]string{}}
+
+	for _, fragment := range strings.Split(raw, ";") {
+		fragment = strings.TrimSpace(fragment)
+		if fragment == "" {
+			continue
+		}
+
+		tag, value, found := strings.Cut(fragment, "=")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !found || tag == "" {
+			record.Problems = append(record.Problems, fmt.Sprintf("'%s' is not a tag=value pair", fragment))
+			continue
+		}
+		if _, exists := record.Tags[tag]; exists {
+			record.Problems = append(record.Problems, fmt.Sprintf("tag '%s' appears more than once", tag))
+			continue
+		}
+		record.Tags[tag] = strings.TrimSpace(value)
+	}
+
+	return record
+}
+
+// syntaxFindings reports the parse problems of a record as errors
+func syntaxFindings(check string, record *TagRecord) []Finding {
+	var findings []Finding
+	for _, problem := range record.Problems {
+		findings = append(findings, Finding{Severity: SeverityError, Check: check, Message: problem})
+	}
+	return findings
+}
+
+// checkDMARC validates a DMARC record (RFC 7489)
+func checkDMARC(record *TagRecord) []Finding {
+	findings := syntaxFindings("DMARC", record)
+	add := func(severity Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Check: "DMARC", Message: fmt.Sprintf(format, args...)})
+	}
+
+	policy, found := record.Tags["p"]
+	switch {
+	case !found:
+		add(SeverityError, "missing required policy tag 'p'")
+	case !validDMARCPolicy(policy):
+		add(SeverityError, "invalid policy p=%s (expected none, quarantine or reject)", policy)
+	case strings.EqualFold(policy, "none"):
+		add(SeverityWarning, "p=none only monitors; spoofed mail is still delivered")
+	}
+
+	if subdomain, found := record.Tags["sp"]; found {
+		if !validDMARCPolicy(subdomain) {
+			add(SeverityError, "invalid subdomain policy sp=%s", subdomain)
+		} else if strings.EqualFold(subdomain, "none") && !strings.EqualFold(policy, "none") {
+			add(SeverityWarning, "sp=none leaves subdomains unprotected")
+		}
+	}
+
+	if value, found := record.Tags["pct"]; found {
+		pct, err := strconv.Atoi(value)
+		switch {
+		case err != nil || pct < 0 || pct > 100:
+			add(SeverityError, "invalid pct=%s (expected 0-100)", value)
+		case pct < 100:
+			add(SeverityWarning, "pct=%d applies the policy to only part of the failing mail", pct)
+		}
+	}
+
+	for _, tag := range []string{"adkim", "aspf"} {
+		if value, found := record.Tags[tag]; found && !strings.EqualFold(value, "r") && !strings.EqualFold(value, "s") {
+			add(SeverityError, "invalid alignment mode %s=%s (expected r or s)", tag, value)
+		}
+	}
+
+	if value, found := record.Tags["fo"]; found {
+		for _, option := range strings.Split(value, ":") {
+			switch strings.TrimSpace(option) {
+			case "0", "1", "d", "s":
+				continue
+			}
+			add(SeverityError, "invalid failure reporting option fo=%s", value)
+			break
+		}
+	}
+
+	if value, found := record.Tags["rua"]; !found || value == "" {
+		add(SeverityWarning, "no aggregate report address (rua); failures go unnoticed")
+	}
+	for _, tag := range []string{"rua", "ruf"} {
+		for _, uri := range splitList(record.Tags[tag]) {
+			if !hasSchemePrefix(uri, "mailto:") {
+				add(SeverityError, "%s entry '%s' is not a mailto: URI", tag, uri)
+			}
+		}
+	}
+
+	return findings
+}
+
+// validDMARCPolicy reports whether value is a DMARC policy name
+func validDMARCPolicy(value string) bool {
+	switch strings.ToLower(value) {
+	case "none", "quarantine", "reject":
+		return true
+	}
+	return false
+}
+
+// checkDKIM validates a DKIM key record (RFC 6376)
+func checkDKIM(check string, record *TagRecord) []Finding {
+	findings := syntaxFindings(check, record)
+	add := func(severity Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if version, found := record.Tags["v"]; found {
+		if version != "DKIM1" {
+			add(SeverityError, "invalid version v=%s (expected DKIM1)", version)
+		} else if !hasVersionPrefix(record.Raw, "v=DKIM1") {
+			add(SeverityError, "v=DKIM1 must be the first tag")
+		}
+	}
+
+	for _, flag := range strings.Split(record.Tags["t"], ":") {
+		if strings.TrimSpace(flag) == "y" {
+			add(SeverityWarning, "t=y: the domain is testing DKIM and verifiers may ignore failures")
+		}
+	}
+
+	if hashes, found := record.Tags["h"]; found && !strings.Contains(strings.ToLower(hashes), "sha256") {
+		add(SeverityWarning, "h=%s allows only weak hash algorithms", hashes)
+	}
+
+	encoded, found := record.Tags["p"]
+	if !found {
+		add(SeverityError, "missing required public key tag 'p'")
+		return findings
+	}
+	encoded = strings.Join(strings.Fields(encoded), "")
+	if encoded == "" {
+		add(SeverityWarning, "empty p=: the key has been revoked")
+		return findings
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		add(SeverityError, "public key is not valid base64")
+		return findings
+	}
+
+	keyType := strings.ToLower(record.Tags["k"])
+	switch keyType {
+	case "", "rsa":
+		bits, ok := rsaKeyBits(key)
+		switch {
+		case !ok:
+			add(SeverityError, "public key is not a valid RSA key")
+		case bits < 1024:
+			add(SeverityError, "%d-bit RSA key is too short to be trusted", bits)
+		case bits < 2048:
+			add(SeverityWarning, "%d-bit RSA key is weak; use at least 2048 bits", bits)
+		}
+	case "ed25519":
+		if len(key) != ed25519.PublicKeySize {
+			add(SeverityError, "ed25519 key is %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+		}
+	default:
+		add(SeverityError, "unknown key type k=%s", keyType)
+	}
+
+	return findings
+}
+
+// rsaKeyBits returns the modulus size of a DER-encoded RSA public key, accepting
+// both SubjectPublicKeyInfo and bare PKCS #1 encodings
+func rsaKeyBits(der []byte) (int, bool) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey.N.BitLen(), true
+		}
+		return 0, false
+	}
+	if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return rsaKey.N.BitLen(), true
+	}
+	return 0, false
+}
+
+// checkMTASTS validates an MTA-STS TXT record (RFC 8461)
+func checkMTASTS(record *TagRecord) []Finding {
+	findings := syntaxFindings("MTA-STS", record)
+
+	id, found := record.Tags["id"]
+	switch {
+	case !found:
+		findings = append(findings, Finding{Severity: SeverityError, Check: "MTA-STS", Message: "missing required tag 'id'"})
+	case len(id) == 0 || len(id) > 32 || !isAlphanumeric(id):
+		findings = append(findings, Finding{Severity: SeverityError, Check: "MTA-STS", Message: fmt.Sprintf("invalid id=%s (expected 1-32 letters and digits)", id)})
+	}
+
+	return findings
+}
+
+// checkTLSRPT validates a TLS-RPT record (RFC 8460)
+func checkTLSRPT(record *TagRecord) []Finding {
+	findings := syntaxFindings("TLS-RPT", record)
+
+	uris := splitList(record.Tags["rua"])
+	if len(uris) == 0 {
+		findings = append(findings, Finding{Severity: SeverityError, Check: "TLS-RPT", Message: "missing required report address tag 'rua'"})
+	}
+	for _, uri := range uris {
+		if !hasSchemePrefix(uri, "mailto:") && !hasSchemePrefix(uri, "https:") {
+			findings = append(findings, Finding{Severity: SeverityError, Check: "TLS-RPT", Message: fmt.Sprintf("rua entry '%s' is not a mailto: or https: URI", uri)})
+		}
+	}
+
+	return findings
+}
+
+// checkBIMI validates a BIMI assertion record
+func checkBIMI(record *TagRecord) []Finding {
+	findings := syntaxFindings("BIMI", record)
+	add := func(severity Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Check: "BIMI", Message: fmt.Sprintf(format, args...)})
+	}
+
+	logo, found := record.Tags["l"]
+	switch {
+	case !found:
+		add(SeverityError, "missing logo location tag 'l'")
+	case logo != "" && !hasSchemePrefix(logo, "https:"):
+		add(SeverityError, "logo location '%s' is not an https: URI", logo)
+	}
+
+	authority := record.Tags["a"]
+	switch {
+	case authority == "":
+		add(SeverityInfo, "no evidence document (a=); some mailbox providers will not show the logo")
+	case !hasSchemePrefix(authority, "https:"):
+		add(SeverityError, "evidence document '%s' is not an https: URI", authority)
+	}
+
+	return findings
+}
+
+// splitList splits a comma-separated tag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// hasSchemePrefix reports whether uri starts with scheme, ignoring case
+func hasSchemePrefix(uri, scheme string) bool {
+	return len(uri) > len(scheme) && strings.EqualFold(uri[:len(scheme)], scheme)
+}
+
+// isAlphanumeric reports whether s consists only of ASCII letters and digits
+func isAlphanumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isAlpha(s[i]) && (s[i] < '0' || s[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package mailcheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTagRecord(t *testing.T) {
+	record := parseTagRecord("_dmarc.example.com", "v=DMARC1; P = reject ;; rua=mailto:a@example.com; bogus; p=none")
+
+	if record.Tags["v"] != "DMARC1" || record.Tags["p"] != "reject" || record.Tags["rua"] != "mailto:a@example.com" {
+		t.Errorf("Unexpected tags %v", record.Tags)
+	}
+
+	expected := []string{"'bogus' is not a tag=value pair", "tag 'p' appears more than once"}
+	if strings.Join(record.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Problems = %v, want %v", record.Problems, expected)
+	}
+}
+
+// checkRecord runs check on a parsed record and compares the findings against
+// the expected substrings; nil expects no findings
+func checkRecord(t *testing.T, check func(*TagRecord) []Finding, raw string, expected []string) {
+	t.Helper()
+	findings := check(parseTagRecord("name.example.com", raw))
+	messages := findingMessages(findings)
+
+	if expected == nil && len(findings) != 0 {
+		t.Errorf("Expected no findings for %q, got:\n%s", raw, messages)
+	}
+	for _, want := range expected {
+		if !strings.Contains(messages, want) {
+			t.Errorf("Expected finding %q for %q, got:\n%s", want, raw, messages)
+		}
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+	}{
+		{"strict", "v=DMARC1; p=reject; sp=quarantine; rua=mailto:d@example.com; adkim=s; aspf=r; fo=1:d", nil},
+		{"missing policy", "v=DMARC1; rua=mailto:d@example.com", []string{"ERROR DMARC: missing required policy tag 'p'"}},
+		{"invalid policy", "v=DMARC1; p=block; rua=mailto:d@example.com", []string{"ERROR DMARC: invalid policy p=block"}},
+		{"monitoring only", "v=DMARC1; p=none; rua=mailto:d@example.com", []string{"WARNING DMARC: p=none only monitors"}},
+		{"weak subdomains", "v=DMARC1; p=reject; sp=none; rua=mailto:d@example.com", []string{"WARNING DMARC: sp=none leaves subdomains unprotected"}},
+		{"partial pct", "v=DMARC1; p=quarantine; pct=25; rua=mailto:d@example.com", []string{"WARNING DMARC: pct=25 applies"}},
+		{"invalid pct", "v=DMARC1; p=quarantine; pct=150; rua=mailto:d@example.com", []string{"ERROR DMARC: invalid pct=150"}},
+		{"invalid alignment", "v=DMARC1; p=reject; adkim=x; rua=mailto:d@example.com", []string{"ERROR DMARC: invalid alignment mode adkim=x"}},
+		{"invalid fo", "v=DMARC1; p=reject; fo=1:x; rua=mailto:d@example.com", []string{"ERROR DMARC: invalid failure reporting option fo=1:x"}},
+		{"no reports", "v=DMARC1; p=reject", []string{"WARNING DMARC: no aggregate report address"}},
+		{"bad report URI", "v=DMARC1; p=reject; rua=d@example.com; ruf=https://example.com", []string{"rua entry 'd@example.com' is not a mailto: URI", "ruf entry 'https://example.com' is not a mailto: URI"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRecord(t, checkDMARC, tt.raw, tt.expected)
+		})
+	}
+}
+
+func TestCheckDKIM(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+	}{
+		{"2048-bit RSA", "v=DKIM1; k=rsa; p=" + rsaKey(t, 2048), nil},
+		{"no version tag", "k=rsa; p=" + rsaKey(t, 4096), nil},
+		{"1024-bit RSA", "v=DKIM1; p=" + rsaKey(t, 1024), []string{"WARNING DKIM: 1024-bit RSA key is weak"}},
+		{"512-bit RSA", "v=DKIM1; p=" + rsaKey(t, 512), []string{"ERROR DKIM: 512-bit RSA key is too short"}},
+		{"ed25519", "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=", nil},
+		{"short ed25519", "v=DKIM1; k=ed25519; p=AAAA", []string{"ERROR DKIM: ed25519 key is 3 bytes, expected 32"}},
+		{"revoked", "v=DKIM1; p=", []string{"WARNING DKIM: empty p=: the key has been revoked"}},
+		{"missing key", "v=DKIM1; k=rsa", []string{"ERROR DKIM: missing required public key tag 'p'"}},
+		{"bad base64", "v=DKIM1; p=not*base64", []string{"ERROR DKIM: public key is not valid base64"}},
+		{"not RSA", "v=DKIM1; p=AAAA", []string{"ERROR DKIM: public key is not a valid RSA key"}},
+		{"unknown key type", "v=DKIM1; k=dsa; p=AAAA", []string{"ERROR DKIM: unknown key type k=dsa"}},
+		{"version not first", "k=rsa; v=DKIM1; p=" + rsaKey(t, 2048), []string{"ERROR DKIM: v=DKIM1 must be the first tag"}},
+		{"testing and sha1", "v=DKIM1; t=y:s; h=sha1; p=" + rsaKey(t, 2048), []string{"WARNING DKIM: t=y", "WARNING DKIM: h=sha1 allows only weak hash algorithms"}},
+	}
+
+	check := func(record *TagRecord) []Finding { return checkDKIM("DKIM", record) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRecord(t, check, tt.raw, tt.expected)
+		})
+	}
+}
+
+func TestCheckMTASTSAndTLSRPT(t *testing.T) {
+	checkRecord(t, checkMTASTS, "v=STSv1; id=20240101", nil)
+	checkRecord(t, checkMTASTS, "v=STSv1", []string{"ERROR MTA-STS: missing required tag 'id'"})
+	checkRecord(t, checkMTASTS, "v=STSv1; id=2024-01-01", []string{"ERROR MTA-STS: invalid id=2024-01-01"})
+
+	checkRecord(t, checkTLSRPT, "v=TLSRPTv1; rua=mailto:tls@example.com,https://report.example.com/tls", nil)
+	checkRecord(t, checkTLSRPT, "v=TLSRPTv1", []string{"ERROR TLS-RPT: missing required report address tag 'rua'"})
+	checkRecord(t, checkTLSRPT, "v=TLSRPTv1; rua=ftp://example.com", []string{"ERROR TLS-RPT: rua entry 'ftp://example.com' is not a mailto: or https: URI"})
+}
+
+func TestCheckBIMI(t *testing.T) {
+	checkRecord(t, checkBIMI, "v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem", nil)
+	checkRecord(t, checkBIMI, "v=BIMI1; l=https://example.com/logo.svg", []string{"INFO BIMI: no evidence document"})
+	checkRecord(t, checkBIMI, "v=BIMI1; a=https://example.com/vmc.pem", []string{"ERROR BIMI: missing logo location tag 'l'"})
+	checkRecord(t, checkBIMI, "v=BIMI1; l=http://example.com/logo.svg; a=https://example.com/vmc.pem", []string{"ERROR BIMI: logo location 'http://example.com/logo.svg' is not an https: URI"})
+}
@@ -0,0 +1,281 @@
+package mailcheck
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"go-dig/pkg/errors"
+)
+
+// MaxSPFLookups is the RFC 7208 limit on terms that cause DNS lookups
+const MaxSPFLookups = 10
+
+// SPFResult is the outcome of evaluating a domain's SPF record
+type SPFResult struct {
+	Record   string
+	Lookups  int      // DNS-querying terms across the record and everything it includes
+	Includes []string // Domains expanded through include: or redirect=, in evaluation order
+	Findings []Finding
+}
+
+// spfWalk holds the state of one SPF evaluation
+type spfWalk struct {
+	checker *Checker
+	result  *SPFResult
+	path    map[string]bool // Domains on the current include chain, for loop detection
+}
+
+// evaluateSPF fetches the SPF record of domain and expands it recursively
+func (c *Checker) evaluateSPF(domain string) (*SPFResult, error) {
+	result := &SPFResult{}
+
+	records, err := c.spfRecords(domain)
+	if err != nil {
+		return result, err
+	}
+	switch {
+	case len(records) == 0:
+		result.Findings = append(result.Findings, Finding{Severity: SeverityWarning, Check: "SPF", Message: "no SPF record; receivers cannot tell which hosts may send for the domain"})
+		return result, nil
+	case len(records) > 1:
+		result.Findings = append(result.Findings, Finding{Severity: SeverityError, Check: "SPF", Message: fmt.Sprintf("%d SPF records found; exactly one is allowed (permerror)", len(records))})
+	}
+	result.Record = records[0]
+
+	walk := &spfWalk{checker: c, result: result, path: map[string]bool{strings.ToLower(domain): true}}
+	if err := walk.evaluate("SPF", records[0], true); err != nil {
+		return result, err
+	}
+
+	if result.Lookups > MaxSPFLookups {
+		result.Findings = append(result.Findings, Finding{
+			Severity: SeverityError,
+			Check:    "SPF",
+			Message:  fmt.Sprintf("%d DNS lookups needed, exceeding the limit of %d (permerror)", result.Lookups, MaxSPFLookups),
+		})
+	}
+
+	return result, nil
+}
+
+// spfRecords returns the TXT records at domain that are SPF records
+func (c *Checker) spfRecords(domain string) ([]string, error) {
+	texts, err := c.lookupTXT(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+	for _, text := range texts {
+		if hasVersionPrefix(text, "v=spf1") {
+			records = append(records, text)
+		}
+	}
+	return records, nil
+}
+
+// evaluate checks the terms of one SPF record. policy is true when the
+// record's "all" mechanism decides the domain's policy, i.e. for the top-level
+// record and redirect targets but not for includes.
+func (w *spfWalk) evaluate(check, record string, policy bool) error {
+	add := func(severity Severity, format string, args ...interface{}) {
+		w.result.Findings = append(w.result.Findings, Finding{Severity: severity, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var redirect string
+	sawAll := false
+
+	for _, term := range strings.Fields(record)[1:] {
+		if sawAll {
+			add(SeverityWarning, "terms after 'all' are ignored: %s", term)
+			break
+		}
+
+		// Modifiers are name=value, where name cannot contain ':' or '/'
+		if name, value, found := strings.Cut(term, "="); found && !strings.ContainsAny(name, ":/") {
+			switch strings.ToLower(name) {
+			case "redirect":
+				if redirect != "" {
+					add(SeverityError, "redirect modifier appears more than once")
+				}
+				redirect = value
+				w.result.Lookups++
+			case "exp":
+				if value == "" {
+					add(SeverityError, "exp modifier has no domain")
+				}
+			default:
+				if name == "" || !isAlpha(name[0]) {
+					add(SeverityError, "invalid term '%s'", term)
+				}
+			}
+			continue
+		}
+
+		qualifier := byte('+')
+		if strings.IndexByte("+-~?", term[0]) >= 0 {
+			qualifier = term[0]
+			term = term[1:]
+		}
+
+		mechanism, argument := term, ""
+		if i := strings.IndexAny(term, ":/"); i >= 0 {
+			mechanism, argument = term[:i], term[i:]
+		}
+		domainSpec := strings.TrimPrefix(argument, ":")
+
+		switch strings.ToLower(mechanism) {
+		case "all":
+			if argument != "" {
+				add(SeverityError, "'all' does not take an argument: %s", term)
+			}
+			sawAll = true
+			if policy {
+				switch qualifier {
+				case '+':
+					add(SeverityError, "'+all' authorises every host on the internet to send mail")
+				case '?':
+					add(SeverityWarning, "'?all' gives unmatched senders a neutral result; prefer '~all' or '-all'")
+				}
+			}
+		case "include":
+			if !strings.HasPrefix(argument, ":") || domainSpec == "" {
+				add(SeverityError, "include requires a domain: %s", term)
+				continue
+			}
+			w.result.Lookups++
+			if err := w.expand("include", domainSpec, false); err != nil {
+				return err
+			}
+		case "a", "mx":
+			w.result.Lookups++
+			w.checkDomainAndCIDR(add, term, argument)
+		case "ptr":
+			w.result.Lookups++
+			add(SeverityWarning, "the 'ptr' mechanism is slow and deprecated (RFC 7208 section 5.5)")
+		case "exists":
+			if !strings.HasPrefix(argument, ":") || domainSpec == "" {
+				add(SeverityError, "exists requires a domain: %s", term)
+				continue
+			}
+			w.result.Lookups++
+		case "ip4", "ip6":
+			if !validIPArgument(mechanism, domainSpec) || !strings.HasPrefix(argument, ":") {
+				add(SeverityError, "invalid %s address or network: %s", strings.ToLower(mechanism), term)
+			}
+		default:
+			add(SeverityError, "unknown mechanism '%s'", mechanism)
+		}
+	}
+
+	if redirect != "" {
+		if sawAll {
+			add(SeverityInfo, "redirect=%s is ignored because the record has an 'all' mechanism", redirect)
+		} else if err := w.expand("redirect", redirect, policy); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if policy && !sawAll {
+		add(SeverityWarning, "no 'all' mechanism; unmatched senders get a neutral result")
+	}
+	return nil
+}
+
+// expand fetches and evaluates the SPF record of an include or redirect target
+func (w *spfWalk) expand(kind, target string, policy bool) error {
+	check := fmt.Sprintf("SPF %s:%s", kind, target)
+
+	if strings.Contains(target, "%") {
+		w.result.Findings = append(w.result.Findings, Finding{Severity: SeverityInfo, Check: check, Message: "target uses macros and was not expanded"})
+		return nil
+	}
+
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+	if err := errors.ValidateDomain(target); err != nil {
+		w.result.Findings = append(w.result.Findings, Finding{Severity: SeverityError, Check: check, Message: "invalid domain: " + errors.Message(err)})
+		return nil
+	}
+	if w.path[target] {
+		w.result.Findings = append(w.result.Findings, Finding{Severity: SeverityError, Check: check, Message: "include loop: the domain includes itself (permerror)"})
+		return nil
+	}
+	if w.result.Lookups > 2*MaxSPFLookups {
+		// Already far over the limit; stop querying
+		return nil
+	}
+
+	records, err := w.checker.spfRecords(target)
+	if err != nil {
+		return err
+	}
+	w.result.Includes = append(w.result.Includes, target)
+
+	switch {
+	case len(records) == 0:
+		w.result.Findings = append(w.result.Findings, Finding{Severity: SeverityError, Check: check, Message: "target has no SPF record (permerror)"})
+		return nil
+	case len(records) > 1:
+		w.result.Findings = append(w.result.Findings, Finding{Severity: SeverityError, Check: check, Message: fmt.Sprintf("target has %d SPF records (permerror)", len(records))})
+	}
+
+	w.path[target] = true
+	defer delete(w.path, target)
+
+	return w.evaluate(check, records[0], policy)
+}
+
+// checkDomainAndCIDR validates the optional ":domain" and "/cidr" parts of a or mx
+func (w *spfWalk) checkDomainAndCIDR(add func(Severity, string, ...interface{}), term, argument string) {
+	domainSpec := argument
+	cidr := ""
+	if i := strings.Index(argument, "/"); i >= 0 {
+		domainSpec, cidr = argument[:i], argument[i:]
+	}
+
+	if domainSpec != "" {
+		name := strings.TrimSuffix(strings.TrimPrefix(domainSpec, ":"), ".")
+		if !strings.HasPrefix(domainSpec, ":") || (!strings.Contains(name, "%") && errors.ValidateDomain(name) != nil) {
+			add(SeverityError, "invalid domain in '%s'", term)
+		}
+	}
+
+	if cidr != "" {
+		// Either /n for IPv4, //n for IPv6, or /n//m for both
+		for _, length := range strings.Split(strings.TrimPrefix(cidr, "/"), "//") {
+			if length == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR("::/" + length); err != nil {
+				add(SeverityError, "invalid prefix length in '%s'", term)
+				break
+			}
+		}
+	}
+}
+
+// validIPArgument reports whether value is a valid address or network for ip4/ip6
+func validIPArgument(mechanism, value string) bool {
+	address := value
+	if strings.Contains(value, "/") {
+		ip, _, err := net.ParseCIDR(value)
+		if err != nil {
+			return false
+		}
+		address = ip.String()
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	isIPv4 := ip.To4() != nil && !strings.Contains(address, ":")
+	return isIPv4 == strings.EqualFold(mechanism, "ip4")
+}
+
+// isAlpha reports whether b is an ASCII letter
+func isAlpha(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
@@ -0,0 +1,183 @@
+package mailcheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func evaluate(t *testing.T, records map[string][]string) *SPFResult {
+	t.Helper()
+	client := newFixture()
+	client.txt = records
+	result, err := NewChecker(client, "").evaluateSPF("example.com")
+	if err != nil {
+		t.Fatalf("evaluateSPF() error = %v, want nil", err)
+	}
+	return result
+}
+
+func findingMessages(findings []Finding) string {
+	var messages []string
+	for _, finding := range findings {
+		messages = append(messages, finding.Severity.String()+" "+finding.Check+": "+finding.Message)
+	}
+	return strings.Join(messages, "\n")
+}
+
+func TestChecker_EvaluateSPF(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  map[string][]string
+		lookups  int
+		expected []string // Substrings of expected findings; nil means none
+	}{
+		{
+			name:    "strict policy",
+			records: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.1 ip6:2001:db8::1 a mx/24 -all"}},
+			lookups: 2,
+		},
+		{
+			name:     "pass all",
+			records:  map[string][]string{"example.com": {"v=spf1 +all"}},
+			expected: []string{"ERROR SPF: '+all' authorises every host"},
+		},
+		{
+			name:     "neutral all",
+			records:  map[string][]string{"example.com": {"v=spf1 mx ?all"}},
+			lookups:  1,
+			expected: []string{"WARNING SPF: '?all' gives unmatched senders a neutral result"},
+		},
+		{
+			name:     "no all",
+			records:  map[string][]string{"example.com": {"v=spf1 mx"}},
+			lookups:  1,
+			expected: []string{"WARNING SPF: no 'all' mechanism"},
+		},
+		{
+			name:     "ptr and terms after all",
+			records:  map[string][]string{"example.com": {"v=spf1 ptr -all mx"}},
+			lookups:  1,
+			expected: []string{"'ptr' mechanism is slow and deprecated", "terms after 'all' are ignored: mx"},
+		},
+		{
+			name:    "syntax errors",
+			records: map[string][]string{"example.com": {"v=spf1 ip4:300.1.1.1 ip6:192.0.2.1 include foo:bar a:bad..name -all"}},
+			lookups: 1,
+			expected: []string{
+				"invalid ip4 address or network: ip4:300.1.1.1",
+				"invalid ip6 address or network: ip6:192.0.2.1",
+				"include requires a domain: include",
+				"unknown mechanism 'foo'",
+				"invalid domain in 'a:bad..name'",
+			},
+		},
+		{
+			name:     "multiple records",
+			records:  map[string][]string{"example.com": {"v=spf1 -all", "v=spf1 mx -all"}},
+			expected: []string{"ERROR SPF: 2 SPF records found"},
+		},
+		{
+			name: "include without SPF record",
+			records: map[string][]string{
+				"example.com":     {"v=spf1 include:missing.example -all"},
+				"missing.example": {"not spf"},
+			},
+			lookups:  1,
+			expected: []string{"ERROR SPF include:missing.example: target has no SPF record"},
+		},
+		{
+			name: "include loop",
+			records: map[string][]string{
+				"example.com": {"v=spf1 include:a.example -all"},
+				"a.example":   {"v=spf1 include:example.com ~all"},
+			},
+			lookups:  2,
+			expected: []string{"ERROR SPF include:example.com: include loop"},
+		},
+		{
+			name: "redirect decides the policy",
+			records: map[string][]string{
+				"example.com":      {"v=spf1 redirect=_spf.example.com"},
+				"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24 +all"},
+			},
+			lookups:  1,
+			expected: []string{"ERROR SPF redirect:_spf.example.com: '+all' authorises every host"},
+		},
+		{
+			name: "include does not decide the policy",
+			records: map[string][]string{
+				"example.com":      {"v=spf1 include:_spf.example.com -all"},
+				"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24 ?all"},
+			},
+			lookups: 1,
+		},
+		{
+			name:     "macro target",
+			records:  map[string][]string{"example.com": {"v=spf1 include:%{d}.spf.example -all"}},
+			lookups:  1,
+			expected: []string{"INFO SPF include:%{d}.spf.example: target uses macros"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(t, tt.records)
+			messages := findingMessages(result.Findings)
+
+			if result.Lookups != tt.lookups {
+				t.Errorf("Lookups = %d, want %d", result.Lookups, tt.lookups)
+			}
+			if tt.expected == nil && len(result.Findings) != 0 {
+				t.Errorf("Expected no findings, got:\n%s", messages)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(messages, want) {
+					t.Errorf("Expected finding %q, got:\n%s", want, messages)
+				}
+			}
+		})
+	}
+}
+
+func TestChecker_EvaluateSPF_LookupLimit(t *testing.T) {
+	// example.com includes four providers, each of which uses a, mx and exists
+	records := map[string][]string{}
+	var includes []string
+	for i := 1; i <= 4; i++ {
+		name := fmt.Sprintf("p%d.example", i)
+		includes = append(includes, "include:"+name)
+		records[name] = []string{"v=spf1 a mx exists:%{i}.check.example ~all"}
+	}
+	records["example.com"] = []string{"v=spf1 " + strings.Join(includes, " ") + " -all"}
+
+	result := evaluate(t, records)
+	if result.Lookups != 16 {
+		t.Errorf("Lookups = %d, want 16", result.Lookups)
+	}
+	if len(result.Includes) != 4 || result.Includes[0] != "p1.example" {
+		t.Errorf("Unexpected includes %v", result.Includes)
+	}
+
+	messages := findingMessages(result.Findings)
+	if !strings.Contains(messages, "ERROR SPF: 16 DNS lookups needed, exceeding the limit of 10") {
+		t.Errorf("Expected lookup limit finding, got:\n%s", messages)
+	}
+}
+
+func TestChecker_EvaluateSPF_LongRecord(t *testing.T) {
+	// Records longer than 255 bytes are split into several character strings
+	var terms []string
+	for i := 0; i < 30; i++ {
+		terms = append(terms, fmt.Sprintf("ip4:198.51.100.%d", i))
+	}
+	record := "v=spf1 " + strings.Join(terms, " ") + " -all"
+
+	result := evaluate(t, map[string][]string{"example.com": {record}})
+	if result.Record != record {
+		t.Errorf("Record = %q, want the joined record", result.Record)
+	}
+	if len(result.Findings) != 0 {
+		t.Errorf("Expected no findings, got:\n%s", findingMessages(result.Findings))
+	}
+}
@@ -0,0 +1,89 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"go-dig/pkg/errors"
+
+	"golang.org/x/term"
+)
+
+// ANSI styles of coloured text output
+const (
+	styleReset = "\x1b[0m"
+	styleName  = "\x1b[1;34m" // Bold blue owner names
+	styleTTL   = "\x1b[36m"   // Cyan TTLs
+	styleClass = "\x1b[2m"    // Dim classes, which are nearly always IN
+	styleType  = "\x1b[33m"   // Yellow record types
+	styleRdata = "\x1b[32m"   // Green record data
+	styleError = "\x1b[1;31m" // Bold red network, DNS and system errors
+	styleWarn  = "\x1b[1;33m" // Bold yellow input errors, which the user can correct
+)
+
+// Column styles of record lines with a TTL, and of answers, which have none
+var (
+	recordStyles = []string{styleName, styleTTL, styleClass, styleType, styleRdata}
+	answerStyles = []string{styleName, styleClass, styleType, styleRdata}
+)
+
+// columnSpace separates the aligned columns of coloured records
+const columnSpace = "  "
+
+// ColorEnabled reports whether text written to file should be coloured: it
+// is a terminal, NO_COLOR (https://no-color.org) is unset or empty, and TERM
+// is not "dumb"
+func ColorEnabled(file *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// paint wraps text in an ANSI style when colour is on
+func (f *formatter) paint(style, text string) string {
+	if !f.options.Color || text == "" {
+		return text
+	}
+	return style + text + styleReset
+}
+
+// errorStyle returns the style of the message of an error of the given type
+func errorStyle(errType errors.ErrorType) string {
+	if errType == errors.ErrorTypeInput {
+		return styleWarn
+	}
+	return styleError
+}
+
+// writeRecords writes record lines, each a row of columns with a style per
+// column. Plain output keeps the fixed layout, the owner name padded to 30
+// characters and the other columns separated by tabs. Coloured output pads
+// every column to its widest value in the section, so long names and record
+// data stay aligned.
+func (f *formatter) writeRecords(output *strings.Builder, rows [][]string, styles []string) {
+	if !f.options.Color {
+		for _, row := range rows {
+			output.WriteString(fmt.Sprintf("%-30s\t%s\n", row[0], strings.Join(row[1:], "\t")))
+		}
+		return
+	}
+
+	widths := make([]int, len(styles))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			output.WriteString(f.paint(styles[i], cell))
+			if i < len(row)-1 {
+				output.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + columnSpace)
+			}
+		}
+		output.WriteString("\n")
+	}
+}
@@ -0,0 +1,86 @@
+package output
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+func TestColorEnabled(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	t.Setenv("NO_COLOR", "")
+	if ColorEnabled(writer) {
+		t.Error("Expected no colour for a pipe")
+	}
+
+	// NO_COLOR and a dumb terminal turn colour off before the terminal is checked
+	t.Setenv("NO_COLOR", "1")
+	if ColorEnabled(os.Stdout) {
+		t.Error("Expected no colour with NO_COLOR set")
+	}
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "dumb")
+	if ColorEnabled(os.Stdout) {
+		t.Error("Expected no colour for a dumb terminal")
+	}
+}
+
+func TestFormatResult_Color(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Records:    []string{"192.0.2.1", "192.0.2.10"},
+		Chain: []dns.Record{
+			{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "a-very-long-alias-name-beyond-thirty-characters.example.net."},
+			{Name: "a-very-long-alias-name-beyond-thirty-characters.example.net.", Type: "CNAME", TTL: 60, Value: "edge.example.net."},
+		},
+		Server: "192.0.2.53:53",
+	}
+
+	output := NewFormatterWithOptions(Options{Color: true}).FormatResult(result)
+	for _, element := range []string{
+		// Names are padded to the longest owner name in the chain
+		styleName + "www.example.com." + styleReset + strings.Repeat(" ", 44) + columnSpace + styleTTL + "300" + styleReset,
+		styleName + "a-very-long-alias-name-beyond-thirty-characters.example.net." + styleReset + columnSpace + styleTTL + "60" + styleReset + " " + columnSpace,
+		styleType + "CNAME" + styleReset + columnSpace + styleRdata + "edge.example.net." + styleReset + "\n",
+		// Answers are aligned on their own
+		styleName + "edge.example.net." + styleReset + columnSpace + styleClass + "IN" + styleReset,
+		styleRdata + "192.0.2.10" + styleReset + "\n",
+	} {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain %q, but it didn't.\nActual output:\n%q", element, output)
+		}
+	}
+
+	// Without colour the output keeps its fixed layout and has no escapes
+	plain := NewFormatter().FormatResult(result)
+	if strings.Contains(plain, "\x1b[") {
+		t.Errorf("Expected no escape sequences, got %q", plain)
+	}
+	if want := "www.example.com.              \t300\tIN\tCNAME\ta-very-long-alias-name-beyond-thirty-characters.example.net.\n"; !strings.Contains(plain, want) {
+		t.Errorf("Expected plain output to contain %q, got:\n%s", want, plain)
+	}
+}
+
+func TestFormatError_Color(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Color: true})
+
+	output := formatter.FormatError(errors.NewDNSError("domain 'example.com' not found (NXDOMAIN)", nil, "example.com", ""))
+	if !strings.HasPrefix(output, styleError+"Error: domain 'example.com' not found (NXDOMAIN)"+styleReset+"\n") {
+		t.Errorf("Expected a red DNS error, got %q", output)
+	}
+
+	output = formatter.FormatError(errors.NewInputError("invalid domain", nil))
+	if !strings.HasPrefix(output, styleWarn+"Error: invalid domain"+styleReset+"\n") {
+		t.Errorf("Expected a yellow input error, got %q", output)
+	}
+}
@@ -2,27 +2,72 @@ package output
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"go-dig/pkg/dns"
 	"go-dig/pkg/errors"
+	"go-dig/pkg/idn"
 )
 
-// Formatter interface defines output formatting functionality
+// Formatter interface defines output formatting of query results and errors.
+// The reports of the other commands are formatted by plain functions such as
+// FormatDelegation and FormatCapture.
 type Formatter interface {
 	FormatResult(result *dns.Result) string
 	FormatError(err error) string
 }
 
+// Output formats selectable with Options.Format
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatZone = "zone" // Answers as zone-file records; everything else as text
+	FormatCSV  = "csv"  // Query results as comma-separated rows; everything else as text
+	FormatTSV  = "tsv"  // Query results as tab-separated rows; everything else as text
+)
+
+// Tabular reports whether a format writes query results as rows of a table,
+// which keeps failures in the table next to the results
+func Tabular(format string) bool {
+	return format == FormatCSV || format == FormatTSV
+}
+
+// Options controls the output format and how names are displayed
+type Options struct {
+	Format       string // FormatText (the default when empty), FormatJSON, FormatZone, FormatCSV or FormatTSV
+	UnicodeNames bool   // Show internationalized names as U-labels instead of xn-- A-labels
+	Color        bool   // Colour text output and align records for a terminal (see ColorEnabled)
+	JSONLines    bool   // Write each JSON document on a line of its own (JSON Lines) instead of indented
+}
+
 // formatter implements the Formatter interface
-type formatter struct{}
+type formatter struct {
+	options Options
+}
 
 // NewFormatter creates a new output formatter
 func NewFormatter() Formatter {
 	return &formatter{}
 }
 
+// NewFormatterWithOptions creates an output formatter with the given format
+// and display options
+func NewFormatterWithOptions(options Options) Formatter {
+	switch options.Format {
+	case FormatJSON:
+		return &jsonFormatter{formatter: &formatter{options: options}}
+	case FormatZone:
+		return &zoneFormatter{formatter: &formatter{options: options}}
+	case FormatCSV:
+		return &tableFormatter{formatter: &formatter{options: options}, comma: ','}
+	case FormatTSV:
+		return &tableFormatter{formatter: &formatter{options: options}, comma: '\t'}
+	}
+	return &formatter{options: options}
+}
+
 // FormatResult formats a successful DNS query result for display
 func (f *formatter) FormatResult(result *dns.Result) string {
 	if result == nil {
@@ -37,7 +82,12 @@ func (f *formatter) FormatResult(result *dns.Result) string {
 	var output strings.Builder
 
 	// Header with query information - show what was queried and where
-	output.WriteString(fmt.Sprintf("; <<>> go-dig <<>> %s %s", result.Domain, result.RecordType))
+	class := resultClass(result)
+	if class == "IN" {
+		output.WriteString(fmt.Sprintf("; <<>> go-dig <<>> %s %s", f.displayName(result.Domain), result.RecordType))
+	} else {
+		output.WriteString(fmt.Sprintf("; <<>> go-dig <<>> %s %s %s", f.displayName(result.Domain), class, result.RecordType))
+	}
 	if result.Server != "" {
 		output.WriteString(fmt.Sprintf(" @%s", strings.TrimSuffix(result.Server, ":53")))
 	}
@@ -47,12 +97,49 @@ func (f *formatter) FormatResult(result *dns.Result) string {
 	output.WriteString(fmt.Sprintf(";; Query time: %v\n", formatDuration(result.QueryTime)))
 	output.WriteString(fmt.Sprintf(";; SERVER: %s\n", result.Server))
 	output.WriteString(fmt.Sprintf(";; WHEN: %s\n", time.Now().Format("Mon Jan 02 15:04:05 MST 2006")))
+	if flags := formatFlags(result); flags != "" {
+		output.WriteString(fmt.Sprintf(";; %s\n", flags))
+	}
+	if result.NoData {
+		output.WriteString(";; ->>HEADER<<- status: NOERROR, ANSWER: 0 (NODATA)\n")
+	}
+	for _, extended := range result.ExtendedErrors {
+		output.WriteString(fmt.Sprintf(";; %s\n", extended))
+	}
+	if result.NSID != "" {
+		output.WriteString(fmt.Sprintf(";; NSID: %s\n", formatNSID(result.NSID)))
+	}
+	if result.Cookie != nil {
+		output.WriteString(fmt.Sprintf(";; COOKIE: %s\n", formatCookie(result.Cookie)))
+	}
 	output.WriteString("\n")
 
+	// Aliases followed on the way to the answer, one hop per line
+	if len(result.Chain) > 0 {
+		output.WriteString(fmt.Sprintf(";; CNAME CHAIN: (%d alias", len(result.Chain)))
+		if len(result.Chain) != 1 {
+			output.WriteString("es")
+		}
+		output.WriteString(")\n")
+		var rows [][]string
+		for _, hop := range result.Chain {
+			rows = append(rows, []string{f.displayName(hop.Name), strconv.FormatUint(uint64(hop.TTL), 10), class, "CNAME", f.displayName(hop.Value)})
+		}
+		f.writeRecords(&output, rows, recordStyles)
+		output.WriteString("\n")
+	}
+
 	// Answer section with record count
 	recordCount := len(result.Records)
 	if recordCount == 0 {
 		output.WriteString(";; ANSWER SECTION: (empty)\n")
+		if result.NoData {
+			output.WriteString(f.formatNoData(result))
+		} else if result.Unresolved {
+			output.WriteString(fmt.Sprintf("\n;; %s was not resolved by the server; +follow chases the chain\n", f.displayName(ownerName(result))))
+		} else if len(result.Referral) > 0 {
+			output.WriteString(f.formatReferral(result))
+		}
 	} else {
 		output.WriteString(fmt.Sprintf(";; ANSWER SECTION: (%d record", recordCount))
 		if recordCount != 1 {
@@ -61,37 +148,130 @@ func (f *formatter) FormatResult(result *dns.Result) string {
 		output.WriteString(")\n")
 
 		// Format records based on type
+		var rows [][]string
 		for _, record := range result.Records {
 			formattedRecord := f.formatRecordValue(result.RecordType, record)
-			output.WriteString(fmt.Sprintf("%-30s\tIN\t%s\t%s\n",
-				result.Domain, result.RecordType, formattedRecord))
+			rows = append(rows, []string{f.displayName(ownerName(result)), class, result.RecordType, formattedRecord})
 		}
+		f.writeRecords(&output, rows, answerStyles)
 	}
 
 	return output.String()
 }
 
+// resultClass returns the class of a query, which is IN for results that
+// do not name one
+func resultClass(result *dns.Result) string {
+	if result.Class == "" {
+		return "IN"
+	}
+	return result.Class
+}
+
+// formatFlags shows the header flags of the response like dig, with the
+// opcode when it is not QUERY; results without a response show nothing
+func formatFlags(result *dns.Result) string {
+	if result.Flags == nil {
+		return ""
+	}
+	flags := fmt.Sprintf("flags: %s", strings.Join(result.Flags, " "))
+	if result.Opcode != "" && result.Opcode != "QUERY" {
+		flags += fmt.Sprintf("; opcode: %s", result.Opcode)
+	}
+	return flags
+}
+
+// formatNSID shows a name server identifier in hex like dig, followed by
+// its text when it is printable
+func formatNSID(nsid string) string {
+	formatted := fmt.Sprintf("% x", nsid)
+	for _, c := range nsid {
+		if c < ' ' || c > '~' {
+			return formatted
+		}
+	}
+	return fmt.Sprintf("%s (%q)", formatted, nsid)
+}
+
+// formatCookie shows the DNS cookies exchanged with the server and the status
+// of the one it returned
+func formatCookie(cookie *dns.CookieResult) string {
+	status := cookie.Status
+	if cookie.BadCookie {
+		status += ", sent again after BADCOOKIE"
+	}
+	if cookie.Server == "" {
+		return fmt.Sprintf("client %s, no server cookie (%s)", cookie.Client, status)
+	}
+	return fmt.Sprintf("client %s, server %s (%s)", cookie.Client, cookie.Server, status)
+}
+
+// ownerName returns the name the answers belong to: the end of the CNAME
+// chain, or the queried domain if there is none
+func ownerName(result *dns.Result) string {
+	if len(result.Chain) > 0 {
+		return result.Chain[len(result.Chain)-1].Value
+	}
+	return result.Domain
+}
+
+// formatNoData describes a NODATA answer and the SOA that controls how long it is cached
+func (f *formatter) formatNoData(result *dns.Result) string {
+	var output strings.Builder
+
+	if result.SOA != nil {
+		output.WriteString("\n;; AUTHORITY SECTION:\n")
+		soa := []string{f.displayName(result.SOA.Name), strconv.FormatUint(uint64(result.SOA.TTL), 10), resultClass(result), "SOA", result.SOA.Value}
+		f.writeRecords(&output, [][]string{soa}, recordStyles)
+	}
+
+	output.WriteString(fmt.Sprintf("\n;; %s exists but has no %s records", f.displayName(ownerName(result)), result.RecordType))
+	if result.SOA != nil {
+		output.WriteString(fmt.Sprintf("; negative answer cached for %ds", result.NegativeTTL))
+	}
+	output.WriteString("\n")
+
+	return output.String()
+}
+
+// formatReferral shows the name servers a server without the zone referred the query to
+func (f *formatter) formatReferral(result *dns.Result) string {
+	var output strings.Builder
+
+	output.WriteString("\n;; AUTHORITY SECTION:\n")
+	var rows [][]string
+	for _, ns := range result.Referral {
+		rows = append(rows, []string{f.displayName(ns.Name), strconv.FormatUint(uint64(ns.TTL), 10), resultClass(result), "NS", f.displayName(ns.Value)})
+	}
+	f.writeRecords(&output, rows, recordStyles)
+
+	output.WriteString(fmt.Sprintf("\n;; Referral: the server does not answer for %s and refers to the name servers of %s; query one of them or a recursive resolver\n",
+		f.displayName(ownerName(result)), f.displayName(result.Referral[0].Name)))
+
+	return output.String()
+}
+
 // FormatError formats error messages for display with enhanced error handling
 func (f *formatter) FormatError(err error) string {
 	if err == nil {
 		return ""
 	}
 
-	// Check if it's a DigError for enhanced formatting
-	if digErr, ok := err.(*errors.DigError); ok {
+	// Check if it is, or wraps, a DigError for enhanced formatting
+	if digErr, ok := errors.AsDigError(err); ok {
 		return f.formatDigError(digErr)
 	}
 
 	// Fallback for other error types
-	return fmt.Sprintf("Error: %s\n", err.Error())
+	return f.paint(styleError, fmt.Sprintf("Error: %s", err.Error())) + "\n"
 }
 
 // formatDigError formats DigError with context-specific information
 func (f *formatter) formatDigError(digErr *errors.DigError) string {
 	var output strings.Builder
 
-	// Write the main error message
-	output.WriteString(fmt.Sprintf("Error: %s\n", digErr.Message))
+	// Write the main error message, coloured by severity
+	output.WriteString(f.paint(errorStyle(digErr.Type), fmt.Sprintf("Error: %s", digErr.Message)) + "\n")
 
 	// Add context-specific information based on error type
 	switch digErr.Type {
@@ -134,6 +314,17 @@ func (f *formatter) formatDigError(digErr *errors.DigError) string {
 		output.WriteString("- Try running as administrator if needed\n")
 	}
 
+	// Explain Extended DNS Errors, which are more specific than the rcode
+	if len(digErr.ExtendedErrors) > 0 {
+		output.WriteString("\nThe server explained the failure (Extended DNS Errors):\n")
+		for _, extended := range digErr.ExtendedErrors {
+			output.WriteString(fmt.Sprintf("- %s\n", extended))
+			if advice := extendedErrorAdvice(extended.Code); advice != "" {
+				output.WriteString(fmt.Sprintf("  %s\n", advice))
+			}
+		}
+	}
+
 	// Add underlying cause if available
 	if digErr.Cause != nil {
 		output.WriteString(fmt.Sprintf("\nUnderlying cause: %v\n", digErr.Cause))
@@ -142,6 +333,42 @@ func (f *formatter) formatDigError(digErr *errors.DigError) string {
 	return output.String()
 }
 
+// extendedErrorAdvice returns troubleshooting advice for an Extended DNS Error
+// info code, or an empty string if there is nothing specific to suggest
+func extendedErrorAdvice(code uint16) string {
+	switch code {
+	case 1, 2:
+		return "The zone is signed with an algorithm this resolver does not support, so it is treated as unsigned."
+	case 3, 19:
+		return "The resolver served an expired cached answer because it could not reach the domain's nameservers; check them with 'go-dig check-delegation'."
+	case 4:
+		return "The resolver deliberately replaced the real answer; query a different resolver to see the original."
+	case 5, 6, 9, 10, 11, 12, 27:
+		return "DNSSEC validation failed: check that the DS record at the parent matches the zone's DNSKEY and that the zone is correctly signed."
+	case 7, 25:
+		return "The zone's DNSSEC signatures have expired; the zone operator must re-sign the zone."
+	case 8:
+		return "The zone's DNSSEC signatures are not valid yet; check the clocks of the signer and the resolver."
+	case 13:
+		return "The resolver is returning a cached failure; retry after the negative cache time or query another resolver."
+	case 14:
+		return "The resolver is still starting up; retry in a moment."
+	case 15, 16, 17:
+		return "The resolver's filtering policy blocked this name; query a different resolver to bypass the filter."
+	case 18:
+		return "The resolver does not accept queries from this client; use a resolver you are permitted to query."
+	case 20:
+		return "The server is not authoritative for this name and does not recurse; query a recursive resolver instead."
+	case 21:
+		return "The server does not support this kind of query; try a different DNS server."
+	case 22, 23:
+		return "The resolver could not reach any of the domain's nameservers; check them with 'go-dig check-delegation'."
+	case 24:
+		return "The domain's nameservers returned invalid data; contact the zone operator."
+	}
+	return ""
+}
+
 // formatRecordValue formats a record value based on its type for proper display
 func (f *formatter) formatRecordValue(recordType, value string) string {
 	switch strings.ToUpper(recordType) {
@@ -150,13 +377,18 @@ func (f *formatter) formatRecordValue(recordType, value string) string {
 		return value
 	case "MX":
 		// MX records already include priority from DNS client
+		if preference, exchange, found := strings.Cut(value, " "); found {
+			return preference + " " + f.displayName(exchange)
+		}
 		return value
 	case "CNAME":
 		// CNAME records should end with a dot if they don't already
 		if !strings.HasSuffix(value, ".") {
-			return value + "."
+			value += "."
 		}
-		return value
+		return f.displayName(value)
+	case "NS":
+		return f.displayName(value)
 	case "TXT":
 		// TXT records should be quoted if they contain spaces or special characters
 		if strings.Contains(value, " ") || strings.ContainsAny(value, "\"'\\") {
@@ -171,6 +403,14 @@ func (f *formatter) formatRecordValue(recordType, value string) string {
 	}
 }
 
+// displayName returns a domain name in the form selected by the options
+func (f *formatter) displayName(name string) string {
+	if f.options.UnicodeNames {
+		return idn.ToUnicode(name)
+	}
+	return name
+}
+
 // formatDuration formats duration in a human-readable way similar to dig
 func formatDuration(d time.Duration) string {
 	if d < time.Millisecond {
@@ -48,6 +48,84 @@ func TestFormatResult_Success(t *testing.T) {
 	}
 }
 
+func TestFormatResult_ChaosWithNSID(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "hostname.bind",
+		RecordType: "TXT",
+		Class:      "CH",
+		Records:    []string{"fra-3"},
+		NSID:       "fra",
+		Server:     "192.0.2.53:53",
+	}
+
+	output := NewFormatter().FormatResult(result)
+	for _, element := range []string{
+		"; <<>> go-dig <<>> hostname.bind CH TXT @192.0.2.53\n",
+		";; NSID: 66 72 61 (\"fra\")\n",
+		"hostname.bind                 \tCH\tTXT\t",
+	} {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain %q, but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	// An identifier that is not text is only shown in hex
+	result.NSID = "\x01\xff"
+	if output := NewFormatter().FormatResult(result); !strings.Contains(output, ";; NSID: 01 ff\n") {
+		t.Errorf("Expected a hex-only NSID, got:\n%s", output)
+	}
+}
+
+func TestFormatResult_Flags(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "example.com",
+		RecordType: "A",
+		Records:    []string{"192.0.2.1"},
+		Flags:      []string{"qr", "aa", "cd"},
+		Opcode:     "QUERY",
+		Server:     "192.0.2.53:53",
+	}
+
+	output := NewFormatter().FormatResult(result)
+	if !strings.Contains(output, ";; flags: qr aa cd\n") {
+		t.Errorf("Expected the response flags in the header, got:\n%s", output)
+	}
+
+	result.Opcode = "NOTIFY"
+	if output := NewFormatter().FormatResult(result); !strings.Contains(output, ";; flags: qr aa cd; opcode: NOTIFY\n") {
+		t.Errorf("Expected the opcode after the flags, got:\n%s", output)
+	}
+
+	// Results built without a response have no flags line
+	result.Flags = nil
+	if output := NewFormatter().FormatResult(result); strings.Contains(output, "flags:") {
+		t.Errorf("Expected no flags line, got:\n%s", output)
+	}
+}
+
+func TestFormatResult_Cookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie dns.CookieResult
+		want   string
+	}{
+		{"valid", dns.CookieResult{Client: "0102030405060708", Server: "a1a2a3a4a5a6a7a8", Status: dns.CookieValid},
+			";; COOKIE: client 0102030405060708, server a1a2a3a4a5a6a7a8 (valid)\n"},
+		{"after BADCOOKIE", dns.CookieResult{Client: "0102030405060708", Server: "a1a2a3a4a5a6a7a8", Status: dns.CookieValid, BadCookie: true},
+			";; COOKIE: client 0102030405060708, server a1a2a3a4a5a6a7a8 (valid, sent again after BADCOOKIE)\n"},
+		{"missing", dns.CookieResult{Client: "0102030405060708", Status: dns.CookieMissing},
+			";; COOKIE: client 0102030405060708, no server cookie (missing)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := NewFormatter().FormatResult(&dns.Result{Domain: "example.com", RecordType: "A", Records: []string{"192.0.2.1"}, Cookie: &tt.cookie})
+			if !strings.Contains(output, tt.want) {
+				t.Errorf("Expected output to contain %q, but it didn't.\nActual output:\n%s", tt.want, output)
+			}
+		})
+	}
+}
+
 func TestFormatResult_MultipleRecords(t *testing.T) {
 	formatter := NewFormatter()
 
@@ -558,6 +636,191 @@ func TestFormatResult_EmptyRecords(t *testing.T) {
 	}
 }
 
+func TestFormatResult_NoData(t *testing.T) {
+	formatter := NewFormatter()
+
+	result := &dns.Result{
+		Domain:      "www.example.com",
+		RecordType:  "AAAA",
+		Rcode:       "NOERROR",
+		NoData:      true,
+		SOA:         &dns.Record{Name: "example.com.", Type: "SOA", TTL: 3600, Value: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"},
+		NegativeTTL: 300,
+		Server:      "8.8.8.8:53",
+		QueryTime:   20 * time.Millisecond,
+	}
+
+	output := formatter.FormatResult(result)
+
+	expectedElements := []string{
+		";; ->>HEADER<<- status: NOERROR, ANSWER: 0 (NODATA)",
+		";; ANSWER SECTION: (empty)",
+		";; AUTHORITY SECTION:",
+		fmt.Sprintf("%-30s\t3600\tIN\tSOA\tns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", "example.com."),
+		";; www.example.com exists but has no AAAA records; negative answer cached for 300s",
+	}
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	// Without an SOA the authority section and cache time are omitted
+	result.SOA = nil
+	result.NegativeTTL = 0
+	output = formatter.FormatResult(result)
+	if strings.Contains(output, "AUTHORITY SECTION") || strings.Contains(output, "cached for") {
+		t.Errorf("Expected no authority details without an SOA, got:\n%s", output)
+	}
+	if !strings.Contains(output, ";; www.example.com exists but has no AAAA records\n") {
+		t.Errorf("Expected NODATA note, got:\n%s", output)
+	}
+}
+
+func TestFormatResult_Unresolved(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "alias.example.com",
+		RecordType: "A",
+		Rcode:      "NOERROR",
+		Chain:      []dns.Record{{Name: "alias.example.com.", Type: "CNAME", TTL: 300, Value: "www.example.net."}},
+		Unresolved: true,
+		Server:     "192.0.2.53:53",
+	}
+
+	output := NewFormatter().FormatResult(result)
+	if !strings.Contains(output, ";; www.example.net. was not resolved by the server; +follow chases the chain\n") {
+		t.Errorf("Expected the unresolved target, got:\n%s", output)
+	}
+	if strings.Contains(output, "NODATA") || strings.Contains(output, "has no A records") {
+		t.Errorf("Expected an unresolved chain not to be shown as NODATA, got:\n%s", output)
+	}
+}
+
+// referralResult is a +norecurse answer referring www.example.com to the servers of example.com
+func referralResult() *dns.Result {
+	return &dns.Result{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Rcode:      "NOERROR",
+		Referral: []dns.Record{
+			{Name: "example.com.", Type: "NS", TTL: 172800, Value: "a.iana-servers.net.", Data: "a.iana-servers.net."},
+			{Name: "example.com.", Type: "NS", TTL: 172800, Value: "b.iana-servers.net.", Data: "b.iana-servers.net."},
+		},
+		Server: "192.0.2.53:53",
+	}
+}
+
+func TestFormatResult_Referral(t *testing.T) {
+	output := NewFormatter().FormatResult(referralResult())
+
+	expectedElements := []string{
+		";; ANSWER SECTION: (empty)",
+		";; AUTHORITY SECTION:",
+		fmt.Sprintf("%-30s\t172800\tIN\tNS\ta.iana-servers.net.", "example.com."),
+		";; Referral: the server does not answer for www.example.com and refers to the name servers of example.com.",
+	}
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+}
+
+func TestFormatDigError_ExtendedErrors(t *testing.T) {
+	formatter := NewFormatter()
+
+	err := errors.NewDNSError("DNS server experienced an internal failure", nil, "example.com", "8.8.8.8:53").
+		WithKind(errors.ErrServFail).
+		WithExtendedErrors([]errors.ExtendedError{
+			{Code: 6, Text: "signature expired"},
+			{Code: 0},
+		})
+
+	output := formatter.FormatError(err)
+
+	expectedElements := []string{
+		"Error: DNS server experienced an internal failure",
+		"The server explained the failure (Extended DNS Errors):",
+		"- EDE 6 (DNSSEC Bogus): signature expired\n  DNSSEC validation failed",
+		"- EDE 0 (Other Error)\n",
+	}
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	if output := formatter.FormatError(errors.NewDNSError("DNS server refused the query", nil, "example.com", "")); strings.Contains(output, "Extended DNS Errors") {
+		t.Errorf("Expected no extended error section without EDE, got:\n%s", output)
+	}
+}
+
+func TestFormatResult_ExtendedErrors(t *testing.T) {
+	formatter := NewFormatter()
+
+	result := &dns.Result{
+		Domain:         "example.com",
+		RecordType:     "A",
+		Records:        []string{"192.0.2.1"},
+		Server:         "8.8.8.8:53",
+		ExtendedErrors: []errors.ExtendedError{{Code: 3, Text: "served from cache"}},
+	}
+
+	output := formatter.FormatResult(result)
+	if !strings.Contains(output, ";; EDE 3 (Stale Answer): served from cache\n") {
+		t.Errorf("Expected stale answer note, got:\n%s", output)
+	}
+}
+
+func TestExtendedErrorAdvice(t *testing.T) {
+	for code := uint16(1); code <= 24; code++ {
+		if extendedErrorAdvice(code) == "" {
+			t.Errorf("Expected advice for EDE %d", code)
+		}
+	}
+	if advice := extendedErrorAdvice(0); advice != "" {
+		t.Errorf("Expected no advice for Other Error, got %q", advice)
+	}
+}
+
+func TestFormatResult_CNAMEChain(t *testing.T) {
+	formatter := NewFormatter()
+
+	result := &dns.Result{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Records:    []string{"192.0.2.7"},
+		Chain: []dns.Record{
+			{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "cdn.example.net."},
+			{Name: "cdn.example.net.", Type: "CNAME", TTL: 60, Value: "edge.example.org."},
+		},
+		Server: "8.8.8.8:53",
+	}
+
+	output := formatter.FormatResult(result)
+
+	expectedElements := []string{
+		";; CNAME CHAIN: (2 aliases)\n",
+		fmt.Sprintf("%-30s\t300\tIN\tCNAME\tcdn.example.net.\n", "www.example.com."),
+		fmt.Sprintf("%-30s\t60\tIN\tCNAME\tedge.example.org.\n", "cdn.example.net."),
+		";; ANSWER SECTION: (1 record)",
+		fmt.Sprintf("%-30s\tIN\tA\t192.0.2.7\n", "edge.example.org."),
+	}
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+	if strings.Index(output, "CNAME CHAIN") > strings.Index(output, "ANSWER SECTION") {
+		t.Error("Expected the chain to precede the answer section")
+	}
+
+	result.Chain = result.Chain[:1]
+	if output := formatter.FormatResult(result); !strings.Contains(output, ";; CNAME CHAIN: (1 alias)\n") {
+		t.Errorf("Expected singular alias count, got:\n%s", output)
+	}
+}
+
 func TestFormatResult_NoServerSpecified(t *testing.T) {
 	formatter := NewFormatter()
 
@@ -720,3 +983,28 @@ func TestFormatResult_ConsistentDomainFormatting(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatResult_UnicodeNames(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "xn--mnchen-3ya.de",
+		RecordType: "MX",
+		Records:    []string{"10 mail.xn--mnchen-3ya.de."},
+		Server:     "8.8.8.8:53",
+	}
+
+	output := NewFormatter().FormatResult(result)
+	if !strings.Contains(output, "go-dig <<>> xn--mnchen-3ya.de MX") || !strings.Contains(output, "10 mail.xn--mnchen-3ya.de.") {
+		t.Errorf("Expected A-labels by default, got:\n%s", output)
+	}
+
+	output = NewFormatterWithOptions(Options{UnicodeNames: true}).FormatResult(result)
+	expectedElements := []string{
+		"; <<>> go-dig <<>> münchen.de MX",
+		fmt.Sprintf("%-30s\tIN\tMX\t10 mail.münchen.de.", "münchen.de"),
+	}
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+	"go-dig/pkg/pcap"
+
+	mdns "github.com/miekg/dns"
+)
+
+// jsonFormatter prints query results and errors as JSON documents. The
+// reports of the other commands keep their text form.
+type jsonFormatter struct {
+	*formatter
+}
+
+// jsonRecord is a resource record in JSON output
+type jsonRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+// jsonExtendedError is an Extended DNS Error in JSON output
+type jsonExtendedError struct {
+	Code uint16 `json:"code"`
+	Name string `json:"name"`
+	Text string `json:"text,omitempty"`
+}
+
+// jsonResult is the JSON document for a successful query
+type jsonResult struct {
+	Domain         string              `json:"domain"`
+	Type           string              `json:"type"`
+	Class          string              `json:"class,omitempty"`
+	Server         string              `json:"server"`
+	Status         string              `json:"status,omitempty"`
+	Opcode         string              `json:"opcode,omitempty"`
+	Flags          []string            `json:"flags,omitempty"`
+	QueryTimeMs    float64             `json:"query_time_ms"`
+	Chain          []jsonRecord        `json:"chain"`
+	Answers        []jsonRecord        `json:"answers"`
+	NoData         bool                `json:"nodata,omitempty"`
+	SOA            *jsonRecord         `json:"soa,omitempty"`
+	NegativeTTL    uint32              `json:"negative_ttl,omitempty"`
+	Unresolved     bool                `json:"unresolved,omitempty"`
+	Referral       []jsonRecord        `json:"referral,omitempty"`
+	ExtendedErrors []jsonExtendedError `json:"extended_errors,omitempty"`
+	NSID           *jsonNSID           `json:"nsid,omitempty"`
+	Cookie         *jsonCookie         `json:"cookie,omitempty"`
+}
+
+// jsonCookie is the DNS cookie exchange of a query in JSON output
+type jsonCookie struct {
+	Client    string `json:"client"`
+	Server    string `json:"server,omitempty"`
+	Status    string `json:"status"`
+	BadCookie bool   `json:"badcookie,omitempty"`
+}
+
+// jsonNSID is a name server identifier in JSON output
+type jsonNSID struct {
+	Hex  string `json:"hex"`
+	Text string `json:"text"`
+}
+
+// jsonError is the JSON document for a failed query
+type jsonError struct {
+	Type           string              `json:"type"`
+	Kind           string              `json:"kind,omitempty"`
+	Message        string              `json:"message"`
+	Domain         string              `json:"domain,omitempty"`
+	Server         string              `json:"server,omitempty"`
+	Cause          string              `json:"cause,omitempty"`
+	ExtendedErrors []jsonExtendedError `json:"extended_errors,omitempty"`
+}
+
+// jsonPacket is a DNS message read from a packet capture
+type jsonPacket struct {
+	Time    string       `json:"time"`
+	Network string       `json:"network"`
+	Src     string       `json:"src"`
+	Dst     string       `json:"dst"`
+	Size    int          `json:"size"`
+	Message *jsonMessage `json:"message,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// jsonMessage is a DNS message in JSON output
+type jsonMessage struct {
+	ID         uint16         `json:"id"`
+	Opcode     string         `json:"opcode"`
+	Status     string         `json:"status"`
+	Flags      []string       `json:"flags"`
+	Question   []jsonQuestion `json:"question"`
+	Answer     []jsonRecord   `json:"answer"`
+	Authority  []jsonRecord   `json:"authority"`
+	Additional []jsonRecord   `json:"additional"`
+	EDNS       *jsonEDNS      `json:"edns,omitempty"`
+}
+
+// jsonQuestion is an entry of the question section in JSON output
+type jsonQuestion struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+// jsonEDNS is the OPT pseudo-record of a message in JSON output
+type jsonEDNS struct {
+	Version uint8  `json:"version"`
+	UDPSize uint16 `json:"udp_size"`
+	DO      bool   `json:"do"`
+}
+
+// FormatResult formats a DNS query result as a JSON object
+func (f *jsonFormatter) FormatResult(result *dns.Result) string {
+	if result == nil {
+		return f.FormatError(fmt.Errorf("no result to format"))
+	}
+	if result.Error != nil {
+		return f.FormatError(result.Error)
+	}
+
+	document := jsonResult{
+		Domain:         f.displayName(result.Domain),
+		Type:           result.RecordType,
+		Server:         result.Server,
+		Status:         result.Rcode,
+		Flags:          result.Flags,
+		QueryTimeMs:    float64(result.QueryTime.Microseconds()) / 1000,
+		Chain:          []jsonRecord{},
+		Answers:        []jsonRecord{},
+		NoData:         result.NoData,
+		NegativeTTL:    result.NegativeTTL,
+		Unresolved:     result.Unresolved,
+		ExtendedErrors: f.jsonExtendedErrors(result.ExtendedErrors),
+	}
+
+	for _, hop := range result.Chain {
+		document.Chain = append(document.Chain, f.jsonRecord(hop))
+	}
+	for _, ns := range result.Referral {
+		document.Referral = append(document.Referral, f.jsonRecord(ns))
+	}
+
+	if len(result.Answers) > 0 {
+		for _, answer := range result.Answers {
+			document.Answers = append(document.Answers, f.jsonRecord(answer))
+		}
+	} else {
+		// Results built without structured answers only carry the values
+		for _, value := range result.Records {
+			document.Answers = append(document.Answers, f.jsonRecord(dns.Record{Name: ownerName(result), Type: result.RecordType, Value: value}))
+		}
+	}
+
+	if result.SOA != nil {
+		soa := f.jsonRecord(*result.SOA)
+		document.SOA = &soa
+	}
+	// Class IN, which nearly every query uses, is left out
+	if class := resultClass(result); class != "IN" {
+		document.Class = class
+	}
+	// Like the class, the usual QUERY opcode is left out
+	if result.Opcode != "QUERY" {
+		document.Opcode = result.Opcode
+	}
+	if result.NSID != "" {
+		document.NSID = &jsonNSID{Hex: fmt.Sprintf("%x", result.NSID), Text: result.NSID}
+	}
+	if cookie := result.Cookie; cookie != nil {
+		document.Cookie = &jsonCookie{Client: cookie.Client, Server: cookie.Server, Status: cookie.Status, BadCookie: cookie.BadCookie}
+	}
+
+	return f.marshal(document)
+}
+
+// FormatError formats an error as a JSON object with a single "error" member
+func (f *jsonFormatter) FormatError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	document := jsonError{Type: "Unknown", Message: err.Error()}
+	if digErr, ok := errors.AsDigError(err); ok {
+		document.Type = digErr.Type.String()
+		document.Message = digErr.Message
+		document.Domain = f.displayName(digErr.Domain)
+		document.Server = digErr.Server
+		document.ExtendedErrors = f.jsonExtendedErrors(digErr.ExtendedErrors)
+		if digErr.Kind != nil {
+			document.Kind = digErr.Kind.Error()
+		}
+		if digErr.Cause != nil {
+			document.Cause = digErr.Cause.Error()
+		}
+	}
+
+	return f.marshal(struct {
+		Error jsonError `json:"error"`
+	}{document})
+}
+
+// formatCapture formats the DNS messages read from a packet capture as a
+// JSON array
+func (f *jsonFormatter) formatCapture(packets []pcap.Packet) string {
+	documents := []jsonPacket{}
+	for _, packet := range packets {
+		document := jsonPacket{
+			Time:    packet.Time.Format(time.RFC3339Nano),
+			Network: packet.Network,
+			Src:     packet.Src,
+			Dst:     packet.Dst,
+			Size:    len(packet.Data),
+		}
+		if packet.Err != nil {
+			document.Error = packet.Err.Error()
+		} else {
+			document.Message = f.jsonMessage(packet.Msg)
+		}
+		documents = append(documents, document)
+	}
+	return marshalJSON(documents)
+}
+
+// jsonMessage converts a DNS message with all its sections
+func (f *jsonFormatter) jsonMessage(msg *mdns.Msg) *jsonMessage {
+	document := &jsonMessage{
+		ID:         msg.Id,
+		Opcode:     mdns.OpcodeToString[msg.Opcode],
+		Status:     mdns.RcodeToString[msg.Rcode],
+		Flags:      []string{},
+		Question:   []jsonQuestion{},
+		Answer:     f.jsonRRs(msg.Answer),
+		Authority:  f.jsonRRs(msg.Ns),
+		Additional: f.jsonRRs(msg.Extra),
+	}
+
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", msg.Response}, {"aa", msg.Authoritative}, {"tc", msg.Truncated}, {"rd", msg.RecursionDesired},
+		{"ra", msg.RecursionAvailable}, {"ad", msg.AuthenticatedData}, {"cd", msg.CheckingDisabled},
+	} {
+		if flag.set {
+			document.Flags = append(document.Flags, flag.name)
+		}
+	}
+
+	for _, question := range msg.Question {
+		document.Question = append(document.Question, jsonQuestion{
+			Name:  f.displayName(question.Name),
+			Type:  mdns.TypeToString[question.Qtype],
+			Class: mdns.ClassToString[question.Qclass],
+		})
+	}
+
+	if opt := msg.IsEdns0(); opt != nil {
+		document.EDNS = &jsonEDNS{Version: opt.Version(), UDPSize: opt.UDPSize(), DO: opt.Do()}
+	}
+	return document
+}
+
+// jsonRRs converts the records of a message section, leaving out the OPT
+// pseudo-record, which is shown separately
+func (f *jsonFormatter) jsonRRs(rrs []mdns.RR) []jsonRecord {
+	records := []jsonRecord{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == mdns.TypeOPT {
+			continue
+		}
+		records = append(records, f.jsonRecord(dns.Record{
+			Name:  rr.Header().Name,
+			Type:  mdns.TypeToString[rr.Header().Rrtype],
+			TTL:   rr.Header().Ttl,
+			Value: strings.TrimPrefix(rr.String(), rr.Header().String()),
+		}))
+	}
+	return records
+}
+
+// jsonRecord converts a record, applying the display options to its names
+func (f *jsonFormatter) jsonRecord(record dns.Record) jsonRecord {
+	value := record.Value
+	if record.Type == "CNAME" || record.Type == "NS" {
+		value = f.displayName(value)
+	}
+	return jsonRecord{
+		Name:  f.displayName(record.Name),
+		Type:  strings.ToUpper(record.Type),
+		TTL:   record.TTL,
+		Value: value,
+	}
+}
+
+// jsonExtendedErrors converts Extended DNS Errors, adding their registered names
+func (f *jsonFormatter) jsonExtendedErrors(extended []errors.ExtendedError) []jsonExtendedError {
+	var converted []jsonExtendedError
+	for _, e := range extended {
+		converted = append(converted, jsonExtendedError{Code: e.Code, Name: e.Name(), Text: e.Text})
+	}
+	return converted
+}
+
+// marshal renders a query result or error document, on a single line with
+// the JSONLines option
+func (f *jsonFormatter) marshal(document interface{}) string {
+	if !f.options.JSONLines {
+		return marshalJSON(document)
+	}
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Sprintf("{\"error\":{\"type\":\"System\",\"message\":%q}}\n", err.Error())
+	}
+	return string(encoded) + "\n"
+}
+
+// marshalJSON renders document as indented JSON followed by a newline
+func marshalJSON(document interface{}) string {
+	encoded, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("{\"error\": {\"type\": \"System\", \"message\": %q}}\n", err.Error())
+	}
+	return string(encoded) + "\n"
+}
@@ -0,0 +1,242 @@
+package output
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+func TestNewFormatterWithOptions_JSON(t *testing.T) {
+	if _, ok := NewFormatterWithOptions(Options{Format: FormatJSON}).(*jsonFormatter); !ok {
+		t.Error("Expected FormatJSON to select the JSON formatter")
+	}
+	if _, ok := NewFormatterWithOptions(Options{Format: FormatText}).(*formatter); !ok {
+		t.Error("Expected FormatText to select the text formatter")
+	}
+}
+
+func TestJSONFormatter_FormatResult(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON})
+
+	result := &dns.Result{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Records:    []string{"192.0.2.7"},
+		Answers:    []dns.Record{{Name: "edge.example.org.", Type: "A", TTL: 20, Value: "192.0.2.7"}},
+		Chain: []dns.Record{
+			{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "cdn.example.net."},
+			{Name: "cdn.example.net.", Type: "CNAME", TTL: 60, Value: "edge.example.org."},
+		},
+		Rcode:          "NOERROR",
+		Server:         "8.8.8.8:53",
+		QueryTime:      1500 * time.Microsecond,
+		ExtendedErrors: []errors.ExtendedError{{Code: 3}},
+	}
+
+	var document jsonResult
+	if err := json.Unmarshal([]byte(formatter.FormatResult(result)), &document); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+
+	if document.Domain != "www.example.com" || document.Type != "A" || document.Status != "NOERROR" || document.QueryTimeMs != 1.5 {
+		t.Errorf("Unexpected query fields: %+v", document)
+	}
+	expectedChain := []jsonRecord{
+		{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "cdn.example.net."},
+		{Name: "cdn.example.net.", Type: "CNAME", TTL: 60, Value: "edge.example.org."},
+	}
+	if len(document.Chain) != len(expectedChain) {
+		t.Fatalf("Expected %d hops, got %+v", len(expectedChain), document.Chain)
+	}
+	for i, hop := range expectedChain {
+		if document.Chain[i] != hop {
+			t.Errorf("Hop %d = %+v, want %+v", i, document.Chain[i], hop)
+		}
+	}
+	if len(document.Answers) != 1 || document.Answers[0] != (jsonRecord{Name: "edge.example.org.", Type: "A", TTL: 20, Value: "192.0.2.7"}) {
+		t.Errorf("Unexpected answers: %+v", document.Answers)
+	}
+	if len(document.ExtendedErrors) != 1 || document.ExtendedErrors[0].Name != "Stale Answer" {
+		t.Errorf("Unexpected extended errors: %+v", document.ExtendedErrors)
+	}
+}
+
+func TestJSONFormatter_FormatResult_ChaosWithNSID(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON})
+
+	var document jsonResult
+	output := formatter.FormatResult(&dns.Result{Domain: "id.server", RecordType: "TXT", Class: "CH", Records: []string{"fra-3"}, NSID: "fra"})
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	if document.Class != "CH" || document.NSID == nil || *document.NSID != (jsonNSID{Hex: "667261", Text: "fra"}) {
+		t.Errorf("Unexpected class and NSID in %s", output)
+	}
+
+	output = formatter.FormatResult(&dns.Result{Domain: "example.com", RecordType: "A", Class: "IN", Records: []string{"192.0.2.1"}})
+	if strings.Contains(output, `"class"`) || strings.Contains(output, `"nsid"`) {
+		t.Errorf("Expected class IN and a missing NSID to be left out, got %s", output)
+	}
+}
+
+func TestJSONFormatter_FormatResult_Flags(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON})
+
+	var document jsonResult
+	output := formatter.FormatResult(&dns.Result{Domain: "example.com", RecordType: "A", Records: []string{"192.0.2.1"}, Flags: []string{"qr", "aa"}, Opcode: "QUERY"})
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	if strings.Join(document.Flags, " ") != "qr aa" || document.Opcode != "" {
+		t.Errorf("Expected the flags and no QUERY opcode, got %s", output)
+	}
+
+	output = formatter.FormatResult(&dns.Result{Domain: "example.com", RecordType: "A", Flags: []string{"qr"}, Opcode: "NOTIFY"})
+	if !strings.Contains(output, `"opcode": "NOTIFY"`) {
+		t.Errorf("Expected the NOTIFY opcode, got %s", output)
+	}
+}
+
+func TestJSONFormatter_FormatResult_Cookie(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON})
+
+	var document jsonResult
+	output := formatter.FormatResult(&dns.Result{Domain: "example.com", RecordType: "A", Records: []string{"192.0.2.1"},
+		Cookie: &dns.CookieResult{Client: "0102030405060708", Server: "a1a2a3a4a5a6a7a8", Status: dns.CookieValid, BadCookie: true}})
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	want := jsonCookie{Client: "0102030405060708", Server: "a1a2a3a4a5a6a7a8", Status: "valid", BadCookie: true}
+	if document.Cookie == nil || *document.Cookie != want {
+		t.Errorf("Unexpected cookie in %s", output)
+	}
+
+	output = formatter.FormatResult(&dns.Result{Domain: "example.com", RecordType: "A", Records: []string{"192.0.2.1"}})
+	if strings.Contains(output, `"cookie"`) {
+		t.Errorf("Expected no cookie without one sent, got %s", output)
+	}
+}
+
+func TestJSONFormatter_FormatResult_NoData(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON})
+
+	output := formatter.FormatResult(&dns.Result{
+		Domain:      "example.com",
+		RecordType:  "AAAA",
+		Records:     []string{},
+		Rcode:       "NOERROR",
+		NoData:      true,
+		SOA:         &dns.Record{Name: "example.com.", Type: "SOA", TTL: 3600, Value: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"},
+		NegativeTTL: 300,
+	})
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &raw); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	if raw["nodata"] != true || raw["negative_ttl"] != float64(300) || raw["soa"] == nil {
+		t.Errorf("Unexpected NODATA fields: %s", output)
+	}
+	// Empty lists are present so consumers need no nil checks
+	if chain, ok := raw["chain"].([]interface{}); !ok || len(chain) != 0 {
+		t.Errorf("Expected an empty chain array, got %v", raw["chain"])
+	}
+	if answers, ok := raw["answers"].([]interface{}); !ok || len(answers) != 0 {
+		t.Errorf("Expected an empty answers array, got %v", raw["answers"])
+	}
+}
+
+func TestJSONFormatter_FormatResult_Unresolved(t *testing.T) {
+	output := NewFormatterWithOptions(Options{Format: FormatJSON}).FormatResult(&dns.Result{
+		Domain:     "alias.example.com",
+		RecordType: "A",
+		Rcode:      "NOERROR",
+		Chain:      []dns.Record{{Name: "alias.example.com.", Type: "CNAME", TTL: 300, Value: "www.example.net."}},
+		Unresolved: true,
+	})
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &raw); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	if raw["unresolved"] != true || raw["nodata"] != nil {
+		t.Errorf("Expected an unresolved chain without nodata, got %s", output)
+	}
+}
+
+func TestJSONFormatter_FormatResult_Referral(t *testing.T) {
+	var document struct {
+		Answers  []jsonRecord `json:"answers"`
+		Referral []jsonRecord `json:"referral"`
+	}
+	output := NewFormatterWithOptions(Options{Format: FormatJSON}).FormatResult(referralResult())
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	if len(document.Answers) != 0 || len(document.Referral) != 2 || document.Referral[1].Value != "b.iana-servers.net." {
+		t.Errorf("Expected the referral's NS records, got %s", output)
+	}
+}
+
+func TestJSONFormatter_JSONLines(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON, JSONLines: true})
+	result := &dns.Result{Domain: "example.com", RecordType: "A", Rcode: "NOERROR", Records: []string{"192.0.2.1"}}
+	err := errors.NewDNSError("domain 'missing.example.com' not found (NXDOMAIN)", nil, "missing.example.com", "")
+
+	for _, output := range []string{formatter.FormatResult(result), formatter.FormatError(err)} {
+		if strings.Count(output, "\n") != 1 || !strings.HasSuffix(output, "\n") {
+			t.Errorf("Expected a single line, got %q", output)
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal([]byte(output), &document); err != nil {
+			t.Errorf("Line is not a JSON object: %v\n%s", err, output)
+		}
+	}
+}
+
+func TestJSONFormatter_FormatError(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON})
+
+	err := errors.NewDNSError("domain 'example.com' not found (NXDOMAIN)", nil, "example.com", "8.8.8.8:53").
+		WithKind(errors.ErrNXDomain).
+		WithExtendedErrors([]errors.ExtendedError{{Code: 6, Text: "bogus"}})
+
+	var document struct {
+		Error jsonError `json:"error"`
+	}
+	if jsonErr := json.Unmarshal([]byte(formatter.FormatResult(&dns.Result{Error: err})), &document); jsonErr != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", jsonErr)
+	}
+	if document.Error.Type != "DNS" || document.Error.Kind != "NXDOMAIN" || document.Error.Domain != "example.com" {
+		t.Errorf("Unexpected error document: %+v", document.Error)
+	}
+	if len(document.Error.ExtendedErrors) != 1 || document.Error.ExtendedErrors[0].Name != "DNSSEC Bogus" {
+		t.Errorf("Unexpected extended errors: %+v", document.Error.ExtendedErrors)
+	}
+
+	if output := formatter.FormatError(nil); output != "" {
+		t.Errorf("Expected empty output for nil error, got %q", output)
+	}
+}
+
+func TestJSONFormatter_UnicodeNames(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatJSON, UnicodeNames: true})
+
+	var document jsonResult
+	output := formatter.FormatResult(&dns.Result{
+		Domain:     "xn--mnchen-3ya.de",
+		RecordType: "CNAME",
+		Records:    []string{"xn--bcher-kva.example."},
+		Answers:    []dns.Record{{Name: "xn--mnchen-3ya.de.", Type: "CNAME", TTL: 60, Value: "xn--bcher-kva.example."}},
+	})
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatalf("FormatResult() is not valid JSON: %v", err)
+	}
+	if document.Domain != "münchen.de" || document.Answers[0].Name != "münchen.de." || document.Answers[0].Value != "bücher.example." {
+		t.Errorf("Expected Unicode names, got %+v", document)
+	}
+}
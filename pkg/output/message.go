@@ -0,0 +1,54 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"go-dig/pkg/pcap"
+
+	mdns "github.com/miekg/dns"
+)
+
+// FormatCapture formats the DNS messages read from a packet capture by
+// read-pcap: a JSON array for FormatJSON, otherwise the dig-style rendering
+// of each message
+func FormatCapture(packets []pcap.Packet, options Options) string {
+	if options.Format == FormatJSON {
+		return (&jsonFormatter{formatter: &formatter{options: options}}).formatCapture(packets)
+	}
+	return formatCapture(packets)
+}
+
+// FormatMessage formats a single DNS message for decode: a JSON object for
+// FormatJSON, otherwise the dig-style rendering of its header and sections
+func FormatMessage(msg *mdns.Msg, options Options) string {
+	if options.Format == FormatJSON {
+		return marshalJSON((&jsonFormatter{formatter: &formatter{options: options}}).jsonMessage(msg))
+	}
+	return msg.String()
+}
+
+// formatCapture formats the DNS messages read from a packet capture, each
+// with the dig-style rendering of its header and sections
+func formatCapture(packets []pcap.Packet) string {
+	var output strings.Builder
+
+	for i, packet := range packets {
+		output.WriteString(fmt.Sprintf(";; [%d] %s %s %s -> %s (%d bytes)\n", i+1,
+			packet.Time.Format("2006-01-02 15:04:05.000000 MST"), packet.Network, packet.Src, packet.Dst, len(packet.Data)))
+		if packet.Err != nil {
+			output.WriteString(fmt.Sprintf(";; malformed DNS message: %v\n", packet.Err))
+		} else {
+			output.WriteString(packet.Msg.String())
+		}
+		output.WriteString("\n")
+	}
+
+	output.WriteString(fmt.Sprintf(";; %d DNS message", len(packets)))
+	if len(packets) != 1 {
+		output.WriteString("s")
+	}
+	output.WriteString("\n")
+
+	return output.String()
+}
@@ -0,0 +1,111 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/pcap"
+
+	mdns "github.com/miekg/dns"
+)
+
+// capturedPackets returns a query, its answer and an undecodable message as
+// read from a capture
+func capturedPackets() []pcap.Packet {
+	query := new(mdns.Msg)
+	query.SetQuestion("www.example.com.", mdns.TypeA)
+	query.SetEdns0(1232, true)
+	response := new(mdns.Msg)
+	response.SetReply(query)
+	response.Authoritative = true
+	rr, _ := mdns.NewRR("www.example.com. 300 IN A 192.0.2.1")
+	response.Answer = append(response.Answer, rr)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 250000000, time.UTC)
+	return []pcap.Packet{
+		{Time: at, Network: "udp", Src: "192.0.2.10:40000", Dst: "192.0.2.53:53", Data: make([]byte, 44), Msg: query},
+		{Time: at.Add(time.Millisecond), Network: "udp", Src: "192.0.2.53:53", Dst: "192.0.2.10:40000", Data: make([]byte, 60), Msg: response},
+		{Time: at.Add(2 * time.Millisecond), Network: "tcp", Src: "192.0.2.10:40001", Dst: "192.0.2.53:53", Data: []byte{1, 2, 3}, Err: fmt.Errorf("dns: overflow unpacking uint16")},
+	}
+}
+
+func TestFormatCapture(t *testing.T) {
+	output := FormatCapture(capturedPackets(), Options{})
+
+	expectedElements := []string{
+		";; [1] 2024-01-02 03:04:05.250000 UTC udp 192.0.2.10:40000 -> 192.0.2.53:53 (44 bytes)\n;; opcode: QUERY, status: NOERROR",
+		"; EDNS: version 0; flags: do; udp: 1232",
+		";; [2] 2024-01-02 03:04:05.251000 UTC udp 192.0.2.53:53 -> 192.0.2.10:40000 (60 bytes)",
+		";; flags: qr aa rd;",
+		"www.example.com.\t300\tIN\tA\t192.0.2.1",
+		";; [3] 2024-01-02 03:04:05.252000 UTC tcp 192.0.2.10:40001 -> 192.0.2.53:53 (3 bytes)\n;; malformed DNS message: dns: overflow unpacking uint16",
+		"\n;; 3 DNS messages\n",
+	}
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain %q, but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	if output := FormatCapture(nil, Options{}); output != ";; 0 DNS messages\n" {
+		t.Errorf("Unexpected output for an empty capture: %q", output)
+	}
+}
+
+func TestFormatCapture_JSON(t *testing.T) {
+	var documents []jsonPacket
+	if err := json.Unmarshal([]byte(FormatCapture(capturedPackets(), Options{Format: FormatJSON})), &documents); err != nil {
+		t.Fatalf("Output is not a JSON array: %v", err)
+	}
+	if len(documents) != 3 {
+		t.Fatalf("Expected 3 packets, got %d", len(documents))
+	}
+
+	query, response, broken := documents[0], documents[1], documents[2]
+	if query.Time != "2024-01-02T03:04:05.25Z" || query.Network != "udp" || query.Src != "192.0.2.10:40000" || query.Dst != "192.0.2.53:53" || query.Size != 44 {
+		t.Errorf("Unexpected packet fields: %+v", query)
+	}
+	if query.Message == nil || query.Message.EDNS == nil || !query.Message.EDNS.DO || query.Message.EDNS.UDPSize != 1232 {
+		t.Errorf("Expected the query's EDNS options, got %+v", query.Message)
+	}
+	if len(query.Message.Additional) != 0 {
+		t.Errorf("Expected the OPT record to be left out of the additional section, got %v", query.Message.Additional)
+	}
+	if len(query.Message.Question) != 1 || query.Message.Question[0] != (jsonQuestion{Name: "www.example.com.", Type: "A", Class: "IN"}) {
+		t.Errorf("Unexpected question section: %v", query.Message.Question)
+	}
+
+	message := response.Message
+	if message == nil || message.ID != query.Message.ID || message.Status != "NOERROR" || message.Opcode != "QUERY" {
+		t.Fatalf("Unexpected response header: %+v", message)
+	}
+	if strings.Join(message.Flags, " ") != "qr aa rd" {
+		t.Errorf("Flags = %v, want [qr aa rd]", message.Flags)
+	}
+	if len(message.Answer) != 1 || message.Answer[0] != (jsonRecord{Name: "www.example.com.", Type: "A", TTL: 300, Value: "192.0.2.1"}) {
+		t.Errorf("Unexpected answer section: %v", message.Answer)
+	}
+
+	if broken.Message != nil || broken.Error != "dns: overflow unpacking uint16" {
+		t.Errorf("Expected the decoding error for the malformed message, got %+v", broken)
+	}
+
+	if output := FormatCapture(nil, Options{Format: FormatJSON}); output != "[]\n" {
+		t.Errorf("Unexpected output for an empty capture: %q", output)
+	}
+}
+
+func TestFormatMessage_JSON(t *testing.T) {
+	response := capturedPackets()[1].Msg
+
+	var document jsonMessage
+	if err := json.Unmarshal([]byte(FormatMessage(response, Options{Format: FormatJSON})), &document); err != nil {
+		t.Fatalf("Output is not a JSON object: %v", err)
+	}
+	if document.ID != response.Id || len(document.Answer) != 1 || len(document.Question) != 1 {
+		t.Errorf("Unexpected message: %+v", document)
+	}
+}
@@ -0,0 +1,236 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go-dig/pkg/delegation"
+	"go-dig/pkg/errors"
+	"go-dig/pkg/mailcheck"
+	"go-dig/pkg/propagation"
+	"go-dig/pkg/watch"
+)
+
+// The reports of the propagation, watch, check-delegation and mailcheck
+// commands are text in every output format. They are plain functions rather
+// than Formatter methods, so a new command does not change the interface.
+
+// FormatPropagation formats the progress of a propagation check after one polling round
+func FormatPropagation(report *propagation.Report, round *propagation.Round) string {
+	if report == nil || round == nil {
+		return ""
+	}
+
+	var output strings.Builder
+
+	output.WriteString(fmt.Sprintf(";; Round %d (%v elapsed): %d/%d servers agree on %s %s\n",
+		round.Number, round.Elapsed.Round(time.Second), round.Agreed(), len(round.Statuses),
+		report.Domain, report.RecordType))
+
+	for _, status := range round.Statuses {
+		role := "resolver"
+		if status.Target.Authoritative {
+			role = "auth"
+		}
+		name := status.Target.Name
+		if status.Target.Authoritative {
+			name = fmt.Sprintf("%s (%s)", status.Target.Name, status.Target.Server)
+		}
+
+		switch {
+		case status.Error != nil:
+			output.WriteString(fmt.Sprintf("  %-8s %-40s\tERROR\t%s\n", role, name, errors.Message(status.Error)))
+		case status.Matched:
+			output.WriteString(fmt.Sprintf("  %-8s %-40s\tOK\tTTL %d\n", role, name, status.TTL))
+		default:
+			output.WriteString(fmt.Sprintf("  %-8s %-40s\tPENDING\t%s (TTL %d remaining)\n",
+				role, name, strings.Join(status.Records, ", "), status.TTL))
+		}
+	}
+
+	return output.String()
+}
+
+// FormatWatchUpdate formats one query of a watch as text with the given
+// display options. The first query is shown in full; later queries only show
+// what changed since the previous one.
+func FormatWatchUpdate(update *watch.Update, options Options) string {
+	return (&formatter{options: options}).formatWatchUpdate(update)
+}
+
+// formatWatchUpdate formats one query of a watch
+func (f *formatter) formatWatchUpdate(update *watch.Update) string {
+	if update == nil {
+		return ""
+	}
+
+	var output strings.Builder
+	stamp := update.Time.Format("15:04:05")
+
+	if update.Initial {
+		output.WriteString(f.FormatResult(update.Result))
+		output.WriteString(fmt.Sprintf("\n;; [%s] watching, next query in %v\n", stamp, update.Next))
+		return output.String()
+	}
+
+	for _, change := range update.Changes {
+		switch change.Kind {
+		case watch.RecordAdded, watch.RecordRemoved:
+			sign := "+"
+			if change.Kind == watch.RecordRemoved {
+				sign = "-"
+			}
+			output.WriteString(fmt.Sprintf(";; [%s] %s %s\t%d\tIN\t%s\t%s\n", stamp, sign,
+				f.displayName(change.Record.Name), change.Record.TTL, change.Record.Type,
+				f.formatRecordValue(change.Record.Type, change.Record.Value)))
+		case watch.RcodeChanged:
+			output.WriteString(fmt.Sprintf(";; [%s] status: %s -> %s\n", stamp, rcodeName(change.Old), rcodeName(change.New)))
+		case watch.ServerChanged:
+			output.WriteString(fmt.Sprintf(";; [%s] server: %s -> %s\n", stamp, change.Old, change.New))
+		case watch.QueryFailed:
+			output.WriteString(fmt.Sprintf(";; [%s] error: %s\n", stamp, change.New))
+		}
+	}
+
+	return output.String()
+}
+
+// FormatDelegation formats the result of a delegation health check
+func FormatDelegation(report *delegation.Report) string {
+	if report == nil {
+		return ""
+	}
+
+	var output strings.Builder
+
+	output.WriteString(fmt.Sprintf("; <<>> go-dig <<>> check-delegation %s\n", report.Zone))
+	output.WriteString(fmt.Sprintf(";; Parent zone: %s (referral from %s)\n", report.Parent, report.ParentServer))
+	output.WriteString(fmt.Sprintf(";; Parent NS: %s\n", strings.Join(report.ParentNS, ", ")))
+	output.WriteString(fmt.Sprintf(";; Child NS:  %s\n", strings.Join(report.ChildNS, ", ")))
+	output.WriteString("\n")
+
+	output.WriteString(fmt.Sprintf(";; NAMESERVERS: (%d)\n", len(report.Nameservers)))
+	for _, ns := range report.Nameservers {
+		var listed []string
+		if ns.InParent {
+			listed = append(listed, "parent")
+		}
+		if ns.InChild {
+			listed = append(listed, "child")
+		}
+		glue := "no glue"
+		if len(ns.Glue) > 0 {
+			glue = "glue " + strings.Join(ns.Glue, ",")
+		}
+		address := ns.Address
+		if address == "" {
+			address = "unresolved"
+		}
+		output.WriteString(fmt.Sprintf("%-30s\t%s\t%s\t%s\tUDP %s\tTCP %s\n",
+			ns.Name, address, strings.Join(listed, "+"), glue, probeSummary(ns.UDP), probeSummary(ns.TCP)))
+	}
+	output.WriteString("\n")
+
+	if len(report.Findings) == 0 {
+		output.WriteString(";; FINDINGS: none, delegation is healthy\n")
+		return output.String()
+	}
+
+	output.WriteString(fmt.Sprintf(";; FINDINGS: (%d)\n", len(report.Findings)))
+	for _, finding := range report.Findings {
+		if finding.Nameserver != "" {
+			output.WriteString(fmt.Sprintf("%-7s %s: %s\n", finding.Severity, finding.Nameserver, finding.Message))
+		} else {
+			output.WriteString(fmt.Sprintf("%-7s %s\n", finding.Severity, finding.Message))
+		}
+	}
+
+	return output.String()
+}
+
+// FormatMailCheck formats the result of a mail authentication check
+func FormatMailCheck(report *mailcheck.Report) string {
+	if report == nil {
+		return ""
+	}
+
+	var output strings.Builder
+
+	output.WriteString(fmt.Sprintf("; <<>> go-dig <<>> mailcheck %s\n", report.Domain))
+	output.WriteString("\n")
+
+	output.WriteString(";; RECORDS:\n")
+	mx := "none"
+	if len(report.MX) > 0 {
+		mx = strings.Join(report.MX, ", ")
+	}
+	output.WriteString(fmt.Sprintf("%-10s\t%s\n", "MX", mx))
+
+	spf := "none"
+	if report.SPF != nil && report.SPF.Record != "" {
+		spf = fmt.Sprintf("%q (%d of %d lookups)", report.SPF.Record, report.SPF.Lookups, mailcheck.MaxSPFLookups)
+	}
+	output.WriteString(fmt.Sprintf("%-10s\t%s\n", "SPF", spf))
+
+	output.WriteString(formatTagRecord("DMARC", report.DMARC))
+	selectors := make([]string, 0, len(report.DKIM))
+	for selector := range report.DKIM {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+	for _, selector := range selectors {
+		output.WriteString(formatTagRecord("DKIM "+selector, report.DKIM[selector]))
+	}
+	output.WriteString(formatTagRecord("MTA-STS", report.MTASTS))
+	output.WriteString(formatTagRecord("TLS-RPT", report.TLSRPT))
+	output.WriteString(formatTagRecord("BIMI", report.BIMI))
+	output.WriteString("\n")
+
+	if len(report.Findings) == 0 {
+		output.WriteString(";; FINDINGS: none\n")
+		return output.String()
+	}
+
+	output.WriteString(fmt.Sprintf(";; FINDINGS: (%d)\n", len(report.Findings)))
+	for _, finding := range report.Findings {
+		output.WriteString(fmt.Sprintf("%-7s %s: %s\n", finding.Severity, finding.Check, finding.Message))
+	}
+
+	return output.String()
+}
+
+// formatTagRecord formats one line of the mailcheck record summary
+func formatTagRecord(label string, record *mailcheck.TagRecord) string {
+	if record == nil {
+		return fmt.Sprintf("%-10s\tnone\n", label)
+	}
+	return fmt.Sprintf("%-10s\t%q\n", label, record.Raw)
+}
+
+// probeSummary describes an SOA probe in a few words
+func probeSummary(probe delegation.Probe) string {
+	switch {
+	case probe.Network == "":
+		return "not tested"
+	case probe.Error != nil:
+		return "no response"
+	case probe.Rcode != "NOERROR":
+		return probe.Rcode
+	case !probe.Authoritative:
+		return "non-authoritative"
+	case !probe.HasSOA:
+		return "no SOA"
+	default:
+		return fmt.Sprintf("serial %d", probe.Serial)
+	}
+}
+
+// rcodeName returns the response code for display, naming missing responses
+func rcodeName(rcode string) string {
+	if rcode == "" {
+		return "no response"
+	}
+	return rcode
+}
@@ -0,0 +1,195 @@
+package output
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/delegation"
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+	"go-dig/pkg/mailcheck"
+	"go-dig/pkg/propagation"
+	"go-dig/pkg/watch"
+)
+
+func TestFormatPropagation(t *testing.T) {
+	report := &propagation.Report{Domain: "www.example.com", RecordType: "A", Zone: "example.com"}
+	round := &propagation.Round{
+		Number:  2,
+		Elapsed: 30 * time.Second,
+		Statuses: []propagation.ServerStatus{
+			{
+				Target:  propagation.Target{Name: "ns1.example.com", Server: "192.0.2.53", Authoritative: true},
+				Records: []string{"203.0.113.10"},
+				TTL:     300,
+				Matched: true,
+			},
+			{
+				Target:  propagation.Target{Name: "8.8.8.8", Server: "8.8.8.8"},
+				Records: []string{"192.0.2.99"},
+				TTL:     120,
+			},
+			{
+				Target: propagation.Target{Name: "1.1.1.1", Server: "1.1.1.1"},
+				Error:  errors.NewNetworkError("DNS server timeout", nil, "1.1.1.1:53"),
+			},
+		},
+	}
+
+	output := FormatPropagation(report, round)
+
+	expectedElements := []string{
+		";; Round 2 (30s elapsed): 1/3 servers agree on www.example.com A",
+		"ns1.example.com (192.0.2.53)",
+		"\tOK\tTTL 300",
+		"\tPENDING\t192.0.2.99 (TTL 120 remaining)",
+		"\tERROR\tDNS server timeout",
+	}
+
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	if FormatPropagation(nil, nil) != "" {
+		t.Error("Expected empty output for nil report")
+	}
+}
+
+func TestFormatWatchUpdate(t *testing.T) {
+	stamp := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	result := &dns.Result{
+		Domain:     "example.com",
+		RecordType: "A",
+		Records:    []string{"192.0.2.1"},
+		Server:     "8.8.8.8:53",
+		Rcode:      "NOERROR",
+	}
+
+	initial := FormatWatchUpdate(&watch.Update{Time: stamp, Result: result, Initial: true, Next: time.Minute}, Options{})
+	for _, element := range []string{";; ANSWER SECTION: (1 record)", ";; [15:04:05] watching, next query in 1m0s"} {
+		if !strings.Contains(initial, element) {
+			t.Errorf("Expected initial output to contain '%s'.\nActual output:\n%s", element, initial)
+		}
+	}
+
+	output := FormatWatchUpdate(&watch.Update{
+		Time:   stamp,
+		Result: result,
+		Changes: []watch.Change{
+			{Kind: watch.ServerChanged, Old: "8.8.8.8:53", New: "1.1.1.1:53"},
+			{Kind: watch.RcodeChanged, Old: "NOERROR", New: ""},
+			{Kind: watch.QueryFailed, New: "DNS server timeout"},
+			{Kind: watch.RecordAdded, Record: dns.Record{Name: "example.com.", Type: "A", TTL: 300, Value: "198.51.100.1"}},
+			{Kind: watch.RecordRemoved, Record: dns.Record{Name: "example.com.", Type: "A", TTL: 300, Value: "192.0.2.1"}},
+		},
+	}, Options{})
+
+	expected := ";; [15:04:05] server: 8.8.8.8:53 -> 1.1.1.1:53\n" +
+		";; [15:04:05] status: NOERROR -> no response\n" +
+		";; [15:04:05] error: DNS server timeout\n" +
+		";; [15:04:05] + example.com.\t300\tIN\tA\t198.51.100.1\n" +
+		";; [15:04:05] - example.com.\t300\tIN\tA\t192.0.2.1\n"
+	if output != expected {
+		t.Errorf("Unexpected delta output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	if FormatWatchUpdate(&watch.Update{Time: stamp, Result: result}, Options{}) != "" {
+		t.Error("Expected no output when nothing changed")
+	}
+}
+
+func TestFormatDelegation(t *testing.T) {
+	report := &delegation.Report{
+		Zone:         "example.com",
+		Parent:       "com",
+		ParentServer: "a.gtld-servers.net",
+		ParentNS:     []string{"ns1.example.com", "ns2.example.com"},
+		ChildNS:      []string{"ns1.example.com"},
+		Nameservers: []*delegation.Nameserver{
+			{
+				Name:     "ns1.example.com",
+				Address:  "192.0.2.53",
+				InParent: true,
+				InChild:  true,
+				Glue:     []string{"192.0.2.53"},
+				UDP:      delegation.Probe{Network: "udp", Rcode: "NOERROR", Authoritative: true, HasSOA: true, Serial: 42},
+				TCP:      delegation.Probe{Network: "tcp", Error: errors.NewNetworkError("DNS server timeout", nil, "192.0.2.53:53")},
+			},
+			{Name: "ns2.example.com", InParent: true},
+		},
+		Findings: []delegation.Finding{
+			{Severity: delegation.SeverityError, Nameserver: "ns1.example.com", Message: "lame: no response over TCP (DNS server timeout)"},
+			{Severity: delegation.SeverityWarning, Message: "SOA serial mismatch"},
+		},
+	}
+
+	output := FormatDelegation(report)
+
+	expectedElements := []string{
+		"; <<>> go-dig <<>> check-delegation example.com",
+		";; Parent zone: com (referral from a.gtld-servers.net)",
+		";; Parent NS: ns1.example.com, ns2.example.com",
+		"ns1.example.com               \t192.0.2.53\tparent+child\tglue 192.0.2.53\tUDP serial 42\tTCP no response",
+		"ns2.example.com               \tunresolved\tparent\tno glue\tUDP not tested\tTCP not tested",
+		";; FINDINGS: (2)",
+		"ERROR   ns1.example.com: lame: no response over TCP (DNS server timeout)",
+		"WARNING SOA serial mismatch",
+	}
+
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	report.Findings = nil
+	if !strings.Contains(FormatDelegation(report), ";; FINDINGS: none, delegation is healthy") {
+		t.Error("Expected healthy summary when there are no findings")
+	}
+}
+
+func TestFormatMailCheck(t *testing.T) {
+	report := &mailcheck.Report{
+		Domain: "example.com",
+		MX:     []string{"10 mx.example.com."},
+		SPF:    &mailcheck.SPFResult{Record: "v=spf1 mx -all", Lookups: 1},
+		DMARC:  &mailcheck.TagRecord{Raw: "v=DMARC1; p=none"},
+		DKIM:   map[string]*mailcheck.TagRecord{"s2": nil, "s1": {Raw: "v=DKIM1; p="}},
+		Findings: []mailcheck.Finding{
+			{Severity: mailcheck.SeverityWarning, Check: "DMARC", Message: "p=none only monitors; spoofed mail is still delivered"},
+			{Severity: mailcheck.SeverityInfo, Check: "MTA-STS", Message: "no MTA-STS record at _mta-sts.example.com; inbound TLS is opportunistic"},
+		},
+	}
+
+	output := FormatMailCheck(report)
+
+	expectedElements := []string{
+		"; <<>> go-dig <<>> mailcheck example.com",
+		"MX        \t10 mx.example.com.",
+		"SPF       \t\"v=spf1 mx -all\" (1 of 10 lookups)",
+		"DMARC     \t\"v=DMARC1; p=none\"",
+		"DKIM s1   \t\"v=DKIM1; p=\"\nDKIM s2   \tnone",
+		"MTA-STS   \tnone",
+		";; FINDINGS: (2)",
+		"WARNING DMARC: p=none only monitors",
+		"INFO    MTA-STS: no MTA-STS record",
+	}
+
+	for _, element := range expectedElements {
+		if !strings.Contains(output, element) {
+			t.Errorf("Expected output to contain '%s', but it didn't.\nActual output:\n%s", element, output)
+		}
+	}
+
+	report.Findings = nil
+	if !strings.Contains(FormatMailCheck(report), ";; FINDINGS: none") {
+		t.Error("Expected empty findings summary when there are no findings")
+	}
+	if FormatMailCheck(nil) != "" {
+		t.Error("Expected empty output for a nil report")
+	}
+}
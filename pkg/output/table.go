@@ -0,0 +1,149 @@
+package output
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+// tableColumns is the header of CSV and TSV output. Columns are only ever
+// added at the end, so spreadsheets and scripts reading them keep working.
+var tableColumns = []string{"query", "qtype", "server", "rcode", "owner", "ttl", "class", "type", "rdata", "latency_ms", "error_type", "error"}
+
+// tableFormatter writes query results as rows of a CSV or TSV table for
+// loading into spreadsheets: one row per record of the CNAME chain and the
+// answers, one row for a query without answers, and one row per failure.
+// The header is written before the first row only, so a formatter shared by
+// the queries of a run writes a single table. The reports of the other
+// commands keep their text form.
+type tableFormatter struct {
+	*formatter
+	comma  rune
+	header sync.Once
+}
+
+// FormatResult writes the rows of a query result, or its failure
+func (f *tableFormatter) FormatResult(result *dns.Result) string {
+	if result == nil {
+		return f.FormatError(fmt.Errorf("no result to format"))
+	}
+
+	query := []string{f.displayName(result.Domain), strings.ToUpper(result.RecordType), result.Server, result.Rcode}
+	latency := ""
+	if result.QueryTime > 0 {
+		latency = strconv.FormatFloat(float64(result.QueryTime.Microseconds())/1000, 'f', 3, 64)
+	}
+	if result.Error != nil {
+		errType, message := tableError(result.Error)
+		return f.writeRows([][]string{append(query, "", "", "", "", "", latency, errType, message)})
+	}
+
+	records := append([]dns.Record{}, result.Chain...)
+	if len(result.Answers) > 0 {
+		records = append(records, result.Answers...)
+	} else if len(result.Referral) > 0 {
+		// A referral has no answers; its rows are the NS records it refers to
+		records = append(records, result.Referral...)
+	} else {
+		// Results built without structured answers only carry the values
+		for _, value := range result.Records {
+			records = append(records, dns.Record{Name: ownerName(result), Type: result.RecordType, Value: value})
+		}
+	}
+
+	class := resultClass(result)
+	var rows [][]string
+	for _, record := range records {
+		data := record.Data
+		switch {
+		case data == "":
+			data = record.Value
+		case record.Type == "TXT":
+			data = txtText(data)
+		}
+		row := append(append([]string{}, query...), f.displayName(record.Name), strconv.FormatUint(uint64(record.TTL), 10), class, record.Type, data, latency, "", "")
+		rows = append(rows, row)
+	}
+	// A query that found nothing still has its row, so every query is in the table
+	if len(rows) == 0 {
+		rows = append(rows, append(query, "", "", "", "", "", latency, "", ""))
+	}
+	return f.writeRows(rows)
+}
+
+// txtText returns the character-strings of TXT data in master-file syntax
+// without their quotes and escapes, concatenated as SPF, DKIM and DMARC read
+// them, so that the cell holds the text itself
+func txtText(data string) string {
+	var text []byte
+	quoted := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+4 <= len(data) && isDecimal(data[i+1:i+4]):
+			code, _ := strconv.Atoi(data[i+1 : i+4])
+			text = append(text, byte(code))
+			i += 3
+		case c == '\\' && i+1 < len(data):
+			i++
+			text = append(text, data[i])
+		case c == ' ' && !quoted:
+			// Space between character-strings
+		default:
+			text = append(text, c)
+		}
+	}
+	return string(text)
+}
+
+// isDecimal reports whether s consists of decimal digits only
+func isDecimal(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// FormatError writes a failure that has no result as a row with the domain
+// and server the error names
+func (f *tableFormatter) FormatError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var domain, server string
+	if digErr, ok := errors.AsDigError(err); ok {
+		domain, server = f.displayName(digErr.Domain), digErr.Server
+	}
+	errType, message := tableError(err)
+	return f.writeRows([][]string{{domain, "", server, "", "", "", "", "", "", "", errType, message}})
+}
+
+// tableError returns the type and message of an error for the error columns
+func tableError(err error) (string, string) {
+	if digErr, ok := errors.AsDigError(err); ok {
+		return digErr.Type.String(), digErr.Message
+	}
+	return "Unknown", err.Error()
+}
+
+// writeRows writes rows, preceded by the header the first time, quoting
+// fields such as TXT data that hold separators, quotes or line breaks
+func (f *tableFormatter) writeRows(rows [][]string) string {
+	var output strings.Builder
+	writer := csv.NewWriter(&output)
+	writer.Comma = f.comma
+	f.header.Do(func() {
+		writer.Write(tableColumns)
+	})
+	writer.WriteAll(rows)
+	return output.String()
+}
@@ -0,0 +1,120 @@
+package output
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+func TestNewFormatterWithOptions_Table(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatTSV} {
+		if _, ok := NewFormatterWithOptions(Options{Format: format}).(*tableFormatter); !ok {
+			t.Errorf("Expected %s to select the table formatter", format)
+		}
+		if !Tabular(format) {
+			t.Errorf("Tabular(%q) = false, want true", format)
+		}
+	}
+	if Tabular(FormatJSON) {
+		t.Error("Tabular(json) = true, want false")
+	}
+}
+
+// readTable parses table output back into its rows
+func readTable(t *testing.T, output string, comma rune) [][]string {
+	t.Helper()
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.Comma = comma
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Output is not a valid table: %v\n%s", err, output)
+	}
+	return rows
+}
+
+func TestTableFormatter_FormatResult(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatCSV})
+	result := &dns.Result{
+		Domain:     "alias.example.com",
+		RecordType: "A",
+		Rcode:      "NOERROR",
+		Records:    []string{"192.0.2.1"},
+		Chain: []dns.Record{
+			{Name: "alias.example.com.", Type: "CNAME", TTL: 60, Value: "www.example.com.", Data: "www.example.com."},
+		},
+		Answers: []dns.Record{
+			{Name: "www.example.com.", Type: "A", TTL: 300, Value: "192.0.2.1", Data: "192.0.2.1"},
+		},
+		Server:    "192.0.2.53:53",
+		QueryTime: 1500 * time.Microsecond,
+	}
+
+	want := "query,qtype,server,rcode,owner,ttl,class,type,rdata,latency_ms,error_type,error\n" +
+		"alias.example.com,A,192.0.2.53:53,NOERROR,alias.example.com.,60,IN,CNAME,www.example.com.,1.500,,\n" +
+		"alias.example.com,A,192.0.2.53:53,NOERROR,www.example.com.,300,IN,A,192.0.2.1,1.500,,\n"
+	if output := formatter.FormatResult(result); output != want {
+		t.Errorf("FormatResult() =\n%s\nwant:\n%s", output, want)
+	}
+
+	// Later results of the same run add rows without repeating the header
+	noData := &dns.Result{Domain: "example.com", RecordType: "MX", Rcode: "NOERROR", NoData: true, Server: "192.0.2.53:53"}
+	if output := formatter.FormatResult(noData); output != "example.com,MX,192.0.2.53:53,NOERROR,,,,,,,,\n" {
+		t.Errorf("Expected a single row without a header for NODATA, got:\n%s", output)
+	}
+}
+
+func TestTableFormatter_FormatResult_Referral(t *testing.T) {
+	rows := readTable(t, NewFormatterWithOptions(Options{Format: FormatCSV}).FormatResult(referralResult()), ',')
+	if len(rows) != 3 || rows[1][7] != "NS" || rows[1][8] != "a.iana-servers.net." || rows[2][8] != "b.iana-servers.net." {
+		t.Errorf("Expected a row for each NS record of the referral, got %q", rows)
+	}
+}
+
+func TestTableFormatter_FormatResult_TXTQuoting(t *testing.T) {
+	data := `"v=spf1 include:_spf.example.com" " -all" "a,\"b\"	c\\d\065"`
+	text := "v=spf1 include:_spf.example.com -alla,\"b\"\tc\\dA"
+	result := &dns.Result{
+		Domain:     "example.com",
+		RecordType: "TXT",
+		Rcode:      "NOERROR",
+		Answers:    []dns.Record{{Name: "example.com.", Type: "TXT", TTL: 300, Data: data}},
+	}
+
+	for _, tt := range []struct {
+		format string
+		comma  rune
+	}{{FormatCSV, ','}, {FormatTSV, '\t'}} {
+		t.Run(tt.format, func(t *testing.T) {
+			rows := readTable(t, NewFormatterWithOptions(Options{Format: tt.format}).FormatResult(result), tt.comma)
+			if len(rows) != 2 || len(rows[1]) != len(tableColumns) {
+				t.Fatalf("Expected a header and one row of %d columns, got %q", len(tableColumns), rows)
+			}
+			if rows[1][8] != text {
+				t.Errorf("rdata = %q, want %q", rows[1][8], text)
+			}
+		})
+	}
+}
+
+func TestTableFormatter_Failures(t *testing.T) {
+	formatter := NewFormatterWithOptions(Options{Format: FormatCSV})
+	err := errors.NewDNSError("domain 'missing.example.com' not found (NXDOMAIN)", nil, "missing.example.com", "192.0.2.53:53")
+
+	// A failed query keeps its query columns
+	result := &dns.Result{Domain: "missing.example.com", RecordType: "A", Rcode: "NXDOMAIN", Server: "192.0.2.53:53", Error: err}
+	rows := readTable(t, formatter.FormatResult(result), ',')
+	want := []string{"missing.example.com", "A", "192.0.2.53:53", "NXDOMAIN", "", "", "", "", "", "", "DNS", "domain 'missing.example.com' not found (NXDOMAIN)"}
+	if len(rows) != 2 || strings.Join(rows[1], "|") != strings.Join(want, "|") {
+		t.Errorf("Expected the header and %q, got %q", want, rows)
+	}
+
+	// A failure without a result has the domain and server of the error
+	rows = readTable(t, formatter.FormatError(errors.NewNetworkError("connection refused", nil, "192.0.2.54:53")), ',')
+	if len(rows) != 1 || rows[0][2] != "192.0.2.54:53" || rows[0][10] != "Network" || rows[0][11] != "connection refused" {
+		t.Errorf("Unexpected failure row %q", rows)
+	}
+}
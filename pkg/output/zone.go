@@ -0,0 +1,179 @@
+package output
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-dig/pkg/dns"
+
+	mdns "github.com/miekg/dns"
+)
+
+// zoneFormatter writes answers as RFC 1035 master-file records that can be
+// pasted into a zone file: $ORIGIN and $TTL directives, then each record with
+// names relative to the origin and a TTL only where it differs from $TTL.
+// Names stay in A-labels, as zone files need them. Errors and the output of
+// the other commands are text.
+type zoneFormatter struct {
+	*formatter
+}
+
+// zoneRecord is a record in master-file syntax
+type zoneRecord struct {
+	name  string
+	ttl   uint32
+	class string
+	rtype string
+	data  string
+}
+
+// nameFields are the fields of the record data of each type that hold domain
+// names, which are written relative to the origin like owner names
+var nameFields = map[string][]int{
+	"CNAME": {0},
+	"NS":    {0},
+	"MX":    {1},
+	"SOA":   {0, 1},
+}
+
+// FormatResult writes the CNAME chain and answers of a query as zone records
+func (f *zoneFormatter) FormatResult(result *dns.Result) string {
+	if result == nil || result.Error != nil {
+		return f.formatter.FormatResult(result)
+	}
+
+	var output strings.Builder
+	class := resultClass(result)
+	output.WriteString(fmt.Sprintf("; <<>> go-dig <<>> %s %s %s", result.Domain, class, result.RecordType))
+	if result.Server != "" {
+		output.WriteString(fmt.Sprintf(" @%s", strings.TrimSuffix(result.Server, ":53")))
+	}
+	output.WriteString("\n")
+
+	var records []zoneRecord
+	for _, hop := range result.Chain {
+		records = append(records, newZoneRecord(hop, class))
+	}
+	if len(result.Answers) > 0 {
+		for _, answer := range result.Answers {
+			records = append(records, newZoneRecord(answer, class))
+		}
+	} else {
+		// Results built without structured answers only carry the values
+		for _, value := range result.Records {
+			records = append(records, newZoneRecord(dns.Record{Name: ownerName(result), Type: result.RecordType, Value: value}, class))
+		}
+	}
+
+	// A referral's name servers are the delegation of the zone that has the records
+	if len(records) == 0 && len(result.Referral) > 0 {
+		output.WriteString(fmt.Sprintf("; referral: %s is served by the name servers of %s\n", ownerName(result), result.Referral[0].Name))
+		for _, ns := range result.Referral {
+			records = append(records, newZoneRecord(ns, class))
+		}
+	}
+
+	if len(records) == 0 {
+		output.WriteString(fmt.Sprintf("; %s has no %s records\n", ownerName(result), result.RecordType))
+		return output.String()
+	}
+	output.WriteString(formatZone(records))
+	return output.String()
+}
+
+// newZoneRecord converts a record of a result; records built without data in
+// master-file syntax use their value
+func newZoneRecord(record dns.Record, class string) zoneRecord {
+	data := record.Data
+	if data == "" {
+		data = record.Value
+	}
+	return zoneRecord{name: mdns.Fqdn(record.Name), ttl: record.TTL, class: class, rtype: strings.ToUpper(record.Type), data: data}
+}
+
+// formatZone writes records with $ORIGIN set to the closest name enclosing
+// every owner and $TTL to the most common TTL. Records with no common
+// enclosing name below the root keep absolute names.
+func formatZone(records []zoneRecord) string {
+	var output strings.Builder
+
+	origin := records[0].name
+	for _, record := range records[1:] {
+		origin = commonSuffix(origin, record.name)
+	}
+	if origin != "." {
+		output.WriteString(fmt.Sprintf("$ORIGIN %s\n", origin))
+	}
+	ttl := commonTTL(records)
+	output.WriteString(fmt.Sprintf("$TTL %d\n", ttl))
+
+	for _, record := range records {
+		line := relativeName(record.name, origin)
+		if record.ttl != ttl {
+			line += "\t" + strconv.FormatUint(uint64(record.ttl), 10)
+		}
+		output.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\n", line, record.class, record.rtype, relativeData(record, origin)))
+	}
+	return output.String()
+}
+
+// commonSuffix returns the closest name enclosing two fully qualified names
+func commonSuffix(a, b string) string {
+	aLabels := mdns.SplitDomainName(a)
+	bLabels := mdns.SplitDomainName(b)
+	shared := 0
+	for shared < len(aLabels) && shared < len(bLabels) &&
+		strings.EqualFold(aLabels[len(aLabels)-1-shared], bLabels[len(bLabels)-1-shared]) {
+		shared++
+	}
+	if shared == 0 {
+		return "."
+	}
+	return mdns.Fqdn(strings.Join(aLabels[len(aLabels)-shared:], "."))
+}
+
+// commonTTL returns the TTL most records have, the first of them on a tie
+func commonTTL(records []zoneRecord) uint32 {
+	counts := make(map[uint32]int)
+	for _, record := range records {
+		counts[record.ttl]++
+	}
+	best := records[0].ttl
+	for _, record := range records {
+		if counts[record.ttl] > counts[best] {
+			best = record.ttl
+		}
+	}
+	return best
+}
+
+// relativeName writes a name relative to origin: @ for the origin itself, the
+// leading labels for names below it, and the absolute name otherwise
+func relativeName(name, origin string) string {
+	if origin == "." {
+		return name
+	}
+	if strings.EqualFold(name, origin) {
+		return "@"
+	}
+	if mdns.IsSubDomain(origin, name) {
+		return name[:len(name)-len(origin)-1]
+	}
+	return name
+}
+
+// relativeData writes the names in the data of a record relative to origin
+func relativeData(record zoneRecord, origin string) string {
+	positions, ok := nameFields[record.rtype]
+	if !ok {
+		return record.data
+	}
+	fields := strings.Fields(record.data)
+	for _, i := range positions {
+		if i < len(fields) && strings.HasSuffix(fields[i], ".") {
+			fields[i] = relativeName(fields[i], origin)
+		}
+	}
+	return strings.Join(fields, " ")
+}
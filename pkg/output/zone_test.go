@@ -0,0 +1,114 @@
+package output
+
+import (
+	"strings"
+	"testing"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+func TestNewFormatterWithOptions_Zone(t *testing.T) {
+	if _, ok := NewFormatterWithOptions(Options{Format: FormatZone}).(*zoneFormatter); !ok {
+		t.Error("Expected FormatZone to select the zone formatter")
+	}
+}
+
+func TestZoneFormatter_FormatResult(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "alias.example.com",
+		RecordType: "A",
+		Records:    []string{"192.0.2.1", "192.0.2.2"},
+		Chain: []dns.Record{
+			{Name: "alias.example.com.", Type: "CNAME", TTL: 60, Value: "www.example.com.", Data: "www.example.com."},
+		},
+		Answers: []dns.Record{
+			{Name: "www.example.com.", Type: "A", TTL: 300, Value: "192.0.2.1", Data: "192.0.2.1"},
+			{Name: "www.example.com.", Type: "A", TTL: 300, Value: "192.0.2.2", Data: "192.0.2.2"},
+		},
+		Server: "192.0.2.53:53",
+	}
+
+	want := "; <<>> go-dig <<>> alias.example.com IN A @192.0.2.53\n" +
+		"$ORIGIN example.com.\n" +
+		"$TTL 300\n" +
+		"alias\t60\tIN\tCNAME\twww\n" +
+		"www\tIN\tA\t192.0.2.1\n" +
+		"www\tIN\tA\t192.0.2.2\n"
+	if output := NewFormatterWithOptions(Options{Format: FormatZone}).FormatResult(result); output != want {
+		t.Errorf("FormatResult() =\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestZoneFormatter_FormatResult_RecordData(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers []dns.Record
+		want    string
+	}{
+		{
+			name: "TXT strings stay quoted",
+			answers: []dns.Record{
+				{Name: "example.com.", Type: "TXT", TTL: 600, Value: `v=spf1 -all second "part"`, Data: `"v=spf1 -all" "second \"part\""`},
+			},
+			want: "$ORIGIN example.com.\n$TTL 600\n@\tIN\tTXT\t\"v=spf1 -all\" \"second \\\"part\\\"\"\n",
+		},
+		{
+			name: "MX exchanges are relative",
+			answers: []dns.Record{
+				{Name: "example.com.", Type: "MX", TTL: 3600, Data: "10 mail.example.com."},
+				{Name: "example.com.", Type: "MX", TTL: 3600, Data: "20 mx.example.net."},
+			},
+			want: "$ORIGIN example.com.\n$TTL 3600\n@\tIN\tMX\t10 mail\n@\tIN\tMX\t20 mx.example.net.\n",
+		},
+		{
+			name: "owners without a common parent stay absolute",
+			answers: []dns.Record{
+				{Name: "example.com.", Type: "NS", TTL: 60, Data: "ns1.example.com."},
+				{Name: "example.net.", Type: "NS", TTL: 60, Data: "ns1.example.net."},
+			},
+			want: "$TTL 60\nexample.com.\tIN\tNS\tns1.example.com.\nexample.net.\tIN\tNS\tns1.example.net.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &dns.Result{Domain: "example.com", RecordType: tt.answers[0].Type, Answers: tt.answers}
+			output := NewFormatterWithOptions(Options{Format: FormatZone}).FormatResult(result)
+			if body := output[strings.Index(output, "\n")+1:]; body != tt.want {
+				t.Errorf("FormatResult() =\n%s\nwant:\n%s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneFormatter_FormatResult_NoRecords(t *testing.T) {
+	result := &dns.Result{Domain: "example.com", RecordType: "MX", Rcode: "NOERROR"}
+	output := NewFormatterWithOptions(Options{Format: FormatZone}).FormatResult(result)
+	if !strings.HasSuffix(output, "; example.com has no MX records\n") {
+		t.Errorf("Expected a comment for no records, got:\n%s", output)
+	}
+}
+
+func TestZoneFormatter_FormatResult_Referral(t *testing.T) {
+	output := NewFormatterWithOptions(Options{Format: FormatZone}).FormatResult(referralResult())
+	want := "; referral: www.example.com is served by the name servers of example.com.\n" +
+		"$ORIGIN example.com.\n" +
+		"$TTL 172800\n" +
+		"@\tIN\tNS\ta.iana-servers.net.\n" +
+		"@\tIN\tNS\tb.iana-servers.net.\n"
+	if body := output[strings.Index(output, "\n")+1:]; body != want {
+		t.Errorf("FormatResult() =\n%s\nwant:\n%s", body, want)
+	}
+}
+
+func TestZoneFormatter_FormatResult_Error(t *testing.T) {
+	result := &dns.Result{
+		Domain:     "missing.example.com",
+		RecordType: "A",
+		Error:      errors.NewDNSError("domain 'missing.example.com' not found (NXDOMAIN)", nil, "missing.example.com", ""),
+	}
+	output := NewFormatterWithOptions(Options{Format: FormatZone}).FormatResult(result)
+	if output != NewFormatter().FormatResult(result) {
+		t.Errorf("Expected errors to be written as text, got:\n%s", output)
+	}
+}
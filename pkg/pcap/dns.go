@@ -0,0 +1,274 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Packet is a DNS message found in a capture
+type Packet struct {
+	Time    time.Time
+	Network string    // "udp" or "tcp"
+	Src     string    // Sender as host:port
+	Dst     string    // Receiver as host:port
+	Data    []byte    // The message as captured
+	Msg     *mdns.Msg // Decoded message; nil if Err is set
+	Err     error     // Why the message could not be decoded
+}
+
+// ReadDNS reads a pcap or pcapng capture and returns the DNS messages sent to
+// or from port, in capture order. Messages over TCP are reassembled from
+// their segments. Frames that are not IP, fragments and other traffic are
+// skipped.
+func ReadDNS(r io.Reader, port int) ([]Packet, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var packets []Packet
+	streams := map[string]*tcpStream{}
+	for number := 1; ; number++ {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, fmt.Errorf("frame %d: %w", number, err)
+		}
+
+		segment, ok := decodeFrame(frame)
+		if !ok || (segment.srcPort != port && segment.dstPort != port) {
+			continue
+		}
+		src := net.JoinHostPort(segment.srcIP.String(), strconv.Itoa(segment.srcPort))
+		dst := net.JoinHostPort(segment.dstIP.String(), strconv.Itoa(segment.dstPort))
+
+		if segment.protocol == protocolUDP {
+			packets = append(packets, newPacket(frame.Time, "udp", src, dst, segment.payload))
+			continue
+		}
+
+		key := src + ">" + dst
+		stream := streams[key]
+		if stream == nil || segment.syn {
+			stream = &tcpStream{}
+			streams[key] = stream
+		}
+		for _, msg := range stream.add(segment) {
+			packets = append(packets, newPacket(frame.Time, "tcp", src, dst, msg))
+		}
+		if segment.fin || segment.rst {
+			delete(streams, key)
+		}
+	}
+}
+
+// newPacket decodes a DNS message
+func newPacket(at time.Time, network, src, dst string, data []byte) Packet {
+	packet := Packet{Time: at, Network: network, Src: src, Dst: dst, Data: data}
+	msg := new(mdns.Msg)
+	if err := msg.Unpack(data); err != nil {
+		packet.Err = err
+	} else {
+		packet.Msg = msg
+	}
+	return packet
+}
+
+// segment is the UDP datagram or TCP segment of a frame
+type segment struct {
+	protocol         byte
+	srcIP, dstIP     net.IP
+	srcPort, dstPort int
+	seq              uint32 // TCP only
+	syn, fin, rst    bool
+	payload          []byte
+}
+
+// decodeFrame decodes the IP packet in a frame down to its transport payload
+func decodeFrame(frame *Frame) (*segment, bool) {
+	data := frame.Data
+	var etherType uint16
+
+	switch frame.LinkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		// 802.1Q and 802.1ad VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case LinkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[0:]), data[20:]
+	case LinkTypeNull, LinkTypeLoop:
+		// The address family is in host byte order for Null; any version
+		// of it is recognized by the IP version nibble instead
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+	default:
+		return nil, false
+	}
+
+	if etherType != 0 && etherType != 0x0800 && etherType != 0x86dd {
+		return nil, false
+	}
+	if len(data) == 0 {
+		return nil, false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return decodeIPv4(data)
+	case 6:
+		return decodeIPv6(data)
+	}
+	return nil, false
+}
+
+// decodeIPv4 decodes an IPv4 packet
+func decodeIPv4(data []byte) (*segment, bool) {
+	if len(data) < 20 {
+		return nil, false
+	}
+	headerLength := int(data[0]&0x0f) * 4
+	totalLength := int(binary.BigEndian.Uint16(data[2:]))
+	if headerLength < 20 || totalLength < headerLength || len(data) < headerLength {
+		return nil, false
+	}
+	// Fragments are not reassembled
+	if flags := binary.BigEndian.Uint16(data[6:]); flags&0x2000 != 0 || flags&0x1fff != 0 {
+		return nil, false
+	}
+	if totalLength < len(data) {
+		data = data[:totalLength] // Drop Ethernet padding
+	}
+	return decodeTransport(data[9], net.IP(data[12:16]), net.IP(data[16:20]), data[headerLength:])
+}
+
+// decodeIPv6 decodes an IPv6 packet, skipping extension headers
+func decodeIPv6(data []byte) (*segment, bool) {
+	if len(data) < 40 {
+		return nil, false
+	}
+	payloadLength := int(binary.BigEndian.Uint16(data[4:]))
+	next := data[6]
+	src, dst := net.IP(data[8:24]), net.IP(data[24:40])
+	payload := data[40:]
+	if payloadLength < len(payload) {
+		payload = payload[:payloadLength]
+	}
+
+	for {
+		switch next {
+		case 0, 43, 60: // Hop-by-hop, routing and destination options
+			if len(payload) < 8 {
+				return nil, false
+			}
+			length := (int(payload[1]) + 1) * 8
+			if len(payload) < length {
+				return nil, false
+			}
+			next, payload = payload[0], payload[length:]
+		case protocolUDP, protocolTCP:
+			return decodeTransport(next, src, dst, payload)
+		default: // Fragments and other protocols
+			return nil, false
+		}
+	}
+}
+
+// decodeTransport decodes a UDP datagram or TCP segment
+func decodeTransport(protocol byte, src, dst net.IP, data []byte) (*segment, bool) {
+	s := &segment{protocol: protocol, srcIP: src, dstIP: dst}
+	switch protocol {
+	case protocolUDP:
+		if len(data) < 8 {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if length < 8 || length > len(data) {
+			return nil, false
+		}
+		s.payload = data[8:length]
+	case protocolTCP:
+		if len(data) < 20 {
+			return nil, false
+		}
+		offset := int(data[12]>>4) * 4
+		if offset < 20 || offset > len(data) {
+			return nil, false
+		}
+		s.seq = binary.BigEndian.Uint32(data[4:])
+		flags := data[13]
+		s.fin, s.syn, s.rst = flags&0x01 != 0, flags&0x02 != 0, flags&0x04 != 0
+		s.payload = data[offset:]
+	default:
+		return nil, false
+	}
+	s.srcPort = int(binary.BigEndian.Uint16(data[0:]))
+	s.dstPort = int(binary.BigEndian.Uint16(data[2:]))
+	return s, true
+}
+
+// tcpStream reassembles the DNS messages sent in one direction of a TCP
+// connection, each preceded by its two-byte length
+type tcpStream struct {
+	started bool
+	next    uint32 // Sequence number of the next expected byte
+	buffer  []byte
+}
+
+// add appends the payload of a segment and returns the messages completed
+// by it. Retransmitted bytes are dropped; after a gap in the sequence the
+// stream starts over with the segment.
+func (s *tcpStream) add(seg *segment) [][]byte {
+	seq, payload := seg.seq, seg.payload
+	if seg.syn {
+		seq++
+	}
+
+	if s.started {
+		ahead := int32(seq - s.next)
+		switch {
+		case ahead < 0 && int(-ahead) >= len(payload):
+			return nil // Retransmission
+		case ahead < 0:
+			payload = payload[-ahead:]
+		case ahead > 0:
+			s.buffer = nil // Missed data; resynchronize on this segment
+		}
+	}
+	s.started = true
+	s.next = seq + uint32(len(seg.payload))
+	s.buffer = append(s.buffer, payload...)
+
+	var messages [][]byte
+	for len(s.buffer) >= 2 {
+		length := int(binary.BigEndian.Uint16(s.buffer))
+		if len(s.buffer) < 2+length {
+			break
+		}
+		messages = append(messages, s.buffer[2:2+length])
+		s.buffer = s.buffer[2+length:]
+	}
+	return messages
+}
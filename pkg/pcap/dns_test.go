@@ -0,0 +1,116 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ethernetFrame wraps an IPv4 packet in an Ethernet header with a VLAN tag
+func ethernetFrame(packet []byte) []byte {
+	frame := make([]byte, 12, 18+len(packet))
+	frame = append(frame, 0x81, 0x00, 0, 7, 0x08, 0x00)
+	return append(frame, packet...)
+}
+
+// ipv4Packet builds an IPv4 packet from 192.0.2.10 to 192.0.2.53
+func ipv4Packet(protocol byte, flags uint16, payload []byte) []byte {
+	header := make([]byte, 20)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(header[6:], flags)
+	header[9] = protocol
+	copy(header[12:], net.IPv4(192, 0, 2, 10).To4())
+	copy(header[16:], net.IPv4(192, 0, 2, 53).To4())
+	return append(header, payload...)
+}
+
+// tcpSegment builds a TCP segment from port 40000 to port 53
+func tcpSegment(seq uint32, flags byte, payload []byte) []byte {
+	segment := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], 40000)
+	binary.BigEndian.PutUint16(segment[2:], 53)
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	segment[12] = 5 << 4
+	segment[13] = flags
+	return append(segment, payload...)
+}
+
+// udpDatagram builds a UDP datagram between the given ports
+func udpDatagram(srcPort, dstPort int, payload []byte) []byte {
+	datagram := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(datagram[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(datagram[4:], uint16(8+len(payload)))
+	return append(datagram, payload...)
+}
+
+func TestReadDNS_TCPReassembly(t *testing.T) {
+	first := packMsg(t, "www.example.com.", mdns.TypeA)
+	second := packMsg(t, "mail.example.com.", mdns.TypeMX)
+
+	// Two length-prefixed messages split unevenly across segments
+	var stream []byte
+	for _, msg := range [][]byte{first, second} {
+		stream = binary.BigEndian.AppendUint16(stream, uint16(len(msg)))
+		stream = append(stream, msg...)
+	}
+	split := len(first) - 5
+
+	frames := [][]byte{
+		ethernetFrame(ipv4Packet(protocolTCP, 0x4000, tcpSegment(999, 0x02, nil))),                           // SYN
+		ethernetFrame(ipv4Packet(protocolTCP, 0x4000, tcpSegment(1000, 0x18, stream[:split]))),               // Partial first message
+		ethernetFrame(ipv4Packet(protocolTCP, 0x4000, tcpSegment(1000, 0x18, stream[:split]))),               // Retransmission
+		ethernetFrame(ipv4Packet(protocolTCP, 0x4000, tcpSegment(uint32(1000+split), 0x18, stream[split:]))), // Rest of both
+	}
+
+	packets, err := ReadDNS(bytes.NewReader(ngCapture(time.Now(), frames...)), 53)
+	if err != nil {
+		t.Fatalf("ReadDNS() error = %v", err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(packets))
+	}
+	if packets[0].Msg.Question[0].Name != "www.example.com." || packets[1].Msg.Question[0].Name != "mail.example.com." {
+		t.Errorf("Unexpected messages %v and %v", packets[0].Msg.Question, packets[1].Msg.Question)
+	}
+	if packets[0].Network != "tcp" || packets[0].Src != "192.0.2.10:40000" || packets[0].Dst != "192.0.2.53:53" {
+		t.Errorf("Unexpected packet %s %s -> %s", packets[0].Network, packets[0].Src, packets[0].Dst)
+	}
+}
+
+func TestReadDNS_Filtering(t *testing.T) {
+	query := packMsg(t, "www.example.com.", mdns.TypeA)
+
+	frames := [][]byte{
+		ethernetFrame(ipv4Packet(protocolUDP, 0, udpDatagram(40000, 5353, query))),    // Other port
+		ethernetFrame(ipv4Packet(protocolUDP, 0x2000, udpDatagram(40000, 53, query))), // First fragment
+		ethernetFrame(ipv4Packet(1, 0, []byte{8, 0, 0, 0, 0, 0, 0, 0})),               // ICMP
+		append(make([]byte, 12), 0x08, 0x06, 0, 1),                                    // ARP
+		ethernetFrame(ipv4Packet(protocolUDP, 0, udpDatagram(40000, 53, []byte{1, 2, 3, 4, 5}))),
+		ethernetFrame(ipv4Packet(protocolUDP, 0, udpDatagram(53, 40000, query))),
+	}
+
+	packets, err := ReadDNS(bytes.NewReader(ngCapture(time.Now(), frames...)), 53)
+	if err != nil {
+		t.Fatalf("ReadDNS() error = %v", err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets on port 53, got %d: %+v", len(packets), packets)
+	}
+	if packets[0].Err == nil || packets[0].Msg != nil || len(packets[0].Data) != 5 {
+		t.Errorf("Expected an undecodable message with its bytes, got %+v", packets[0])
+	}
+	if packets[1].Err != nil || packets[1].Src != "192.0.2.10:53" {
+		t.Errorf("Expected a decoded message from port 53, got %+v", packets[1])
+	}
+
+	packets, err = ReadDNS(bytes.NewReader(ngCapture(time.Now(), frames...)), 5353)
+	if err != nil || len(packets) != 1 {
+		t.Errorf("Expected 1 packet on port 5353, got %d (error %v)", len(packets), err)
+	}
+}
@@ -0,0 +1,233 @@
+// Package pcap writes DNS traffic to capture files that Wireshark and tcpdump
+// read, and reads DNS messages back from pcap and pcapng captures.
+//
+// Written captures hold the DNS messages exactly as sent and received, with
+// IP and UDP or TCP headers built from the addresses of the connection. TCP
+// segments carry the two-byte length prefix of DNS over TCP but no handshake.
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Link types of captured frames (see https://www.tcpdump.org/linktypes.html)
+const (
+	LinkTypeNull      = 0   // BSD loopback, address family in host byte order
+	LinkTypeEthernet  = 1   // Ethernet II
+	LinkTypeRaw       = 101 // IPv4 or IPv6 packet without a link header
+	LinkTypeLoop      = 108 // OpenBSD loopback, address family in network byte order
+	LinkTypeLinuxSLL  = 113 // Linux "any" interface
+	LinkTypeIPv4      = 228
+	LinkTypeIPv6      = 229
+	LinkTypeLinuxSLL2 = 276
+)
+
+// File format constants
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+	snapLength        = 65535
+)
+
+// IP protocol numbers
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// Writer writes DNS messages to a pcap file as IP packets. It is safe for
+// concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	ipID uint16            // Identification of the next IPv4 packet
+	seqs map[string]uint32 // Next TCP sequence number of each direction of a connection
+	err  error
+}
+
+// NewWriter writes the pcap file header to w and returns a Writer for the
+// packets. Timestamps have nanosecond resolution.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], magicNanoseconds)
+	binary.LittleEndian.PutUint16(header[4:], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], snapLength)
+	binary.LittleEndian.PutUint32(header[20:], LinkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, seqs: map[string]uint32{}}, nil
+}
+
+// WriteDNS writes a DNS message sent from src to dst at the given time. The
+// addresses are *net.UDPAddr or *net.TCPAddr and select the transport.
+func (w *Writer) WriteDNS(at time.Time, src, dst net.Addr, msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	packet, err := w.packet(src, dst, msg)
+	if err == nil {
+		err = w.writeRecord(at, packet)
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+// Err returns the first error writing a packet, if any
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// writeRecord writes one packet with its record header
+func (w *Writer) writeRecord(at time.Time, packet []byte) error {
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(at.Nanosecond()))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(packet)))
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.w.Write(packet)
+	return err
+}
+
+// packet builds the IP packet carrying msg. The caller holds w.mu.
+func (w *Writer) packet(src, dst net.Addr, msg []byte) ([]byte, error) {
+	var srcIP, dstIP net.IP
+	var srcPort, dstPort int
+	var segment []byte
+	var protocol byte
+
+	switch s := src.(type) {
+	case *net.UDPAddr:
+		d, ok := dst.(*net.UDPAddr)
+		if !ok {
+			return nil, fmt.Errorf("pcap: mismatched addresses %s and %s", src, dst)
+		}
+		srcIP, dstIP, srcPort, dstPort = s.IP, d.IP, s.Port, d.Port
+		protocol = protocolUDP
+		segment = make([]byte, 8+len(msg))
+		binary.BigEndian.PutUint16(segment[0:], uint16(srcPort))
+		binary.BigEndian.PutUint16(segment[2:], uint16(dstPort))
+		binary.BigEndian.PutUint16(segment[4:], uint16(len(segment)))
+		copy(segment[8:], msg)
+	case *net.TCPAddr:
+		d, ok := dst.(*net.TCPAddr)
+		if !ok {
+			return nil, fmt.Errorf("pcap: mismatched addresses %s and %s", src, dst)
+		}
+		srcIP, dstIP, srcPort, dstPort = s.IP, d.IP, s.Port, d.Port
+		protocol = protocolTCP
+
+		// Sequence numbers continue across the messages of a connection
+		flow, reverse := s.String()+">"+d.String(), d.String()+">"+s.String()
+		seq, ok := w.seqs[flow]
+		if !ok {
+			seq = 1
+		}
+		ack, ok := w.seqs[reverse]
+		if !ok {
+			ack = 1
+		}
+		w.seqs[flow] = seq + 2 + uint32(len(msg))
+
+		segment = make([]byte, 20+2+len(msg))
+		binary.BigEndian.PutUint16(segment[0:], uint16(srcPort))
+		binary.BigEndian.PutUint16(segment[2:], uint16(dstPort))
+		binary.BigEndian.PutUint32(segment[4:], seq)
+		binary.BigEndian.PutUint32(segment[8:], ack)
+		segment[12] = 5 << 4                            // Header length in 32-bit words
+		segment[13] = 0x18                              // PSH, ACK
+		binary.BigEndian.PutUint16(segment[14:], 65535) // Window
+		binary.BigEndian.PutUint16(segment[20:], uint16(len(msg)))
+		copy(segment[22:], msg)
+	default:
+		return nil, fmt.Errorf("pcap: unsupported address type %T", src)
+	}
+
+	checksumOffset := 6 // UDP
+	if protocol == protocolTCP {
+		checksumOffset = 16
+	}
+
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		header := make([]byte, 20)
+		header[0] = 0x45 // Version 4, 20-byte header
+		binary.BigEndian.PutUint16(header[2:], uint16(len(header)+len(segment)))
+		binary.BigEndian.PutUint16(header[4:], w.ipID)
+		binary.BigEndian.PutUint16(header[6:], 0x4000) // Don't fragment
+		header[8] = 64                                 // TTL
+		header[9] = protocol
+		copy(header[12:], src4)
+		copy(header[16:], dst4)
+		binary.BigEndian.PutUint16(header[10:], checksum(header, 0))
+		w.ipID++
+
+		binary.BigEndian.PutUint16(segment[checksumOffset:], transportChecksum(src4, dst4, protocol, segment))
+		return append(header, segment...), nil
+	}
+
+	src16, dst16 := srcIP.To16(), dstIP.To16()
+	if src16 == nil || dst16 == nil {
+		return nil, fmt.Errorf("pcap: invalid addresses %s and %s", src, dst)
+	}
+	header := make([]byte, 40)
+	header[0] = 0x60 // Version 6
+	binary.BigEndian.PutUint16(header[4:], uint16(len(segment)))
+	header[6] = protocol
+	header[7] = 64 // Hop limit
+	copy(header[8:], src16)
+	copy(header[24:], dst16)
+
+	binary.BigEndian.PutUint16(segment[checksumOffset:], transportChecksum(src16, dst16, protocol, segment))
+	return append(header, segment...), nil
+}
+
+// transportChecksum computes the UDP or TCP checksum of segment, which
+// covers a pseudo-header of the IP addresses, protocol and length
+func transportChecksum(src, dst net.IP, protocol byte, segment []byte) uint16 {
+	pseudo := make([]byte, 0, 2*len(src)+4)
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = append(pseudo, 0, protocol)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+
+	sum := checksum(segment, sum16(pseudo))
+	if sum == 0 && protocol == protocolUDP {
+		return 0xffff // Zero means "no checksum" in UDP
+	}
+	return sum
+}
+
+// checksum returns the Internet checksum (RFC 1071) of data, continuing from
+// a partial sum
+func checksum(data []byte, partial uint32) uint16 {
+	sum := partial + sum16(data)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// sum16 adds data as big-endian 16-bit words
+func sum16(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
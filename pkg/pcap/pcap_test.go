@@ -0,0 +1,105 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// packMsg returns a query for name in wire format
+func packMsg(t *testing.T, name string, qtype uint16) []byte {
+	t.Helper()
+	msg := new(mdns.Msg)
+	msg.SetQuestion(name, qtype)
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+	return packed
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	client4 := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+	server4 := &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+	client6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 40001}
+	server6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+
+	query := packMsg(t, "www.example.com.", mdns.TypeA)
+	second := packMsg(t, "mail.example.com.", mdns.TypeMX)
+	writes := []struct {
+		src, dst net.Addr
+		msg      []byte
+	}{
+		{client4, server4, query},
+		{client6, server6, query},
+		{client6, server6, second},
+	}
+	for _, write := range writes {
+		if err := writer.WriteDNS(at, write.src, write.dst, write.msg); err != nil {
+			t.Fatalf("WriteDNS() error = %v", err)
+		}
+	}
+	if err := writer.WriteDNS(at, client4, server6, query); err == nil || writer.Err() == nil {
+		t.Error("Expected an error for mismatched address types")
+	}
+
+	packets, err := ReadDNS(bytes.NewReader(buffer.Bytes()), 53)
+	if err != nil {
+		t.Fatalf("ReadDNS() error = %v", err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("Expected 3 packets, got %d", len(packets))
+	}
+
+	expected := []struct{ network, src, dst, name string }{
+		{"udp", "192.0.2.10:40000", "192.0.2.53:53", "www.example.com."},
+		{"tcp", "[2001:db8::10]:40001", "[2001:db8::53]:53", "www.example.com."},
+		{"tcp", "[2001:db8::10]:40001", "[2001:db8::53]:53", "mail.example.com."},
+	}
+	for i, packet := range packets {
+		want := expected[i]
+		if packet.Network != want.network || packet.Src != want.src || packet.Dst != want.dst {
+			t.Errorf("Packet %d = %s %s -> %s, want %s %s -> %s", i, packet.Network, packet.Src, packet.Dst, want.network, want.src, want.dst)
+		}
+		if packet.Err != nil || packet.Msg.Question[0].Name != want.name {
+			t.Errorf("Packet %d decoded to %v (error %v), want a query for %s", i, packet.Msg, packet.Err, want.name)
+		}
+		if !packet.Time.Equal(at) {
+			t.Errorf("Packet %d time = %v, want %v", i, packet.Time, at)
+		}
+	}
+	if !bytes.Equal(packets[0].Data, query) {
+		t.Error("Expected the message bytes to be kept exactly")
+	}
+}
+
+func TestWriter_Checksums(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, _ := NewWriter(&buffer)
+	src := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+	dst := &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+	if err := writer.WriteDNS(time.Now(), src, dst, packMsg(t, "example.com.", mdns.TypeA)); err != nil {
+		t.Fatalf("WriteDNS() error = %v", err)
+	}
+
+	packet := buffer.Bytes()[24+16:]
+	if checksum(packet[:20], 0) != 0 {
+		t.Error("IPv4 header checksum does not verify")
+	}
+	pseudo := append(append(append([]byte{}, packet[12:16]...), packet[16:20]...), 0, protocolUDP)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(packet)-20))
+	if checksum(packet[20:], sum16(pseudo)) != 0 {
+		t.Error("UDP checksum does not verify")
+	}
+}
@@ -0,0 +1,272 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// pcapng block types
+const (
+	blockSectionHeader    = 0x0a0d0d0a
+	blockInterface        = 0x00000001
+	blockPacket           = 0x00000002 // Obsolete, still written by old tools
+	blockSimplePacket     = 0x00000003
+	blockEnhancedPacket   = 0x00000006
+	byteOrderMagic        = 0x1a2b3c4d
+	optionEndOfOpt        = 0
+	optionTimestampResol  = 9
+	maxBlockLength        = 64 << 20
+	defaultTimestampResol = 6 // Microseconds
+)
+
+// ErrNotCapture is returned by NewReader for input that is neither a pcap nor
+// a pcapng file
+var ErrNotCapture = errors.New("not a pcap or pcapng file")
+
+// Frame is a captured link-layer frame
+type Frame struct {
+	Time     time.Time
+	LinkType int
+	Data     []byte // Captured bytes, which may be shorter than the frame was
+}
+
+// Reader reads the frames of a pcap or pcapng capture
+type Reader struct {
+	r   *bufio.Reader
+	ng  bool
+	ord binary.ByteOrder
+
+	// pcap
+	linkType int
+	nanos    bool
+
+	// pcapng
+	interfaces []ngInterface
+}
+
+// ngInterface is an interface description of a pcapng section
+type ngInterface struct {
+	linkType int
+	snapLen  uint32
+	units    float64 // Timestamp units per second
+}
+
+// NewReader reads the file header from r and returns a Reader for the frames
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+
+	magic, err := reader.r.Peek(4)
+	if err != nil {
+		return nil, ErrNotCapture
+	}
+	if binary.BigEndian.Uint32(magic) == blockSectionHeader {
+		reader.ng = true
+		if err := reader.readSectionHeader(); err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		return nil, ErrNotCapture
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(header) {
+		case magicMicroseconds:
+			reader.ord = order
+		case magicNanoseconds:
+			reader.ord, reader.nanos = order, true
+		default:
+			continue
+		}
+		// The upper bits of the link type field carry an FCS length
+		reader.linkType = int(order.Uint32(header[20:]) & 0x0fffffff)
+		return reader, nil
+	}
+	return nil, ErrNotCapture
+}
+
+// Next returns the next frame. It returns io.EOF at the end of the capture.
+func (r *Reader) Next() (*Frame, error) {
+	if r.ng {
+		return r.nextBlock()
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated packet header")
+		}
+		return nil, err
+	}
+	seconds, fraction := r.ord.Uint32(header[0:]), r.ord.Uint32(header[4:])
+	length := r.ord.Uint32(header[8:])
+	if length > maxBlockLength {
+		return nil, fmt.Errorf("packet length %d is too large", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("truncated packet data")
+	}
+
+	nanoseconds := int64(fraction)
+	if !r.nanos {
+		nanoseconds *= 1000
+	}
+	return &Frame{Time: time.Unix(int64(seconds), nanoseconds).UTC(), LinkType: r.linkType, Data: data}, nil
+}
+
+// readSectionHeader reads a pcapng section header block and starts a new
+// section with its byte order
+func (r *Reader) readSectionHeader() error {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		return ErrNotCapture
+	}
+	switch {
+	case binary.LittleEndian.Uint32(head[8:]) == byteOrderMagic:
+		r.ord = binary.LittleEndian
+	case binary.BigEndian.Uint32(head[8:]) == byteOrderMagic:
+		r.ord = binary.BigEndian
+	default:
+		return ErrNotCapture
+	}
+
+	length := r.ord.Uint32(head[4:])
+	if length < 28 || length%4 != 0 || length > maxBlockLength {
+		return fmt.Errorf("invalid section header length %d", length)
+	}
+	if _, err := r.r.Discard(int(length) - 12); err != nil {
+		return fmt.Errorf("truncated section header")
+	}
+	r.interfaces = nil
+	return nil
+}
+
+// nextBlock reads pcapng blocks until one holds a packet
+func (r *Reader) nextBlock() (*Frame, error) {
+	for {
+		typeBytes, err := r.r.Peek(4)
+		if err != nil {
+			if len(typeBytes) == 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("truncated block")
+		}
+		if binary.BigEndian.Uint32(typeBytes) == blockSectionHeader {
+			if err := r.readSectionHeader(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(r.r, head); err != nil {
+			return nil, fmt.Errorf("truncated block")
+		}
+		blockType, length := r.ord.Uint32(head[0:]), r.ord.Uint32(head[4:])
+		if length < 12 || length%4 != 0 || length > maxBlockLength {
+			return nil, fmt.Errorf("invalid block length %d", length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return nil, fmt.Errorf("truncated block")
+		}
+		body = body[:len(body)-4] // Trailing copy of the length
+
+		switch blockType {
+		case blockInterface:
+			if err := r.readInterface(body); err != nil {
+				return nil, err
+			}
+		case blockEnhancedPacket, blockPacket:
+			return r.packetBlock(blockType, body)
+		case blockSimplePacket:
+			if len(body) < 4 || len(r.interfaces) == 0 {
+				return nil, fmt.Errorf("invalid simple packet block")
+			}
+			iface := r.interfaces[0]
+			length := r.ord.Uint32(body)
+			if iface.snapLen > 0 && length > iface.snapLen {
+				length = iface.snapLen
+			}
+			if int(length) > len(body)-4 {
+				return nil, fmt.Errorf("invalid simple packet block")
+			}
+			// Simple packets carry no timestamp
+			return &Frame{LinkType: iface.linkType, Data: body[4 : 4+length]}, nil
+		}
+	}
+}
+
+// readInterface reads an interface description block
+func (r *Reader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("invalid interface description block")
+	}
+	iface := ngInterface{
+		linkType: int(r.ord.Uint16(body[0:])),
+		snapLen:  r.ord.Uint32(body[4:]),
+		units:    math.Pow10(defaultTimestampResol),
+	}
+
+	options := body[8:]
+	for len(options) >= 4 {
+		code, length := r.ord.Uint16(options[0:]), int(r.ord.Uint16(options[2:]))
+		if code == optionEndOfOpt || 4+length > len(options) {
+			break
+		}
+		if code == optionTimestampResol && length >= 1 {
+			resolution := options[4]
+			if resolution&0x80 != 0 {
+				iface.units = math.Pow(2, float64(resolution&0x7f))
+			} else {
+				iface.units = math.Pow10(int(resolution))
+			}
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+
+	r.interfaces = append(r.interfaces, iface)
+	return nil
+}
+
+// packetBlock returns the frame of an enhanced or obsolete packet block
+func (r *Reader) packetBlock(blockType uint32, body []byte) (*Frame, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("invalid packet block")
+	}
+	var index int
+	if blockType == blockEnhancedPacket {
+		index = int(r.ord.Uint32(body[0:]))
+	} else {
+		index = int(r.ord.Uint16(body[0:]))
+	}
+	if index >= len(r.interfaces) {
+		return nil, fmt.Errorf("packet block refers to unknown interface %d", index)
+	}
+	iface := r.interfaces[index]
+
+	length := r.ord.Uint32(body[12:])
+	if int(length) > len(body)-20 {
+		return nil, fmt.Errorf("invalid packet block")
+	}
+
+	stamp := uint64(r.ord.Uint32(body[4:]))<<32 | uint64(r.ord.Uint32(body[8:]))
+	seconds := stamp / uint64(iface.units)
+	remainder := stamp % uint64(iface.units)
+	nanoseconds := int64(float64(remainder) * 1e9 / iface.units)
+
+	return &Frame{
+		Time:     time.Unix(int64(seconds), nanoseconds).UTC(),
+		LinkType: iface.linkType,
+		Data:     body[20 : 20+length],
+	}, nil
+}
@@ -0,0 +1,130 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// ngBlock builds a little-endian pcapng block around body, padding it to a
+// multiple of four bytes
+func ngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	length := uint32(12 + len(body))
+	block := binary.LittleEndian.AppendUint32(nil, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	return binary.LittleEndian.AppendUint32(block, length)
+}
+
+// ngCapture builds a pcapng capture with one Ethernet interface using
+// nanosecond timestamps and an enhanced packet block per frame
+func ngCapture(at time.Time, frames ...[]byte) []byte {
+	var capture []byte
+
+	header := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, 0)
+	header = binary.LittleEndian.AppendUint64(header, ^uint64(0)) // Section length unknown
+	capture = append(capture, ngBlock(blockSectionHeader, header)...)
+
+	iface := binary.LittleEndian.AppendUint16(nil, LinkTypeEthernet)
+	iface = binary.LittleEndian.AppendUint16(iface, 0)
+	iface = binary.LittleEndian.AppendUint32(iface, 262144)
+	iface = append(iface, optionTimestampResol, 0, 1, 0, 9, 0, 0, 0) // if_tsresol = 10^-9
+	iface = append(iface, 0, 0, 0, 0)                                // opt_endofopt
+	capture = append(capture, ngBlock(blockInterface, iface)...)
+
+	stamp := uint64(at.UnixNano())
+	for _, frame := range frames {
+		packet := binary.LittleEndian.AppendUint32(nil, 0)
+		packet = binary.LittleEndian.AppendUint32(packet, uint32(stamp>>32))
+		packet = binary.LittleEndian.AppendUint32(packet, uint32(stamp))
+		packet = binary.LittleEndian.AppendUint32(packet, uint32(len(frame)))
+		packet = binary.LittleEndian.AppendUint32(packet, uint32(len(frame)))
+		packet = append(packet, frame...)
+		capture = append(capture, ngBlock(blockEnhancedPacket, packet)...)
+	}
+	return capture
+}
+
+func TestReader_PcapNG(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	capture := ngCapture(at, []byte{1, 2, 3}, []byte{4, 5, 6, 7, 8})
+
+	reader, err := NewReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	for _, want := range [][]byte{{1, 2, 3}, {4, 5, 6, 7, 8}} {
+		frame, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if !bytes.Equal(frame.Data, want) || frame.LinkType != LinkTypeEthernet || !frame.Time.Equal(at) {
+			t.Errorf("Frame = %+v, want data %v at %v", frame, want, at)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last frame, got %v", err)
+	}
+}
+
+func TestReader_PcapBigEndian(t *testing.T) {
+	header := binary.BigEndian.AppendUint32(nil, magicMicroseconds)
+	header = binary.BigEndian.AppendUint16(header, 2)
+	header = binary.BigEndian.AppendUint16(header, 4)
+	header = append(header, make([]byte, 8)...)
+	header = binary.BigEndian.AppendUint32(header, snapLength)
+	header = binary.BigEndian.AppendUint32(header, LinkTypeRaw)
+
+	record := binary.BigEndian.AppendUint32(nil, 1704164645)
+	record = binary.BigEndian.AppendUint32(record, 250000) // Microseconds
+	record = binary.BigEndian.AppendUint32(record, 2)
+	record = binary.BigEndian.AppendUint32(record, 2)
+	record = append(record, 0x45, 0)
+
+	reader, err := NewReader(bytes.NewReader(append(header, record...)))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	frame, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	want := time.Unix(1704164645, 250000000).UTC()
+	if !frame.Time.Equal(want) || frame.LinkType != LinkTypeRaw || len(frame.Data) != 2 {
+		t.Errorf("Frame = %+v, want 2 bytes of raw IP at %v", frame, want)
+	}
+}
+
+func TestReader_Invalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":    nil,
+		"text":     []byte("this is not a capture file at all"),
+		"short":    {0xd4, 0xc3, 0xb2},
+		"ng order": ngBlock(blockSectionHeader, make([]byte, 16)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(data)); err != ErrNotCapture {
+				t.Errorf("NewReader() error = %v, want ErrNotCapture", err)
+			}
+		})
+	}
+
+	// A capture cut off in the middle of a packet
+	var buffer bytes.Buffer
+	writer, _ := NewWriter(&buffer)
+	writer.writeRecord(time.Now(), make([]byte, 40))
+	reader, err := NewReader(bytes.NewReader(buffer.Bytes()[:buffer.Len()-10]))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("Expected an error for a truncated packet, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package propagation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+// DefaultResolvers lists the public recursive resolvers polled when none are configured
+var DefaultResolvers = []string{
+	"8.8.8.8",        // Google
+	"1.1.1.1",        // Cloudflare
+	"9.9.9.9",        // Quad9
+	"208.67.222.222", // OpenDNS
+}
+
+// Options controls a propagation check
+type Options struct {
+	Domain     string
+	RecordType string
+	Expected   []string
+	Resolvers  []string // Recursive resolvers to poll; nil means DefaultResolvers
+	Bootstrap  string   // Resolver used for NS discovery; empty means system default
+	Interval   time.Duration
+	Deadline   time.Duration
+}
+
+// Target is a single server polled during a propagation check
+type Target struct {
+	Name          string // Nameserver host name, or the resolver address
+	Server        string
+	Authoritative bool
+}
+
+// ServerStatus holds the latest answer seen from one target
+type ServerStatus struct {
+	Target  Target
+	Records []string
+	TTL     uint32 // Lowest TTL among the returned records
+	Matched bool
+	Error   error
+}
+
+// Round holds the status of every target after one polling pass
+type Round struct {
+	Number   int
+	Elapsed  time.Duration
+	Statuses []ServerStatus
+}
+
+// Agreed returns the number of targets that returned the expected value
+func (r *Round) Agreed() int {
+	agreed := 0
+	for _, status := range r.Statuses {
+		if status.Matched {
+			agreed++
+		}
+	}
+	return agreed
+}
+
+// Complete reports whether every target returned the expected value
+func (r *Round) Complete() bool {
+	return len(r.Statuses) > 0 && r.Agreed() == len(r.Statuses)
+}
+
+// Report summarises a finished propagation check
+type Report struct {
+	Domain     string
+	RecordType string
+	Zone       string
+	Expected   []string
+	Final      *Round
+}
+
+// Checker polls authoritative servers and resolvers until they agree on a value
+type Checker struct {
+	client dns.Client
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// NewChecker creates a propagation checker that issues queries through client
+func NewChecker(client dns.Client) *Checker {
+	return &Checker{
+		client: client,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Run polls every target until all of them return the expected value or the
+// deadline passes. progress is called after each round and may be nil.
+func (c *Checker) Run(opts Options, progress func(*Report, *Round)) (*Report, error) {
+	if len(opts.Expected) == 0 {
+		return nil, errors.NewInputError("at least one expected value is required", nil)
+	}
+
+	recordType := strings.ToUpper(opts.RecordType)
+	report := &Report{
+		Domain:     opts.Domain,
+		RecordType: recordType,
+		Expected:   opts.Expected,
+	}
+
+	zone, nameservers, err := c.findZone(opts.Domain, opts.Bootstrap)
+	if err != nil {
+		return report, err
+	}
+	report.Zone = zone
+
+	targets := c.resolveNameservers(nameservers, opts.Bootstrap)
+	if len(targets) == 0 {
+		return report, errors.NewDNSError(fmt.Sprintf("could not resolve any nameserver address for zone '%s'", zone), nil, zone, opts.Bootstrap)
+	}
+	resolvers := opts.Resolvers
+	if resolvers == nil {
+		resolvers = DefaultResolvers
+	}
+	for _, resolver := range resolvers {
+		targets = append(targets, Target{Name: resolver, Server: resolver})
+	}
+
+	expected := normalizeSet(recordType, opts.Expected)
+	start := c.now()
+
+	for number := 1; ; number++ {
+		round := c.poll(targets, opts.Domain, recordType, expected)
+		round.Number = number
+		round.Elapsed = c.now().Sub(start)
+		report.Final = round
+
+		if progress != nil {
+			progress(report, round)
+		}
+
+		if round.Complete() {
+			return report, nil
+		}
+
+		if round.Elapsed+opts.Interval > opts.Deadline {
+			return report, errors.NewDNSError(
+				fmt.Sprintf("%s record for '%s' reached %d of %d servers before the %v deadline",
+					recordType, opts.Domain, round.Agreed(), len(round.Statuses), opts.Deadline),
+				nil, opts.Domain, "")
+		}
+
+		c.sleep(opts.Interval)
+	}
+}
+
+// findZone walks up from domain until it finds a name with NS records
+func (c *Checker) findZone(domain, bootstrap string) (string, []string, error) {
+	name := strings.TrimSuffix(domain, ".")
+	for strings.Contains(name, ".") {
+		result, err := c.client.Query(name, "NS", bootstrap)
+		if err == nil && len(result.Records) > 0 {
+			return name, result.Records, nil
+		}
+		name = name[strings.Index(name, ".")+1:]
+	}
+
+	return "", nil, errors.NewDNSError(fmt.Sprintf("could not find the zone containing '%s'", domain), nil, domain, bootstrap)
+}
+
+// resolveNameservers looks up an address for each nameserver host name.
+// Nameservers that cannot be resolved are skipped.
+func (c *Checker) resolveNameservers(nameservers []string, bootstrap string) []Target {
+	var targets []Target
+	for _, ns := range nameservers {
+		host := strings.TrimSuffix(ns, ".")
+		result, err := c.client.Query(host, "A", bootstrap)
+		if err != nil || len(result.Records) == 0 {
+			continue
+		}
+		targets = append(targets, Target{
+			Name:          host,
+			Server:        result.Records[0],
+			Authoritative: true,
+		})
+	}
+	return targets
+}
+
+// poll queries every target concurrently and returns their statuses in target order
+func (c *Checker) poll(targets []Target, domain, recordType string, expected []string) *Round {
+	round := &Round{Statuses: make([]ServerStatus, len(targets))}
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+
+			status := ServerStatus{Target: target}
+			result, err := c.client.Query(domain, recordType, target.Server)
+			if err != nil {
+				status.Error = err
+			} else {
+				status.Records = result.Records
+				status.TTL = lowestTTL(result.Answers)
+				status.Matched = equalSets(normalizeSet(recordType, result.Records), expected)
+			}
+			round.Statuses[i] = status
+		}(i, target)
+	}
+	wg.Wait()
+
+	return round
+}
+
+// lowestTTL returns the smallest TTL among answers, or 0 if there are none
+func lowestTTL(answers []dns.Record) uint32 {
+	var lowest uint32
+	for i, answer := range answers {
+		if i == 0 || answer.TTL < lowest {
+			lowest = answer.TTL
+		}
+	}
+	return lowest
+}
+
+// normalizeSet returns a sorted copy of values suitable for comparison.
+// Names are compared case-insensitively and without the trailing dot;
+// TXT data is compared exactly.
+func normalizeSet(recordType string, values []string) []string {
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if recordType != "TXT" {
+			value = strings.TrimSuffix(strings.ToLower(value), ".")
+		}
+		normalized = append(normalized, value)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// equalSets compares two sorted value sets
+func equalSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package propagation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/dnsfake"
+	"go-dig/pkg/errors"
+)
+
+// newTestChecker returns a checker with a fake clock advanced by sleep
+func newTestChecker(client dns.Client) *Checker {
+	checker := NewChecker(client)
+	now := time.Unix(0, 0)
+	checker.now = func() time.Time { return now }
+	checker.sleep = func(d time.Duration) { now = now.Add(d) }
+	return checker
+}
+
+// zoneFixture sets up example.com with two nameservers
+func zoneFixture() *dnsfake.Client {
+	client := dnsfake.NewClient()
+	client.Answer("", "example.com", "NS", "ns1.example.com.", "ns2.example.com.")
+	client.Answer("", "ns1.example.com", "A", "192.0.2.53")
+	client.Answer("", "ns2.example.com", "A", "198.51.100.53")
+	return client
+}
+
+func TestChecker_Run_AllAgree(t *testing.T) {
+	client := zoneFixture()
+	client.Answer("192.0.2.53", "www.example.com", "A", "203.0.113.10")
+	client.Answer("198.51.100.53", "www.example.com", "A", "203.0.113.10")
+	client.Answer("8.8.8.8", "www.example.com", "A", "203.0.113.10")
+
+	rounds := 0
+	report, err := newTestChecker(client).Run(Options{
+		Domain:     "www.example.com",
+		RecordType: "a",
+		Expected:   []string{"203.0.113.10"},
+		Resolvers:  []string{"8.8.8.8"},
+		Interval:   time.Second,
+		Deadline:   time.Minute,
+	}, func(report *Report, round *Round) { rounds++ })
+
+	if err != nil {
+		t.Fatalf("Run() error = %v, want nil", err)
+	}
+	if report.Zone != "example.com" {
+		t.Errorf("Zone = %s, want example.com", report.Zone)
+	}
+	if rounds != 1 {
+		t.Errorf("Expected 1 round, got %d", rounds)
+	}
+	if len(report.Final.Statuses) != 3 {
+		t.Fatalf("Expected 3 polled servers, got %d", len(report.Final.Statuses))
+	}
+	if !report.Final.Statuses[0].Target.Authoritative || report.Final.Statuses[2].Target.Authoritative {
+		t.Error("Expected nameservers first, marked authoritative, followed by resolvers")
+	}
+	if report.Final.Statuses[0].TTL != 300 {
+		t.Errorf("TTL = %d, want 300", report.Final.Statuses[0].TTL)
+	}
+}
+
+func TestChecker_Run_PropagatesOverTime(t *testing.T) {
+	client := zoneFixture()
+	client.Answer("192.0.2.53", "www.example.com", "A", "203.0.113.10")
+	client.Answer("198.51.100.53", "www.example.com", "A", "203.0.113.10")
+	client.Answer("8.8.8.8", "www.example.com", "A", "192.0.2.99")
+	client.AnswerAfter("8.8.8.8", "www.example.com", "A", 2, "203.0.113.10")
+
+	var agreed []int
+	report, err := newTestChecker(client).Run(Options{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Expected:   []string{"203.0.113.10"},
+		Resolvers:  []string{"8.8.8.8"},
+		Interval:   10 * time.Second,
+		Deadline:   time.Minute,
+	}, func(report *Report, round *Round) { agreed = append(agreed, round.Agreed()) })
+
+	if err != nil {
+		t.Fatalf("Run() error = %v, want nil", err)
+	}
+	if len(agreed) != 3 || agreed[0] != 2 || agreed[2] != 3 {
+		t.Errorf("Unexpected progress %v, want [2 2 3]", agreed)
+	}
+	if report.Final.Elapsed != 20*time.Second {
+		t.Errorf("Elapsed = %v, want 20s", report.Final.Elapsed)
+	}
+}
+
+func TestChecker_Run_DeadlineExceeded(t *testing.T) {
+	client := zoneFixture()
+	client.Answer("192.0.2.53", "www.example.com", "A", "203.0.113.10")
+	client.Answer("198.51.100.53", "www.example.com", "A", "192.0.2.99")
+
+	report, err := newTestChecker(client).Run(Options{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Expected:   []string{"203.0.113.10"},
+		Resolvers:  []string{},
+		Interval:   10 * time.Second,
+		Deadline:   30 * time.Second,
+	}, nil)
+
+	if err == nil {
+		t.Fatal("Expected error when the deadline passes")
+	}
+	if !errors.IsDNSError(err) {
+		t.Errorf("Expected DNS error, got %T", err)
+	}
+	if !strings.Contains(err.Error(), "1 of 2 servers") {
+		t.Errorf("Expected agreement count in error, got: %s", err.Error())
+	}
+	if report.Final.Number != 4 {
+		t.Errorf("Expected 4 rounds before the deadline, got %d", report.Final.Number)
+	}
+}
+
+func TestChecker_Run_NoDataIsNotAgreement(t *testing.T) {
+	client := zoneFixture()
+	client.Answer("192.0.2.53", "www.example.com", "A", "203.0.113.10")
+	client.Answer("198.51.100.53", "www.example.com", "A")
+
+	report, err := newTestChecker(client).Run(Options{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Expected:   []string{"203.0.113.10"},
+		Resolvers:  []string{},
+		Interval:   10 * time.Second,
+		Deadline:   10 * time.Second,
+	}, nil)
+
+	if err == nil {
+		t.Fatal("Expected error when a nameserver has no records")
+	}
+	status := report.Final.Statuses[1]
+	if status.Error != nil || status.Matched || len(status.Records) != 0 {
+		t.Errorf("Expected a NODATA answer to count as an empty set, got %+v", status)
+	}
+}
+
+func TestChecker_Run_ZoneNotFound(t *testing.T) {
+	client := dnsfake.NewClient()
+
+	_, err := newTestChecker(client).Run(Options{
+		Domain:     "www.example.com",
+		RecordType: "A",
+		Expected:   []string{"203.0.113.10"},
+	}, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "could not find the zone") {
+		t.Errorf("Expected zone discovery error, got %v", err)
+	}
+}
+
+func TestChecker_Run_RequiresExpectedValue(t *testing.T) {
+	_, err := newTestChecker(dnsfake.NewClient()).Run(Options{Domain: "example.com", RecordType: "A"}, nil)
+	if !errors.IsInputError(err) {
+		t.Errorf("Expected input error, got %v", err)
+	}
+}
+
+func TestNormalizeSet(t *testing.T) {
+	got := normalizeSet("MX", []string{"20 MX2.Example.com.", " 10 mx1.example.com"})
+	want := []string{"10 mx1.example.com", "20 mx2.example.com"}
+	if !equalSets(got, want) {
+		t.Errorf("normalizeSet() = %v, want %v", got, want)
+	}
+
+	txt := normalizeSet("TXT", []string{"Hello World."})
+	if txt[0] != "Hello World." {
+		t.Errorf("TXT values should be compared exactly, got %q", txt[0])
+	}
+}
@@ -0,0 +1,245 @@
+package watch
+
+import (
+	"fmt"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+)
+
+// Default bounds for the delay between queries
+const (
+	DefaultMinInterval = 5 * time.Second
+	DefaultMaxInterval = time.Hour
+)
+
+// ChangeKind identifies what changed between two consecutive queries
+type ChangeKind int
+
+const (
+	RecordAdded ChangeKind = iota
+	RecordRemoved
+	RcodeChanged
+	ServerChanged
+	QueryFailed
+)
+
+// String returns a string representation of the change kind
+func (k ChangeKind) String() string {
+	switch k {
+	case RecordAdded:
+		return "added"
+	case RecordRemoved:
+		return "removed"
+	case RcodeChanged:
+		return "rcode"
+	case ServerChanged:
+		return "server"
+	case QueryFailed:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes a single difference between two consecutive results.
+// Record is set for added and removed records; Old and New are set for
+// rcode, server and error changes.
+type Change struct {
+	Kind   ChangeKind
+	Record dns.Record
+	Old    string
+	New    string
+}
+
+// Update is emitted after every query
+type Update struct {
+	Time    time.Time
+	Result  *dns.Result
+	Initial bool          // True for the first query, which has no previous result
+	Changes []Change      // Differences from the previous result
+	Next    time.Duration // Delay until the next query
+}
+
+// Watcher repeatedly queries a name, waiting for the answer TTL between queries
+type Watcher struct {
+	client      dns.Client
+	minInterval time.Duration
+	maxInterval time.Duration
+	now         func() time.Time
+	sleep       func(time.Duration)
+}
+
+// NewWatcher creates a watcher whose query interval is clamped to [minInterval, maxInterval]
+func NewWatcher(client dns.Client, minInterval, maxInterval time.Duration) *Watcher {
+	return &Watcher{
+		client:      client,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+}
+
+// Run queries domain until stop is closed, calling emit after every query.
+// Query failures are reported through the emitted result rather than
+// ending the watch. The client should ask for the NSID, which tells the
+// instances behind an anycast address apart.
+func (w *Watcher) Run(domain, recordType, server string, stop <-chan struct{}, emit func(*Update)) {
+	var previous *dns.Result
+
+	for {
+		result, err := w.client.Query(domain, recordType, server)
+		if result == nil {
+			result = &dns.Result{Domain: domain, RecordType: recordType, Server: server, Error: err}
+		}
+
+		update := &Update{
+			Time:    w.now(),
+			Result:  result,
+			Initial: previous == nil,
+			Next:    NextInterval(result, w.minInterval, w.maxInterval),
+		}
+		if previous != nil {
+			update.Changes = Diff(previous, result)
+		}
+		emit(update)
+		previous = carryAnswers(previous, result)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		w.sleep(update.Next)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// NextInterval returns the delay before the next query: the lowest answer
+// TTL, or the negative-caching TTL of a NODATA answer, clamped to
+// [minInterval, maxInterval]. Other results without answers use minInterval.
+func NextInterval(result *dns.Result, minInterval, maxInterval time.Duration) time.Duration {
+	if result == nil {
+		return minInterval
+	}
+
+	var lowest uint32
+	switch {
+	case len(result.Answers) > 0:
+		lowest = result.Answers[0].TTL
+		for _, answer := range result.Answers[1:] {
+			if answer.TTL < lowest {
+				lowest = answer.TTL
+			}
+		}
+	case result.NoData && result.SOA != nil:
+		lowest = result.NegativeTTL
+	default:
+		return minInterval
+	}
+
+	interval := time.Duration(lowest) * time.Second
+	if interval < minInterval {
+		return minInterval
+	}
+	if interval > maxInterval {
+		return maxInterval
+	}
+	return interval
+}
+
+// Diff returns the changes between two consecutive results. TTL changes
+// alone are not reported. A failed query, such as a timeout or SERVFAIL,
+// only reports its status and error, as it says nothing about the records.
+// A server switch is a different server address or, behind one address, a
+// different NSID than the previous answer had.
+func Diff(previous, current *dns.Result) []Change {
+	var changes []Change
+
+	if previous.Rcode != current.Rcode {
+		changes = append(changes, Change{Kind: RcodeChanged, Old: previous.Rcode, New: current.Rcode})
+	}
+	if !answered(current) {
+		if message := errorMessage(current); message != errorMessage(previous) {
+			changes = append(changes, Change{Kind: QueryFailed, Old: errorMessage(previous), New: message})
+		}
+		return changes
+	}
+	if previous.Server != current.Server || (previous.NSID != "" && previous.NSID != current.NSID) {
+		changes = append(changes, Change{Kind: ServerChanged, Old: instance(previous), New: instance(current)})
+	}
+
+	before := recordSet(previous.Answers)
+	after := recordSet(current.Answers)
+
+	for _, record := range current.Answers {
+		if _, found := before[recordKey(record)]; !found {
+			changes = append(changes, Change{Kind: RecordAdded, Record: record})
+		}
+	}
+	for _, record := range previous.Answers {
+		if _, found := after[recordKey(record)]; !found {
+			changes = append(changes, Change{Kind: RecordRemoved, Record: record})
+		}
+	}
+
+	return changes
+}
+
+// answered reports whether a result states the records of the name: an
+// answer, NODATA or NXDOMAIN. Timeouts, SERVFAIL, REFUSED and other failures
+// say nothing about them.
+func answered(result *dns.Result) bool {
+	return result.Rcode == "NXDOMAIN" || (result.Rcode == "NOERROR" && result.Error == nil)
+}
+
+// carryAnswers returns the result the next one is compared with. A failed
+// query keeps the records and NSID of the result before it, so an outage is
+// not reported as every record being removed and added again.
+func carryAnswers(previous, current *dns.Result) *dns.Result {
+	if previous == nil || answered(current) {
+		return current
+	}
+	carried := *current
+	carried.Answers = previous.Answers
+	carried.NSID = previous.NSID
+	return &carried
+}
+
+// errorMessage returns the short message of a result's error, or an empty string
+func errorMessage(result *dns.Result) string {
+	if result.Error == nil {
+		return ""
+	}
+	return errors.Message(result.Error)
+}
+
+// instance names the server that answered: its address, and its NSID if it sent one
+func instance(result *dns.Result) string {
+	if result.NSID == "" {
+		return result.Server
+	}
+	return fmt.Sprintf("%s (NSID %q)", result.Server, result.NSID)
+}
+
+// recordSet indexes records by owner, type and value
+func recordSet(records []dns.Record) map[string]struct{} {
+	set := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		set[recordKey(record)] = struct{}{}
+	}
+	return set
+}
+
+// recordKey identifies a record independently of its TTL
+func recordKey(record dns.Record) string {
+	return record.Name + " " + record.Type + " " + record.Value
+}
@@ -0,0 +1,208 @@
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"go-dig/pkg/dns"
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// scriptedClient returns a fixed sequence of results, repeating the last one
+type scriptedClient struct {
+	results []*dns.Result
+	calls   int
+}
+
+func (s *scriptedClient) Query(domain, recordType, server string) (*dns.Result, error) {
+	result := s.results[len(s.results)-1]
+	if s.calls < len(s.results) {
+		result = s.results[s.calls]
+	}
+	s.calls++
+	return result, result.Error
+}
+
+func (s *scriptedClient) Exchange(msg *mdns.Msg, server, network string) (*mdns.Msg, time.Duration, error) {
+	return nil, 0, errors.NewNetworkError("exchange not supported in tests", nil, server)
+}
+
+func (s *scriptedClient) SetTimeout(duration time.Duration) {}
+
+func answer(value string, ttl uint32) dns.Record {
+	return dns.Record{Name: "example.com.", Type: "A", TTL: ttl, Value: value}
+}
+
+func TestNextInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *dns.Result
+		expected time.Duration
+	}{
+		{"nil result", nil, 5 * time.Second},
+		{"no answers", &dns.Result{}, 5 * time.Second},
+		{"lowest TTL", &dns.Result{Answers: []dns.Record{answer("192.0.2.1", 300), answer("192.0.2.2", 60)}}, time.Minute},
+		{"below minimum", &dns.Result{Answers: []dns.Record{answer("192.0.2.1", 1)}}, 5 * time.Second},
+		{"above maximum", &dns.Result{Answers: []dns.Record{answer("192.0.2.1", 86400)}}, time.Hour},
+		{"NODATA uses negative TTL", &dns.Result{NoData: true, SOA: &dns.Record{Type: "SOA", TTL: 3600}, NegativeTTL: 120}, 2 * time.Minute},
+		{"NODATA without SOA", &dns.Result{NoData: true}, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NextInterval(tt.result, DefaultMinInterval, DefaultMaxInterval)
+			if got != tt.expected {
+				t.Errorf("NextInterval() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	previous := &dns.Result{
+		Server:  "8.8.8.8:53",
+		Rcode:   "NOERROR",
+		Answers: []dns.Record{answer("192.0.2.1", 300), answer("192.0.2.2", 300)},
+	}
+
+	t.Run("TTL change only", func(t *testing.T) {
+		current := &dns.Result{
+			Server:  "8.8.8.8:53",
+			Rcode:   "NOERROR",
+			Answers: []dns.Record{answer("192.0.2.2", 120), answer("192.0.2.1", 120)},
+		}
+		if changes := Diff(previous, current); len(changes) != 0 {
+			t.Errorf("Expected no changes, got %v", changes)
+		}
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		current := &dns.Result{
+			Server:  "1.1.1.1:53",
+			Rcode:   "NOERROR",
+			Answers: []dns.Record{answer("192.0.2.2", 300), answer("198.51.100.1", 300)},
+		}
+		changes := Diff(previous, current)
+		if len(changes) != 3 {
+			t.Fatalf("Expected 3 changes, got %v", changes)
+		}
+		if changes[0].Kind != ServerChanged || changes[0].Old != "8.8.8.8:53" || changes[0].New != "1.1.1.1:53" {
+			t.Errorf("Unexpected server change %+v", changes[0])
+		}
+		if changes[1].Kind != RecordAdded || changes[1].Record.Value != "198.51.100.1" {
+			t.Errorf("Unexpected added record %+v", changes[1])
+		}
+		if changes[2].Kind != RecordRemoved || changes[2].Record.Value != "192.0.2.1" {
+			t.Errorf("Unexpected removed record %+v", changes[2])
+		}
+	})
+
+	t.Run("anycast instance switch", func(t *testing.T) {
+		before := &dns.Result{Server: "8.8.8.8:53", Rcode: "NOERROR", NSID: "fra1", Answers: previous.Answers}
+		current := &dns.Result{Server: "8.8.8.8:53", Rcode: "NOERROR", NSID: "ams2", Answers: previous.Answers}
+		changes := Diff(before, current)
+		if len(changes) != 1 || changes[0].Kind != ServerChanged || changes[0].Old != `8.8.8.8:53 (NSID "fra1")` || changes[0].New != `8.8.8.8:53 (NSID "ams2")` {
+			t.Errorf("Expected a server change between NSIDs, got %+v", changes)
+		}
+	})
+
+	t.Run("query failure", func(t *testing.T) {
+		timeout := errors.NewNetworkError("DNS server timeout", nil, "8.8.8.8:53")
+		current := &dns.Result{Server: "8.8.8.8:53", Error: timeout}
+		changes := Diff(previous, current)
+		if len(changes) != 2 || changes[0].Kind != RcodeChanged || changes[1].Kind != QueryFailed || changes[1].New != "DNS server timeout" {
+			t.Errorf("Expected a status change and the error without removals, got %+v", changes)
+		}
+	})
+
+	t.Run("rcode change", func(t *testing.T) {
+		current := &dns.Result{Server: "8.8.8.8:53", Rcode: "NXDOMAIN"}
+		changes := Diff(previous, current)
+		if len(changes) != 3 {
+			t.Fatalf("Expected rcode change and two removals, got %v", changes)
+		}
+		if changes[0].Kind != RcodeChanged || changes[0].Old != "NOERROR" || changes[0].New != "NXDOMAIN" {
+			t.Errorf("Unexpected rcode change %+v", changes[0])
+		}
+	})
+}
+
+func TestWatcher_Run(t *testing.T) {
+	nxdomain := errors.NewDNSError("domain 'example.com' not found (NXDOMAIN)", nil, "example.com", "8.8.8.8:53")
+	client := &scriptedClient{results: []*dns.Result{
+		{Server: "8.8.8.8:53", Rcode: "NOERROR", Answers: []dns.Record{answer("192.0.2.1", 30)}},
+		{Server: "8.8.8.8:53", Rcode: "NOERROR", Answers: []dns.Record{answer("192.0.2.1", 10)}},
+		{Server: "8.8.8.8:53", Rcode: "NXDOMAIN", Error: nxdomain},
+	}}
+
+	watcher := NewWatcher(client, time.Second, time.Minute)
+	watcher.now = func() time.Time { return time.Unix(0, 0) }
+
+	stop := make(chan struct{})
+	var slept []time.Duration
+	watcher.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		if len(slept) == 2 {
+			close(stop)
+		}
+	}
+
+	var updates []*Update
+	watcher.Run("example.com", "A", "8.8.8.8", stop, func(update *Update) {
+		updates = append(updates, update)
+	})
+
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates before stopping, got %d", len(updates))
+	}
+	if !updates[0].Initial || updates[1].Initial {
+		t.Error("Expected only the first update to be marked initial")
+	}
+	if len(updates[1].Changes) != 0 {
+		t.Errorf("Expected no changes between identical answers, got %v", updates[1].Changes)
+	}
+	if slept[0] != 30*time.Second || slept[1] != 10*time.Second {
+		t.Errorf("Expected sleeps to follow the TTLs, got %v", slept)
+	}
+}
+
+func TestWatcher_Run_QueryFailure(t *testing.T) {
+	timeout := errors.NewNetworkError("DNS server timeout", nil, "8.8.8.8:53")
+	records := []dns.Record{answer("192.0.2.1", 30)}
+	client := &scriptedClient{results: []*dns.Result{
+		{Server: "8.8.8.8:53", Rcode: "NOERROR", Answers: records},
+		{Server: "8.8.8.8:53", Error: timeout},
+		{Server: "8.8.8.8:53", Error: timeout},
+		{Server: "8.8.8.8:53", Rcode: "NOERROR", Answers: records},
+	}}
+
+	watcher := NewWatcher(client, time.Second, time.Minute)
+	stop := make(chan struct{})
+	sleeps := 0
+	watcher.sleep = func(time.Duration) {
+		if sleeps++; sleeps == 4 {
+			close(stop)
+		}
+	}
+
+	var updates []*Update
+	watcher.Run("example.com", "A", "8.8.8.8", stop, func(update *Update) {
+		updates = append(updates, update)
+	})
+
+	if len(updates) != 4 {
+		t.Fatalf("Expected 4 updates, got %d", len(updates))
+	}
+	// The outage is reported once, and the records are unchanged when it ends
+	if changes := updates[1].Changes; len(changes) != 2 || changes[0].Kind != RcodeChanged || changes[1].Kind != QueryFailed {
+		t.Errorf("Expected the failure to be reported, got %+v", changes)
+	}
+	if changes := updates[2].Changes; len(changes) != 0 {
+		t.Errorf("Expected no changes while the failure lasts, got %+v", changes)
+	}
+	if changes := updates[3].Changes; len(changes) != 1 || changes[0].Kind != RcodeChanged || changes[0].New != "NOERROR" {
+		t.Errorf("Expected only the recovery, got %+v", changes)
+	}
+}
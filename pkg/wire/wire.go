@@ -0,0 +1,237 @@
+// Package wire converts DNS messages between their wire format and the text
+// encodings found in logs and packet dumps: hex, as printed by tcpdump -X and
+// Wireshark, and base64 or base64url, as carried in DoH GET requests.
+//
+// Decode checks a message field by field so that a malformed message is
+// reported with the byte offset where it goes wrong.
+package wire
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Encodings of wire messages as text
+const (
+	EncodingAuto      = "auto"      // Detect hex or base64 when parsing
+	EncodingHex       = "hex"       // Lowercase hex digits
+	EncodingBase64    = "base64"    // Standard base64 with padding; either alphabet when parsing
+	EncodingBase64URL = "base64url" // URL-safe base64 without padding (RFC 8484)
+	EncodingRaw       = "raw"       // The wire bytes themselves
+)
+
+// headerLength is the size of the fixed DNS message header
+const headerLength = 12
+
+// DecodeError reports where a wire message is malformed
+type DecodeError struct {
+	Offset int    // Byte offset of the field that could not be decoded
+	Field  string // The field, such as "header" or "answer record 2"
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("byte %d: %s: %v", e.Offset, e.Field, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ParseText returns the wire bytes of a message written as text in the given
+// encoding:
+//
+//   - EncodingHex: hex digits with an optional 0x prefix and whitespace or
+//     colons between bytes
+//   - EncodingBase64 and EncodingBase64URL: base64 in either alphabet, with or
+//     without padding
+//   - EncodingRaw: the text itself, byte for byte
+//   - EncodingAuto or "": a DoH GET URL with the message in the dns parameter,
+//     else hex if the text is an even number of hex digits, else base64
+//
+// Auto-detection reads base64 that happens to consist only of hex digits,
+// such as "ABCD", as hex; give EncodingBase64 for such input.
+func ParseText(text, encoding string) ([]byte, error) {
+	if encoding == EncodingRaw {
+		return []byte(text), nil
+	}
+	text = strings.TrimSpace(text)
+
+	switch encoding {
+	case EncodingHex:
+		return parseHex(text)
+	case EncodingBase64, EncodingBase64URL:
+		return parseBase64(text)
+	case EncodingAuto, "":
+	default:
+		return nil, fmt.Errorf("unknown encoding '%s'", encoding)
+	}
+
+	if text == "" {
+		return nil, fmt.Errorf("no message given")
+	}
+	if strings.Contains(text, "dns=") {
+		return parseURL(text)
+	}
+	if isHex(text) {
+		return parseHex(text)
+	}
+	return parseBase64(text)
+}
+
+// isHex reports whether text holds only hex digits and separators
+func isHex(text string) bool {
+	digits := 0
+	for _, c := range strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X") {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			digits++
+		case c == ':' || c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			return false
+		}
+	}
+	return digits%2 == 0
+}
+
+// parseHex decodes hex digits, ignoring separators between bytes
+func parseHex(text string) ([]byte, error) {
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	digits := strings.Map(func(c rune) rune {
+		if c == ':' || c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			return -1
+		}
+		return c
+	}, text)
+
+	data, err := hex.DecodeString(digits)
+	if err != nil {
+		if invalid, ok := err.(hex.InvalidByteError); ok {
+			return nil, fmt.Errorf("invalid hex digit %q at character %d", rune(invalid), strings.IndexRune(text, rune(invalid))+1)
+		}
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+	return data, nil
+}
+
+// parseBase64 decodes either base64 alphabet with or without padding
+func parseBase64(text string) ([]byte, error) {
+	digits := strings.Map(func(c rune) rune {
+		switch c {
+		case '-':
+			return '+'
+		case '_':
+			return '/'
+		case ' ', '\t', '\n', '\r':
+			return -1
+		}
+		return c
+	}, text)
+
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(digits, "="))
+	if err != nil {
+		if corrupt, ok := err.(base64.CorruptInputError); ok {
+			return nil, fmt.Errorf("invalid base64 at character %d", int64(corrupt)+1)
+		}
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	return data, nil
+}
+
+// parseURL decodes the dns parameter of a DoH GET request or query string
+func parseURL(text string) ([]byte, error) {
+	query := text
+	if i := strings.IndexByte(text, '?'); i >= 0 {
+		query = text[i+1:]
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DoH URL: %w", err)
+	}
+	message := values.Get("dns")
+	if message == "" {
+		return nil, fmt.Errorf("DoH URL has an empty dns parameter")
+	}
+	return parseBase64(message)
+}
+
+// Encode writes a wire message as text in the given encoding. Text encodings
+// end with a newline; EncodingRaw returns the bytes unchanged.
+func Encode(data []byte, encoding string) ([]byte, error) {
+	var text string
+	switch encoding {
+	case EncodingHex:
+		text = hex.EncodeToString(data)
+	case EncodingBase64:
+		text = base64.StdEncoding.EncodeToString(data)
+	case EncodingBase64URL:
+		text = base64.RawURLEncoding.EncodeToString(data)
+	case EncodingRaw:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding '%s'", encoding)
+	}
+	return []byte(text + "\n"), nil
+}
+
+// Decode parses a wire message. Unlike unpacking it in one go, each name and
+// record is checked in turn, so errors carry the offset and section where the
+// message is malformed. Bytes after the last record are an error.
+func Decode(data []byte) (*mdns.Msg, error) {
+	if len(data) < headerLength {
+		return nil, &DecodeError{Offset: 0, Field: "header",
+			Err: fmt.Errorf("message is %d bytes, shorter than the %d-byte header", len(data), headerLength)}
+	}
+
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(data[4+2*i:]))
+	}
+
+	off := headerLength
+	for i := 0; i < counts[0]; i++ {
+		start := off
+		_, next, err := mdns.UnpackDomainName(data, off)
+		if err != nil {
+			return nil, &DecodeError{Offset: start, Field: fmt.Sprintf("question %d name", i+1), Err: err}
+		}
+		if next+4 > len(data) {
+			return nil, &DecodeError{Offset: next, Field: fmt.Sprintf("question %d type and class", i+1),
+				Err: fmt.Errorf("message ends after %d bytes", len(data))}
+		}
+		off = next + 4
+	}
+
+	for section, name := range []string{"answer", "authority", "additional"} {
+		for i := 0; i < counts[section+1]; i++ {
+			start := off
+			field := fmt.Sprintf("%s record %d", name, i+1)
+			// UnpackRR accepts a missing record at the end of the message
+			if off >= len(data) {
+				return nil, &DecodeError{Offset: start, Field: field,
+					Err: fmt.Errorf("message ends after %d bytes", len(data))}
+			}
+			var err error
+			if _, off, err = mdns.UnpackRR(data, off); err != nil {
+				return nil, &DecodeError{Offset: start, Field: field, Err: err}
+			}
+		}
+	}
+
+	if off < len(data) {
+		return nil, &DecodeError{Offset: off, Field: "end of message",
+			Err: fmt.Errorf("%d bytes follow the last record", len(data)-off)}
+	}
+
+	msg := new(mdns.Msg)
+	if err := msg.Unpack(data); err != nil {
+		return nil, &DecodeError{Offset: 0, Field: "message", Err: err}
+	}
+	return msg, nil
+}
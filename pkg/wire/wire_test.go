@@ -0,0 +1,157 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+// packResponse returns a response for www.example.com with one A record
+func packResponse(t *testing.T) []byte {
+	t.Helper()
+	msg := new(mdns.Msg)
+	msg.SetQuestion("www.example.com.", mdns.TypeA)
+	msg.Response = true
+	rr, err := mdns.NewRR("www.example.com. 300 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatalf("NewRR() error = %v", err)
+	}
+	msg.Answer = append(msg.Answer, rr)
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+	return packed
+}
+
+func TestParseText(t *testing.T) {
+	data := packResponse(t)
+	hexText, _ := Encode(data, EncodingHex)
+	base64Text, _ := Encode(data, EncodingBase64)
+	urlText, _ := Encode(data, EncodingBase64URL)
+
+	spaced := strings.TrimSpace(string(hexText))
+	var grouped strings.Builder
+	for i := 0; i < len(spaced); i += 2 {
+		grouped.WriteString(spaced[i:i+2] + ":")
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		encoding string
+	}{
+		{"hex", string(hexText), EncodingAuto},
+		{"hex with prefix", "0x" + strings.ToUpper(spaced), EncodingAuto},
+		{"hex with colons", strings.TrimSuffix(grouped.String(), ":"), EncodingAuto},
+		{"base64", string(base64Text), EncodingAuto},
+		{"base64url", string(urlText), EncodingAuto},
+		{"DoH URL", "https://dns.example/dns-query?ct=application/dns-message&dns=" + strings.TrimSpace(string(urlText)), EncodingAuto},
+		{"explicit base64", string(urlText), EncodingBase64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseText(tt.text, tt.encoding)
+			if err != nil {
+				t.Fatalf("ParseText() error = %v", err)
+			}
+			if !bytes.Equal(parsed, data) {
+				t.Errorf("ParseText() = %x, want %x", parsed, data)
+			}
+		})
+	}
+}
+
+func TestParseText_HexLikeBase64(t *testing.T) {
+	// "ABCD" is both hex and base64; detection takes it as hex
+	if parsed, _ := ParseText("ABCD", EncodingAuto); !bytes.Equal(parsed, []byte{0xab, 0xcd}) {
+		t.Errorf("ParseText(auto) = %x, want abcd", parsed)
+	}
+	if parsed, _ := ParseText("ABCD", EncodingBase64); !bytes.Equal(parsed, []byte{0x00, 0x10, 0x83}) {
+		t.Errorf("ParseText(base64) = %x, want 001083", parsed)
+	}
+}
+
+func TestParseText_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		encoding string
+		want     string
+	}{
+		{"empty", "  ", EncodingAuto, "no message"},
+		{"bad hex digit", "0a0bzz", EncodingHex, "character 5"},
+		{"bad base64", "AAAA*AAA", EncodingAuto, "character 5"},
+		{"empty dns parameter", "https://dns.example/dns-query?dns=", EncodingAuto, "empty dns parameter"},
+		{"unknown encoding", "00", "octal", "unknown encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseText(tt.text, tt.encoding)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseText() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := packResponse(t)
+	msg, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(msg.Answer) != 1 || msg.Question[0].Name != "www.example.com." {
+		t.Errorf("Decode() = %v, want the packed response", msg)
+	}
+
+	// The answer starts after the header and the 21-byte name plus type and class
+	answerStart := headerLength + 17 + 4
+
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int
+		field  string
+	}{
+		{"short header", data[:5], 0, "header"},
+		{"truncated question", data[:headerLength+19], headerLength + 17, "question 1 type and class"},
+		{"truncated answer", data[:len(data)-2], answerStart, "answer record 1"},
+		{"missing record", append(append([]byte{}, data[:10]...), append([]byte{0, 1}, data[12:]...)...), len(data), "additional record 1"},
+		{"trailing bytes", append(append([]byte{}, data...), 0, 0), len(data), "end of message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.data)
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("Decode() error = %v, want a *DecodeError", err)
+			}
+			if decodeErr.Offset != tt.offset || decodeErr.Field != tt.field {
+				t.Errorf("Decode() error at byte %d in %q, want byte %d in %q (%v)", decodeErr.Offset, decodeErr.Field, tt.offset, tt.field, err)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data := []byte{0xfb, 0xff, 0x00}
+	tests := map[string]string{
+		EncodingHex:       "fbff00\n",
+		EncodingBase64:    "+/8A\n",
+		EncodingBase64URL: "-_8A\n",
+		EncodingRaw:       string(data),
+	}
+	for encoding, want := range tests {
+		got, err := Encode(data, encoding)
+		if err != nil || string(got) != want {
+			t.Errorf("Encode(%s) = %q, %v, want %q", encoding, got, err, want)
+		}
+	}
+	if _, err := Encode(data, EncodingAuto); err == nil {
+		t.Error("Expected an error encoding with auto")
+	}
+}
@@ -0,0 +1,279 @@
+package zoneserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ednsBufferSize is the UDP payload size the server advertises in EDNS replies
+const ednsBufferSize = 1232
+
+// maxChain bounds the in-zone CNAME chain followed for a single query
+const maxChain = 16
+
+// Server answers queries authoritatively from a set of zones
+type Server struct {
+	zones []*Zone
+
+	mu      sync.Mutex
+	servers []*mdns.Server
+}
+
+// NewServer creates a server for the given zones. A query is answered from
+// the zone with the longest origin that contains the name.
+func NewServer(zones ...*Zone) *Server {
+	return &Server{zones: zones}
+}
+
+// Start listens on address over UDP and TCP and serves queries in the
+// background. A port of 0 picks a free port, shared by both transports. It
+// returns the address the server listens on.
+func (s *Server) Start(address string) (string, error) {
+	packetConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return "", errors.NewSystemError(fmt.Sprintf("cannot listen on %s/udp", address), err)
+	}
+	bound := packetConn.LocalAddr().String()
+
+	listener, err := net.Listen("tcp", bound)
+	if err != nil {
+		packetConn.Close()
+		return "", errors.NewSystemError(fmt.Sprintf("cannot listen on %s/tcp", bound), err)
+	}
+
+	started := make(chan struct{}, 2)
+	failed := make(chan error, 2)
+	servers := []*mdns.Server{
+		{PacketConn: packetConn, Handler: s},
+		{Listener: listener, Handler: s},
+	}
+	for _, server := range servers {
+		server.NotifyStartedFunc = func() { started <- struct{}{} }
+		go func() {
+			if err := server.ActivateAndServe(); err != nil {
+				failed <- err
+			}
+		}()
+	}
+
+	s.mu.Lock()
+	s.servers = append(s.servers, servers...)
+	s.mu.Unlock()
+
+	for range servers {
+		select {
+		case <-started:
+		case err := <-failed:
+			s.Shutdown()
+			return "", errors.NewSystemError(fmt.Sprintf("cannot serve on %s", bound), err)
+		}
+	}
+	return bound, nil
+}
+
+// Shutdown stops serving on every address the server was started on
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	servers := s.servers
+	s.servers = nil
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, server := range servers {
+		if err := server.Shutdown(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ServeDNS answers a query received over the network. Replies over UDP are
+// truncated to the size the client can accept.
+func (s *Server) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	response := s.Answer(r)
+
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		size := mdns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+		response.Truncate(size)
+	}
+
+	w.WriteMsg(response)
+}
+
+// Answer builds the reply to a query
+func (s *Server) Answer(query *mdns.Msg) *mdns.Msg {
+	response := new(mdns.Msg)
+
+	if query.Opcode != mdns.OpcodeQuery {
+		response.SetRcode(query, mdns.RcodeNotImplemented)
+		return response
+	}
+	if len(query.Question) != 1 {
+		response.SetRcode(query, mdns.RcodeFormatError)
+		return response
+	}
+
+	response.SetReply(query)
+	if opt := query.IsEdns0(); opt != nil {
+		response.SetEdns0(ednsBufferSize, opt.Do())
+	}
+
+	question := query.Question[0]
+	zone := s.findZone(question.Name)
+	if zone == nil || (question.Qclass != mdns.ClassINET && question.Qclass != mdns.ClassANY) {
+		response.Rcode = mdns.RcodeRefused
+		return response
+	}
+
+	zone.answer(response, question.Name, question.Qtype)
+	return response
+}
+
+// findZone returns the zone with the longest origin containing name
+func (s *Server) findZone(name string) *Zone {
+	name = strings.ToLower(mdns.Fqdn(name))
+
+	var best *Zone
+	for _, zone := range s.zones {
+		if mdns.IsSubDomain(zone.Origin, name) && (best == nil || len(zone.Origin) > len(best.Origin)) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// answer fills in the reply for name and qtype following RFC 1034 section
+// 4.3.2: referrals at zone cuts, exact matches, CNAMEs within the zone,
+// wildcards, and NODATA or NXDOMAIN with the SOA for negative caching
+func (z *Zone) answer(response *mdns.Msg, name string, qtype uint16) {
+	response.Authoritative = true
+	seen := map[string]bool{}
+
+	for {
+		lower := strings.ToLower(mdns.Fqdn(name))
+		seen[lower] = true
+
+		if cut := z.delegation(lower, qtype); cut != "" {
+			// Data below a zone cut belongs to the child zone
+			if len(response.Answer) == 0 {
+				response.Authoritative = false
+			}
+			z.referral(response, cut)
+			return
+		}
+
+		rrs, exists := z.lookup(lower, name)
+		if !exists {
+			response.Rcode = mdns.RcodeNameError
+			response.Ns = append(response.Ns, z.negativeSOA())
+			return
+		}
+
+		if matched := rrsOfType(rrs, qtype); len(matched) > 0 {
+			response.Answer = append(response.Answer, matched...)
+			return
+		}
+
+		cnames := rrsOfType(rrs, mdns.TypeCNAME)
+		if len(cnames) == 0 {
+			// The name exists without records of this type (NODATA)
+			response.Ns = append(response.Ns, z.negativeSOA())
+			return
+		}
+
+		// Restart the lookup at the alias target while it stays in the zone
+		response.Answer = append(response.Answer, cnames[0])
+		target := cnames[0].(*mdns.CNAME).Target
+		if !mdns.IsSubDomain(z.Origin, strings.ToLower(target)) || seen[strings.ToLower(target)] || len(seen) > maxChain {
+			return
+		}
+		name = target
+	}
+}
+
+// delegation returns the topmost zone cut at or above name, or "" if name is
+// served by this zone. A DS query at the cut is answered by the parent.
+func (z *Zone) delegation(name string, qtype uint16) string {
+	var ancestors []string
+	for n := name; n != z.Origin && n != "."; n = parentName(n) {
+		ancestors = append(ancestors, n)
+	}
+
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		candidate := ancestors[i]
+		if len(rrsOfType(z.records[candidate], mdns.TypeNS)) == 0 {
+			continue
+		}
+		if candidate == name && qtype == mdns.TypeDS {
+			return ""
+		}
+		return candidate
+	}
+	return ""
+}
+
+// referral adds the NS records of a zone cut and the glue addresses of
+// nameservers inside this zone
+func (z *Zone) referral(response *mdns.Msg, cut string) {
+	nameservers := rrsOfType(z.records[cut], mdns.TypeNS)
+	response.Ns = append(response.Ns, nameservers...)
+
+	for _, rr := range nameservers {
+		host := strings.ToLower(rr.(*mdns.NS).Ns)
+		if !mdns.IsSubDomain(z.Origin, host) {
+			continue
+		}
+		response.Extra = append(response.Extra, rrsOfType(z.records[host], mdns.TypeA)...)
+		response.Extra = append(response.Extra, rrsOfType(z.records[host], mdns.TypeAAAA)...)
+	}
+}
+
+// lookup returns the records at name and whether the name exists. A name
+// without records of its own may be an empty non-terminal, or be covered by a
+// wildcard at its closest encloser (RFC 4592), whose records are copied with
+// the queried name as owner.
+func (z *Zone) lookup(lower, queried string) ([]mdns.RR, bool) {
+	if rrs, ok := z.records[lower]; ok {
+		return rrs, true
+	}
+	if z.names[lower] {
+		return nil, true
+	}
+
+	encloser := parentName(lower)
+	for !z.names[encloser] {
+		encloser = parentName(encloser)
+	}
+
+	wildcard, ok := z.records["*."+encloser]
+	if !ok {
+		return nil, false
+	}
+
+	synthesized := make([]mdns.RR, 0, len(wildcard))
+	for _, rr := range wildcard {
+		copied := mdns.Copy(rr)
+		copied.Header().Name = mdns.Fqdn(queried)
+		synthesized = append(synthesized, copied)
+	}
+	return synthesized, true
+}
+
+// negativeSOA returns the SOA record sent with negative answers, with the TTL
+// lowered to the SOA minimum as RFC 2308 section 3 requires
+func (z *Zone) negativeSOA() mdns.RR {
+	soa := mdns.Copy(z.SOA).(*mdns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
@@ -0,0 +1,223 @@
+package zoneserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+// rrStrings renders records in zone file form with single spaces
+func rrStrings(rrs []mdns.RR) []string {
+	var rendered []string
+	for _, rr := range rrs {
+		rendered = append(rendered, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	return rendered
+}
+
+func TestServer_Answer(t *testing.T) {
+	server := NewServer(parseTestZone(t))
+	soa := "example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300"
+
+	tests := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		rcode         int
+		authoritative bool
+		answer        []string
+		ns            []string
+		extra         []string
+	}{
+		{
+			name: "exact match", qname: "www.example.com.", qtype: mdns.TypeA, authoritative: true,
+			answer: []string{"www.example.com. 3600 IN A 192.0.2.1"},
+		},
+		{
+			name: "case insensitive", qname: "WWW.Example.COM.", qtype: mdns.TypeAAAA, authoritative: true,
+			answer: []string{"www.example.com. 3600 IN AAAA 2001:db8::1"},
+		},
+		{
+			name: "NODATA", qname: "www.example.com.", qtype: mdns.TypeMX, authoritative: true,
+			ns: []string{soa},
+		},
+		{
+			name: "NXDOMAIN", qname: "missing.example.com.", qtype: mdns.TypeA, rcode: mdns.RcodeNameError, authoritative: true,
+			ns: []string{soa},
+		},
+		{
+			name: "empty non-terminal", qname: "b.c.example.com.", qtype: mdns.TypeTXT, authoritative: true,
+			ns: []string{soa},
+		},
+		{
+			name: "any", qname: "example.com.", qtype: mdns.TypeANY, authoritative: true,
+			answer: []string{
+				"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300",
+				"example.com. 3600 IN NS ns1.example.com.",
+				"example.com. 3600 IN NS ns2.example.net.",
+			},
+		},
+		{
+			name: "CNAME in zone", qname: "alias.example.com.", qtype: mdns.TypeA, authoritative: true,
+			answer: []string{"alias.example.com. 3600 IN CNAME www.example.com.", "www.example.com. 3600 IN A 192.0.2.1"},
+		},
+		{
+			name: "CNAME query", qname: "alias.example.com.", qtype: mdns.TypeCNAME, authoritative: true,
+			answer: []string{"alias.example.com. 3600 IN CNAME www.example.com."},
+		},
+		{
+			name: "CNAME out of zone", qname: "ext.example.com.", qtype: mdns.TypeA, authoritative: true,
+			answer: []string{"ext.example.com. 3600 IN CNAME www.example.net."},
+		},
+		{
+			name: "CNAME to missing name", qname: "dangling.example.com.", qtype: mdns.TypeA, rcode: mdns.RcodeNameError, authoritative: true,
+			answer: []string{"dangling.example.com. 3600 IN CNAME missing.example.com."},
+			ns:     []string{soa},
+		},
+		{
+			name: "CNAME loop", qname: "loop1.example.com.", qtype: mdns.TypeA, authoritative: true,
+			answer: []string{"loop1.example.com. 3600 IN CNAME loop2.example.com.", "loop2.example.com. 3600 IN CNAME loop1.example.com."},
+		},
+		{
+			name: "wildcard", qname: "anything.wild.example.com.", qtype: mdns.TypeA, authoritative: true,
+			answer: []string{"anything.wild.example.com. 3600 IN A 192.0.2.99"},
+		},
+		{
+			name: "wildcard below several labels", qname: "x.y.wild.example.com.", qtype: mdns.TypeA, authoritative: true,
+			answer: []string{"x.y.wild.example.com. 3600 IN A 192.0.2.99"},
+		},
+		{
+			name: "wildcard NODATA", qname: "anything.wild.example.com.", qtype: mdns.TypeTXT, authoritative: true,
+			ns: []string{soa},
+		},
+		{
+			name: "existing name is not covered by the wildcard", qname: "host.wild.example.com.", qtype: mdns.TypeA, authoritative: true,
+			ns: []string{soa},
+		},
+		{
+			name: "referral", qname: "www.sub.example.com.", qtype: mdns.TypeA,
+			ns:    []string{"sub.example.com. 3600 IN NS ns1.sub.example.com.", "sub.example.com. 3600 IN NS ns.example.net."},
+			extra: []string{"ns1.sub.example.com. 3600 IN A 192.0.2.54"},
+		},
+		{
+			name: "referral at the cut", qname: "sub.example.com.", qtype: mdns.TypeNS,
+			ns:    []string{"sub.example.com. 3600 IN NS ns1.sub.example.com.", "sub.example.com. 3600 IN NS ns.example.net."},
+			extra: []string{"ns1.sub.example.com. 3600 IN A 192.0.2.54"},
+		},
+		{
+			name: "DS at the cut", qname: "sub.example.com.", qtype: mdns.TypeDS, authoritative: true,
+			answer: []string{"sub.example.com. 3600 IN DS 60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118"},
+		},
+		{
+			name: "other zone", qname: "example.org.", qtype: mdns.TypeA, rcode: mdns.RcodeRefused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(mdns.Msg)
+			query.SetQuestion(tt.qname, tt.qtype)
+			response := server.Answer(query)
+
+			if response.Id != query.Id || !response.Response {
+				t.Errorf("Expected a reply to query %d, got id %d response %v", query.Id, response.Id, response.Response)
+			}
+			if response.Rcode != tt.rcode {
+				t.Errorf("Rcode = %s, want %s", mdns.RcodeToString[response.Rcode], mdns.RcodeToString[tt.rcode])
+			}
+			if response.Authoritative != tt.authoritative {
+				t.Errorf("Authoritative = %v, want %v", response.Authoritative, tt.authoritative)
+			}
+			for section, got := range map[string][]string{"answer": rrStrings(response.Answer), "authority": rrStrings(response.Ns), "additional": rrStrings(response.Extra)} {
+				expected := map[string][]string{"answer": tt.answer, "authority": tt.ns, "additional": tt.extra}[section]
+				if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+					t.Errorf("Unexpected %s section:\n%s\nwant:\n%s", section, strings.Join(got, "\n"), strings.Join(expected, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Answer_Malformed(t *testing.T) {
+	server := NewServer(parseTestZone(t))
+
+	notify := new(mdns.Msg)
+	notify.SetNotify("example.com.")
+	if response := server.Answer(notify); response.Rcode != mdns.RcodeNotImplemented {
+		t.Errorf("NOTIFY Rcode = %s, want NOTIMP", mdns.RcodeToString[response.Rcode])
+	}
+
+	empty := new(mdns.Msg)
+	if response := server.Answer(empty); response.Rcode != mdns.RcodeFormatError {
+		t.Errorf("Empty question Rcode = %s, want FORMERR", mdns.RcodeToString[response.Rcode])
+	}
+
+	chaos := new(mdns.Msg)
+	chaos.SetQuestion("www.example.com.", mdns.TypeTXT)
+	chaos.Question[0].Qclass = mdns.ClassCHAOS
+	if response := server.Answer(chaos); response.Rcode != mdns.RcodeRefused {
+		t.Errorf("CHAOS Rcode = %s, want REFUSED", mdns.RcodeToString[response.Rcode])
+	}
+
+	edns := new(mdns.Msg)
+	edns.SetQuestion("www.example.com.", mdns.TypeA)
+	edns.SetEdns0(4096, true)
+	opt := server.Answer(edns).IsEdns0()
+	if opt == nil || !opt.Do() || opt.UDPSize() != ednsBufferSize {
+		t.Errorf("Expected an EDNS reply echoing the DO bit, got %v", opt)
+	}
+}
+
+func TestServer_Network(t *testing.T) {
+	// A record set too large for a 512-byte UDP reply
+	var big strings.Builder
+	big.WriteString(testZone)
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&big, "big IN TXT \"%02d %s\"\n", i, strings.Repeat("x", 60))
+	}
+	zone, err := ParseZone(strings.NewReader(big.String()), "", "big.zone")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+
+	server := NewServer(zone)
+	address, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer server.Shutdown()
+
+	for _, network := range []string{"udp", "tcp"} {
+		client := &mdns.Client{Net: network}
+		query := new(mdns.Msg)
+		query.SetQuestion("www.example.com.", mdns.TypeA)
+		response, _, err := client.Exchange(query, address)
+		if err != nil {
+			t.Fatalf("%s query error = %v", network, err)
+		}
+		if !response.Authoritative || len(response.Answer) != 1 {
+			t.Errorf("Unexpected %s response:\n%v", network, response)
+		}
+	}
+
+	query := new(mdns.Msg)
+	query.SetQuestion("big.example.com.", mdns.TypeTXT)
+
+	response, _, err := (&mdns.Client{Net: "udp"}).Exchange(query, address)
+	if err != nil {
+		t.Fatalf("udp query error = %v", err)
+	}
+	if !response.Truncated || len(response.Answer) >= 20 {
+		t.Errorf("Expected a truncated UDP reply, got TC=%v with %d answers", response.Truncated, len(response.Answer))
+	}
+
+	response, _, err = (&mdns.Client{Net: "tcp"}).Exchange(query, address)
+	if err != nil {
+		t.Fatalf("tcp query error = %v", err)
+	}
+	if response.Truncated || len(response.Answer) != 20 {
+		t.Errorf("Expected the full set over TCP, got TC=%v with %d answers", response.Truncated, len(response.Answer))
+	}
+}
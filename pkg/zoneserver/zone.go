@@ -0,0 +1,141 @@
+package zoneserver
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go-dig/pkg/errors"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Zone holds the records of one zone, loaded from an RFC 1035 zone file
+type Zone struct {
+	Origin string    // Fully qualified zone name in lower case
+	SOA    *mdns.SOA // The zone's SOA record
+
+	records map[string][]mdns.RR // Records by lower-case owner name
+	names   map[string]bool      // Owner names and the empty non-terminals above them
+	count   int
+}
+
+// LoadZone reads a zone file. If origin is empty it is taken from the owner of
+// the SOA record, so the file must then use absolute names or set $ORIGIN.
+func LoadZone(path, origin string) (*Zone, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.NewInputError(fmt.Sprintf("cannot open zone file %s", path), err)
+	}
+	defer file.Close()
+
+	return ParseZone(file, origin, path)
+}
+
+// ParseZone reads a zone in RFC 1035 format from r. filename is used in error
+// messages and to resolve $INCLUDE directives.
+func ParseZone(r io.Reader, origin, filename string) (*Zone, error) {
+	parserOrigin := "."
+	if origin != "" {
+		parserOrigin = mdns.Fqdn(origin)
+	}
+
+	var rrs []mdns.RR
+	parser := mdns.NewZoneParser(r, parserOrigin, filename)
+	parser.SetIncludeAllowed(true)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, errors.NewInputError(fmt.Sprintf("cannot parse zone file %s", filename), err)
+	}
+
+	// Without an origin the zone is named by its SOA record
+	if origin == "" {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == mdns.TypeSOA {
+				origin = rr.Header().Name
+				break
+			}
+		}
+		if origin == "" {
+			return nil, errors.NewInputError(fmt.Sprintf("zone file %s has no SOA record", filename), nil)
+		}
+	}
+
+	return newZone(mdns.Fqdn(origin), rrs, filename)
+}
+
+// newZone indexes rrs and checks that they form a valid zone
+func newZone(origin string, rrs []mdns.RR, filename string) (*Zone, error) {
+	zone := &Zone{
+		Origin:  strings.ToLower(origin),
+		records: map[string][]mdns.RR{},
+		names:   map[string]bool{},
+	}
+
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if !mdns.IsSubDomain(zone.Origin, name) {
+			return nil, errors.NewInputError(fmt.Sprintf("record %s in %s is outside zone %s", rr.Header().Name, filename, zone.Origin), nil)
+		}
+
+		if soa, ok := rr.(*mdns.SOA); ok {
+			if name != zone.Origin {
+				return nil, errors.NewInputError(fmt.Sprintf("SOA record in %s is at %s instead of the zone origin %s", filename, rr.Header().Name, zone.Origin), nil)
+			}
+			if zone.SOA != nil {
+				return nil, errors.NewInputError(fmt.Sprintf("zone file %s has more than one SOA record", filename), nil)
+			}
+			zone.SOA = soa
+		}
+
+		zone.records[name] = append(zone.records[name], rr)
+		zone.count++
+
+		// Every name between the owner and the origin exists, with or without records
+		for n := name; n != zone.Origin && !zone.names[n]; n = parentName(n) {
+			zone.names[n] = true
+		}
+	}
+	zone.names[zone.Origin] = true
+
+	if zone.SOA == nil {
+		return nil, errors.NewInputError(fmt.Sprintf("zone file %s has no SOA record for %s", filename, zone.Origin), nil)
+	}
+
+	// An alias cannot share its name with other data (RFC 1034 section 3.6.2)
+	for name, rrs := range zone.records {
+		if len(rrsOfType(rrs, mdns.TypeCNAME)) > 0 && len(rrs) > 1 {
+			return nil, errors.NewInputError(fmt.Sprintf("CNAME at %s in %s cannot coexist with other records", name, filename), nil)
+		}
+	}
+
+	return zone, nil
+}
+
+// Len returns the number of records in the zone
+func (z *Zone) Len() int {
+	return z.count
+}
+
+// parentName returns name without its first label; the parent of a top-level
+// name is the root
+func parentName(name string) string {
+	if _, parent, found := strings.Cut(name, "."); found && parent != "" {
+		return parent
+	}
+	return "."
+}
+
+// rrsOfType returns the records of the given type; TypeANY selects all
+func rrsOfType(rrs []mdns.RR, rrtype uint16) []mdns.RR {
+	var matched []mdns.RR
+	for _, rr := range rrs {
+		if rrtype == mdns.TypeANY || rr.Header().Rrtype == rrtype {
+			matched = append(matched, rr)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,126 @@
+package zoneserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-dig/pkg/errors"
+)
+
+// testZone is the zone used by the tests in this package
+const testZone = `$ORIGIN example.com.
+$TTL 3600
+@         IN SOA   ns1 hostmaster 2024010101 7200 3600 1209600 300
+@         IN NS    ns1
+@         IN NS    ns2.example.net.
+ns1       IN A     192.0.2.53
+www       IN A     192.0.2.1
+          IN AAAA  2001:db8::1
+alias     IN CNAME www
+ext       IN CNAME www.example.net.
+dangling  IN CNAME missing
+loop1     IN CNAME loop2
+loop2     IN CNAME loop1
+a.b.c     IN TXT   "deep"
+*.wild    IN A     192.0.2.99
+host.wild IN MX    10 mail
+sub       IN NS    ns1.sub
+sub       IN NS    ns.example.net.
+sub       IN DS    60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118
+ns1.sub   IN A     192.0.2.54
+`
+
+// parseTestZone parses testZone, failing the test on error
+func parseTestZone(t *testing.T) *Zone {
+	t.Helper()
+	zone, err := ParseZone(strings.NewReader(testZone), "", "test.zone")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+	return zone
+}
+
+func TestParseZone(t *testing.T) {
+	zone := parseTestZone(t)
+
+	if zone.Origin != "example.com." {
+		t.Errorf("Origin = %q, want example.com.", zone.Origin)
+	}
+	if zone.SOA == nil || zone.SOA.Serial != 2024010101 {
+		t.Errorf("Unexpected SOA: %v", zone.SOA)
+	}
+	if zone.Len() != 18 {
+		t.Errorf("Len() = %d, want 18", zone.Len())
+	}
+	for _, name := range []string{"c.example.com.", "b.c.example.com.", "wild.example.com."} {
+		if !zone.names[name] {
+			t.Errorf("Expected empty non-terminal %s to exist", name)
+		}
+	}
+}
+
+func TestParseZone_Origin(t *testing.T) {
+	// Relative names are completed with the given origin
+	zone, err := ParseZone(strings.NewReader("@ 300 IN SOA ns1 hostmaster 1 7200 3600 1209600 300\nwww 300 IN A 192.0.2.1\n"), "Example.ORG", "relative.zone")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+	if zone.Origin != "example.org." {
+		t.Errorf("Origin = %q, want example.org.", zone.Origin)
+	}
+	if len(zone.records["www.example.org."]) != 1 {
+		t.Errorf("Expected www.example.org. to be loaded, got %v", zone.records)
+	}
+}
+
+func TestParseZone_Invalid(t *testing.T) {
+	soa := "example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300\n"
+
+	tests := []struct {
+		name        string
+		zone        string
+		origin      string
+		expectError string
+	}{
+		{"no SOA", "www.example.com. 300 IN A 192.0.2.1\n", "", "has no SOA record"},
+		{"no SOA for origin", soa, "example.org", "is outside zone example.org."},
+		{"two SOA records", soa + soa, "", "more than one SOA record"},
+		{"record outside zone", soa + "www.example.net. 300 IN A 192.0.2.1\n", "", "www.example.net. in bad.zone is outside zone example.com."},
+		{"CNAME with other data", soa + "www.example.com. 300 IN CNAME other.example.com.\nwww.example.com. 300 IN TXT \"x\"\n", "", "CNAME at www.example.com. in bad.zone cannot coexist"},
+		{"syntax error", soa + "www.example.com. 300 IN A not-an-address\n", "", "cannot parse zone file bad.zone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseZone(strings.NewReader(tt.zone), tt.origin, "bad.zone")
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Fatalf("ParseZone() error = %v, want error containing %q", err, tt.expectError)
+			}
+			if !errors.IsInputError(err) {
+				t.Errorf("Expected input error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadZone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	if err := os.WriteFile(path, []byte(testZone), 0o600); err != nil {
+		t.Fatalf("writing zone file: %v", err)
+	}
+
+	zone, err := LoadZone(path, "")
+	if err != nil {
+		t.Fatalf("LoadZone() error = %v", err)
+	}
+	if zone.Origin != "example.com." || zone.Len() != 18 {
+		t.Errorf("Unexpected zone %s with %d records", zone.Origin, zone.Len())
+	}
+
+	_, err = LoadZone(filepath.Join(t.TempDir(), "missing.zone"), "")
+	if err == nil || !errors.IsInputError(err) || !strings.Contains(err.Error(), "cannot open zone file") {
+		t.Errorf("Expected an input error for a missing file, got %v", err)
+	}
+}